@@ -1,82 +1,601 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 
+	"github.com/m-rcd/notes/pkg/api"
+	v1 "github.com/m-rcd/notes/pkg/api/v1"
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/backup"
+	"github.com/m-rcd/notes/pkg/collab"
+	"github.com/m-rcd/notes/pkg/config"
 	"github.com/m-rcd/notes/pkg/database"
 	"github.com/m-rcd/notes/pkg/database/local"
 	"github.com/m-rcd/notes/pkg/database/sql"
+	"github.com/m-rcd/notes/pkg/encryption"
+	"github.com/m-rcd/notes/pkg/events"
 	"github.com/m-rcd/notes/pkg/handler"
-	"github.com/m-rcd/notes/pkg/utils"
+	"github.com/m-rcd/notes/pkg/health"
+	"github.com/m-rcd/notes/pkg/importer"
+	"github.com/m-rcd/notes/pkg/logging"
+	"github.com/m-rcd/notes/pkg/metrics"
+	"github.com/m-rcd/notes/pkg/migrate"
+	"github.com/m-rcd/notes/pkg/openapi"
+	"github.com/m-rcd/notes/pkg/responses"
+	"github.com/m-rcd/notes/pkg/server"
+	"github.com/m-rcd/notes/pkg/tracing"
+	"github.com/m-rcd/notes/pkg/webhooks"
 
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
-	fmt.Println("Listening on port 10000")
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(importCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate-data" {
+		os.Exit(migrateDataCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		os.Exit(backupCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(restoreCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		os.Exit(reencryptCommand(os.Args[2:]))
+	}
+
+	cfg := loadConfig("notes", os.Args[1:])
+	logger := logrus.StandardLogger()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.Endpoint)
+	if err != nil {
+		logger.WithError(err).Fatal("failed to set up tracing")
+	}
+
+	m := metrics.New()
+	broker := events.NewBroker(events.DefaultLogSize)
+	store, err := getDb(cfg.Database)
+	if err != nil {
+		logger.WithError(err).Fatal("failed to set up database")
+	}
+
+	timeouts := database.Timeouts{Default: cfg.Database.Timeout, Operations: cfg.Database.OperationTimeouts}
+	db := events.Publishing(m.Instrument(tracing.Instrument(database.WithTimeouts(store, timeouts), cfg.Database.Type), cfg.Database.Type), broker)
+
+	if err := db.Open(); err != nil {
+		logger.WithError(err).Fatal("failed to open database")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.WithField("address", cfg.Server.Address).Info("listening")
 
+	checker := health.New(db)
+	guard := webhooks.Guard{AllowInternal: cfg.Webhooks.AllowInternal}
+	feed := events.NewHandler(broker)
+	hub := collab.NewHub(db, cfg.API.CollabSaveInterval)
+	srv := server.New(serverOptions(cfg.Server), newRouter(db, logger, m, checker, feed, hub, guard, cfg.API), db)
+	srv.OnShutdown(checker.ShuttingDown)
+	srv.OnShutdown(broker.Close)
+	srv.OnShutdown(hub.Close)
+
+	dispatcher := webhooks.NewDispatcher(db, logger, webhooks.Options{Workers: cfg.Webhooks.Workers, MaxAttempts: cfg.Webhooks.MaxAttempts, Guard: guard})
+	broker.Listen(dispatcher.Notify)
+	srv.AddWorker(dispatcher.Run)
+	srv.AddWorker(func(ctx context.Context) {
+		<-ctx.Done()
+
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.WithError(err).Error("failed to flush traces")
+		}
+	})
+
+	if err := srv.Run(ctx); err != nil {
+		logger.WithError(err).Fatal("server failed")
+	}
+
+	logger.Info("server stopped")
+}
+
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Println("usage: notes config print [flags]")
+		return 2
+	}
+
+	cfg, err := config.Load("notes config print", args[1:], os.LookupEnv)
+	if err != nil {
+		return exitCode(err)
+	}
+
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Print(out)
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func importCommand(args []string) int {
 	var (
-		storage string
-		workDir string
+		file, format, user string
+		dryRun             bool
 	)
 
-	flag.StringVar(&storage, "db", "local", "store notes on the local filesystem or in an SQL database (default: local)")
-	flag.StringVar(&workDir, "directory", "/tmp", "notes location when `--db` set to `local` (default: /tmp)")
-	flag.Parse()
+	cfg, err := config.LoadCommand("notes import", args, os.LookupEnv, func(fs *flag.FlagSet) {
+		fs.StringVar(&file, "file", "", "the file to import")
+		fs.StringVar(&format, "format", "", "the format of the file: markdown, json, enex or keep, guessed from its name when unset")
+		fs.StringVar(&user, "user", "", "the user to import the notes for")
+		fs.BoolVar(&dryRun, "dry-run", false, "only report what the import would do")
+	})
+	if err != nil {
+		return exitCode(err)
+	}
 
-	db := getDb(storage, workDir)
+	if file == "" || user == "" {
+		fmt.Println("usage: notes import --file <file> --user <username> [--format <format>] [--dry-run] [flags]")
+		return 2
+	}
 
+	if err := cfg.Validate(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer f.Close()
+
+	items, err := importer.Read(f, format, importer.MediaTypeOf(file))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	store, err := getDb(cfg.Database)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	db := database.WithTimeouts(store, database.Timeouts{Default: cfg.Database.Timeout, Operations: cfg.Database.OperationTimeouts})
 	if err := db.Open(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		return 1
 	}
+	defer db.Close()
 
-	defer func() {
-		if err := db.Close(); err != nil {
+	results, err := importer.Import(context.Background(), db, user, items, dryRun)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	failed := 0
+	for _, result := range results {
+		line := fmt.Sprintf("%s\t%s", result.Status, result.Source)
+		if result.Id != "" {
+			line += "\tnote " + result.Id
+		}
+		if result.Reason != "" {
+			line += "\t" + result.Reason
+		}
+		fmt.Println(line)
+
+		if result.Status == importer.StatusFailed {
+			failed++
+		}
+	}
+	fmt.Println(responses.Imported(results, dryRun).Message)
+
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func migrateDataCommand(args []string) int {
+	var from, to string
+
+	cfg, err := config.LoadCommand("notes migrate-data", args, os.LookupEnv, func(fs *flag.FlagSet) {
+		fs.StringVar(&from, "from", "", "the backend to copy the notes from, `local:<directory>` or `sql:<username>:<password>@<host>:<port>/<name>`")
+		fs.StringVar(&to, "to", "", "the backend to copy the notes to, like --from")
+	})
+	if err != nil {
+		return exitCode(err)
+	}
+
+	if from == "" || to == "" {
+		fmt.Println("usage: notes migrate-data --from <backend> --to <backend> [flags]")
+		return 2
+	}
+
+	var dbs []database.Database
+	for _, spec := range []string{from, to} {
+		backend, err := cfg.Database.Backend(spec)
+		if err != nil {
+			fmt.Println(err)
+			return 2
+		}
+
+		backendCfg := cfg
+		backendCfg.Database = backend
+		if err := backendCfg.Validate(); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+
+		store, err := getDb(backend)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+
+		db := database.WithTimeouts(store, database.Timeouts{Default: backend.Timeout, Operations: backend.OperationTimeouts})
+		if err := db.Open(); err != nil {
 			fmt.Println(err)
+			return 1
+		}
+		defer db.Close()
+
+		dbs = append(dbs, db)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var total migrate.Result
+	err = migrate.Migrate(ctx, dbs[0], dbs[1], func(result migrate.Result) {
+		fmt.Printf("%s: %d copied, %d of them with a new id, %d already there, %d failed; source %d active and %d archived, destination %d active and %d archived\n",
+			result.User, result.Copied, result.Renumbered, result.Skipped, len(result.Errors),
+			result.Source.Active, result.Source.Archived, result.Destination.Active, result.Destination.Archived)
+		for _, message := range result.Errors {
+			fmt.Println("  " + message)
 		}
-	}()
 
-	handleRequests(db)
+		total.Copied += result.Copied
+		total.Skipped += result.Skipped
+		total.Errors = append(total.Errors, result.Errors...)
+		total.Missing += result.Missing
+	})
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("the migration stopped, run it again to carry on")
+		return 1
+	}
+
+	fmt.Printf("%d notes copied, %d already there and %d failed\n", total.Copied, total.Skipped, len(total.Errors))
+	if !total.Verified() {
+		fmt.Printf("%d notes are missing from the destination, run the migration again to retry them\n", total.Missing)
+		return 1
+	}
+
+	return 0
 }
 
-func handleRequests(db database.Database) {
-	h := handler.New(db)
+func backupCommand(args []string) int {
+	var file string
+
+	cfg, err := config.LoadCommand("notes backup", args, os.LookupEnv, func(fs *flag.FlagSet) {
+		fs.StringVar(&file, "file", "", "the file to write the backup to")
+	})
+	if err != nil {
+		return exitCode(err)
+	}
+
+	if file == "" {
+		fmt.Println("usage: notes backup --file <file> [flags]")
+		return 2
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	store, err := getDb(cfg.Database)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	db := database.WithTimeouts(store, database.Timeouts{Default: cfg.Database.Timeout, Operations: cfg.Database.OperationTimeouts})
+	if err := db.Open(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer db.Close()
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	manifest, err := backup.Write(ctx, f, db, time.Now())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		fmt.Println(err)
+		return 1
+	}
+
+	notes := 0
+	for _, user := range manifest.Users {
+		notes += user.Active + user.Archived
+	}
+	fmt.Printf("backed up %d notes of %d users and %d webhooks to %s\n", notes, len(manifest.Users), manifest.Webhooks, file)
+
+	return 0
+}
+
+func restoreCommand(args []string) int {
+	var file string
+
+	cfg, err := config.LoadCommand("notes restore", args, os.LookupEnv, func(fs *flag.FlagSet) {
+		fs.StringVar(&file, "file", "", "the backup to restore")
+	})
+	if err != nil {
+		return exitCode(err)
+	}
+
+	if file == "" {
+		fmt.Println("usage: notes restore --file <file> [flags]")
+		return 2
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	store, err := getDb(cfg.Database)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	db := database.WithTimeouts(store, database.Timeouts{Default: cfg.Database.Timeout, Operations: cfg.Database.OperationTimeouts})
+	if err := db.Open(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	summary, err := backup.Restore(ctx, f, info.Size(), db)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	fmt.Printf("restored %d notes of %d users, %d of them with a new id, and %d webhooks\n", summary.Notes, summary.Users, summary.Renumbered, summary.Webhooks)
+
+	return 0
+}
+
+func reencryptCommand(args []string) int {
+	var rotate bool
+
+	cfg, err := config.LoadCommand("notes reencrypt", args, os.LookupEnv, func(fs *flag.FlagSet) {
+		fs.BoolVar(&rotate, "rotate-data-keys", false, "give every user a new data key and encrypt all of their notes with it")
+	})
+	if err != nil {
+		return exitCode(err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	keys, err := cfg.Database.Encryption.Keyring()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if keys == nil {
+		fmt.Println("no encryption keys are configured, set NOTES_ENCRYPTION_KEY or --encryption-key-file")
+		return 2
+	}
+
+	db := database.WithTimeouts(getBackend(cfg.Database), database.Timeouts{Default: cfg.Database.Timeout, Operations: cfg.Database.OperationTimeouts})
+	if err := db.Open(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var total encryption.Result
+	err = encryption.Reencrypt(ctx, db, keys, rotate, func(result encryption.Result) {
+		fmt.Printf("%s: %d data keys rewrapped, %d notes encrypted, %d failed\n", result.User, result.Rewrapped, result.Encrypted, len(result.Errors))
+		for _, message := range result.Errors {
+			fmt.Println("  " + message)
+		}
+
+		total.Rewrapped += result.Rewrapped
+		total.Encrypted += result.Encrypted
+		total.Errors = append(total.Errors, result.Errors...)
+	})
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("re-encrypting stopped, run it again to carry on")
+		return 1
+	}
+
+	fmt.Printf("%d data keys rewrapped, %d notes encrypted and %d failed\n", total.Rewrapped, total.Encrypted, len(total.Errors))
+	if len(total.Errors) > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func loadConfig(name string, args []string) config.Config {
+	cfg, err := config.Load(name, args, os.LookupEnv)
+	if err != nil {
+		os.Exit(exitCode(err))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := logging.Configure(logrus.StandardLogger(), cfg.Log.Format, cfg.Log.Level); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return cfg
+}
+
+func exitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+
+	fmt.Println(err)
+	return 2
+}
+
+func newRouter(db database.Database, logger *logrus.Logger, m *metrics.Metrics, checker *health.Checker, feed http.Handler, hub http.Handler, guard webhooks.Guard, cfg config.APIConfig) http.Handler {
 	myRouter := mux.NewRouter().StrictSlash(true)
+	myRouter.Use(tracing.Middleware(), logging.Middleware(logger), m.Middleware)
+
+	idempotent := api.Idempotent(db, cfg.IdempotencyWindow)
+
+	v1Handler := v1.New(db, guard)
+	v1Router := myRouter.PathPrefix(v1.Prefix).Subrouter()
+	v1Router.Use(idempotent)
+	v1Handler.Register(v1Router)
+	v1Router.Handle("/events", feed).Methods("GET")
+	v1Router.Handle("/notes/{id}/collab", hub).Methods("GET")
+
+	admin := v1Router.PathPrefix("/admin").Subrouter()
+	admin.Use(auth.Admin(cfg.Admins))
+	admin.HandleFunc("/backup", v1Handler.Backup).Methods("GET")
+
+	// The unversioned routes predate /api/v1 and are kept for existing clients.
+	legacySunset, _ := cfg.LegacySunsetDate()
+	h := handler.New(db)
+	legacy := myRouter.NewRoute().Subrouter()
+	legacy.Use(api.Deprecated(legacySunset, v1.Prefix), idempotent)
+	legacy.HandleFunc("/note", h.CreateNewNote).Methods("POST")
+	legacy.HandleFunc("/note/{id}", h.UpdateNote).Methods("PATCH")
+	legacy.HandleFunc("/note/{id}", h.ReplaceNote).Methods("PUT")
+	legacy.HandleFunc("/note/{id}/archive", h.ArchiveNote).Methods("POST")
+	legacy.HandleFunc("/note/{id}/unarchive", h.UnarchiveNote).Methods("POST")
+	legacy.HandleFunc("/notes/archive", h.ArchiveNotes).Methods("POST")
+	legacy.HandleFunc("/notes/unarchive", h.UnarchiveNotes).Methods("POST")
+	legacy.HandleFunc("/notes/batch", h.BatchNotes).Methods("POST")
+	legacy.HandleFunc("/note/{id}", h.DeleteNote).Methods("DELETE")
+	legacy.HandleFunc("/notes/active", h.ListActiveNotes).Methods("GET")
+	legacy.HandleFunc("/notes/archived", h.ListArchivedNotes).Methods("GET")
+
 	myRouter.HandleFunc("/", h.HomePage)
-	myRouter.HandleFunc("/note", h.CreateNewNote).Methods("POST")
-	myRouter.HandleFunc("/note/{id}", h.UpdateNote).Methods("PATCH")
-	myRouter.HandleFunc("/note/{id}", h.DeleteNote).Methods("DELETE")
-	myRouter.HandleFunc("/notes/active", h.ListActiveNotes).Methods("GET")
-	myRouter.HandleFunc("/notes/archived", h.ListArchivedNotes).Methods("GET")
+	myRouter.Handle("/events", feed).Methods("GET")
+	myRouter.Handle("/metrics", m.Handler()).Methods("GET")
+	myRouter.HandleFunc("/healthz", checker.Liveness).Methods("GET")
+	myRouter.HandleFunc("/readyz", checker.Readiness).Methods("GET")
+	myRouter.Handle("/openapi.json", openapi.Handler()).Methods("GET")
+
+	return myRouter
+}
+
+func serverOptions(cfg config.ServerConfig) server.Options {
+	return server.Options{
+		Address:         cfg.Address,
+		ReadTimeout:     cfg.ReadTimeout,
+		WriteTimeout:    cfg.WriteTimeout,
+		IdleTimeout:     cfg.IdleTimeout,
+		ShutdownTimeout: cfg.ShutdownTimeout,
+		ShutdownDelay:   cfg.ShutdownDelay,
+		TLS: server.TLSOptions{
+			CertFile:        cfg.TLS.CertFile,
+			KeyFile:         cfg.TLS.KeyFile,
+			ClientCAFile:    cfg.TLS.ClientCAFile,
+			RedirectAddress: cfg.TLS.RedirectAddress,
+		},
+	}
+}
 
-	log.Fatal(http.ListenAndServe(":10000", myRouter))
+// getDb returns the backend, which encrypts the content of notes when
+// encryption keys are configured.
+func getDb(cfg config.DatabaseConfig) (database.Database, error) {
+	keys, err := cfg.Encryption.Keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	if keys == nil {
+		return getBackend(cfg), nil
+	}
+
+	return encryption.Encrypting(getBackend(cfg), keys), nil
 }
 
-func getDb(storage, workDir string) database.Database {
+func getBackend(cfg config.DatabaseConfig) database.Database {
 	var db database.Database
 
-	switch storage {
+	switch cfg.Type {
 	case "sql":
-		username := os.Getenv("DB_USERNAME")
-		if !utils.IsSet(username) {
-			fmt.Println("DB_USERNAME must be set")
-			os.Exit(1)
-		}
-		password := os.Getenv("DB_PASSWORD")
-		if !utils.IsSet(password) {
-			fmt.Println("DB_PASSWORD must be set")
-			os.Exit(1)
-		}
-		db = sql.NewSQL(username, password, "127.0.0.1", "3306")
+		db = sql.NewSQL(cfg.SQL.Username, cfg.SQL.Password, cfg.SQL.Host, cfg.SQL.Port, cfg.SQL.Name)
 	default:
-		db = local.NewLocalFileSystem(workDir)
+		db = local.NewLocalFileSystem(cfg.Directory)
 	}
 
 	return db
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/m-rcd/notes/pkg/collab"
+	"github.com/m-rcd/notes/pkg/config"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/events"
+	"github.com/m-rcd/notes/pkg/health"
+	"github.com/m-rcd/notes/pkg/metrics"
+	"github.com/m-rcd/notes/pkg/openapi"
+	"github.com/m-rcd/notes/pkg/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Router", func() {
+	It("serves every route of the OpenAPI document and nothing else", func() {
+		db := new(databasefakes.FakeDatabase)
+		feed := events.NewHandler(events.NewBroker(events.DefaultLogSize))
+		hub := collab.NewHub(db, time.Second)
+		defer hub.Close()
+
+		router := newRouter(db, logrus.New(), metrics.New(), health.New(db), feed, hub, webhooks.Guard{}, config.APIConfig{})
+		Expect(router).To(BeAssignableToTypeOf(&mux.Router{}))
+		Expect(openapi.CheckRoutes(router.(*mux.Router))).To(Succeed())
+	})
+})
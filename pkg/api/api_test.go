@@ -0,0 +1,30 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Api", func() {
+	Context("Deprecated", func() {
+		It("announces the sunset and the successor of a route", func() {
+			sunset := time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)
+			handler := api.Deprecated(sunset, "/api/v1")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+
+			r := httptest.NewRecorder()
+			handler.ServeHTTP(r, httptest.NewRequest("GET", "/notes/active", nil))
+
+			Expect(r.Code).To(Equal(http.StatusTeapot))
+			Expect(r.Header().Get("Deprecation")).To(Equal("true"))
+			Expect(r.Header().Get("Sunset")).To(Equal("Fri, 01 Jan 2027 00:00:00 GMT"))
+			Expect(r.Header().Get("Link")).To(Equal(`</api/v1>; rel="successor-version"`))
+		})
+	})
+})
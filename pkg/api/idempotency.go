@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/logging"
+	"github.com/m-rcd/notes/pkg/responses"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// ReplayedHeader is set on responses repeated for a retry.
+	ReplayedHeader = "Idempotent-Replayed"
+	// MaxIdempotencyKeyLength bounds the keys clients can choose.
+	MaxIdempotencyKeyLength = 255
+)
+
+// inFlight is how long a key stays taken by a request that has not been
+// answered, e.g. because the server stopped while serving it.
+const inFlight = time.Minute
+
+// Idempotent lets clients retry POST requests safely. The response to the
+// first request sent with an `Idempotency-Key` header is stored in db and
+// replayed to requests with the same key for window. Reusing a key for a
+// different request, or while the first one is still being served, fails with
+// 409. Responses to requests that failed on the server are not kept, so they
+// can be retried. Keys are scoped to the user of the client certificate, if
+// any, and responses are stored for the user the request is about.
+func Idempotent(db database.Database, window time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > MaxIdempotencyKeyLength {
+				reject(w, responses.BadRequest("idempotency key must be at most 255 characters"))
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				reject(w, responses.BadRequest(err.Error()))
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			var caller string
+			if user, ok := auth.UserFromContext(r.Context()); ok {
+				caller = user.Username
+			}
+
+			// The owner's data key seals the stored response. Requests that
+			// name no user are answered with errors, which are kept as they are.
+			owner, _ := auth.Owner(r)
+
+			now := time.Now()
+			record := database.IdempotencyRecord{
+				Key:         digest(caller, key),
+				Fingerprint: digest(r.Method, r.URL.RequestURI(), string(body)),
+				ExpiresAt:   now.Add(inFlight),
+				Username:    owner,
+			}
+
+			logger := logging.FromContext(r.Context())
+			existing, claimed, err := db.ClaimIdempotencyKey(r.Context(), record, now)
+			if err != nil {
+				logger.WithError(err).Error("failed to claim idempotency key")
+				reject(w, responses.Error(err))
+				return
+			}
+
+			if !claimed {
+				replay(w, existing, record.Fingerprint)
+				return
+			}
+
+			recorder := &bodyRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			// The client may have given up waiting, which is when the response
+			// matters most, so it is stored whether or not the request is done.
+			ctx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(r.Context()))
+			if recorder.failed() {
+				err = db.ReleaseIdempotencyKey(ctx, record.Key)
+			} else {
+				record.StatusCode = recorder.status
+				record.ContentType = recorder.Header().Get("Content-Type")
+				record.Body = recorder.body.Bytes()
+				record.ExpiresAt = time.Now().Add(window)
+				err = db.SaveIdempotencyKey(ctx, record)
+			}
+
+			if err != nil {
+				logger.WithError(err).Error("failed to store idempotency key")
+			}
+		})
+	}
+}
+
+func replay(w http.ResponseWriter, record database.IdempotencyRecord, fingerprint string) {
+	if record.Fingerprint != fingerprint {
+		reject(w, responses.Conflict("idempotency key was already used for a different request"))
+		return
+	}
+
+	if record.Pending() {
+		reject(w, responses.Conflict("a request with this idempotency key is still being served"))
+		return
+	}
+
+	w.Header().Set("Content-Type", record.ContentType)
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+func reject(w http.ResponseWriter, response responses.JsonNoteResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.StatusCode)
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func digest(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type bodyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bodyRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the connection, for handlers
+// that extend their write deadline.
+func (r *bodyRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// failed reports a server error, including the ones the legacy routes send
+// with HTTP status 200 and only give in the body's `status_code`.
+func (r *bodyRecorder) failed() bool {
+	if r.status >= http.StatusInternalServerError {
+		return true
+	}
+
+	var response struct {
+		StatusCode int `json:"status_code"`
+	}
+	json.Unmarshal(r.body.Bytes(), &response)
+
+	return response.StatusCode >= http.StatusInternalServerError
+}
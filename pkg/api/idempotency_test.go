@@ -0,0 +1,185 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/api"
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/database/local"
+	"github.com/m-rcd/notes/pkg/encryption"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/responses"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Idempotent", func() {
+	var (
+		workDir string
+		db      *local.LocalFileSystem
+		calls   int
+		status  int
+		serve   http.HandlerFunc
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		var err error
+		workDir, err = ioutil.TempDir("", "idempotency")
+		Expect(err).NotTo(HaveOccurred())
+
+		db = local.NewLocalFileSystem(workDir)
+		Expect(db.Open()).To(Succeed())
+
+		calls = 0
+		status = http.StatusCreated
+		serve = func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"status_code":%d,"call":%d,"body":%q}`, status, calls, body)
+		}
+		handler = api.Idempotent(db, time.Hour)(serve)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(workDir)).To(Succeed())
+	})
+
+	send := func(ctx context.Context, method, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/notes", bytes.NewBufferString(body)).WithContext(ctx)
+		if key != "" {
+			req.Header.Set(api.IdempotencyKeyHeader, key)
+		}
+
+		r := httptest.NewRecorder()
+		handler.ServeHTTP(r, req)
+
+		return r
+	}
+
+	It("replays the response to the first request to retries", func() {
+		first := send(context.Background(), "POST", "retry", `{"name":"Vampires"}`)
+		Expect(first.Code).To(Equal(http.StatusCreated))
+		Expect(first.Header().Get(api.ReplayedHeader)).To(BeEmpty())
+
+		retry := send(context.Background(), "POST", "retry", `{"name":"Vampires"}`)
+		Expect(retry.Code).To(Equal(http.StatusCreated))
+		Expect(retry.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(retry.Header().Get(api.ReplayedHeader)).To(Equal("true"))
+		Expect(retry.Body.String()).To(Equal(first.Body.String()))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("stores the response encrypted for the user the request is about", func() {
+		key := make([]byte, encryption.KeySize)
+		_, err := rand.Read(key)
+		Expect(err).NotTo(HaveOccurred())
+		keys, err := encryption.ParseKeys(base64.StdEncoding.EncodeToString(key))
+		Expect(err).NotTo(HaveOccurred())
+		handler = api.Idempotent(encryption.Encrypting(db, keys), time.Hour)(serve)
+
+		first := send(context.Background(), "POST", "sealed", `{"name":"Vampires","user":{"username":"Buffy"}}`)
+		retry := send(context.Background(), "POST", "sealed", `{"name":"Vampires","user":{"username":"Buffy"}}`)
+		Expect(retry.Header().Get(api.ReplayedHeader)).To(Equal("true"))
+		Expect(retry.Body.String()).To(Equal(first.Body.String()))
+
+		files, err := ioutil.ReadDir(workDir + "/idempotency")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		stored, err := ioutil.ReadFile(workDir + "/idempotency/" + files[0].Name())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(stored)).NotTo(ContainSubstring(base64.StdEncoding.EncodeToString(first.Body.Bytes())))
+	})
+
+	It("rejects a key reused for a different request", func() {
+		send(context.Background(), "POST", "retry", `{"name":"Vampires"}`)
+
+		r := send(context.Background(), "POST", "retry", `{"name":"Demons"}`)
+		Expect(r.Code).To(Equal(http.StatusConflict))
+
+		var response responses.JsonNoteResponse
+		Expect(json.Unmarshal(r.Body.Bytes(), &response)).To(Succeed())
+		Expect(response.StatusCode).To(Equal(http.StatusConflict))
+		Expect(response.Message).To(Equal("idempotency key was already used for a different request"))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("rejects a retry while the first request is being served", func() {
+		var retry *httptest.ResponseRecorder
+		handler = api.Idempotent(db, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := httptest.NewRequest("POST", "/notes", strings.NewReader(`{}`))
+			req.Header.Set(api.IdempotencyKeyHeader, "retry")
+			retry = httptest.NewRecorder()
+			api.Idempotent(db, time.Hour)(http.NotFoundHandler()).ServeHTTP(retry, req)
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+		Expect(send(context.Background(), "POST", "retry", `{}`).Code).To(Equal(http.StatusCreated))
+		Expect(retry.Code).To(Equal(http.StatusConflict))
+		Expect(retry.Body.String()).To(ContainSubstring("still being served"))
+	})
+
+	It("lets requests that failed on the server be retried", func() {
+		status = http.StatusInternalServerError
+		send(context.Background(), "POST", "retry", `{}`)
+
+		status = http.StatusCreated
+		Expect(send(context.Background(), "POST", "retry", `{}`).Code).To(Equal(http.StatusCreated))
+		Expect(calls).To(Equal(2))
+	})
+
+	It("lets legacy failures sent with status 200 be retried", func() {
+		handler = api.Idempotent(db, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			json.NewEncoder(w).Encode(responses.Failure("failed to create note"))
+		}))
+
+		send(context.Background(), "POST", "retry", `{}`)
+		send(context.Background(), "POST", "retry", `{}`)
+		Expect(calls).To(Equal(2))
+	})
+
+	It("forgets responses after the window", func() {
+		handler = api.Idempotent(db, time.Millisecond)(serve)
+
+		send(context.Background(), "POST", "retry", `{}`)
+		time.Sleep(5 * time.Millisecond)
+		Expect(send(context.Background(), "POST", "retry", `{}`).Header().Get(api.ReplayedHeader)).To(BeEmpty())
+		Expect(calls).To(Equal(2))
+	})
+
+	It("keeps the keys of each client certificate apart", func() {
+		send(auth.WithUser(context.Background(), models.User{Username: "Buffy"}), "POST", "retry", `{}`)
+		r := send(auth.WithUser(context.Background(), models.User{Username: "Spike"}), "POST", "retry", `{}`)
+
+		Expect(r.Header().Get(api.ReplayedHeader)).To(BeEmpty())
+		Expect(calls).To(Equal(2))
+	})
+
+	It("only applies to POST requests with a key", func() {
+		send(context.Background(), "POST", "", `{}`)
+		send(context.Background(), "POST", "", `{}`)
+		send(context.Background(), "PUT", "retry", `{}`)
+		send(context.Background(), "PUT", "retry", `{}`)
+		Expect(calls).To(Equal(4))
+	})
+
+	It("rejects keys that are too long", func() {
+		r := send(context.Background(), "POST", strings.Repeat("k", api.MaxIdempotencyKeyLength+1), `{}`)
+		Expect(r.Code).To(Equal(http.StatusBadRequest))
+		Expect(calls).To(Equal(0))
+	})
+})
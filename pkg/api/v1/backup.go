@@ -0,0 +1,26 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/backup"
+	"github.com/m-rcd/notes/pkg/logging"
+)
+
+// Backup streams a backup of every note and webhook, for admins only.
+func (h *Handler) Backup(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	download := &download{w: w, log: logging.FromContext(r.Context()), name: fmt.Sprintf("notes-backup-%s.zip", now.Format("20060102T150405Z")), contentType: "application/zip"}
+	if _, err := backup.Write(r.Context(), download, h.db, now); err != nil {
+		log := logging.FromContext(r.Context())
+		if !download.started {
+			write(w, failure(log, err, "failed to back up notes"))
+			return
+		}
+
+		log.WithError(err).Error("failed to back up notes")
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,119 @@
+package v1_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	v1 "github.com/m-rcd/notes/pkg/api/v1"
+	"github.com/m-rcd/notes/pkg/backup"
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/responses"
+	"github.com/m-rcd/notes/pkg/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("V1 backup", func() {
+	var (
+		fake_db  *databasefakes.FakeDatabase
+		snapshot *databasefakes.FakeSnapshot
+		router   *mux.Router
+	)
+
+	BeforeEach(func() {
+		fake_db = new(databasefakes.FakeDatabase)
+		snapshot = new(databasefakes.FakeSnapshot)
+		fake_db.SnapshotStub = func(_ context.Context, fn func(database.Snapshot) error) error {
+			return fn(snapshot)
+		}
+
+		h := v1.New(fake_db, webhooks.Guard{})
+		router = mux.NewRouter()
+		router.HandleFunc("/api/v1/admin/backup", h.Backup)
+	})
+
+	get := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://localhost:10000/api/v1/admin/backup", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		r := httptest.NewRecorder()
+		router.ServeHTTP(r, req)
+
+		return r
+	}
+
+	It("sends a backup of every note", func() {
+		snapshot.ListUsersReturns([]string{"Buffy"}, nil)
+		snapshot.EachNoteStub = func(_ context.Context, _ string, fn func(models.Note) error) error {
+			return fn(models.Note{Id: "1", Name: "Stakes", Content: "Pointy.", User: models.User{Username: "Buffy"}})
+		}
+
+		r := get()
+		Expect(r.Code).To(Equal(http.StatusOK))
+		Expect(r.Header().Get("Content-Type")).To(Equal("application/zip"))
+		Expect(r.Header().Get("Content-Disposition")).To(MatchRegexp(`^attachment; filename="notes-backup-\d{8}T\d{6}Z\.zip"$`))
+
+		manifest, err := backup.Verify(bytes.NewReader(r.Body.Bytes()), int64(r.Body.Len()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.Users).To(Equal([]backup.User{{Username: "Buffy", Notes: "users/0/notes.jsonl", Active: 1}}))
+	})
+
+	It("keeps sending a backup that outlasts the server's write timeout", func() {
+		content := make([]byte, 64*1024)
+		_, err := rand.Read(content)
+		Expect(err).NotTo(HaveOccurred())
+
+		snapshot.ListUsersReturns([]string{"Buffy"}, nil)
+		snapshot.EachNoteStub = func(_ context.Context, _ string, fn func(models.Note) error) error {
+			for i := 0; i < 4; i++ {
+				time.Sleep(50 * time.Millisecond)
+				if err := fn(models.Note{Id: fmt.Sprint(i), Name: "Noise", Content: hex.EncodeToString(content), User: models.User{Username: "Buffy"}}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		server := httptest.NewUnstartedServer(router)
+		server.Config.WriteTimeout = 100 * time.Millisecond
+		server.Start()
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/api/v1/admin/backup")
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+
+		manifest, err := backup.Verify(bytes.NewReader(body), int64(len(body)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.Users[0].Active).To(Equal(4))
+	})
+
+	It("answers with JSON when the backup fails before it starts", func() {
+		snapshot.ListUsersReturns(nil, errors.New("disk on fire"))
+
+		r := get()
+		Expect(r.Code).To(Equal(http.StatusInternalServerError))
+		Expect(r.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		var response responses.JsonNoteResponse
+		Expect(json.Unmarshal(r.Body.Bytes(), &response)).To(Succeed())
+		Expect(response.Message).To(Equal("disk on fire"))
+	})
+})
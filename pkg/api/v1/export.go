@@ -0,0 +1,75 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/export"
+	"github.com/m-rcd/notes/pkg/logging"
+	"github.com/m-rcd/notes/pkg/responses"
+)
+
+// Export streams the user's notes as a ZIP of Markdown files.
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "zip" {
+		write(w, responses.BadRequest(fmt.Sprintf("format must be zip, got %q", format)))
+		return
+	}
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	download := &download{w: w, log: logging.FromContext(r.Context()), name: "notes.zip", contentType: "application/zip"}
+	if err := export.Zip(r.Context(), download, h.db, owner); err != nil {
+		log := logging.FromContext(r.Context())
+		if !download.started {
+			write(w, failure(log, err, "failed to export notes"))
+			return
+		}
+
+		// The status has been sent, so the only way left to tell the client
+		// is to break off the download.
+		log.WithError(err).Error("failed to export notes")
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// downloadWait is how long a download may take to send each write, in place
+// of the server's write timeout, which would cut off large files.
+const downloadWait = 30 * time.Second
+
+// download sends the headers of a file with its first bytes, so that
+// failures before then can still be answered with JSON.
+type download struct {
+	w           http.ResponseWriter
+	log         *logrus.Entry
+	name        string
+	contentType string
+	started     bool
+	stuck       bool
+}
+
+func (d *download) Write(p []byte) (int, error) {
+	if !d.started {
+		d.w.Header().Set("Content-Type", d.contentType)
+		d.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.name))
+		d.started = true
+	}
+
+	// Servers that cannot move the deadline keep their own, which is only
+	// logged once per download.
+	if err := http.NewResponseController(d.w).SetWriteDeadline(time.Now().Add(downloadWait)); err != nil && !d.stuck {
+		d.stuck = true
+		d.log.WithError(err).Warn("cannot extend the write deadline of the download")
+	}
+
+	return d.w.Write(p)
+}
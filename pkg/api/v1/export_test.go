@@ -0,0 +1,169 @@
+package v1_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	v1 "github.com/m-rcd/notes/pkg/api/v1"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/logging"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/responses"
+	"github.com/m-rcd/notes/pkg/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("V1 export", func() {
+	var (
+		fake_db *databasefakes.FakeDatabase
+		router  *mux.Router
+	)
+
+	BeforeEach(func() {
+		fake_db = new(databasefakes.FakeDatabase)
+		h := v1.New(fake_db, webhooks.Guard{})
+		router = mux.NewRouter()
+		h.Register(router.PathPrefix(v1.Prefix).Subrouter())
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://localhost:10000"+path, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		r := httptest.NewRecorder()
+		router.ServeHTTP(r, req)
+
+		return r
+	}
+
+	It("sends the user's notes as a ZIP", func() {
+		fake_db.EachNoteStub = func(_ context.Context, _ string, fn func(models.Note) error) error {
+			return fn(models.Note{Id: "1", Name: "Stakes", Content: "Pointy."})
+		}
+
+		r := get("/api/v1/export?username=Buffy&format=zip")
+		Expect(r.Code).To(Equal(http.StatusOK))
+		Expect(r.Header().Get("Content-Type")).To(Equal("application/zip"))
+		Expect(r.Header().Get("Content-Disposition")).To(Equal(`attachment; filename="notes.zip"`))
+
+		archive, err := zip.NewReader(bytes.NewReader(r.Body.Bytes()), int64(r.Body.Len()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archive.File).To(HaveLen(1))
+		Expect(archive.File[0].Name).To(Equal("active/Stakes.md"))
+
+		_, username, _ := fake_db.EachNoteArgsForCall(0)
+		Expect(username).To(Equal("Buffy"))
+	})
+
+	It("rejects other formats", func() {
+		r := get("/api/v1/export?username=Buffy&format=tar")
+		Expect(r.Code).To(Equal(http.StatusBadRequest))
+
+		var response responses.JsonNoteResponse
+		Expect(json.Unmarshal(r.Body.Bytes(), &response)).To(Succeed())
+		Expect(response.Message).To(Equal(`format must be zip, got "tar"`))
+		Expect(fake_db.EachNoteCallCount()).To(BeZero())
+	})
+
+	It("answers with JSON when reading the notes fails before anything was sent", func() {
+		fake_db.EachNoteReturns(errors.New("disk on fire"))
+
+		r := get("/api/v1/export?username=Buffy")
+		Expect(r.Code).To(Equal(http.StatusInternalServerError))
+		Expect(r.Header().Get("Content-Type")).To(Equal("application/json"))
+	})
+
+	It("breaks off the download when reading the notes fails half way", func() {
+		content := make([]byte, 64*1024)
+		_, err := rand.Read(content)
+		Expect(err).NotTo(HaveOccurred())
+
+		fake_db.EachNoteStub = func(_ context.Context, _ string, fn func(models.Note) error) error {
+			if err := fn(models.Note{Id: "1", Name: "Noise", Content: hex.EncodeToString(content)}); err != nil {
+				return err
+			}
+
+			return errors.New("disk on fire")
+		}
+
+		Expect(func() { get("/api/v1/export?username=Buffy") }).To(PanicWith(http.ErrAbortHandler))
+	})
+
+	It("keeps sending a download that outlasts the server's write timeout", func() {
+		content := make([]byte, 64*1024)
+		_, err := rand.Read(content)
+		Expect(err).NotTo(HaveOccurred())
+
+		fake_db.EachNoteStub = func(_ context.Context, _ string, fn func(models.Note) error) error {
+			for i := 0; i < 4; i++ {
+				time.Sleep(50 * time.Millisecond)
+				if err := fn(models.Note{Id: fmt.Sprint(i), Name: fmt.Sprintf("Noise %d", i), Content: hex.EncodeToString(content)}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		server := httptest.NewUnstartedServer(router)
+		server.Config.WriteTimeout = 100 * time.Millisecond
+		server.Start()
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/api/v1/export?username=Buffy")
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+
+		archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archive.File).To(HaveLen(4))
+	})
+
+	It("logs once when the write deadline cannot be moved", func() {
+		logger, hook := test.NewNullLogger()
+		router.Use(logging.Middleware(logger))
+
+		content := make([]byte, 64*1024)
+		_, err := rand.Read(content)
+		Expect(err).NotTo(HaveOccurred())
+
+		fake_db.EachNoteStub = func(_ context.Context, _ string, fn func(models.Note) error) error {
+			for i := 0; i < 4; i++ {
+				if err := fn(models.Note{Id: fmt.Sprint(i), Name: fmt.Sprintf("Noise %d", i), Content: hex.EncodeToString(content)}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		r := get("/api/v1/export?username=Buffy")
+		Expect(r.Code).To(Equal(http.StatusOK))
+
+		var warnings []string
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel {
+				warnings = append(warnings, entry.Message)
+			}
+		}
+		Expect(warnings).To(Equal([]string{"cannot extend the write deadline of the download"}))
+	})
+})
@@ -0,0 +1,57 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/importer"
+	"github.com/m-rcd/notes/pkg/logging"
+	"github.com/m-rcd/notes/pkg/responses"
+)
+
+// Import creates notes from an upload in one of the importer formats, the
+// usual one for its content type unless format says otherwise, and reports on
+// each of them. With dry_run, it only reports what it would do.
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			write(w, responses.BadRequest(fmt.Sprintf("dry_run must be true or false, got %q", value)))
+			return
+		}
+	}
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	items, err := importer.Read(http.MaxBytesReader(w, r.Body, importer.MaxSize), r.URL.Query().Get("format"), mediaType)
+	if errors.Is(err, importer.ErrUnsupported) {
+		response := responses.BadRequest(err.Error())
+		response.StatusCode = http.StatusUnsupportedMediaType
+		write(w, response)
+		return
+	}
+	if err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	results, err := importer.Import(r.Context(), h.db, owner, items, dryRun)
+	if err != nil {
+		write(w, failure(logging.FromContext(r.Context()), err, "failed to import notes"))
+		return
+	}
+
+	response := responses.Imported(results, dryRun)
+	writeJSON(w, response.StatusCode, response)
+}
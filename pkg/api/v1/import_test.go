@@ -0,0 +1,100 @@
+package v1_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	v1 "github.com/m-rcd/notes/pkg/api/v1"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/responses"
+	"github.com/m-rcd/notes/pkg/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("V1 import", func() {
+	var (
+		fake_db *databasefakes.FakeDatabase
+		router  *mux.Router
+	)
+
+	BeforeEach(func() {
+		fake_db = new(databasefakes.FakeDatabase)
+		h := v1.New(fake_db, webhooks.Guard{})
+		router = mux.NewRouter()
+		h.Register(router.PathPrefix(v1.Prefix).Subrouter())
+	})
+
+	post := func(path, contentType, body string, response interface{}) int {
+		req, err := http.NewRequest("POST", "http://localhost:10000"+path, bytes.NewBufferString(body))
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Content-Type", contentType)
+
+		r := httptest.NewRecorder()
+		router.ServeHTTP(r, req)
+		Expect(r.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(json.Unmarshal(r.Body.Bytes(), response)).To(Succeed())
+
+		return r.Code
+	}
+
+	It("creates the notes of the import and reports on them", func() {
+		fake_db.PutNoteStub = func(_ context.Context, note models.Note) (models.Note, bool, error) {
+			Expect(note.User.Username).To(Equal("Buffy"))
+			Expect(note.Tags).To(Equal([]string{"wood"}))
+			Expect(note.Created).To(Equal(time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)))
+			note.Id = "2"
+
+			return note, true, nil
+		}
+
+		var response responses.JsonImportResponse
+		code := post("/api/v1/import?username=Buffy", "application/json; charset=utf-8", `[{"name":"Stakes","content":"Pointy.","tags":["wood"],"created_at":"2020-05-01T10:00:00Z"},{"content":"Nameless"}]`, &response)
+		Expect(code).To(Equal(http.StatusMultiStatus))
+		Expect(response.DryRun).To(BeFalse())
+		Expect(response.Results).To(Equal([]models.ImportResult{
+			{Source: "[0]", Status: "created", Id: "2", Name: "Stakes"},
+			{Source: "[1]", Status: "failed", Reason: "name must be set"},
+		}))
+		Expect(response.Message).To(Equal("1 notes were created, 0 skipped and 1 failed"))
+	})
+
+	It("creates nothing on dry runs", func() {
+		var response responses.JsonImportResponse
+		code := post("/api/v1/import?username=Buffy&dry_run=true", "application/json", `[{"name":"Stakes"}]`, &response)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(response.DryRun).To(BeTrue())
+		Expect(response.Created).To(Equal(1))
+		Expect(fake_db.PutNoteCallCount()).To(BeZero())
+	})
+
+	It("reads the import in the format asked for", func() {
+		var response responses.JsonImportResponse
+		code := post("/api/v1/import?username=Buffy&dry_run=true", "application/xml",
+			`<en-export><note><title>Patrol</title><content><![CDATA[<en-note><div>Cemetery</div></en-note>]]></content></note></en-export>`, &response)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(response.Results).To(Equal([]models.ImportResult{{Source: "[0] Patrol", Status: "created", Name: "Patrol"}}))
+
+		var failed responses.JsonNoteResponse
+		Expect(post("/api/v1/import?username=Buffy&format=keep", "application/json", "[]", &failed)).To(Equal(http.StatusUnsupportedMediaType))
+		Expect(failed.Message).To(Equal(`unsupported import "application/json" for the keep format, expected application/zip, application/x-tar or application/gzip`))
+	})
+
+	It("rejects imports it cannot read", func() {
+		var response responses.JsonNoteResponse
+		Expect(post("/api/v1/import?username=Buffy", "text/csv", "name,content", &response)).To(Equal(http.StatusUnsupportedMediaType))
+
+		Expect(post("/api/v1/import?username=Buffy", "application/zip", "not a zip", &response)).To(Equal(http.StatusBadRequest))
+		Expect(response.Message).To(Equal("invalid import: zip: not a valid zip file"))
+
+		Expect(post("/api/v1/import?username=Buffy&dry_run=maybe", "application/json", "[]", &response)).To(Equal(http.StatusBadRequest))
+		Expect(response.Message).To(Equal(`dry_run must be true or false, got "maybe"`))
+	})
+})
@@ -0,0 +1,149 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/logging"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/responses"
+	"github.com/m-rcd/notes/pkg/utils"
+)
+
+// maxChanges bounds how many changes a client can upload at once.
+const maxChanges = 1000
+
+// SyncRequest lists the changes a client made while offline, each on top of
+// the version of the note it last synced.
+type SyncRequest struct {
+	Changes []models.Change `json:"changes"`
+}
+
+// Sync lists the changes to the user's notes since the `since` token of
+// their last sync, or every note without one, and gives the token for the
+// next sync.
+func (h *Handler) Sync(w http.ResponseWriter, r *http.Request) {
+	since, err := parseToken(r.URL.Query().Get("since"))
+	if err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	changes, latest, err := h.db.Changes(r.Context(), owner, since)
+	if err != nil {
+		write(w, failure(logging.FromContext(r.Context()), err, "failed to list changes"))
+		return
+	}
+
+	// A token the server never gave out means its notes were reset, and the
+	// client has to start over.
+	if since > latest {
+		response := responses.Failure("since is newer than the latest change, sync again without it")
+		response.StatusCode = http.StatusGone
+		write(w, response)
+		return
+	}
+
+	if changes == nil {
+		changes = []models.Change{}
+	}
+
+	writeJSON(w, http.StatusOK, responses.Changes(changes, strconv.FormatUint(latest, 10)))
+}
+
+// UploadChanges applies the changes a client made while offline. Changes to
+// notes that changed since the client's version are not applied and are
+// answered with the note as it now is, for the client to resolve.
+func (h *Handler) UploadChanges(w http.ResponseWriter, r *http.Request) {
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	var request SyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	if err := validateChanges(request.Changes, owner); err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	results, err := h.db.ApplyChanges(r.Context(), owner, request.Changes)
+	if err != nil {
+		write(w, failure(logging.FromContext(r.Context()), err, "failed to apply changes"))
+		return
+	}
+
+	response := responses.ChangeResults(results)
+	writeJSON(w, response.StatusCode, response)
+}
+
+// validateChanges checks the uploaded changes and makes their notes owner's.
+func validateChanges(changes []models.Change, owner string) error {
+	if len(changes) == 0 {
+		return errors.New("give at least one change")
+	}
+
+	if len(changes) > maxChanges {
+		return fmt.Errorf("at most %d changes can be uploaded at once", maxChanges)
+	}
+
+	for i := range changes {
+		change := &changes[i]
+
+		if change.Deleted {
+			if !utils.IsSet(change.Id) {
+				return fmt.Errorf("change %d: a new note cannot be deleted", i)
+			}
+
+			change.Note = nil
+			continue
+		}
+
+		if change.Note == nil {
+			return fmt.Errorf("change %d: note must be set", i)
+		}
+
+		if !utils.IsSet(change.Note.Name) {
+			return fmt.Errorf("change %d: name must be set", i)
+		}
+
+		if utils.IsSet(change.Note.User.Username) && change.Note.User.Username != owner {
+			return fmt.Errorf("change %d: user does not match the sync", i)
+		}
+
+		change.Note.Id = change.Id
+		change.Note.User = models.User{Username: owner}
+	}
+
+	return nil
+}
+
+func parseToken(token string) (uint64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	since, err := strconv.ParseUint(token, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("since must be a token given by an earlier sync, got %q", token)
+	}
+
+	return since, nil
+}
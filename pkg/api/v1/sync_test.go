@@ -0,0 +1,153 @@
+package v1_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+
+	v1 "github.com/m-rcd/notes/pkg/api/v1"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/responses"
+	"github.com/m-rcd/notes/pkg/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("V1 sync", func() {
+	var (
+		fake_db *databasefakes.FakeDatabase
+		router  *mux.Router
+		note    = models.Note{Id: "1", Name: "Note", Content: "Slayer", User: models.User{Username: "Buffy"}}
+	)
+
+	BeforeEach(func() {
+		fake_db = new(databasefakes.FakeDatabase)
+		h := v1.New(fake_db, webhooks.Guard{})
+		router = mux.NewRouter()
+		h.Register(router.PathPrefix(v1.Prefix).Subrouter())
+	})
+
+	serve := func(method, path, body string, response interface{}) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "http://localhost:10000"+path, bytes.NewBufferString(body))
+		Expect(err).NotTo(HaveOccurred())
+
+		r := httptest.NewRecorder()
+		router.ServeHTTP(r, req)
+		Expect(r.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(json.Unmarshal(r.Body.Bytes(), response)).To(Succeed())
+
+		return r
+	}
+
+	Context("#Sync", func() {
+		It("lists the changes since the token and gives the next one", func() {
+			changes := []models.Change{{Id: "1", Version: 6, Note: &note}, {Id: "2", Version: 7, Deleted: true}}
+			fake_db.ChangesReturns(changes, 7, nil)
+
+			var response responses.JsonSyncResponse
+			r := serve("GET", "/api/v1/sync?username=Buffy&since=5", "", &response)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.Changes).To(Equal(changes))
+			Expect(response.Token).To(Equal("7"))
+
+			_, username, since := fake_db.ChangesArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+			Expect(since).To(Equal(uint64(5)))
+		})
+
+		It("lists every note without a token", func() {
+			fake_db.ChangesReturns(nil, 0, nil)
+
+			var response responses.JsonSyncResponse
+			r := serve("GET", "/api/v1/sync?username=Buffy", "", &response)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.Changes).To(BeEmpty())
+			Expect(response.Token).To(Equal("0"))
+
+			_, _, since := fake_db.ChangesArgsForCall(0)
+			Expect(since).To(BeZero())
+		})
+
+		It("rejects tokens it did not give out", func() {
+			var response responses.JsonNoteResponse
+			r := serve("GET", "/api/v1/sync?username=Buffy&since=soon", "", &response)
+			Expect(r.Code).To(Equal(http.StatusBadRequest))
+			Expect(response.Message).To(Equal(`since must be a token given by an earlier sync, got "soon"`))
+			Expect(fake_db.ChangesCallCount()).To(BeZero())
+		})
+
+		It("asks the client to start over when the token is newer than every change", func() {
+			fake_db.ChangesReturns(nil, 3, nil)
+
+			var response responses.JsonNoteResponse
+			r := serve("GET", "/api/v1/sync?username=Buffy&since=5", "", &response)
+			Expect(r.Code).To(Equal(http.StatusGone))
+			Expect(response.Message).To(Equal("since is newer than the latest change, sync again without it"))
+		})
+
+		It("reports storage failures", func() {
+			fake_db.ChangesReturns(nil, 0, errors.New("disk on fire"))
+
+			var response responses.JsonNoteResponse
+			r := serve("GET", "/api/v1/sync?username=Buffy", "", &response)
+			Expect(r.Code).To(Equal(http.StatusInternalServerError))
+			Expect(response.Message).To(Equal("disk on fire"))
+		})
+	})
+
+	Context("#UploadChanges", func() {
+		It("applies the changes for the user and reports conflicts", func() {
+			results := []models.ChangeResult{
+				{Change: models.Change{Id: "1", Version: 8, Note: &note}},
+				{Change: models.Change{Id: "2", Version: 7, Deleted: true}, Conflict: true},
+			}
+			fake_db.ApplyChangesReturns(results, nil)
+
+			var response responses.JsonChangeResultsResponse
+			r := serve("POST", "/api/v1/sync?username=Buffy", `{"changes":[{"id":"1","version":6,"note":{"name":"Note","content":"Slayer"}},{"id":"2","version":5,"note":{"name":"Other"}}]}`, &response)
+			Expect(r.Code).To(Equal(http.StatusMultiStatus))
+			Expect(response.Results).To(Equal(results))
+			Expect(response.Message).To(Equal("1 of 2 changes were applied"))
+
+			_, username, changes := fake_db.ApplyChangesArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+			Expect(changes).To(Equal([]models.Change{
+				{Id: "1", Version: 6, Note: &models.Note{Id: "1", Name: "Note", Content: "Slayer", User: models.User{Username: "Buffy"}}},
+				{Id: "2", Version: 5, Note: &models.Note{Id: "2", Name: "Other", User: models.User{Username: "Buffy"}}},
+			}))
+		})
+
+		It("drops the note of deletes", func() {
+			fake_db.ApplyChangesReturns([]models.ChangeResult{{Change: models.Change{Id: "1", Version: 9, Deleted: true}}}, nil)
+
+			var response responses.JsonChangeResultsResponse
+			r := serve("POST", "/api/v1/sync?username=Buffy", `{"changes":[{"id":"1","version":8,"deleted":true,"note":{"name":"Note"}}]}`, &response)
+			Expect(r.Code).To(Equal(http.StatusOK))
+
+			_, _, changes := fake_db.ApplyChangesArgsForCall(0)
+			Expect(changes).To(Equal([]models.Change{{Id: "1", Version: 8, Deleted: true}}))
+		})
+
+		It("rejects invalid uploads", func() {
+			for body, message := range map[string]string{
+				`{"changes":[]}`:                                                     "give at least one change",
+				`{"changes":[{"deleted":true}]}`:                                     "change 0: a new note cannot be deleted",
+				`{"changes":[{"id":"1","version":2}]}`:                               "change 0: note must be set",
+				`{"changes":[{"note":{"content":"Slayer"}}]}`:                        "change 0: name must be set",
+				`{"changes":[{"note":{"name":"Note","user":{"username":"Faith"}}}]}`: "change 0: user does not match the sync",
+			} {
+				var response responses.JsonNoteResponse
+				r := serve("POST", "/api/v1/sync?username=Buffy", body, &response)
+				Expect(r.Code).To(Equal(http.StatusBadRequest), body)
+				Expect(response.Message).To(Equal(message))
+			}
+
+			Expect(fake_db.ApplyChangesCallCount()).To(BeZero())
+		})
+	})
+})
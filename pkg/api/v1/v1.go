@@ -0,0 +1,343 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/bulk"
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/logging"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/patch"
+	"github.com/m-rcd/notes/pkg/responses"
+	"github.com/m-rcd/notes/pkg/webhooks"
+)
+
+const Prefix = "/api/v1"
+
+const (
+	stateActive   = "active"
+	stateArchived = "archived"
+)
+
+type Handler struct {
+	db    database.Database
+	guard webhooks.Guard
+}
+
+// New makes the v1 handlers. guard checks the URLs webhooks are subscribed
+// with.
+func New(db database.Database, guard webhooks.Guard) Handler {
+	return Handler{
+		db:    db,
+		guard: guard,
+	}
+}
+
+// Register adds the v1 routes to router, which is expected to be mounted at
+// Prefix.
+func (h *Handler) Register(router *mux.Router) {
+	router.HandleFunc("/notes", h.CreateNote).Methods("POST")
+	router.HandleFunc("/notes/active", h.ListActiveNotes).Methods("GET")
+	router.HandleFunc("/notes/archived", h.ListArchivedNotes).Methods("GET")
+	router.HandleFunc("/notes/{id}", h.UpdateNote).Methods("PATCH")
+	router.HandleFunc("/notes/{id}", h.ReplaceNote).Methods("PUT")
+	router.HandleFunc("/notes/{id}/archive", h.ArchiveNote).Methods("POST")
+	router.HandleFunc("/notes/{id}/unarchive", h.UnarchiveNote).Methods("POST")
+	router.HandleFunc("/notes/archive", h.ArchiveNotes).Methods("POST")
+	router.HandleFunc("/notes/unarchive", h.UnarchiveNotes).Methods("POST")
+	router.HandleFunc("/notes/batch", h.BatchNotes).Methods("POST")
+	router.HandleFunc("/notes/{id}", h.DeleteNote).Methods("DELETE")
+	router.HandleFunc("/users/{username}/notes", h.ListNotes).Methods("GET")
+	router.HandleFunc("/users/{username}/notes/{id}", h.DeleteNote).Methods("DELETE")
+	router.HandleFunc("/sync", h.Sync).Methods("GET")
+	router.HandleFunc("/sync", h.UploadChanges).Methods("POST")
+	router.HandleFunc("/export", h.Export).Methods("GET")
+	router.HandleFunc("/import", h.Import).Methods("POST")
+
+	// Webhooks make the server send requests on the caller's behalf, so only
+	// callers with a client certificate can subscribe them.
+	hooks := router.PathPrefix("/webhooks").Subrouter()
+	hooks.Use(auth.Authenticated)
+	hooks.HandleFunc("", h.CreateWebhook).Methods("POST")
+	hooks.HandleFunc("", h.ListWebhooks).Methods("GET")
+	hooks.HandleFunc("/{id}", h.DeleteWebhook).Methods("DELETE")
+	hooks.HandleFunc("/{id}/deliveries", h.ListDeliveries).Methods("GET")
+}
+
+func (h *Handler) CreateNote(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	if err := auth.CheckBody(r.Context(), body); err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	note, err := h.db.Create(r.Context(), ioutil.NopCloser(bytes.NewReader(body)))
+	if err != nil {
+		write(w, failure(logging.FromContext(r.Context()), err, "failed to create note"))
+		return
+	}
+
+	logging.SetUser(r.Context(), note.User.Username)
+	response := responses.Success([]models.Note{note}, "The note was successfully created")
+	response.StatusCode = http.StatusCreated
+	write(w, response)
+}
+
+// UpdateNote applies a JSON Merge Patch or JSON Patch when the request says
+// so, and otherwise updates the fields that are set. Only the owner's notes
+// can be updated.
+func (h *Handler) UpdateNote(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	var change patch.Func
+	if patch.Supports(r.Header.Get("Content-Type")) {
+		change, err = patch.New(r.Header.Get("Content-Type"), body)
+	} else {
+		change, err = patch.Fields(body)
+	}
+	if err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	h.applyChange(w, r, id, owner, change, "The note was successfully updated")
+}
+
+// ReplaceNote replaces the whole note; fields that are left out are cleared.
+func (h *Handler) ReplaceNote(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	var note models.Note
+	if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	h.applyChange(w, r, id, owner, patch.Replace(note), "The note was successfully updated")
+}
+
+func (h *Handler) ArchiveNote(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, true, "The note was successfully archived")
+}
+
+func (h *Handler) UnarchiveNote(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, false, "The note was successfully unarchived")
+}
+
+func (h *Handler) setArchived(w http.ResponseWriter, r *http.Request, archived bool, message string) {
+	id := mux.Vars(r)["id"]
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	h.applyChange(w, r, id, owner, patch.Archived(archived), message)
+}
+
+func (h *Handler) ArchiveNotes(w http.ResponseWriter, r *http.Request) {
+	h.setArchivedBulk(w, r, true, "archived")
+}
+
+func (h *Handler) UnarchiveNotes(w http.ResponseWriter, r *http.Request) {
+	h.setArchivedBulk(w, r, false, "unarchived")
+}
+
+// setArchivedBulk changes the notes selected by the request and answers with
+// a result for each of them.
+func (h *Handler) setArchivedBulk(w http.ResponseWriter, r *http.Request, archived bool, done string) {
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	request, err := bulk.Decode(r.Body)
+	if err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	results, err := bulk.SetArchived(r.Context(), h.db, owner, request, archived)
+	if err != nil {
+		write(w, failure(logging.FromContext(r.Context()), err, "failed to change notes"))
+		return
+	}
+
+	writeResults(w, responses.Results(results, done))
+}
+
+// BatchNotes runs a list of creates, updates and deletes together.
+func (h *Handler) BatchNotes(w http.ResponseWriter, r *http.Request) {
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	operations, err := bulk.DecodeBatch(r.Body, owner)
+	if err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	results, err := bulk.Batch(r.Context(), h.db, owner, operations)
+
+	var batchErr *database.BatchError
+	switch {
+	case errors.As(err, &batchErr):
+		logging.FromContext(r.Context()).WithError(err).Warn("batch not applied")
+		writeResults(w, responses.NotApplied(results, err))
+	case err != nil:
+		write(w, failure(logging.FromContext(r.Context()), err, "failed to apply batch"))
+	default:
+		writeResults(w, responses.Applied(results))
+	}
+}
+
+func (h *Handler) applyChange(w http.ResponseWriter, r *http.Request, id string, owner string, change patch.Func, message string) {
+	logging.SetUser(r.Context(), owner)
+
+	note, err := h.db.Patch(r.Context(), id, owner, change)
+	if err != nil {
+		write(w, failure(logging.FromContext(r.Context()).WithField("id", id), err, "failed to update note"))
+		return
+	}
+
+	write(w, responses.Success([]models.Note{note}, message))
+}
+
+func (h *Handler) DeleteNote(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	if err := h.db.Delete(r.Context(), id, owner); err != nil {
+		write(w, failure(logging.FromContext(r.Context()).WithField("id", id), err, "failed to delete note"))
+		return
+	}
+
+	write(w, responses.Success([]models.Note{}, "The note was successfully deleted"))
+}
+
+func (h *Handler) ListActiveNotes(w http.ResponseWriter, r *http.Request) {
+	h.listNotes(w, r, stateActive)
+}
+
+func (h *Handler) ListArchivedNotes(w http.ResponseWriter, r *http.Request) {
+	h.listNotes(w, r, stateArchived)
+}
+
+// ListNotes lists the notes in the state given by the `state` query
+// parameter, active unless stated otherwise.
+func (h *Handler) ListNotes(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "":
+		state = stateActive
+	case stateActive, stateArchived:
+	default:
+		write(w, responses.BadRequest(fmt.Sprintf("state must be %q or %q", stateActive, stateArchived)))
+		return
+	}
+
+	h.listNotes(w, r, state)
+}
+
+func (h *Handler) listNotes(w http.ResponseWriter, r *http.Request, state string) {
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+
+	list := h.db.ListActiveNotes
+	if state == stateArchived {
+		list = h.db.ListArchivedNotes
+	}
+
+	notes, err := list(r.Context(), owner)
+	if err != nil {
+		write(w, failure(logging.FromContext(r.Context()), err, fmt.Sprintf("failed to list %s notes", state)))
+		return
+	}
+
+	write(w, responses.Success(orEmpty(notes), fmt.Sprintf("The %s notes were successfully listed", state)))
+}
+
+func failure(log *logrus.Entry, err error, message string) responses.JsonNoteResponse {
+	if errors.Is(err, context.Canceled) {
+		log.WithError(err).Warn(message + ": request cancelled")
+	} else {
+		log.WithError(err).Error(message)
+	}
+
+	return responses.Error(err)
+}
+
+// write sends the response with its status code as the HTTP status, so that
+// every v1 route answers with the same envelope.
+func write(w http.ResponseWriter, response responses.JsonNoteResponse) {
+	writeJSON(w, response.StatusCode, response)
+}
+
+func writeResults(w http.ResponseWriter, response responses.JsonResultsResponse) {
+	writeJSON(w, response.StatusCode, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func orEmpty(notes []models.Note) []models.Note {
+	if notes == nil {
+		return []models.Note{}
+	}
+
+	return notes
+}
@@ -0,0 +1,348 @@
+package v1_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+
+	v1 "github.com/m-rcd/notes/pkg/api/v1"
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/patch"
+	"github.com/m-rcd/notes/pkg/responses"
+	"github.com/m-rcd/notes/pkg/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("V1", func() {
+	var (
+		fake_db *databasefakes.FakeDatabase
+		router  *mux.Router
+		note    = models.Note{Id: "1", Name: "Vampires", Content: "I SLAY", User: models.User{Username: "Buffy"}}
+	)
+
+	BeforeEach(func() {
+		fake_db = new(databasefakes.FakeDatabase)
+		h := v1.New(fake_db, webhooks.Guard{})
+		router = mux.NewRouter()
+		h.Register(router.PathPrefix(v1.Prefix).Subrouter())
+	})
+
+	serveAs := func(contentType, method, path, body string) (*httptest.ResponseRecorder, responses.JsonNoteResponse) {
+		req, err := http.NewRequest(method, "http://localhost:10000"+path, bytes.NewBufferString(body))
+		Expect(err).NotTo(HaveOccurred())
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		r := httptest.NewRecorder()
+		router.ServeHTTP(r, req)
+		Expect(r.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		var response responses.JsonNoteResponse
+		Expect(json.Unmarshal(r.Body.Bytes(), &response)).To(Succeed())
+		Expect(response.StatusCode).To(Equal(r.Code))
+
+		return r, response
+	}
+
+	serve := func(method, path, body string) (*httptest.ResponseRecorder, responses.JsonNoteResponse) {
+		return serveAs("", method, path, body)
+	}
+
+	Context("#CreateNote", func() {
+		It("responds with the created note", func() {
+			fake_db.CreateReturns(note, nil)
+
+			r, response := serve("POST", "/api/v1/notes", `{"name":"Vampires","content":"I SLAY","user":{"username":"Buffy"}}`)
+			Expect(r.Code).To(Equal(http.StatusCreated))
+			Expect(response.Type).To(Equal("success"))
+			Expect(response.Data).To(Equal([]models.Note{note}))
+			Expect(response.Message).To(Equal("The note was successfully created"))
+		})
+
+		It("sends failures with their status", func() {
+			fake_db.CreateReturns(models.Note{}, errors.New("disk on fire"))
+
+			r, response := serve("POST", "/api/v1/notes", `{"name":"Vampires","user":{"username":"Buffy"}}`)
+			Expect(r.Code).To(Equal(http.StatusInternalServerError))
+			Expect(response.Type).To(Equal("failed"))
+			Expect(response.Message).To(Equal("disk on fire"))
+		})
+
+		It("rejects the notes the backend refuses as bad requests", func() {
+			fake_db.CreateReturns(models.Note{}, database.Invalid(errors.New("name must be set")))
+
+			r, response := serve("POST", "/api/v1/notes", `{"user":{"username":"Buffy"}}`)
+			Expect(r.Code).To(Equal(http.StatusBadRequest))
+			Expect(response.Message).To(Equal("name must be set"))
+		})
+
+		It("rejects notes an authenticated user creates for someone else", func() {
+			req, err := http.NewRequest("POST", "http://localhost:10000/api/v1/notes", bytes.NewBufferString(`{"name":"Vampires","user":{"username":"Spike"}}`))
+			Expect(err).NotTo(HaveOccurred())
+			req = req.WithContext(auth.WithUser(req.Context(), models.User{Username: "Buffy"}))
+
+			r := httptest.NewRecorder()
+			router.ServeHTTP(r, req)
+			Expect(r.Code).To(Equal(http.StatusForbidden))
+			Expect(fake_db.CreateCallCount()).To(BeZero())
+		})
+	})
+
+	Context("#UpdateNote", func() {
+		It("updates the fields that are set on the owner's note with the id in the path", func() {
+			fake_db.PatchStub = func(_ context.Context, _ string, _ string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+				return apply(note)
+			}
+
+			r, response := serve("PATCH", "/api/v1/notes/1", `{"content":"I STAKE","archived":true,"user":{"username":"Buffy"}}`)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.Data[0].Name).To(Equal(note.Name))
+			Expect(response.Data[0].Content).To(Equal("I STAKE"))
+			Expect(response.Data[0].Archived).To(BeTrue())
+
+			_, id, username, _ := fake_db.PatchArgsForCall(0)
+			Expect(id).To(Equal("1"))
+			Expect(username).To(Equal("Buffy"))
+		})
+
+		It("does not find the notes of other users", func() {
+			fake_db.PatchReturns(models.Note{}, database.ErrNoteNotFound)
+
+			r, response := serve("PATCH", "/api/v1/notes/1", `{"content":"I STAKE","user":{"username":"Spike"}}`)
+			Expect(r.Code).To(Equal(http.StatusNotFound))
+			Expect(response.Message).To(Equal("note does not exist"))
+
+			_, _, username, _ := fake_db.PatchArgsForCall(0)
+			Expect(username).To(Equal("Spike"))
+		})
+
+		It("needs an owner", func() {
+			r, _ := serve("PATCH", "/api/v1/notes/1", `{"content":"I STAKE"}`)
+			Expect(r.Code).To(Equal(http.StatusBadRequest))
+			Expect(fake_db.PatchCallCount()).To(Equal(0))
+		})
+
+		Context("with a patch document", func() {
+			BeforeEach(func() {
+				fake_db.PatchStub = func(_ context.Context, _ string, _ string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+					return apply(note)
+				}
+			})
+
+			It("applies a JSON Merge Patch", func() {
+				r, response := serveAs("application/merge-patch+json", "PATCH", "/api/v1/notes/1?username=Buffy", `{"content":null}`)
+				Expect(r.Code).To(Equal(http.StatusOK))
+				Expect(response.Data[0].Content).To(BeEmpty())
+				Expect(response.Data[0].Name).To(Equal(note.Name))
+
+				_, id, username, _ := fake_db.PatchArgsForCall(0)
+				Expect(id).To(Equal("1"))
+				Expect(username).To(Equal("Buffy"))
+			})
+
+			It("applies a JSON Patch", func() {
+				r, response := serveAs("application/json-patch+json", "PATCH", "/api/v1/notes/1?username=Buffy", `[{"op":"replace","path":"/archived","value":true}]`)
+				Expect(r.Code).To(Equal(http.StatusOK))
+				Expect(response.Data[0].Archived).To(BeTrue())
+			})
+
+			It("rejects patches that cannot be applied", func() {
+				r, response := serveAs("application/json-patch+json", "PATCH", "/api/v1/notes/1?username=Buffy", `[{"op":"test","path":"/content","value":"I STAKE"}]`)
+				Expect(r.Code).To(Equal(http.StatusBadRequest))
+				Expect(response.Message).To(HavePrefix("invalid patch"))
+			})
+
+			It("rejects malformed patches without touching the note", func() {
+				r, _ := serveAs("application/json-patch+json", "PATCH", "/api/v1/notes/1?username=Buffy", `{"op":"remove"}`)
+				Expect(r.Code).To(Equal(http.StatusBadRequest))
+				Expect(fake_db.PatchCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("#ArchiveNote", func() {
+		It("archives the note and nothing else", func() {
+			fake_db.PatchStub = func(_ context.Context, _ string, _ string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+				return apply(note)
+			}
+
+			r, response := serve("POST", "/api/v1/notes/1/archive?username=Buffy", "")
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.Message).To(Equal("The note was successfully archived"))
+
+			archived := note
+			archived.Archived = true
+			Expect(response.Data).To(Equal([]models.Note{archived}))
+
+			_, id, username, _ := fake_db.PatchArgsForCall(0)
+			Expect(id).To(Equal("1"))
+			Expect(username).To(Equal("Buffy"))
+		})
+	})
+
+	Context("#ArchiveNotes", func() {
+		It("reports on each note", func() {
+			fake_db.SetArchivedReturns([]models.NoteResult{{Id: "1", Note: &note}, {Id: "2", Error: "note does not exist"}}, nil)
+
+			r, _ := serve("POST", "/api/v1/notes/unarchive", `{"username":"Buffy","ids":["1","2"]}`)
+			Expect(r.Code).To(Equal(http.StatusMultiStatus))
+
+			var response responses.JsonResultsResponse
+			Expect(json.Unmarshal(r.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Type).To(Equal("partial"))
+			Expect(response.Message).To(Equal("1 of 2 notes were unarchived"))
+			Expect(response.Results[1]).To(Equal(models.NoteResult{Id: "2", Error: "note does not exist"}))
+
+			_, username, ids, archived := fake_db.SetArchivedArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+			Expect(ids).To(Equal([]string{"1", "2"}))
+			Expect(archived).To(BeFalse())
+		})
+
+		It("rejects requests that select no notes", func() {
+			r, response := serve("POST", "/api/v1/notes/archive", `{"username":"Buffy"}`)
+			Expect(r.Code).To(Equal(http.StatusBadRequest))
+			Expect(response.Message).To(Equal("invalid bulk request: give either ids or a filter"))
+			Expect(fake_db.SetArchivedCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("#BatchNotes", func() {
+		It("applies the operations for the user", func() {
+			fake_db.BatchReturns([]models.NoteResult{{Id: "1", Note: &note}, {Id: "2"}}, nil)
+
+			r, _ := serve("POST", "/api/v1/notes/batch?username=Buffy", `{"operations":[{"op":"create","note":{"name":"Vampires"}},{"op":"delete","id":"2"}]}`)
+			Expect(r.Code).To(Equal(http.StatusOK))
+
+			var response responses.JsonResultsResponse
+			Expect(json.Unmarshal(r.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Message).To(Equal("The 2 operations were successfully applied"))
+			Expect(response.Results).To(HaveLen(2))
+
+			_, username, operations := fake_db.BatchArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+			Expect(operations).To(HaveLen(2))
+			Expect(operations[0].Note.User.Username).To(Equal("Buffy"))
+		})
+
+		It("reports the operation that stopped the batch", func() {
+			fake_db.BatchReturns(nil, &database.BatchError{Index: 0, Err: fmt.Errorf("%w: name must be set", patch.ErrInvalid)})
+
+			r, _ := serve("POST", "/api/v1/notes/batch?username=Buffy", `{"operations":[{"op":"update","id":"1","note":{"name":null}},{"op":"delete","id":"2"}]}`)
+			Expect(r.Code).To(Equal(http.StatusBadRequest))
+
+			var response responses.JsonResultsResponse
+			Expect(json.Unmarshal(r.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Type).To(Equal("failed"))
+			Expect(response.Results).To(Equal([]models.NoteResult{
+				{Id: "1", Error: "invalid patch: name must be set"},
+				{Id: "2", Error: "not applied"},
+			}))
+		})
+
+		It("rejects invalid batches", func() {
+			r, response := serve("POST", "/api/v1/notes/batch?username=Buffy", `{"operations":[{"op":"delete"}]}`)
+			Expect(r.Code).To(Equal(http.StatusBadRequest))
+			Expect(response.Message).To(Equal("invalid bulk request: operation 0: id must be set"))
+			Expect(fake_db.BatchCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("#ReplaceNote", func() {
+		It("replaces the whole note", func() {
+			fake_db.PatchStub = func(_ context.Context, _ string, _ string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+				return apply(note)
+			}
+
+			r, response := serve("PUT", "/api/v1/notes/1", `{"name":"Slayers","user":{"username":"Buffy"}}`)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.Data).To(Equal([]models.Note{{Id: note.Id, Name: "Slayers", User: models.User{Username: "Buffy"}}}))
+
+			_, _, username, _ := fake_db.PatchArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+		})
+	})
+
+	Context("#DeleteNote", func() {
+		It("deletes the note of the user in the path", func() {
+			r, _ := serve("DELETE", "/api/v1/users/Buffy/notes/1", "")
+			Expect(r.Code).To(Equal(http.StatusOK))
+
+			_, id, username := fake_db.DeleteArgsForCall(0)
+			Expect(id).To(Equal("1"))
+			Expect(username).To(Equal("Buffy"))
+		})
+
+		It("reports timeouts", func() {
+			fake_db.DeleteReturns(context.DeadlineExceeded)
+
+			r, response := serve("DELETE", "/api/v1/notes/1", `{"username":"Buffy"}`)
+			Expect(r.Code).To(Equal(http.StatusGatewayTimeout))
+			Expect(response.Message).To(Equal("the storage operation timed out"))
+		})
+	})
+
+	Context("#ListActiveNotes", func() {
+		It("wraps the notes in the envelope", func() {
+			fake_db.ListActiveNotesReturns([]models.Note{note}, nil)
+
+			r, response := serve("GET", "/api/v1/notes/active", `{"username":"Buffy"}`)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.Type).To(Equal("success"))
+			Expect(response.Data).To(Equal([]models.Note{note}))
+		})
+	})
+
+	Context("#ListNotes", func() {
+		It("lists the notes of the user in the path", func() {
+			fake_db.ListArchivedNotesReturns([]models.Note{note}, nil)
+
+			r, response := serve("GET", "/api/v1/users/Buffy/notes?state=archived", "")
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.Data).To(Equal([]models.Note{note}))
+			Expect(response.Message).To(Equal("The archived notes were successfully listed"))
+
+			_, username := fake_db.ListArchivedNotesArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+		})
+
+		It("lists active notes by default", func() {
+			serve("GET", "/api/v1/users/Buffy/notes", "")
+			Expect(fake_db.ListActiveNotesCallCount()).To(Equal(1))
+		})
+
+		It("rejects unknown states", func() {
+			r, response := serve("GET", "/api/v1/users/Buffy/notes?state=deleted", "")
+			Expect(r.Code).To(Equal(http.StatusBadRequest))
+			Expect(response.Message).To(Equal(`state must be "active" or "archived"`))
+			Expect(fake_db.ListActiveNotesCallCount()).To(Equal(0))
+		})
+
+		It("rejects requests that do not name a user", func() {
+			r, response := serve("GET", "/api/v1/notes/active", "")
+			Expect(r.Code).To(Equal(http.StatusBadRequest))
+			Expect(response.Message).To(Equal("user must be set"))
+		})
+	})
+
+	Context("#ListArchivedNotes", func() {
+		It("lists no notes as an empty list", func() {
+			fake_db.ListArchivedNotesReturns(nil, nil)
+
+			_, response := serve("GET", "/api/v1/notes/archived", `{"username":"Buffy"}`)
+			Expect(response.Data).NotTo(BeNil())
+			Expect(response.Data).To(BeEmpty())
+		})
+	})
+})
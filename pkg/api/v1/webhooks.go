@@ -0,0 +1,161 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/logging"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/responses"
+	"github.com/m-rcd/notes/pkg/utils"
+	"github.com/m-rcd/notes/pkg/webhooks"
+)
+
+const stateDead = "dead"
+
+// CreateWebhook subscribes a URL to events of the user's notes. A secret is
+// made up when the request has none; either way it is only shown here.
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return
+	}
+
+	var webhook models.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+	webhook.Id = ""
+	webhook.User = models.User{Username: owner}
+
+	if err := webhooks.Validate(webhook); err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	if err := h.guard.CheckURL(r.Context(), webhook.URL); err != nil {
+		write(w, responses.BadRequest(err.Error()))
+		return
+	}
+
+	if !utils.IsSet(webhook.Secret) {
+		if webhook.Secret, err = webhooks.NewSecret(); err != nil {
+			write(w, failure(logging.FromContext(r.Context()), err, "failed to make webhook secret"))
+			return
+		}
+	}
+
+	logging.SetUser(r.Context(), owner)
+	created, err := h.db.CreateWebhook(r.Context(), webhook)
+	if err != nil {
+		write(w, failure(logging.FromContext(r.Context()), err, "failed to create webhook"))
+		return
+	}
+
+	response := responses.Webhooks([]models.Webhook{created}, "The webhook was successfully created")
+	response.StatusCode = http.StatusCreated
+	writeJSON(w, response.StatusCode, response)
+}
+
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	list, ok := h.webhooks(w, r)
+	if !ok {
+		return
+	}
+
+	for i := range list {
+		list[i].Secret = ""
+	}
+
+	writeJSON(w, http.StatusOK, responses.Webhooks(list, "The webhooks were successfully listed"))
+}
+
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.webhook(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeleteWebhook(r.Context(), webhook.Id, webhook.User.Username); err != nil {
+		write(w, failure(logging.FromContext(r.Context()).WithField("webhook", webhook.Id), err, "failed to delete webhook"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, responses.Webhooks([]models.Webhook{}, "The webhook was successfully deleted"))
+}
+
+// ListDeliveries lists the attempts at delivering events to a webhook, or
+// only the dead ones with `?state=dead`.
+func (h *Handler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if state != "" && state != stateDead {
+		write(w, responses.BadRequest(fmt.Sprintf("state must be %q", stateDead)))
+		return
+	}
+
+	webhook, ok := h.webhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.db.ListDeliveries(r.Context(), webhook.Id, webhook.User.Username)
+	if err != nil {
+		write(w, failure(logging.FromContext(r.Context()).WithField("webhook", webhook.Id), err, "failed to list deliveries"))
+		return
+	}
+
+	listed := []models.Delivery{}
+	for _, delivery := range deliveries {
+		if state != stateDead || delivery.Dead {
+			listed = append(listed, delivery)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, responses.Deliveries(listed, "The deliveries were successfully listed"))
+}
+
+func (h *Handler) webhooks(w http.ResponseWriter, r *http.Request) ([]models.Webhook, bool) {
+	owner, err := auth.Owner(r)
+	if err != nil {
+		write(w, auth.OwnerFailure(err))
+		return nil, false
+	}
+
+	logging.SetUser(r.Context(), owner)
+	list, err := h.db.ListWebhooks(r.Context(), owner)
+	if err != nil {
+		write(w, failure(logging.FromContext(r.Context()), err, "failed to list webhooks"))
+		return nil, false
+	}
+
+	if list == nil {
+		list = []models.Webhook{}
+	}
+
+	return list, true
+}
+
+// webhook finds the `{id}` webhook among the user's.
+func (h *Handler) webhook(w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	list, ok := h.webhooks(w, r)
+	if !ok {
+		return models.Webhook{}, false
+	}
+
+	id := mux.Vars(r)["id"]
+	for _, webhook := range list {
+		if webhook.Id == id {
+			return webhook, true
+		}
+	}
+
+	write(w, responses.NotFound(database.ErrWebhookNotFound.Error()))
+	return models.Webhook{}, false
+}
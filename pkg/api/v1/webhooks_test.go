@@ -0,0 +1,240 @@
+package v1_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+
+	v1 "github.com/m-rcd/notes/pkg/api/v1"
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/responses"
+	"github.com/m-rcd/notes/pkg/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// hosts resolves names like a DNS server would.
+type hosts map[string]string
+
+func (h hosts) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ip, ok := h[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+var _ = Describe("V1 webhooks", func() {
+	var (
+		fake_db *databasefakes.FakeDatabase
+		router  *mux.Router
+		webhook = models.Webhook{Id: "1", User: models.User{Username: "Buffy"}, URL: "https://example.com/hooks", Events: []string{"note.created"}, Secret: "stake"}
+	)
+
+	BeforeEach(func() {
+		fake_db = new(databasefakes.FakeDatabase)
+		h := v1.New(fake_db, webhooks.Guard{Resolver: hosts{"example.com": "93.184.216.34", "metadata.internal": "169.254.169.254"}})
+		router = mux.NewRouter()
+		h.Register(router.PathPrefix(v1.Prefix).Subrouter())
+	})
+
+	serveAs := func(user, method, path, body string, response interface{}) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "https://localhost:10000"+path, bytes.NewBufferString(body))
+		Expect(err).NotTo(HaveOccurred())
+		if user != "" {
+			req = req.WithContext(auth.WithUser(req.Context(), models.User{Username: user}))
+		}
+
+		r := httptest.NewRecorder()
+		router.ServeHTTP(r, req)
+		Expect(r.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(json.Unmarshal(r.Body.Bytes(), response)).To(Succeed())
+
+		return r
+	}
+
+	serve := func(method, path, body string, response interface{}) *httptest.ResponseRecorder {
+		return serveAs("Buffy", method, path, body, response)
+	}
+
+	It("needs a client certificate", func() {
+		var response responses.JsonNoteResponse
+		r := serveAs("", "POST", "/api/v1/webhooks?username=Buffy", `{"url":"https://example.com/hooks","events":["note.created"]}`, &response)
+		Expect(r.Code).To(Equal(http.StatusForbidden))
+		Expect(fake_db.CreateWebhookCallCount()).To(Equal(0))
+
+		r = serveAs("", "GET", "/api/v1/webhooks/1/deliveries?username=Buffy", "", &response)
+		Expect(r.Code).To(Equal(http.StatusForbidden))
+		Expect(fake_db.ListDeliveriesCallCount()).To(Equal(0))
+	})
+
+	Context("#CreateWebhook", func() {
+		It("subscribes the webhook for the user and shows its secret", func() {
+			fake_db.CreateWebhookStub = func(_ context.Context, webhook models.Webhook) (models.Webhook, error) {
+				webhook.Id = "1"
+				return webhook, nil
+			}
+
+			var response responses.JsonWebhookResponse
+			r := serve("POST", "/api/v1/webhooks?username=Buffy", `{"id":"9","url":"https://example.com/hooks","events":["note.created"]}`, &response)
+			Expect(r.Code).To(Equal(http.StatusCreated))
+			Expect(response.Message).To(Equal("The webhook was successfully created"))
+
+			created := response.Data[0]
+			Expect(created.Id).To(Equal("1"))
+			Expect(created.User.Username).To(Equal("Buffy"))
+			Expect(created.Secret).To(HaveLen(64))
+
+			_, stored := fake_db.CreateWebhookArgsForCall(0)
+			Expect(stored.Secret).To(Equal(created.Secret))
+		})
+
+		It("keeps the secret it is given", func() {
+			fake_db.CreateWebhookReturns(webhook, nil)
+
+			var response responses.JsonWebhookResponse
+			serve("POST", "/api/v1/webhooks", `{"url":"https://example.com/hooks","events":["note.created"],"secret":"stake","user":{"username":"Buffy"}}`, &response)
+
+			_, stored := fake_db.CreateWebhookArgsForCall(0)
+			Expect(stored.Secret).To(Equal("stake"))
+		})
+
+		It("rejects invalid webhooks", func() {
+			var response responses.JsonNoteResponse
+			r := serve("POST", "/api/v1/webhooks?username=Buffy", `{"url":"https://example.com/hooks","events":["note.read"]}`, &response)
+			Expect(r.Code).To(Equal(http.StatusBadRequest))
+			Expect(response.Message).To(Equal(`unknown event "note.read"`))
+			Expect(fake_db.CreateWebhookCallCount()).To(Equal(0))
+		})
+
+		It("rejects URLs of internal addresses", func() {
+			for _, url := range []string{"http://127.0.0.1:8080/hooks", "http://[::1]/hooks", "http://10.0.0.7/hooks", "http://metadata.internal/latest"} {
+				var response responses.JsonNoteResponse
+				r := serve("POST", "/api/v1/webhooks", `{"url":"`+url+`","events":["note.created"]}`, &response)
+				Expect(r.Code).To(Equal(http.StatusBadRequest), url)
+				Expect(response.Message).To(Equal(webhooks.ErrInternalAddress.Error()), url)
+			}
+
+			Expect(fake_db.CreateWebhookCallCount()).To(Equal(0))
+		})
+
+		It("rejects URLs whose host does not resolve", func() {
+			var response responses.JsonNoteResponse
+			r := serve("POST", "/api/v1/webhooks", `{"url":"https://nowhere.example/hooks","events":["note.created"]}`, &response)
+			Expect(r.Code).To(Equal(http.StatusBadRequest))
+			Expect(response.Message).To(HavePrefix(`cannot resolve "nowhere.example"`))
+			Expect(fake_db.CreateWebhookCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("#ListWebhooks", func() {
+		It("lists the user's webhooks without their secrets", func() {
+			fake_db.ListWebhooksReturns([]models.Webhook{webhook}, nil)
+
+			var response responses.JsonWebhookResponse
+			r := serve("GET", "/api/v1/webhooks?username=Buffy", "", &response)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.Data).To(HaveLen(1))
+			Expect(response.Data[0].URL).To(Equal(webhook.URL))
+			Expect(response.Data[0].Secret).To(BeEmpty())
+
+			_, username := fake_db.ListWebhooksArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+		})
+
+		It("lists no webhooks as an empty list", func() {
+			var response responses.JsonWebhookResponse
+			serve("GET", "/api/v1/webhooks?username=Buffy", "", &response)
+			Expect(response.Data).NotTo(BeNil())
+		})
+	})
+
+	Context("#DeleteWebhook", func() {
+		It("deletes one of the user's webhooks", func() {
+			fake_db.ListWebhooksReturns([]models.Webhook{webhook}, nil)
+
+			var response responses.JsonWebhookResponse
+			r := serve("DELETE", "/api/v1/webhooks/1?username=Buffy", "", &response)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.Message).To(Equal("The webhook was successfully deleted"))
+
+			_, id, username := fake_db.DeleteWebhookArgsForCall(0)
+			Expect(id).To(Equal("1"))
+			Expect(username).To(Equal("Buffy"))
+		})
+
+		It("does not find the webhooks of other users", func() {
+			fake_db.ListWebhooksReturns([]models.Webhook{webhook}, nil)
+
+			var response responses.JsonNoteResponse
+			r := serve("DELETE", "/api/v1/webhooks/2?username=Buffy", "", &response)
+			Expect(r.Code).To(Equal(http.StatusNotFound))
+			Expect(response.Message).To(Equal("webhook does not exist"))
+			Expect(fake_db.DeleteWebhookCallCount()).To(Equal(0))
+		})
+
+		It("does not find a webhook deleted in the meantime", func() {
+			fake_db.ListWebhooksReturns([]models.Webhook{webhook}, nil)
+			fake_db.DeleteWebhookReturns(database.ErrWebhookNotFound)
+
+			var response responses.JsonNoteResponse
+			r := serve("DELETE", "/api/v1/webhooks/1?username=Buffy", "", &response)
+			Expect(r.Code).To(Equal(http.StatusNotFound))
+			Expect(response.Message).To(Equal("webhook does not exist"))
+		})
+	})
+
+	Context("#ListDeliveries", func() {
+		deliveries := []models.Delivery{
+			{Id: "b", WebhookId: "1", Attempt: 5, Error: "receiver answered 500 Internal Server Error", Dead: true, Payload: "{}"},
+			{Id: "a", WebhookId: "1", Attempt: 1, StatusCode: 200},
+		}
+
+		BeforeEach(func() {
+			fake_db.ListWebhooksReturns([]models.Webhook{webhook}, nil)
+			fake_db.ListDeliveriesReturns(deliveries, nil)
+		})
+
+		It("lists the log of the webhook", func() {
+			var response responses.JsonDeliveryResponse
+			r := serve("GET", "/api/v1/webhooks/1/deliveries?username=Buffy", "", &response)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.Data).To(Equal(deliveries))
+
+			_, id, username := fake_db.ListDeliveriesArgsForCall(0)
+			Expect(id).To(Equal("1"))
+			Expect(username).To(Equal("Buffy"))
+		})
+
+		It("lists the dead deliveries", func() {
+			var response responses.JsonDeliveryResponse
+			serve("GET", "/api/v1/webhooks/1/deliveries?username=Buffy&state=dead", "", &response)
+			Expect(response.Data).To(Equal(deliveries[:1]))
+		})
+
+		It("rejects unknown states", func() {
+			var response responses.JsonNoteResponse
+			r := serve("GET", "/api/v1/webhooks/1/deliveries?username=Buffy&state=pending", "", &response)
+			Expect(r.Code).To(Equal(http.StatusBadRequest))
+			Expect(response.Message).To(Equal(`state must be "dead"`))
+		})
+
+		It("sends storage failures", func() {
+			fake_db.ListDeliveriesReturns(nil, errors.New("boom"))
+
+			var response responses.JsonNoteResponse
+			r := serve("GET", "/api/v1/webhooks/1/deliveries?username=Buffy", "", &response)
+			Expect(r.Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/responses"
+	"github.com/m-rcd/notes/pkg/utils"
+)
+
+type contextKey struct{}
+
+// maxOwnerBody is how much of a body Owner reads to find the user it names.
+// The bodies that name a user, notes and lists of ids, fit well within it.
+const maxOwnerBody = 1 << 20
+
+var (
+	ErrNoUser       = errors.New("user must be set")
+	ErrUserMismatch = errors.New("user does not match client certificate")
+	ErrInvalidUser  = errors.New(`username must not contain "/", "\" or ".."`)
+)
+
+func WithUser(ctx context.Context, user models.User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+func UserFromContext(ctx context.Context) (models.User, bool) {
+	user, ok := ctx.Value(contextKey{}).(models.User)
+
+	return user, ok
+}
+
+// ClientCertificate maps the common name of a verified client certificate to
+// the user making the request. It leaves the body alone: Owner checks the user
+// a request names, and CheckBody the user of a note being created.
+func ClientCertificate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user := models.User{Username: r.TLS.VerifiedChains[0][0].Subject.CommonName}
+		if !utils.IsSet(user.Username) {
+			forbidden(w, "client certificate has no common name")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+	})
+}
+
+// CheckBody rejects a body naming another user than the authenticated
+// caller, for the routes that take the user from the body they read.
+func CheckBody(ctx context.Context, body []byte) error {
+	user, ok := UserFromContext(ctx)
+	if requested := requestedUsername(body); ok && utils.IsSet(requested) && requested != user.Username {
+		return ErrUserMismatch
+	}
+
+	return nil
+}
+
+// Admin lets through only the requests of admins, who are known by their
+// client certificate.
+func Admin(admins []string) func(http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, admin := range admins {
+		allowed[admin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok || !allowed[user.Username] {
+				forbidden(w, "admin routes need the client certificate of an admin")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Authenticated refuses requests without a client certificate, for routes
+// that must not be open to whoever can name a user.
+func Authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := UserFromContext(r.Context()); !ok {
+			forbidden(w, "this route needs a client certificate")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Owner works out whose notes a request is about: the `username` path
+// variable, else the `username` query parameter, else a JSON body naming the
+// user. Only the first maxOwnerBody bytes of a body are read, and uploads of
+// other types, such as ZIP archives, are not read at all. An authenticated caller can only ask for their own notes, and is
+// assumed when the request names nobody. Names that could be taken for a path
+// are refused.
+func Owner(r *http.Request) (string, error) {
+	requested, err := requestedOwner(r)
+	if err != nil {
+		return "", err
+	}
+
+	if user, ok := UserFromContext(r.Context()); ok {
+		if utils.IsSet(requested) && requested != user.Username {
+			return "", ErrUserMismatch
+		}
+
+		requested = user.Username
+	}
+
+	if !utils.IsSet(requested) {
+		return "", ErrNoUser
+	}
+
+	if strings.ContainsAny(requested, `/\`) || strings.Contains(requested, "..") {
+		return "", ErrInvalidUser
+	}
+
+	return requested, nil
+}
+
+// OwnerFailure builds the response for an error returned by Owner.
+func OwnerFailure(err error) responses.JsonNoteResponse {
+	if errors.Is(err, ErrUserMismatch) {
+		return responses.Forbidden(err.Error())
+	}
+
+	return responses.BadRequest(err.Error())
+}
+
+func requestedOwner(r *http.Request) (string, error) {
+	if username := mux.Vars(r)["username"]; utils.IsSet(username) {
+		return username, nil
+	}
+
+	if username := r.URL.Query().Get("username"); utils.IsSet(username) {
+		return username, nil
+	}
+
+	if r.Body == nil || !namesUser(r.Header.Get("Content-Type")) {
+		return "", nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxOwnerBody+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(body) > maxOwnerBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+		return "", nil
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return requestedUsername(body), nil
+}
+
+// namesUser reports whether a body of the media type can name a user: JSON,
+// or no type or a form, which is what `curl -d` sends.
+func namesUser(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || mediaType == "application/x-www-form-urlencoded"
+}
+
+func requestedUsername(body []byte) string {
+	var request struct {
+		Username string      `json:"username"`
+		User     models.User `json:"user"`
+	}
+
+	if err := json.Unmarshal(body, &request); err != nil {
+		return ""
+	}
+
+	if utils.IsSet(request.User.Username) {
+		return request.User.Username
+	}
+
+	return request.Username
+}
+
+func forbidden(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+
+	json.NewEncoder(w).Encode(responses.Forbidden(message))
+}
@@ -0,0 +1,224 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Auth", func() {
+	var (
+		seenUser models.User
+		seenBody string
+		found    bool
+		next     http.Handler
+	)
+
+	BeforeEach(func() {
+		seenUser, seenBody, found = models.User{}, "", false
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seenUser, found = auth.UserFromContext(r.Context())
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).NotTo(HaveOccurred())
+			seenBody = string(body)
+		})
+	})
+
+	withClientCertificate := func(req *http.Request, commonName string) *http.Request {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+		return req
+	}
+
+	Context("ClientCertificate", func() {
+		It("maps the certificate common name to the user", func() {
+			data := `{"name":"Vampires","content":"I SLAY","user":{"username":"Buffy"}}`
+			req, err := http.NewRequest("POST", "https://localhost:10000/note", bytes.NewBufferString(data))
+			Expect(err).NotTo(HaveOccurred())
+			r := httptest.NewRecorder()
+
+			auth.ClientCertificate(next).ServeHTTP(r, withClientCertificate(req, "Buffy"))
+			Expect(found).To(BeTrue())
+			Expect(seenUser).To(Equal(models.User{Username: "Buffy"}))
+			Expect(seenBody).To(Equal(data))
+		})
+
+		It("passes requests without a client certificate through", func() {
+			req, err := http.NewRequest("GET", "http://localhost:10000/notes/active", bytes.NewBufferString(`{"username":"Buffy"}`))
+			Expect(err).NotTo(HaveOccurred())
+			r := httptest.NewRecorder()
+
+			auth.ClientCertificate(next).ServeHTTP(r, req)
+			Expect(found).To(BeFalse())
+			Expect(seenBody).To(Equal(`{"username":"Buffy"}`))
+		})
+
+		It("leaves the body to the handlers", func() {
+			data := `{"username":"Spike"}`
+			req, err := http.NewRequest("POST", "https://localhost:10000/api/v1/import", bytes.NewBufferString(data))
+			Expect(err).NotTo(HaveOccurred())
+			r := httptest.NewRecorder()
+
+			auth.ClientCertificate(next).ServeHTTP(r, withClientCertificate(req, "Buffy"))
+			Expect(found).To(BeTrue())
+			Expect(seenBody).To(Equal(data))
+		})
+	})
+
+	Context("CheckBody", func() {
+		It("rejects bodies naming another user than the authenticated one", func() {
+			ctx := auth.WithUser(context.Background(), models.User{Username: "Buffy"})
+			Expect(auth.CheckBody(ctx, []byte(`{"user":{"username":"Buffy"}}`))).To(Succeed())
+			Expect(auth.CheckBody(ctx, []byte(`{"name":"Vampires"}`))).To(Succeed())
+
+			err := auth.CheckBody(ctx, []byte(`{"user":{"username":"Spike"}}`))
+			Expect(err).To(MatchError(auth.ErrUserMismatch))
+			Expect(auth.OwnerFailure(err).StatusCode).To(Equal(http.StatusForbidden))
+
+			Expect(auth.CheckBody(context.Background(), []byte(`{"user":{"username":"Spike"}}`))).To(Succeed())
+		})
+	})
+
+	Context("Admin", func() {
+		admin := func(req *http.Request) int {
+			r := httptest.NewRecorder()
+			auth.ClientCertificate(auth.Admin([]string{"Giles"})(next)).ServeHTTP(r, req)
+
+			return r.Code
+		}
+
+		It("lets admins through", func() {
+			req, err := http.NewRequest("GET", "https://localhost:10000/api/v1/admin/backup", http.NoBody)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(admin(withClientCertificate(req, "Giles"))).To(Equal(http.StatusOK))
+			Expect(seenUser).To(Equal(models.User{Username: "Giles"}))
+		})
+
+		It("rejects other users and requests without a client certificate", func() {
+			req, err := http.NewRequest("GET", "https://localhost:10000/api/v1/admin/backup", http.NoBody)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(admin(withClientCertificate(req, "Buffy"))).To(Equal(http.StatusForbidden))
+
+			req, err = http.NewRequest("GET", "http://localhost:10000/api/v1/admin/backup?username=Giles", http.NoBody)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(admin(req)).To(Equal(http.StatusForbidden))
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	Context("Authenticated", func() {
+		authenticated := func(req *http.Request) int {
+			r := httptest.NewRecorder()
+			auth.ClientCertificate(auth.Authenticated(next)).ServeHTTP(r, req)
+
+			return r.Code
+		}
+
+		It("lets users with a client certificate through", func() {
+			req, err := http.NewRequest("GET", "https://localhost:10000/api/v1/webhooks", http.NoBody)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(authenticated(withClientCertificate(req, "Buffy"))).To(Equal(http.StatusOK))
+			Expect(seenUser).To(Equal(models.User{Username: "Buffy"}))
+		})
+
+		It("rejects requests that only name a user", func() {
+			req, err := http.NewRequest("GET", "http://localhost:10000/api/v1/webhooks?username=Buffy", http.NoBody)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(authenticated(req)).To(Equal(http.StatusForbidden))
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	Context("Owner", func() {
+		newRequest := func(url, body string) *http.Request {
+			req, err := http.NewRequest("GET", url, bytes.NewBufferString(body))
+			Expect(err).NotTo(HaveOccurred())
+			return req
+		}
+
+		It("prefers the path, then the query, then the body", func() {
+			req := mux.SetURLVars(newRequest("http://localhost:10000/api/v1/users/Buffy/notes?username=Spike", `{"username":"Giles"}`), map[string]string{"username": "Buffy"})
+			Expect(auth.Owner(req)).To(Equal("Buffy"))
+
+			req = newRequest("http://localhost:10000/notes/active?username=Spike", `{"username":"Giles"}`)
+			Expect(auth.Owner(req)).To(Equal("Spike"))
+
+			req = newRequest("http://localhost:10000/notes/active", `{"username":"Giles"}`)
+			Expect(auth.Owner(req)).To(Equal("Giles"))
+			body, err := ioutil.ReadAll(req.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal(`{"username":"Giles"}`))
+		})
+
+		It("only reads JSON bodies, and no more than a note's worth of them", func() {
+			req := newRequest("http://localhost:10000/api/v1/import", `{"username":"Giles"}`)
+			req.Header.Set("Content-Type", "application/zip")
+			_, err := auth.Owner(req)
+			Expect(err).To(MatchError(auth.ErrNoUser))
+
+			req = newRequest("http://localhost:10000/notes/archive", `{"username":"Giles"}`)
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+			Expect(auth.Owner(req)).To(Equal("Giles"))
+
+			large := `{"content":"` + strings.Repeat("a", 2<<20) + `","username":"Giles"}`
+			req = newRequest("http://localhost:10000/api/v1/sync", large)
+			req = req.WithContext(auth.WithUser(req.Context(), models.User{Username: "Buffy"}))
+			Expect(auth.Owner(req)).To(Equal("Buffy"))
+			body, err := ioutil.ReadAll(req.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal(large))
+		})
+
+		It("uses the authenticated user when the request names nobody", func() {
+			req := newRequest("http://localhost:10000/notes/active", "")
+			req = req.WithContext(auth.WithUser(req.Context(), models.User{Username: "Buffy"}))
+
+			Expect(auth.Owner(req)).To(Equal("Buffy"))
+		})
+
+		Context("when the owner cannot be worked out", func() {
+			It("rejects requests for another user's notes", func() {
+				req := newRequest("http://localhost:10000/notes/active?username=Spike", "")
+				req = req.WithContext(auth.WithUser(req.Context(), models.User{Username: "Buffy"}))
+
+				_, err := auth.Owner(req)
+				Expect(err).To(MatchError(auth.ErrUserMismatch))
+				Expect(auth.OwnerFailure(err).StatusCode).To(Equal(http.StatusForbidden))
+			})
+
+			It("rejects requests naming nobody", func() {
+				_, err := auth.Owner(newRequest("http://localhost:10000/notes/active", ""))
+				Expect(err).To(MatchError(auth.ErrNoUser))
+				Expect(auth.OwnerFailure(err).StatusCode).To(Equal(http.StatusBadRequest))
+			})
+
+			It("rejects names that could be taken for a path", func() {
+				for _, username := range []string{"..", "../Giles", `Giles\Spike`} {
+					_, err := auth.Owner(mux.SetURLVars(newRequest("http://localhost:10000/notes/active", ""), map[string]string{"username": username}))
+					Expect(err).To(MatchError(auth.ErrInvalidUser))
+					Expect(auth.OwnerFailure(err).StatusCode).To(Equal(http.StatusBadRequest))
+				}
+
+				_, err := auth.Owner(newRequest("http://localhost:10000/notes/active?username=a/b", ""))
+				Expect(err).To(MatchError(auth.ErrInvalidUser))
+			})
+		})
+	})
+})
@@ -0,0 +1,318 @@
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// Version is the version of the archive format, which backups of later
+// versions cannot be restored by.
+const Version = 1
+
+const (
+	manifestName = "manifest.json"
+	webhooksName = "webhooks.jsonl"
+)
+
+// ErrNotEmpty is returned when restoring into a database that has notes.
+var ErrNotEmpty = errors.New("the database has notes, backups can only be restored into an empty one")
+
+// Manifest describes a backup: the notes of each user, and the SHA-256 of
+// every file of the archive.
+type Manifest struct {
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"created_at"`
+	Users     []User            `json:"users"`
+	Webhooks  int               `json:"webhooks"`
+	Files     map[string]string `json:"files"`
+}
+
+// User is where the notes of a user are in a backup, one JSON note per line,
+// and how many of them there are.
+type User struct {
+	Username string `json:"username"`
+	Notes    string `json:"notes"`
+	Active   int    `json:"active"`
+	Archived int    `json:"archived"`
+}
+
+// Summary is what a backup restored.
+type Summary struct {
+	Users    int
+	Notes    int
+	Webhooks int
+	// Renumbered counts the notes that could not keep their id.
+	Renumbered int
+}
+
+// Write writes a backup of every note and webhook of db to w as a ZIP, read
+// from a snapshot so that it is consistent, with its manifest last.
+func Write(ctx context.Context, w io.Writer, db database.Database, now time.Time) (Manifest, error) {
+	archive := zip.NewWriter(w)
+	manifest := Manifest{Version: Version, CreatedAt: now.UTC(), Users: []User{}, Files: map[string]string{}}
+
+	err := db.Snapshot(ctx, func(snapshot database.Snapshot) error {
+		users, err := snapshot.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i, username := range users {
+			user := User{Username: username, Notes: fmt.Sprintf("users/%d/notes.jsonl", i)}
+			err := writeFile(archive, &manifest, user.Notes, func(encoder *json.Encoder) error {
+				return snapshot.EachNote(ctx, username, func(note models.Note) error {
+					if note.Archived {
+						user.Archived++
+					} else {
+						user.Active++
+					}
+
+					return encoder.Encode(note)
+				})
+			})
+			if err != nil {
+				return err
+			}
+
+			manifest.Users = append(manifest.Users, user)
+		}
+
+		return writeFile(archive, &manifest, webhooksName, func(encoder *json.Encoder) error {
+			return snapshot.EachWebhook(ctx, func(webhook models.Webhook) error {
+				manifest.Webhooks++
+				return encoder.Encode(webhook)
+			})
+		})
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	file, err := archive.Create(manifestName)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return Manifest{}, err
+	}
+
+	return manifest, archive.Close()
+}
+
+func writeFile(archive *zip.Writer, manifest *Manifest, name string, write func(*json.Encoder) error) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	if err := write(json.NewEncoder(io.MultiWriter(file, hash))); err != nil {
+		return err
+	}
+
+	manifest.Files[name] = hex.EncodeToString(hash.Sum(nil))
+
+	return nil
+}
+
+// Verify checks every file of a backup against the checksum in its manifest.
+func Verify(r io.ReaderAt, size int64) (Manifest, error) {
+	manifest, _, err := open(r, size)
+
+	return manifest, err
+}
+
+func open(r io.ReaderAt, size int64) (Manifest, *zip.Reader, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return Manifest{}, nil, err
+	}
+
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	var manifest Manifest
+	if err := decodeFile(files[manifestName], manifestName, &manifest); err != nil {
+		return Manifest{}, nil, err
+	}
+
+	if manifest.Version < 1 || manifest.Version > Version {
+		return Manifest{}, nil, fmt.Errorf("unsupported backup version %d, expected %d", manifest.Version, Version)
+	}
+
+	for name, checksum := range manifest.Files {
+		file, ok := files[name]
+		if !ok {
+			return Manifest{}, nil, fmt.Errorf("%s is missing from the backup", name)
+		}
+
+		content, err := file.Open()
+		if err != nil {
+			return Manifest{}, nil, err
+		}
+
+		hash := sha256.New()
+		_, err = io.Copy(hash, content)
+		content.Close()
+		if err != nil {
+			return Manifest{}, nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		if hex.EncodeToString(hash.Sum(nil)) != checksum {
+			return Manifest{}, nil, fmt.Errorf("%s does not match its checksum", name)
+		}
+	}
+
+	for _, user := range manifest.Users {
+		if _, ok := manifest.Files[user.Notes]; !ok {
+			return Manifest{}, nil, fmt.Errorf("the notes of %s have no checksum", user.Username)
+		}
+	}
+
+	return manifest, archive, nil
+}
+
+// Restore reads a backup into db, which has to have no notes. Notes keep
+// their ids where db can store them, and webhooks get new ones.
+func Restore(ctx context.Context, r io.ReaderAt, size int64, db database.Database) (Summary, error) {
+	manifest, archive, err := open(r, size)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	users, err := db.ListUsers(ctx)
+	if err != nil {
+		return Summary{}, err
+	}
+	if len(users) > 0 {
+		return Summary{}, ErrNotEmpty
+	}
+
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	var summary Summary
+	for _, user := range manifest.Users {
+		var restored counts
+		err := eachLine(files[user.Notes], func(decoder *json.Decoder) error {
+			var note models.Note
+			if err := decoder.Decode(&note); err != nil {
+				return err
+			}
+
+			if note.User.Username != user.Username {
+				return fmt.Errorf("note %s is not a note of %s", note.Id, user.Username)
+			}
+
+			stored, _, err := db.PutNote(ctx, note)
+			if err != nil {
+				return fmt.Errorf("failed to restore note %s: %w", note.Id, err)
+			}
+
+			restored.add(note)
+			summary.Notes++
+			if stored.Id != note.Id {
+				summary.Renumbered++
+			}
+
+			return nil
+		})
+		if err != nil {
+			return summary, fmt.Errorf("failed to restore the notes of %s: %w", user.Username, err)
+		}
+
+		if restored.Active != user.Active || restored.Archived != user.Archived {
+			return summary, fmt.Errorf("restored %d active and %d archived notes of %s, expected %d and %d",
+				restored.Active, restored.Archived, user.Username, user.Active, user.Archived)
+		}
+
+		summary.Users++
+	}
+
+	if _, ok := manifest.Files[webhooksName]; ok {
+		err = eachLine(files[webhooksName], func(decoder *json.Decoder) error {
+			var webhook models.Webhook
+			if err := decoder.Decode(&webhook); err != nil {
+				return err
+			}
+
+			if _, err := db.CreateWebhook(ctx, webhook); err != nil {
+				return fmt.Errorf("failed to restore webhook %s: %w", webhook.Id, err)
+			}
+
+			summary.Webhooks++
+			return nil
+		})
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	return summary, nil
+}
+
+type counts struct {
+	Active   int
+	Archived int
+}
+
+func (c *counts) add(note models.Note) {
+	if note.Archived {
+		c.Archived++
+	} else {
+		c.Active++
+	}
+}
+
+func decodeFile(file *zip.File, name string, v interface{}) error {
+	if file == nil {
+		return fmt.Errorf("%s is missing from the backup", name)
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	if err := json.NewDecoder(content).Decode(v); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// eachLine calls fn until the JSON values of file run out.
+func eachLine(file *zip.File, fn func(*json.Decoder) error) error {
+	content, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	decoder := json.NewDecoder(content)
+	for decoder.More() {
+		if err := fn(decoder); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
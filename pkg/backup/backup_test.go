@@ -0,0 +1,152 @@
+package backup_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/backup"
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/database/local"
+	"github.com/m-rcd/notes/pkg/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backup", func() {
+	var (
+		ctx      = context.Background()
+		now      = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+		from, to database.Database
+		dirs     []string
+	)
+
+	newBackend := func() database.Database {
+		dir, err := ioutil.TempDir("", "backup_test")
+		Expect(err).NotTo(HaveOccurred())
+		dirs = append(dirs, dir)
+
+		db := local.NewLocalFileSystem(dir)
+		Expect(db.Open()).To(Succeed())
+
+		return db
+	}
+
+	notesOf := func(db database.Database, user string) []models.Note {
+		var notes []models.Note
+		Expect(db.EachNote(ctx, user, func(note models.Note) error {
+			notes = append(notes, note)
+			return nil
+		})).To(Succeed())
+
+		return notes
+	}
+
+	BeforeEach(func() {
+		dirs = nil
+		from, to = newBackend(), newBackend()
+
+		for _, note := range []models.Note{
+			{Id: "1", Name: "Stakes", Content: "Pointy.", User: models.User{Username: "Buffy"}},
+			{Id: "2", Name: "Hellmouth", Content: "Below the library.", Archived: true, User: models.User{Username: "Buffy"}},
+			{Id: "3", Name: "Books", Content: "Dusty.", User: models.User{Username: "Giles"}},
+		} {
+			_, _, err := from.PutNote(ctx, note)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		_, err := from.CreateWebhook(ctx, models.Webhook{User: models.User{Username: "Willow"}, URL: "https://example.com", Events: []string{"note.created"}, Secret: "shh"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		for _, dir := range dirs {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		}
+	})
+
+	It("writes the notes and webhooks with a manifest of their checksums", func() {
+		var buf bytes.Buffer
+		manifest, err := backup.Write(ctx, &buf, from, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.CreatedAt).To(Equal(now))
+		Expect(manifest.Users).To(Equal([]backup.User{
+			{Username: "Buffy", Notes: "users/0/notes.jsonl", Active: 1, Archived: 1},
+			{Username: "Giles", Notes: "users/1/notes.jsonl", Active: 1},
+		}))
+		Expect(manifest.Webhooks).To(Equal(1))
+		Expect(manifest.Files).To(HaveKey("webhooks.jsonl"))
+
+		verified, err := backup.Verify(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(verified).To(Equal(manifest))
+	})
+
+	It("restores a backup into an empty backend", func() {
+		var buf bytes.Buffer
+		_, err := backup.Write(ctx, &buf, from, now)
+		Expect(err).NotTo(HaveOccurred())
+
+		summary, err := backup.Restore(ctx, bytes.NewReader(buf.Bytes()), int64(buf.Len()), to)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary).To(Equal(backup.Summary{Users: 2, Notes: 3, Webhooks: 1}))
+
+		Expect(notesOf(to, "Buffy")).To(Equal(notesOf(from, "Buffy")))
+		Expect(notesOf(to, "Giles")).To(Equal(notesOf(from, "Giles")))
+
+		webhooks, err := to.ListWebhooks(ctx, "Willow")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(webhooks).To(HaveLen(1))
+		Expect(webhooks[0].Secret).To(Equal("shh"))
+
+		_, err = backup.Restore(ctx, bytes.NewReader(buf.Bytes()), int64(buf.Len()), to)
+		Expect(err).To(MatchError(backup.ErrNotEmpty))
+	})
+
+	It("rejects backups that do not match their checksums", func() {
+		var buf bytes.Buffer
+		_, err := backup.Write(ctx, &buf, from, now)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Copy the archive, changing the notes of Giles.
+		archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		Expect(err).NotTo(HaveOccurred())
+
+		var tampered bytes.Buffer
+		writer := zip.NewWriter(&tampered)
+		for _, file := range archive.File {
+			out, err := writer.Create(file.Name)
+			Expect(err).NotTo(HaveOccurred())
+
+			if file.Name == "users/1/notes.jsonl" {
+				Expect(json.NewEncoder(out).Encode(models.Note{Id: "3", Name: "Books", Content: "Burnt.", User: models.User{Username: "Giles"}})).To(Succeed())
+				continue
+			}
+
+			in, err := file.Open()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.Copy(out, in)
+			Expect(err).NotTo(HaveOccurred())
+			in.Close()
+		}
+		Expect(writer.Close()).To(Succeed())
+
+		_, err = backup.Restore(ctx, bytes.NewReader(tampered.Bytes()), int64(tampered.Len()), to)
+		Expect(err).To(MatchError("users/1/notes.jsonl does not match its checksum"))
+		Expect(notesOf(to, "Buffy")).To(BeEmpty())
+	})
+
+	It("rejects archives without a manifest", func() {
+		var buf bytes.Buffer
+		Expect(zip.NewWriter(&buf).Close()).To(Succeed())
+
+		_, err := backup.Verify(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		Expect(err).To(MatchError("manifest.json is missing from the backup"))
+	})
+})
@@ -0,0 +1,129 @@
+package bulk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/patch"
+	"github.com/m-rcd/notes/pkg/utils"
+)
+
+// MaxOperations bounds the size of a batch.
+const MaxOperations = 1000
+
+// BatchRequest lists the operations of a batch. Creates give the new note,
+// updates a JSON Merge Patch of the note with the id, and deletes only the id.
+type BatchRequest struct {
+	Operations []struct {
+		Op   string          `json:"op"`
+		Id   string          `json:"id"`
+		Note json.RawMessage `json:"note"`
+	} `json:"operations"`
+}
+
+// DecodeBatch reads a batch of operations on the notes of owner.
+func DecodeBatch(body io.Reader, owner string) ([]database.Operation, error) {
+	var request BatchRequest
+	if err := json.NewDecoder(body).Decode(&request); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+
+	if len(request.Operations) == 0 {
+		return nil, fmt.Errorf("%w: give at least one operation", ErrInvalid)
+	}
+
+	if len(request.Operations) > MaxOperations {
+		return nil, fmt.Errorf("%w: a batch can have at most %d operations", ErrInvalid, MaxOperations)
+	}
+
+	operations := make([]database.Operation, 0, len(request.Operations))
+	for i, op := range request.Operations {
+		operation, err := decodeOperation(op.Op, op.Id, op.Note, owner)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %s", ErrInvalid, i, err)
+		}
+
+		operations = append(operations, operation)
+	}
+
+	return operations, nil
+}
+
+func decodeOperation(op string, id string, body json.RawMessage, owner string) (database.Operation, error) {
+	operation := database.Operation{Kind: op, Id: id}
+
+	switch op {
+	case database.OperationCreate:
+		if utils.IsSet(id) {
+			return operation, errors.New("a new note cannot have an id")
+		}
+
+		if len(body) == 0 {
+			return operation, errors.New("note must be set")
+		}
+
+		if err := json.Unmarshal(body, &operation.Note); err != nil {
+			return operation, err
+		}
+
+		if !utils.IsSet(operation.Note.User.Username) {
+			operation.Note.User.Username = owner
+		}
+
+		if operation.Note.User.Username != owner {
+			return operation, errors.New("user does not match the batch")
+		}
+
+		if !utils.IsSet(operation.Note.Name) {
+			return operation, errors.New("name must be set")
+		}
+	case database.OperationUpdate:
+		if !utils.IsSet(id) {
+			return operation, errors.New("id must be set")
+		}
+
+		if len(body) == 0 {
+			return operation, errors.New("note must be set")
+		}
+
+		change, err := patch.New(patch.MergePatch, body)
+		if err != nil {
+			return operation, err
+		}
+
+		operation.Apply = change
+	case database.OperationDelete:
+		if !utils.IsSet(id) {
+			return operation, errors.New("id must be set")
+		}
+	default:
+		return operation, fmt.Errorf("op must be %q, %q or %q, got %q", database.OperationCreate, database.OperationUpdate, database.OperationDelete, op)
+	}
+
+	return operation, nil
+}
+
+// Batch runs the operations together. When one of them fails none are
+// applied, and the results say which one it was.
+func Batch(ctx context.Context, db database.Database, owner string, operations []database.Operation) ([]models.NoteResult, error) {
+	results, err := db.Batch(ctx, owner, operations)
+
+	var batchErr *database.BatchError
+	if errors.As(err, &batchErr) {
+		results = make([]models.NoteResult, len(operations))
+		for i, operation := range operations {
+			results[i] = models.NoteResult{Id: operation.Id, Error: "not applied"}
+		}
+
+		if batchErr.Index >= 0 && batchErr.Index < len(results) {
+			results[batchErr.Index].Error = batchErr.Err.Error()
+		}
+	}
+
+	return results, err
+}
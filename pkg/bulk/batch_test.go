@@ -0,0 +1,76 @@
+package bulk_test
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/m-rcd/notes/pkg/bulk"
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch", func() {
+	Context("DecodeBatch", func() {
+		It("reads creates, updates and deletes", func() {
+			operations, err := bulk.DecodeBatch(strings.NewReader(`{"operations":[
+				{"op":"create","note":{"name":"Vampires","content":"I SLAY"}},
+				{"op":"update","id":"1","note":{"content":null}},
+				{"op":"delete","id":"2"}
+			]}`), "Buffy")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(operations).To(HaveLen(3))
+
+			Expect(operations[0].Kind).To(Equal(database.OperationCreate))
+			Expect(operations[0].Note).To(Equal(models.Note{Name: "Vampires", Content: "I SLAY", User: models.User{Username: "Buffy"}}))
+
+			Expect(operations[1].Id).To(Equal("1"))
+			note, err := operations[1].Apply(models.Note{Id: "1", Name: "Demons", Content: "Boo", User: models.User{Username: "Buffy"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(note.Content).To(BeEmpty())
+
+			Expect(operations[2]).To(Equal(database.Operation{Kind: database.OperationDelete, Id: "2"}))
+		})
+
+		It("names the operation that is invalid", func() {
+			_, err := bulk.DecodeBatch(strings.NewReader(`{"operations":[{"op":"delete","id":"1"},{"op":"create","note":{"name":"Vampires","user":{"username":"Spike"}}}]}`), "Buffy")
+			Expect(err).To(MatchError("invalid bulk request: operation 1: user does not match the batch"))
+
+			_, err = bulk.DecodeBatch(strings.NewReader(`{"operations":[{"op":"archive","id":"1"}]}`), "Buffy")
+			Expect(err).To(MatchError(ContainSubstring(`operation 0: op must be "create", "update" or "delete", got "archive"`)))
+
+			_, err = bulk.DecodeBatch(strings.NewReader(`{"operations":[{"op":"update","note":{}}]}`), "Buffy")
+			Expect(err).To(MatchError(ContainSubstring("operation 0: id must be set")))
+
+			_, err = bulk.DecodeBatch(strings.NewReader(`{"operations":[]}`), "Buffy")
+			Expect(err).To(MatchError(bulk.ErrInvalid))
+		})
+
+		It("limits the size of a batch", func() {
+			body := `{"operations":[` + strings.Repeat(`{"op":"delete","id":"1"},`, bulk.MaxOperations) + `{"op":"delete","id":"1"}]}`
+
+			_, err := bulk.DecodeBatch(strings.NewReader(body), "Buffy")
+			Expect(err).To(MatchError(ContainSubstring("at most 1000 operations")))
+		})
+	})
+
+	Context("Batch", func() {
+		It("marks the other operations as not applied when one fails", func() {
+			fake_db := new(databasefakes.FakeDatabase)
+			fake_db.BatchReturns(nil, &database.BatchError{Index: 1, Err: errors.New("note does not exist")})
+
+			results, err := bulk.Batch(ctx, fake_db, "Buffy", []database.Operation{
+				{Kind: database.OperationDelete, Id: "1"},
+				{Kind: database.OperationDelete, Id: "2"},
+			})
+			Expect(err).To(MatchError("operation 1 failed: note does not exist"))
+			Expect(results).To(Equal([]models.NoteResult{
+				{Id: "1", Error: "not applied"},
+				{Id: "2", Error: "note does not exist"},
+			}))
+		})
+	})
+})
@@ -0,0 +1,96 @@
+package bulk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// ErrInvalid is returned for requests that do not say which notes they are
+// about.
+var ErrInvalid = errors.New("invalid bulk request")
+
+// Request selects the notes a bulk operation applies to, either by id or with
+// a filter over the owner's notes.
+type Request struct {
+	Ids    []string `json:"ids"`
+	Filter *Filter  `json:"filter"`
+}
+
+// Filter matches notes by name. An empty filter matches every note.
+type Filter struct {
+	Name string `json:"name"`
+}
+
+// Decode reads a request, which must give either ids or a filter.
+func Decode(body io.Reader) (Request, error) {
+	var request Request
+	if err := json.NewDecoder(body).Decode(&request); err != nil {
+		return Request{}, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+
+	if (len(request.Ids) == 0) == (request.Filter == nil) {
+		return Request{}, fmt.Errorf("%w: give either ids or a filter", ErrInvalid)
+	}
+
+	request.Ids = unique(request.Ids)
+
+	return request, nil
+}
+
+// SetArchived archives or unarchives the notes of owner selected by request.
+// A filter only looks at the notes that are not already in the wanted state.
+func SetArchived(ctx context.Context, db database.Database, owner string, request Request, archived bool) ([]models.NoteResult, error) {
+	ids := request.Ids
+	if request.Filter != nil {
+		var err error
+		if ids, err = request.Filter.match(ctx, db, owner, !archived); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ids) == 0 {
+		return []models.NoteResult{}, nil
+	}
+
+	return db.SetArchived(ctx, owner, ids, archived)
+}
+
+func (f Filter) match(ctx context.Context, db database.Database, owner string, archived bool) ([]string, error) {
+	list := db.ListActiveNotes
+	if archived {
+		list = db.ListArchivedNotes
+	}
+
+	notes, err := list(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, note := range notes {
+		if f.Name == "" || note.Name == f.Name {
+			ids = append(ids, note.Id)
+		}
+	}
+
+	return ids, nil
+}
+
+func unique(ids []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,13 @@
+package bulk_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestBulk(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Bulk Suite")
+}
@@ -0,0 +1,97 @@
+package bulk_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/m-rcd/notes/pkg/bulk"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/database/local"
+	"github.com/m-rcd/notes/pkg/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var ctx = context.Background()
+
+var _ = Describe("Bulk", func() {
+	Context("Decode", func() {
+		It("reads ids, dropping duplicates", func() {
+			request, err := bulk.Decode(strings.NewReader(`{"username":"Buffy","ids":["1","2","1"]}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(request.Ids).To(Equal([]string{"1", "2"}))
+		})
+
+		It("reads a filter", func() {
+			request, err := bulk.Decode(strings.NewReader(`{"filter":{"name":"Vampires"}}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(request.Filter).To(Equal(&bulk.Filter{Name: "Vampires"}))
+		})
+
+		It("needs exactly one of ids and filter", func() {
+			_, err := bulk.Decode(strings.NewReader(`{}`))
+			Expect(err).To(MatchError("invalid bulk request: give either ids or a filter"))
+
+			_, err = bulk.Decode(strings.NewReader(`{"ids":["1"],"filter":{}}`))
+			Expect(err).To(MatchError(bulk.ErrInvalid))
+
+			_, err = bulk.Decode(strings.NewReader(`{"ids":`))
+			Expect(err).To(MatchError(bulk.ErrInvalid))
+		})
+	})
+
+	Context("SetArchived", func() {
+		var fake_db *databasefakes.FakeDatabase
+
+		BeforeEach(func() {
+			fake_db = new(databasefakes.FakeDatabase)
+		})
+
+		It("changes the notes with the given ids", func() {
+			_, err := bulk.SetArchived(ctx, fake_db, "Buffy", bulk.Request{Ids: []string{"1", "2"}}, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, username, ids, archived := fake_db.SetArchivedArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+			Expect(ids).To(Equal([]string{"1", "2"}))
+			Expect(archived).To(BeTrue())
+		})
+
+		It("unarchives the archived notes matching the filter", func() {
+			fake_db.ListArchivedNotesReturns([]models.Note{{Id: "1", Name: "Vampires"}, {Id: "2", Name: "Demons"}, {Id: "3", Name: "Vampires"}}, nil)
+
+			_, err := bulk.SetArchived(ctx, fake_db, "Buffy", bulk.Request{Filter: &bulk.Filter{Name: "Vampires"}}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fake_db.ListActiveNotesCallCount()).To(Equal(0))
+
+			_, _, ids, archived := fake_db.SetArchivedArgsForCall(0)
+			Expect(ids).To(Equal([]string{"1", "3"}))
+			Expect(archived).To(BeFalse())
+		})
+
+		It("does nothing when the filter matches no note", func() {
+			results, err := bulk.SetArchived(ctx, fake_db, "Buffy", bulk.Request{Filter: &bulk.Filter{}}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(BeEmpty())
+			Expect(fake_db.SetArchivedCallCount()).To(Equal(0))
+		})
+
+		It("unarchives nothing for a user who never archived a note", func() {
+			dir, err := ioutil.TempDir("", "bulk_test")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			db := local.NewLocalFileSystem(dir)
+			Expect(db.Open()).To(Succeed())
+			_, err = db.Create(ctx, ioutil.NopCloser(strings.NewReader(`{"name":"Vampires","user":{"username":"Buffy"}}`)))
+			Expect(err).NotTo(HaveOccurred())
+
+			results, err := bulk.SetArchived(ctx, db, "Buffy", bulk.Request{Filter: &bulk.Filter{}}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,174 @@
+package certs_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/certs"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Certs", func() {
+	var (
+		tempDir  string
+		certFile string
+		keyFile  string
+		err      error
+	)
+
+	BeforeEach(func() {
+		tempDir, err = ioutil.TempDir("", "certs_test")
+		Expect(err).NotTo(HaveOccurred())
+
+		certFile = filepath.Join(tempDir, "tls.crt")
+		keyFile = filepath.Join(tempDir, "tls.key")
+		writeCertificate(certFile, keyFile, "first")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	Context("Reloader", func() {
+		It("serves the certificate on disk", func() {
+			reloader, err := certs.NewReloader(certFile, keyFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(commonName(reloader)).To(Equal("first"))
+		})
+
+		It("reloads the certificate when the files change", func() {
+			reloader, err := certs.NewReloader(certFile, keyFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go reloader.Watch(ctx, 10*time.Millisecond)
+
+			writeCertificate(certFile, keyFile, "second")
+			later := time.Now().Add(time.Minute)
+			Expect(os.Chtimes(certFile, later, later)).To(Succeed())
+
+			Eventually(func() string { return commonName(reloader) }).Should(Equal("second"))
+		})
+
+		It("keeps the previous certificate when the new one is invalid", func() {
+			reloader, err := certs.NewReloader(certFile, keyFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.WriteFile(certFile, []byte("not a certificate"), 0600)).To(Succeed())
+			Expect(reloader.Reload()).NotTo(Succeed())
+			Expect(commonName(reloader)).To(Equal("first"))
+		})
+
+		Context("when the files do not exist", func() {
+			It("raises an error", func() {
+				_, err := certs.NewReloader(filepath.Join(tempDir, "missing.crt"), keyFile)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("NewServerConfig", func() {
+		It("does not ask for client certificates by default", func() {
+			reloader, err := certs.NewReloader(certFile, keyFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			config, err := certs.NewServerConfig(reloader, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.ClientAuth).To(Equal(tls.NoClientCert))
+		})
+
+		It("requires client certificates when a CA is given", func() {
+			reloader, err := certs.NewReloader(certFile, keyFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			config, err := certs.NewServerConfig(reloader, certFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.ClientAuth).To(Equal(tls.RequireAndVerifyClientCert))
+			Expect(config.ClientCAs).NotTo(BeNil())
+		})
+
+		Context("when the CA file has no certificates", func() {
+			It("raises an error", func() {
+				reloader, err := certs.NewReloader(certFile, keyFile)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = certs.NewServerConfig(reloader, keyFile)
+				Expect(err).To(MatchError("no certificates found in client CA file"))
+			})
+		})
+	})
+
+	Context("RedirectToHTTPS", func() {
+		It("redirects to the HTTPS port keeping the path", func() {
+			r := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "http://localhost:8080/note?x=1", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			certs.RedirectToHTTPS(":10000").ServeHTTP(r, req)
+			Expect(r.Code).To(Equal(http.StatusPermanentRedirect))
+			Expect(r.Header().Get("Location")).To(Equal("https://localhost:10000/note?x=1"))
+		})
+
+		It("omits the default HTTPS port", func() {
+			r := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "http://example.com/notes/active", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			certs.RedirectToHTTPS(":443").ServeHTTP(r, req)
+			Expect(r.Header().Get("Location")).To(Equal("https://example.com/notes/active"))
+		})
+	})
+})
+
+func commonName(reloader *certs.Reloader) string {
+	cert, err := reloader.GetCertificate(nil)
+	Expect(err).NotTo(HaveOccurred())
+
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	Expect(err).NotTo(HaveOccurred())
+
+	return parsed.Subject.CommonName
+}
+
+func writeCertificate(certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	Expect(ioutil.WriteFile(certFile, certPem, 0600)).To(Succeed())
+	Expect(ioutil.WriteFile(keyFile, keyPem, 0600)).To(Succeed())
+}
@@ -0,0 +1,13 @@
+package collab_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCollab(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Collab Suite")
+}
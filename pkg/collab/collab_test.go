@@ -0,0 +1,319 @@
+package collab_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	stdlog "log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/m-rcd/notes/pkg/collab"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Collab", func() {
+	insert := func(clock uint64, site string, after *collab.ID, value string) collab.Op {
+		return collab.Op{Type: collab.OpInsert, ID: collab.ID{Clock: clock, Site: site}, After: after, Value: value}
+	}
+
+	remove := func(clock uint64, site string) collab.Op {
+		return collab.Op{Type: collab.OpDelete, ID: collab.ID{Clock: clock, Site: site}}
+	}
+
+	Context("Document", func() {
+		var (
+			doc *collab.Document
+			h   = &collab.ID{Clock: 1, Site: "server"}
+			i   = &collab.ID{Clock: 2, Site: "server"}
+		)
+
+		BeforeEach(func() {
+			doc = collab.NewDocument("hi", "server")
+		})
+
+		It("starts from the stored text", func() {
+			Expect(doc.Text()).To(Equal("hi"))
+			Expect(doc.Clock()).To(Equal(uint64(2)))
+		})
+
+		It("converges whatever order concurrent ops arrive in", func() {
+			ops := []collab.Op{
+				insert(3, "buffy", i, "!"),
+				insert(3, "spike", i, "?"),
+				insert(4, "spike", &collab.ID{Clock: 3, Site: "spike"}, "?"),
+				insert(3, "willow", h, "o"),
+				remove(2, "server"),
+			}
+
+			forwards := collab.NewDocument("hi", "server")
+			for _, op := range ops {
+				Expect(forwards.Apply(op)).To(BeTrue())
+			}
+
+			backwards := collab.NewDocument("hi", "server")
+			for _, j := range []int{4, 3, 0, 1, 2} {
+				Expect(backwards.Apply(ops[j])).To(BeTrue())
+			}
+
+			Expect(forwards.Text()).To(Equal("ho??!"))
+			Expect(backwards.Text()).To(Equal(forwards.Text()))
+			Expect(backwards.Elements()).To(Equal(forwards.Elements()))
+			Expect(forwards.Clock()).To(Equal(uint64(4)))
+		})
+
+		It("ignores ops it already applied", func() {
+			Expect(doc.Apply(insert(3, "buffy", nil, "o"))).To(BeTrue())
+			Expect(doc.Apply(insert(3, "buffy", nil, "o"))).To(BeFalse())
+			Expect(doc.Apply(remove(3, "buffy"))).To(BeTrue())
+			Expect(doc.Apply(remove(3, "buffy"))).To(BeFalse())
+			Expect(doc.Text()).To(Equal("hi"))
+		})
+
+		It("rejects ops it cannot place", func() {
+			_, err := doc.Apply(insert(5, "buffy", &collab.ID{Clock: 4, Site: "spike"}, "o"))
+			Expect(err).To(MatchError("element 4@spike does not exist"))
+
+			_, err = doc.Apply(insert(2, "buffy", i, "o"))
+			Expect(err).To(MatchError("clock must be higher than the one of the element it follows"))
+
+			_, err = doc.Apply(insert(3, "buffy", i, "oh"))
+			Expect(err).To(MatchError("value must be one character"))
+
+			_, err = doc.Apply(remove(9, "buffy"))
+			Expect(err).To(MatchError("element 9@buffy does not exist"))
+
+			_, err = doc.Apply(collab.Op{Type: "move", ID: *i})
+			Expect(err).To(MatchError(`op must be "insert" or "delete", got "move"`))
+			Expect(doc.Text()).To(Equal("hi"))
+		})
+	})
+
+	Context("Hub", func() {
+		var (
+			db     *databasefakes.FakeDatabase
+			hub    *collab.Hub
+			server *httptest.Server
+			conns  []*websocket.Conn
+
+			mu    sync.Mutex
+			saved []string
+		)
+
+		BeforeEach(func() {
+			db = new(databasefakes.FakeDatabase)
+			db.ListActiveNotesReturns([]models.Note{{Id: "1", Name: "Vampires", Content: "hi", User: models.User{Username: "Buffy"}}}, nil)
+			db.PatchStub = func(_ context.Context, id string, username string, change func(models.Note) (models.Note, error)) (models.Note, error) {
+				note, err := change(models.Note{Id: id, Name: "Vampires", User: models.User{Username: username}})
+				mu.Lock()
+				saved = append(saved, note.Content)
+				mu.Unlock()
+				db.ListActiveNotesReturns([]models.Note{note}, nil)
+				return note, err
+			}
+			saved = nil
+			conns = nil
+
+			hub = collab.NewHub(db, time.Hour)
+			router := mux.NewRouter()
+			router.Handle("/notes/{id}/collab", hub)
+			server = httptest.NewServer(router)
+		})
+
+		AfterEach(func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			hub.Close()
+			server.Close()
+		})
+
+		join := func(id string) (*websocket.Conn, collab.Message) {
+			url := "ws" + strings.TrimPrefix(server.URL, "http") + "/notes/" + id + "/collab?username=Buffy"
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			Expect(err).NotTo(HaveOccurred())
+			conns = append(conns, conn)
+
+			var snapshot collab.Message
+			Expect(conn.ReadJSON(&snapshot)).To(Succeed())
+			Expect(snapshot.Type).To(Equal(collab.MessageSnapshot))
+
+			return conn, snapshot
+		}
+
+		savedContent := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), saved...)
+		}
+
+		It("merges the ops of each participant, sends them to the others and saves the text when they leave", func() {
+			buffy, snapshot := join("1")
+			Expect(snapshot.Clock).To(Equal(uint64(2)))
+			Expect(snapshot.Elements).To(HaveLen(2))
+
+			spike, other := join("1")
+			Expect(other.Site).NotTo(Equal(snapshot.Site))
+
+			op := insert(3, snapshot.Site, &snapshot.Elements[1].ID, "!")
+			Expect(buffy.WriteJSON(collab.Message{Type: collab.MessageOps, Ops: []collab.Op{op}})).To(Succeed())
+
+			var message collab.Message
+			Expect(spike.ReadJSON(&message)).To(Succeed())
+			Expect(message).To(Equal(collab.Message{Type: collab.MessageOps, Site: snapshot.Site, Ops: []collab.Op{op}}))
+
+			buffy.Close()
+			spike.Close()
+			Eventually(savedContent).Should(Equal([]string{"hi!"}))
+
+			_, snapshot = join("1")
+			Expect(snapshot.Elements).To(HaveLen(3))
+			Expect(snapshot.Elements[2].Value).To(Equal("!"))
+		})
+
+		It("rejects inserts made for another site", func() {
+			buffy, snapshot := join("1")
+
+			op := insert(3, "someone-else", &snapshot.Elements[1].ID, "!")
+			Expect(buffy.WriteJSON(collab.Message{Type: collab.MessageOps, Ops: []collab.Op{op}})).To(Succeed())
+
+			var message collab.Message
+			Expect(buffy.ReadJSON(&message)).To(Succeed())
+			Expect(message.Type).To(Equal(collab.MessageError))
+			Expect(message.Message).To(Equal(`inserts must use site "` + snapshot.Site + `"`))
+
+			buffy.Close()
+			Consistently(savedContent, 100*time.Millisecond).Should(BeEmpty())
+		})
+
+		It("drops a participant that cannot keep up with the errors for its ops", func() {
+			var (
+				log   bytes.Buffer
+				stall sync.RWMutex
+			)
+			server.Close()
+			server = httptest.NewUnstartedServer(server.Config.Handler)
+			server.Listener = stallingListener{Listener: server.Listener, stall: &stall}
+			server.Config.ErrorLog = stdlog.New(&log, "", 0)
+			server.Start()
+
+			buffy, first := join("1")
+			spike, snapshot := join("1")
+
+			ops := make([]collab.Op, 1000)
+			for i := range ops {
+				ops[i] = remove(uint64(i+100), "buffy")
+			}
+			ops = append(ops, insert(3, snapshot.Site, nil, "o"))
+
+			stall.Lock()
+			Expect(spike.WriteJSON(collab.Message{Type: collab.MessageOps, Ops: ops})).To(Succeed())
+			// Give the server time to merge the ops while it cannot send.
+			time.Sleep(200 * time.Millisecond)
+			stall.Unlock()
+
+			Eventually(func() error {
+				var message collab.Message
+				return spike.ReadJSON(&message)
+			}, "5s").Should(MatchError(ContainSubstring("close")))
+
+			op := insert(4, first.Site, nil, "!")
+			Expect(buffy.WriteJSON(collab.Message{Type: collab.MessageOps, Ops: []collab.Op{op}})).To(Succeed())
+			buffy.Close()
+			Eventually(savedContent).Should(Equal([]string{"!hi"}))
+
+			server.Close()
+			Expect(log.String()).NotTo(ContainSubstring("panic"))
+		})
+
+		It("starts a new session from the text saved when the last one closed", func() {
+			saving := make(chan struct{})
+			patch := db.PatchStub
+			db.PatchStub = func(ctx context.Context, id string, username string, change func(models.Note) (models.Note, error)) (models.Note, error) {
+				close(saving)
+				time.Sleep(100 * time.Millisecond)
+				return patch(ctx, id, username, change)
+			}
+
+			buffy, snapshot := join("1")
+			op := insert(3, snapshot.Site, &snapshot.Elements[1].ID, "!")
+			Expect(buffy.WriteJSON(collab.Message{Type: collab.MessageOps, Ops: []collab.Op{op}})).To(Succeed())
+			buffy.Close()
+			Eventually(saving).Should(BeClosed())
+
+			_, snapshot = join("1")
+			Expect(snapshot.Elements).To(HaveLen(3))
+			Expect(snapshot.Elements[2].Value).To(Equal("!"))
+		})
+
+		It("saves the text when shutting down", func() {
+			buffy, snapshot := join("1")
+			// The error for the second op shows the first one was merged.
+			ops := []collab.Op{insert(3, snapshot.Site, nil, "o"), remove(9, "buffy")}
+			Expect(buffy.WriteJSON(collab.Message{Type: collab.MessageOps, Ops: ops})).To(Succeed())
+			var message collab.Message
+			Expect(buffy.ReadJSON(&message)).To(Succeed())
+			Expect(message.Type).To(Equal(collab.MessageError))
+
+			hub.Close()
+			Expect(savedContent()).To(Equal([]string{"ohi"}))
+
+			_, _, err := buffy.ReadMessage()
+			Expect(websocket.IsCloseError(err, websocket.CloseNormalClosure)).To(BeTrue())
+		})
+
+		It("fails for notes the user does not have", func() {
+			resp, err := http.Get(server.URL + "/notes/2/collab?username=Buffy")
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		It("fails when the note cannot be looked up", func() {
+			db.ListActiveNotesReturns(nil, errors.New("boom"))
+
+			resp, err := http.Get(server.URL + "/notes/1/collab?username=Buffy")
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
+
+// stallingListener accepts connections whose writes wait while stall is
+// locked, as if the client stopped reading.
+type stallingListener struct {
+	net.Listener
+	stall *sync.RWMutex
+}
+
+func (l stallingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return stallingConn{Conn: conn, stall: l.stall}, nil
+}
+
+type stallingConn struct {
+	net.Conn
+	stall *sync.RWMutex
+}
+
+func (c stallingConn) Write(p []byte) (int, error) {
+	c.stall.RLock()
+	defer c.stall.RUnlock()
+
+	return c.Conn.Write(p)
+}
@@ -0,0 +1,474 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/logging"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/responses"
+)
+
+// errClosed is returned when joining a session after the hub was closed.
+var errClosed = errors.New("hub is closed")
+
+const (
+	MessageSnapshot = "snapshot"
+	MessageOps      = "ops"
+	MessageError    = "error"
+
+	// serverSite inserted the content a session starts from.
+	serverSite = "server"
+
+	maxMessageSize = 1 << 20
+	sendBuffer     = 256
+	pingPeriod     = 30 * time.Second
+	pongWait       = 2 * pingPeriod
+	writeWait      = 10 * time.Second
+)
+
+// Message is what the server and participants send each other. Participants
+// send their ops; the server answers with a snapshot when they join, the
+// ops of the others as they are merged, and errors for ops it rejected.
+type Message struct {
+	Type     string    `json:"type"`
+	Site     string    `json:"site,omitempty"`
+	Clock    uint64    `json:"clock,omitempty"`
+	Elements []Element `json:"elements,omitempty"`
+	Ops      []Op      `json:"ops,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// Hub runs the editing sessions of notes. A session starts from the stored
+// note when its first participant joins, and its text is saved every
+// saveEvery while it changes and when the last participant leaves.
+type Hub struct {
+	db        database.Database
+	saveEvery time.Duration
+	upgrader  websocket.Upgrader
+
+	mu    sync.Mutex
+	rooms map[string]*room
+	// closing holds the rooms saving their text for the last time, which new
+	// sessions of their note wait for.
+	closing map[string]*room
+	// closes counts the rooms that were closed, so that a session knows
+	// whether the note it read may have been saved since.
+	closes uint64
+	sites  uint64
+	closed bool
+}
+
+func NewHub(db database.Database, saveEvery time.Duration) *Hub {
+	return &Hub{
+		db:        db,
+		saveEvery: saveEvery,
+		rooms:     map[string]*room{},
+		closing:   map[string]*room{},
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket and adds it to the session
+// of the `{id}` note.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	logger := logging.FromContext(r.Context()).WithField("id", id)
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		fail(w, auth.OwnerFailure(err))
+		return
+	}
+	logging.SetUser(r.Context(), owner)
+
+	h.mu.Lock()
+	closes := h.closes
+	h.mu.Unlock()
+
+	note, err := h.find(r.Context(), id, owner)
+	if err != nil {
+		logger.WithError(err).Error("failed to find note")
+		fail(w, responses.Error(err))
+		return
+	}
+
+	if note == nil {
+		fail(w, responses.NotFound("note does not exist"))
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.WithError(err).Warn("failed to start editing session")
+		return
+	}
+
+	c := &client{conn: conn, send: make(chan Message, sendBuffer)}
+	room, err := h.join(r.Context(), *note, closes, c, logger)
+	if err != nil {
+		message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down")
+		if !errors.Is(err, errClosed) {
+			logger.WithError(err).Error("failed to start editing session")
+			message = websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "failed to start editing session")
+		}
+
+		conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+
+	go c.write()
+	c.read(room)
+	h.leave(room, c)
+}
+
+// Close ends every session, saving their text first.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	rooms := h.rooms
+	h.rooms = map[string]*room{}
+	h.mu.Unlock()
+
+	for _, room := range rooms {
+		room.close()
+	}
+}
+
+func (h *Hub) find(ctx context.Context, id string, owner string) (*models.Note, error) {
+	for _, list := range []func(context.Context, string) ([]models.Note, error){h.db.ListActiveNotes, h.db.ListArchivedNotes} {
+		notes, err := list(ctx, owner)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, note := range notes {
+			if note.Id == id {
+				return &note, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// join adds c to the session of note, starting one if there is none. note
+// was read when h had closed closes rooms: a new session only starts from it
+// if no room was closed since, as the text saved when closing may be newer.
+func (h *Hub) join(ctx context.Context, note models.Note, closes uint64, c *client, logger *logrus.Entry) (*room, error) {
+	for {
+		h.mu.Lock()
+		if h.closed {
+			h.mu.Unlock()
+			return nil, errClosed
+		}
+
+		r, ok := h.rooms[note.Id]
+		if !ok && h.closing[note.Id] == nil && h.closes == closes {
+			r = newRoom(h.db, note, h.saveEvery, logger)
+			h.rooms[note.Id] = r
+			ok = true
+		}
+
+		if ok {
+			h.sites++
+			c.site = fmt.Sprintf("site-%d", h.sites)
+			r.add(c)
+			h.mu.Unlock()
+
+			return r, nil
+		}
+
+		closing := h.closing[note.Id]
+		closes = h.closes
+		h.mu.Unlock()
+
+		if closing != nil {
+			select {
+			case <-closing.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		found, err := h.find(ctx, note.Id, note.User.Username)
+		if err != nil {
+			return nil, err
+		}
+		if found == nil {
+			return nil, database.ErrNoteNotFound
+		}
+		note = *found
+	}
+}
+
+func (h *Hub) leave(r *room, c *client) {
+	h.mu.Lock()
+	empty := r.remove(c)
+	if empty && h.rooms[r.note.Id] == r {
+		delete(h.rooms, r.note.Id)
+		h.closing[r.note.Id] = r
+	}
+	h.mu.Unlock()
+
+	if !empty {
+		return
+	}
+
+	r.close()
+
+	h.mu.Lock()
+	if h.closing[r.note.Id] == r {
+		delete(h.closing, r.note.Id)
+	}
+	h.closes++
+	h.mu.Unlock()
+}
+
+type room struct {
+	db     database.Database
+	note   models.Note
+	logger *logrus.Entry
+
+	mu      sync.Mutex
+	doc     *Document
+	clients map[*client]struct{}
+	dirty   bool
+
+	saveMu sync.Mutex
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newRoom(db database.Database, note models.Note, saveEvery time.Duration, logger *logrus.Entry) *room {
+	r := &room{
+		db:      db,
+		note:    note,
+		logger:  logger,
+		doc:     NewDocument(note.Content, serverSite),
+		clients: map[*client]struct{}{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go r.saveEvery(saveEvery)
+
+	return r
+}
+
+func (r *room) add(c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clients[c] = struct{}{}
+	c.send <- Message{Type: MessageSnapshot, Site: c.site, Clock: r.doc.Clock(), Elements: r.doc.Elements()}
+}
+
+// remove reports whether c was the last participant.
+func (r *room) remove(c *client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.clients[c]; ok {
+		delete(r.clients, c)
+		close(c.send)
+	}
+
+	return len(r.clients) == 0
+}
+
+// merge applies the ops of c and sends the ones that changed the document to
+// the other participants. The rest of the ops are dropped along with c when
+// it cannot keep up with the errors for them.
+func (r *room) merge(c *client, ops []Op) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var applied []Op
+	for _, op := range ops {
+		if _, ok := r.clients[c]; !ok {
+			break
+		}
+
+		if op.Type == OpInsert && op.ID.Site != c.site {
+			r.reject(c, fmt.Sprintf("inserts must use site %q", c.site))
+			continue
+		}
+
+		ok, err := r.doc.Apply(op)
+		if err != nil {
+			r.reject(c, err.Error())
+			continue
+		}
+
+		if ok {
+			applied = append(applied, op)
+		}
+	}
+
+	if len(applied) == 0 {
+		return
+	}
+	r.dirty = true
+
+	for other := range r.clients {
+		if other != c {
+			r.deliver(other, Message{Type: MessageOps, Site: c.site, Ops: applied})
+		}
+	}
+}
+
+func (r *room) reject(c *client, message string) {
+	r.deliver(c, Message{Type: MessageError, Message: message})
+}
+
+// deliver drops participants that fall too far behind, who can join again
+// for a new snapshot.
+func (r *room) deliver(c *client, message Message) {
+	if _, ok := r.clients[c]; !ok {
+		return
+	}
+
+	select {
+	case c.send <- message:
+	default:
+		delete(r.clients, c)
+		close(c.send)
+	}
+}
+
+func (r *room) saveEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.save()
+		}
+	}
+}
+
+// save stores the text through the same read-modify-write as a PATCH, which
+// leaves the rest of the note as it is.
+func (r *room) save() {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return
+	}
+	text := r.doc.Text()
+	r.dirty = false
+	r.mu.Unlock()
+
+	_, err := r.db.Patch(context.Background(), r.note.Id, r.note.User.Username, func(note models.Note) (models.Note, error) {
+		note.Content = text
+		return note, nil
+	})
+	if err != nil {
+		r.logger.WithError(err).Error("failed to save edited note")
+
+		r.mu.Lock()
+		r.dirty = true
+		r.mu.Unlock()
+	}
+}
+
+// close stops the session once its text is saved.
+func (r *room) close() {
+	r.once.Do(func() {
+		close(r.stop)
+		r.save()
+
+		r.mu.Lock()
+		for c := range r.clients {
+			delete(r.clients, c)
+			close(c.send)
+		}
+		r.mu.Unlock()
+
+		close(r.done)
+	})
+}
+
+type client struct {
+	conn *websocket.Conn
+	site string
+	send chan Message
+}
+
+func (c *client) read(r *room) {
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		var message Message
+		if err := c.conn.ReadJSON(&message); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				continue
+			}
+			return
+		}
+
+		if message.Type == MessageOps {
+			r.merge(c, message.Ops)
+		}
+	}
+}
+
+// write sends the messages for c until its channel is closed, then closes
+// the connection, which also ends read.
+func (c *client) write() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+
+			if err := c.conn.WriteJSON(message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func fail(w http.ResponseWriter, response responses.JsonNoteResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.StatusCode)
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,165 @@
+package collab
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	OpInsert = "insert"
+	OpDelete = "delete"
+)
+
+// ID identifies an element of a document: the Lamport clock of the site that
+// inserted it when it did, and the site.
+type ID struct {
+	Clock uint64 `json:"clock"`
+	Site  string `json:"site"`
+}
+
+// follows orders elements inserted at the same place: the newest comes first.
+func (id ID) follows(other ID) bool {
+	if id.Clock != other.Clock {
+		return id.Clock > other.Clock
+	}
+
+	return id.Site > other.Site
+}
+
+// Op is an insert of one character after the element After, or at the start
+// when After is nil, or the delete of the element ID.
+type Op struct {
+	Type  string `json:"type"`
+	ID    ID     `json:"id"`
+	After *ID    `json:"after,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// Element is a character of a document. Deleted elements are kept so that
+// inserts after them can still be placed.
+type Element struct {
+	ID      ID     `json:"id"`
+	Value   string `json:"value"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Document is a Replicated Growable Array of characters. Replicas applying
+// the same operations, in any order that keeps each insert after the
+// element it follows, end up with the same text.
+type Document struct {
+	elements []Element
+	clock    uint64
+}
+
+// NewDocument starts a document holding text, inserted by site.
+func NewDocument(text string, site string) *Document {
+	d := &Document{}
+	for _, r := range text {
+		d.clock++
+		d.elements = append(d.elements, Element{ID: ID{Clock: d.clock, Site: site}, Value: string(r)})
+	}
+
+	return d
+}
+
+// Apply merges op into the document. Operations that were already applied
+// are ignored, and applied is false for them.
+func (d *Document) Apply(op Op) (applied bool, err error) {
+	if op.ID.Site == "" || op.ID.Clock == 0 {
+		return false, errors.New("id must have a clock and a site")
+	}
+
+	switch op.Type {
+	case OpInsert:
+		return d.insert(op)
+	case OpDelete:
+		i := d.find(op.ID)
+		if i < 0 {
+			return false, fmt.Errorf("element %d@%s does not exist", op.ID.Clock, op.ID.Site)
+		}
+
+		if d.elements[i].Deleted {
+			return false, nil
+		}
+
+		d.elements[i].Deleted = true
+		return true, nil
+	}
+
+	return false, fmt.Errorf("op must be %q or %q, got %q", OpInsert, OpDelete, op.Type)
+}
+
+func (d *Document) insert(op Op) (bool, error) {
+	if utf8.RuneCountInString(op.Value) != 1 {
+		return false, errors.New("value must be one character")
+	}
+
+	if d.find(op.ID) >= 0 {
+		return false, nil
+	}
+
+	i := 0
+	if op.After != nil {
+		// Placing inserts relies on them being newer than what they follow.
+		if op.ID.Clock <= op.After.Clock {
+			return false, errors.New("clock must be higher than the one of the element it follows")
+		}
+
+		after := d.find(*op.After)
+		if after < 0 {
+			return false, fmt.Errorf("element %d@%s does not exist", op.After.Clock, op.After.Site)
+		}
+		i = after + 1
+	}
+
+	// Newer inserts at the same place, and whatever was inserted after them,
+	// come first. Their clocks are all higher than the new element's.
+	for i < len(d.elements) && d.elements[i].ID.follows(op.ID) {
+		i++
+	}
+
+	d.elements = append(d.elements, Element{})
+	copy(d.elements[i+1:], d.elements[i:])
+	d.elements[i] = Element{ID: op.ID, Value: op.Value}
+
+	if op.ID.Clock > d.clock {
+		d.clock = op.ID.Clock
+	}
+
+	return true, nil
+}
+
+func (d *Document) find(id ID) int {
+	for i, element := range d.elements {
+		if element.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Text is the document's content.
+func (d *Document) Text() string {
+	var text strings.Builder
+	for _, element := range d.elements {
+		if !element.Deleted {
+			text.WriteString(element.Value)
+		}
+	}
+
+	return text.String()
+}
+
+// Elements lists the document in order, deleted elements included.
+func (d *Document) Elements() []Element {
+	return append([]Element(nil), d.elements...)
+}
+
+// Clock is the highest clock of the document's elements. New inserts must
+// use a higher one.
+func (d *Document) Clock() uint64 {
+	return d.clock
+}
@@ -0,0 +1,662 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/encryption"
+	"github.com/m-rcd/notes/pkg/utils"
+)
+
+const redacted = "<redacted>"
+
+type Config struct {
+	Database DatabaseConfig `yaml:"database"`
+	Server   ServerConfig   `yaml:"server"`
+	Log      LogConfig      `yaml:"log"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+	API      APIConfig      `yaml:"api"`
+	Webhooks WebhooksConfig `yaml:"webhooks"`
+}
+
+type DatabaseConfig struct {
+	Type              string                   `yaml:"type"`
+	Directory         string                   `yaml:"directory"`
+	Timeout           time.Duration            `yaml:"timeout"`
+	OperationTimeouts map[string]time.Duration `yaml:"operation_timeouts"`
+	SQL               SQLConfig                `yaml:"sql"`
+	Encryption        EncryptionConfig         `yaml:"encryption"`
+}
+
+type SQLConfig struct {
+	Host         string `yaml:"host"`
+	Port         string `yaml:"port"`
+	Name         string `yaml:"name"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// EncryptionConfig holds the master keys encrypting note content, base64
+// encoded and separated by commas or newlines, the current one first.
+type EncryptionConfig struct {
+	Key     string `yaml:"key"`
+	KeyFile string `yaml:"key_file"`
+}
+
+type ServerConfig struct {
+	Address         string        `yaml:"address"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	ShutdownDelay   time.Duration `yaml:"shutdown_delay"`
+	TLS             TLSConfig     `yaml:"tls"`
+}
+
+type TLSConfig struct {
+	CertFile        string `yaml:"cert_file"`
+	KeyFile         string `yaml:"key_file"`
+	ClientCAFile    string `yaml:"client_ca_file"`
+	RedirectAddress string `yaml:"redirect_address"`
+}
+
+type LogConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter"`
+	Endpoint string `yaml:"endpoint"`
+}
+
+type APIConfig struct {
+	LegacySunset       string        `yaml:"legacy_sunset"`
+	IdempotencyWindow  time.Duration `yaml:"idempotency_window"`
+	CollabSaveInterval time.Duration `yaml:"collab_save_interval"`
+	Admins             []string      `yaml:"admins"`
+}
+
+type WebhooksConfig struct {
+	Workers     int `yaml:"workers"`
+	MaxAttempts int `yaml:"max_attempts"`
+	// AllowInternal lets webhooks be delivered to loopback, private and
+	// link-local addresses, which are refused by default.
+	AllowInternal bool `yaml:"allow_internal"`
+}
+
+const dateFormat = "2006-01-02"
+
+// LegacySunsetDate is the day after which the unversioned routes may be
+// removed.
+func (c APIConfig) LegacySunsetDate() (time.Time, error) {
+	return time.Parse(dateFormat, c.LegacySunset)
+}
+
+// Keyring parses the master keys, and is nil when note content is not
+// encrypted.
+func (c EncryptionConfig) Keyring() (*encryption.Keyring, error) {
+	if !utils.IsSet(c.Key) {
+		return nil, nil
+	}
+
+	return encryption.ParseKeys(c.Key)
+}
+
+// Backend is the configuration of the backend spec names, either
+// `local:<directory>` or `sql:<username>:<password>@<host>:<port>/<name>`,
+// with the parts it leaves out taken from c.
+func (c DatabaseConfig) Backend(spec string) (DatabaseConfig, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	rest := ""
+	if len(parts) == 2 {
+		rest = parts[1]
+	}
+
+	switch parts[0] {
+	case "local":
+		c.Type = "local"
+		if utils.IsSet(rest) {
+			c.Directory = rest
+		}
+	case "sql":
+		c.Type = "sql"
+		if !utils.IsSet(rest) {
+			break
+		}
+
+		// The error would hold the password, so it is not given.
+		u, err := url.Parse("mysql://" + rest)
+		if err != nil {
+			return DatabaseConfig{}, errors.New("invalid sql backend, expected sql:<username>:<password>@<host>:<port>/<name>")
+		}
+
+		if u.User != nil {
+			c.SQL.Username = u.User.Username()
+			if password, ok := u.User.Password(); ok {
+				c.SQL.Password = password
+			}
+		}
+		if utils.IsSet(u.Hostname()) {
+			c.SQL.Host = u.Hostname()
+		}
+		if utils.IsSet(u.Port()) {
+			c.SQL.Port = u.Port()
+		}
+		if name := strings.TrimPrefix(u.Path, "/"); utils.IsSet(name) {
+			c.SQL.Name = name
+		}
+	default:
+		return DatabaseConfig{}, fmt.Errorf("backend must be local:<directory> or sql:<username>:<password>@<host>:<port>/<name>, got %q", parts[0])
+	}
+
+	return c, nil
+}
+
+type setting struct {
+	flag   string
+	env    []string
+	usage  string
+	secret bool
+	bind   func(fs *flag.FlagSet, c *Config, name, usage string)
+}
+
+func stringSetting(field func(c *Config) *string) func(*flag.FlagSet, *Config, string, string) {
+	return func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.StringVar(field(c), name, *field(c), usage)
+	}
+}
+
+func intSetting(field func(c *Config) *int) func(*flag.FlagSet, *Config, string, string) {
+	return func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.IntVar(field(c), name, *field(c), usage)
+	}
+}
+
+func boolSetting(field func(c *Config) *bool) func(*flag.FlagSet, *Config, string, string) {
+	return func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.BoolVar(field(c), name, *field(c), usage)
+	}
+}
+
+func durationSetting(field func(c *Config) *time.Duration) func(*flag.FlagSet, *Config, string, string) {
+	return func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.DurationVar(field(c), name, *field(c), usage)
+	}
+}
+
+func durationMapSetting(field func(c *Config) *map[string]time.Duration) func(*flag.FlagSet, *Config, string, string) {
+	return func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.Var((*durationMap)(field(c)), name, usage)
+	}
+}
+
+func stringListSetting(field func(c *Config) *[]string) func(*flag.FlagSet, *Config, string, string) {
+	return func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.Var((*stringList)(field(c)), name, usage)
+	}
+}
+
+// stringList is a flag.Value for comma separated strings.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); utils.IsSet(item) {
+			list = append(list, item)
+		}
+	}
+	*s = list
+
+	return nil
+}
+
+// durationMap is a flag.Value for comma separated `key=duration` pairs.
+type durationMap map[string]time.Duration
+
+func (d *durationMap) String() string {
+	var pairs []string
+	for key, value := range *d {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func (d *durationMap) Set(value string) error {
+	m := map[string]time.Duration{}
+	for _, pair := range strings.Split(value, ",") {
+		if !utils.IsSet(pair) {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected key=duration, got %q", pair)
+		}
+
+		duration, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return err
+		}
+		m[strings.TrimSpace(parts[0])] = duration
+	}
+	*d = m
+
+	return nil
+}
+
+// settings lists every value that can be set from the environment or the
+// command line. Environment variables are checked in order and the first one
+// set wins.
+var settings = []setting{
+	{flag: "db", env: []string{"NOTES_DB"}, usage: "store notes on the local filesystem or in an SQL database (`local` or `sql`)",
+		bind: stringSetting(func(c *Config) *string { return &c.Database.Type })},
+	{flag: "directory", env: []string{"NOTES_DIRECTORY"}, usage: "notes location when `--db` set to `local`",
+		bind: stringSetting(func(c *Config) *string { return &c.Database.Directory })},
+	{flag: "db-timeout", env: []string{"NOTES_DB_TIMEOUT"}, usage: "maximum duration of a storage operation, 0 for no limit",
+		bind: durationSetting(func(c *Config) *time.Duration { return &c.Database.Timeout })},
+	{flag: "db-operation-timeouts", env: []string{"NOTES_DB_OPERATION_TIMEOUTS"}, usage: "per operation overrides of `--db-timeout`, e.g. `list_active_notes=30s,create=2s`",
+		bind: durationMapSetting(func(c *Config) *map[string]time.Duration { return &c.Database.OperationTimeouts })},
+	{flag: "db-host", env: []string{"NOTES_DB_HOST", "DB_HOST"}, usage: "SQL server host",
+		bind: stringSetting(func(c *Config) *string { return &c.Database.SQL.Host })},
+	{flag: "db-port", env: []string{"NOTES_DB_PORT", "DB_PORT"}, usage: "SQL server port",
+		bind: stringSetting(func(c *Config) *string { return &c.Database.SQL.Port })},
+	{flag: "db-name", env: []string{"NOTES_DB_NAME", "DB_NAME"}, usage: "SQL database name",
+		bind: stringSetting(func(c *Config) *string { return &c.Database.SQL.Name })},
+	{flag: "db-username", env: []string{"NOTES_DB_USERNAME", "DB_USERNAME"}, usage: "SQL username",
+		bind: stringSetting(func(c *Config) *string { return &c.Database.SQL.Username })},
+	{flag: "db-password", env: []string{"NOTES_DB_PASSWORD", "DB_PASSWORD"}, secret: true,
+		bind: stringSetting(func(c *Config) *string { return &c.Database.SQL.Password })},
+	{flag: "db-password-file", env: []string{"NOTES_DB_PASSWORD_FILE", "DB_PASSWORD_FILE"}, usage: "file to read the SQL password from",
+		bind: stringSetting(func(c *Config) *string { return &c.Database.SQL.PasswordFile })},
+	{flag: "encryption-key", env: []string{"NOTES_ENCRYPTION_KEY"}, secret: true,
+		bind: stringSetting(func(c *Config) *string { return &c.Database.Encryption.Key })},
+	{flag: "encryption-key-file", env: []string{"NOTES_ENCRYPTION_KEY_FILE"}, usage: "file with the base64 encoded 32 byte keys encrypting note content, one per line, the current one first",
+		bind: stringSetting(func(c *Config) *string { return &c.Database.Encryption.KeyFile })},
+	{flag: "address", env: []string{"NOTES_ADDRESS"}, usage: "address for the server to listen on",
+		bind: stringSetting(func(c *Config) *string { return &c.Server.Address })},
+	{flag: "read-timeout", env: []string{"NOTES_READ_TIMEOUT"}, usage: "maximum duration for reading an entire request",
+		bind: durationSetting(func(c *Config) *time.Duration { return &c.Server.ReadTimeout })},
+	{flag: "write-timeout", env: []string{"NOTES_WRITE_TIMEOUT"}, usage: "maximum duration before timing out the writing of a response",
+		bind: durationSetting(func(c *Config) *time.Duration { return &c.Server.WriteTimeout })},
+	{flag: "idle-timeout", env: []string{"NOTES_IDLE_TIMEOUT"}, usage: "maximum time to wait for the next request on a keep-alive connection",
+		bind: durationSetting(func(c *Config) *time.Duration { return &c.Server.IdleTimeout })},
+	{flag: "shutdown-timeout", env: []string{"NOTES_SHUTDOWN_TIMEOUT"}, usage: "maximum time to wait for in-flight requests to finish when shutting down",
+		bind: durationSetting(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+	{flag: "shutdown-delay", env: []string{"NOTES_SHUTDOWN_DELAY"}, usage: "time to keep serving after reporting not ready when shutting down, so load balancers can stop sending traffic",
+		bind: durationSetting(func(c *Config) *time.Duration { return &c.Server.ShutdownDelay })},
+	{flag: "tls-cert", env: []string{"NOTES_TLS_CERT"}, usage: "path to a PEM encoded certificate to serve HTTPS with, reloaded when it changes",
+		bind: stringSetting(func(c *Config) *string { return &c.Server.TLS.CertFile })},
+	{flag: "tls-key", env: []string{"NOTES_TLS_KEY"}, usage: "path to the PEM encoded private key for `--tls-cert`",
+		bind: stringSetting(func(c *Config) *string { return &c.Server.TLS.KeyFile })},
+	{flag: "tls-client-ca", env: []string{"NOTES_TLS_CLIENT_CA"}, usage: "path to a PEM encoded CA bundle; when set clients must present a certificate signed by it",
+		bind: stringSetting(func(c *Config) *string { return &c.Server.TLS.ClientCAFile })},
+	{flag: "tls-redirect-address", env: []string{"NOTES_TLS_REDIRECT_ADDRESS"}, usage: "address to listen on for plain HTTP and redirect to HTTPS, e.g. `:8080`",
+		bind: stringSetting(func(c *Config) *string { return &c.Server.TLS.RedirectAddress })},
+	{flag: "log-format", env: []string{"NOTES_LOG_FORMAT"}, usage: "format of the logs (`json` or `logfmt`)",
+		bind: stringSetting(func(c *Config) *string { return &c.Log.Format })},
+	{flag: "log-level", env: []string{"NOTES_LOG_LEVEL"}, usage: "minimum level of the logs, e.g. `debug`, `info` or `error`",
+		bind: stringSetting(func(c *Config) *string { return &c.Log.Level })},
+	{flag: "tracing-exporter", env: []string{"NOTES_TRACING_EXPORTER"}, usage: "where to send traces (`none`, `stdout` or `otlp`)",
+		bind: stringSetting(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{flag: "tracing-endpoint", env: []string{"NOTES_TRACING_ENDPOINT"}, usage: "URL of the OTLP/HTTP collector when `--tracing-exporter` set to `otlp`",
+		bind: stringSetting(func(c *Config) *string { return &c.Tracing.Endpoint })},
+	{flag: "legacy-sunset", env: []string{"NOTES_LEGACY_SUNSET"}, usage: "date after which the unversioned routes may be removed, announced in their `Sunset` header (`YYYY-MM-DD`)",
+		bind: stringSetting(func(c *Config) *string { return &c.API.LegacySunset })},
+	{flag: "idempotency-window", env: []string{"NOTES_IDEMPOTENCY_WINDOW"}, usage: "how long the response to a POST request with an `Idempotency-Key` header is replayed to retries",
+		bind: durationSetting(func(c *Config) *time.Duration { return &c.API.IdempotencyWindow })},
+	{flag: "collab-save-interval", env: []string{"NOTES_COLLAB_SAVE_INTERVAL"}, usage: "how often a note edited over its `collab` WebSocket is saved while it changes",
+		bind: durationSetting(func(c *Config) *time.Duration { return &c.API.CollabSaveInterval })},
+	{flag: "admins", env: []string{"NOTES_ADMINS"}, usage: "comma separated users, by the common name of their client certificate, who can use the `/api/v1/admin` routes",
+		bind: stringListSetting(func(c *Config) *[]string { return &c.API.Admins })},
+	{flag: "webhook-workers", env: []string{"NOTES_WEBHOOK_WORKERS"}, usage: "how many webhook deliveries are attempted at once",
+		bind: intSetting(func(c *Config) *int { return &c.Webhooks.Workers })},
+	{flag: "webhook-max-attempts", env: []string{"NOTES_WEBHOOK_MAX_ATTEMPTS"}, usage: "how many times a webhook delivery is attempted before it is given up on",
+		bind: intSetting(func(c *Config) *int { return &c.Webhooks.MaxAttempts })},
+	{flag: "webhook-allow-internal", env: []string{"NOTES_WEBHOOK_ALLOW_INTERNAL"}, usage: "let webhooks be delivered to loopback, private and link-local addresses",
+		bind: boolSetting(func(c *Config) *bool { return &c.Webhooks.AllowInternal })},
+}
+
+func Default() Config {
+	return Config{
+		Database: DatabaseConfig{
+			Type:      "local",
+			Directory: "/tmp",
+			Timeout:   10 * time.Second,
+			SQL: SQLConfig{
+				Host: "127.0.0.1",
+				Port: "3306",
+				Name: "notes",
+			},
+		},
+		Server: ServerConfig{
+			Address:         ":10000",
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Log: LogConfig{
+			Format: "logfmt",
+			Level:  "info",
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+			Endpoint: "http://localhost:4318",
+		},
+		API: APIConfig{
+			LegacySunset:       "2027-01-01",
+			IdempotencyWindow:  24 * time.Hour,
+			CollabSaveInterval: 5 * time.Second,
+		},
+		Webhooks: WebhooksConfig{
+			Workers:     4,
+			MaxAttempts: 5,
+		},
+	}
+}
+
+// Load builds the effective configuration. Values are taken from, in
+// increasing order of precedence: the defaults, the file given with
+// `--config` (or NOTES_CONFIG), environment variables and command line flags.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	return LoadCommand(name, args, lookupEnv, nil)
+}
+
+// LoadCommand is Load for commands with flags of their own, which define
+// adds to the configuration flags.
+func LoadCommand(name string, args []string, lookupEnv func(string) (string, bool), define func(fs *flag.FlagSet)) (Config, error) {
+	var (
+		path  string
+		given = Default()
+	)
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&path, "config", "", "path to a YAML configuration file")
+	bind(fs, &given, false)
+	if define != nil {
+		define(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if !utils.IsSet(path) {
+		path, _ = lookupEnv("NOTES_CONFIG")
+	}
+
+	config := Default()
+	if utils.IsSet(path) {
+		if err := loadFile(path, &config); err != nil {
+			return Config{}, err
+		}
+	}
+
+	target := flag.NewFlagSet(name, flag.ContinueOnError)
+	bind(target, &config, true)
+
+	for _, s := range settings {
+		for _, env := range s.env {
+			value, ok := lookupEnv(env)
+			if !ok {
+				continue
+			}
+
+			if err := target.Set(s.flag, value); err != nil {
+				return Config{}, fmt.Errorf("invalid value %q for %s: %s", value, env, err)
+			}
+			break
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || target.Lookup(f.Name) == nil || err != nil {
+			return
+		}
+		err = target.Set(f.Name, f.Value.String())
+	})
+	if err != nil {
+		return Config{}, err
+	}
+
+	if err := config.readSecrets(); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+func (c Config) Validate() error {
+	var problems []string
+
+	switch c.Database.Type {
+	case "local":
+		if !utils.IsSet(c.Database.Directory) {
+			problems = append(problems, "database.directory must be set (--directory, NOTES_DIRECTORY)")
+		}
+	case "sql":
+		sql := c.Database.SQL
+		if !utils.IsSet(sql.Username) {
+			problems = append(problems, "database.sql.username must be set (--db-username, DB_USERNAME)")
+		}
+		if !utils.IsSet(sql.Password) {
+			problems = append(problems, "database.sql.password must be set (DB_PASSWORD, or a file with --db-password-file, DB_PASSWORD_FILE)")
+		}
+		if !utils.IsSet(sql.Host) {
+			problems = append(problems, "database.sql.host must be set (--db-host, DB_HOST)")
+		}
+		if !utils.IsSet(sql.Name) {
+			problems = append(problems, "database.sql.name must be set (--db-name, DB_NAME)")
+		}
+		if port, err := strconv.Atoi(sql.Port); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("database.sql.port must be a number between 1 and 65535, got %q (--db-port, DB_PORT)", sql.Port))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("database.type must be `local` or `sql`, got %q (--db, NOTES_DB)", c.Database.Type))
+	}
+
+	if c.Database.Timeout < 0 {
+		problems = append(problems, fmt.Sprintf("database.timeout must not be negative, got %s", c.Database.Timeout))
+	}
+
+	if _, err := c.Database.Encryption.Keyring(); err != nil {
+		problems = append(problems, fmt.Sprintf("database.encryption.key is invalid: %s (NOTES_ENCRYPTION_KEY, or a file with --encryption-key-file, NOTES_ENCRYPTION_KEY_FILE)", err))
+	}
+
+	for _, operation := range sortedKeys(c.Database.OperationTimeouts) {
+		if !isOperation(operation) {
+			problems = append(problems, fmt.Sprintf("database.operation_timeouts has unknown operation %q, expected one of %s", operation, strings.Join(database.Operations, ", ")))
+		}
+		if timeout := c.Database.OperationTimeouts[operation]; timeout < 0 {
+			problems = append(problems, fmt.Sprintf("database.operation_timeouts.%s must not be negative, got %s", operation, timeout))
+		}
+	}
+
+	server := c.Server
+	if !utils.IsSet(server.Address) {
+		problems = append(problems, "server.address must be set (--address, NOTES_ADDRESS)")
+	}
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read_timeout", server.ReadTimeout},
+		{"write_timeout", server.WriteTimeout},
+		{"idle_timeout", server.IdleTimeout},
+		{"shutdown_timeout", server.ShutdownTimeout},
+		{"shutdown_delay", server.ShutdownDelay},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			problems = append(problems, fmt.Sprintf("server.%s must not be negative, got %s", timeout.name, timeout.value))
+		}
+	}
+
+	tls := server.TLS
+	if utils.IsSet(tls.CertFile) != utils.IsSet(tls.KeyFile) {
+		problems = append(problems, "server.tls.cert_file and server.tls.key_file must be set together (--tls-cert, --tls-key)")
+	}
+
+	if !utils.IsSet(tls.CertFile) && (utils.IsSet(tls.ClientCAFile) || utils.IsSet(tls.RedirectAddress)) {
+		problems = append(problems, "server.tls.client_ca_file and server.tls.redirect_address require server.tls.cert_file and server.tls.key_file")
+	}
+
+	if c.Log.Format != "json" && c.Log.Format != "logfmt" {
+		problems = append(problems, fmt.Sprintf("log.format must be `json` or `logfmt`, got %q (--log-format, NOTES_LOG_FORMAT)", c.Log.Format))
+	}
+
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		problems = append(problems, fmt.Sprintf("log.level is invalid: %s (--log-level, NOTES_LOG_LEVEL)", err))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if endpoint, err := url.Parse(c.Tracing.Endpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || !utils.IsSet(endpoint.Host) {
+			problems = append(problems, fmt.Sprintf("tracing.endpoint must be an http or https URL, got %q (--tracing-endpoint, NOTES_TRACING_ENDPOINT)", c.Tracing.Endpoint))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("tracing.exporter must be `none`, `stdout` or `otlp`, got %q (--tracing-exporter, NOTES_TRACING_EXPORTER)", c.Tracing.Exporter))
+	}
+
+	if _, err := c.API.LegacySunsetDate(); err != nil {
+		problems = append(problems, fmt.Sprintf("api.legacy_sunset must be a date like 2027-01-01, got %q (--legacy-sunset, NOTES_LEGACY_SUNSET)", c.API.LegacySunset))
+	}
+
+	if c.API.IdempotencyWindow <= 0 {
+		problems = append(problems, fmt.Sprintf("api.idempotency_window must be positive, got %s (--idempotency-window, NOTES_IDEMPOTENCY_WINDOW)", c.API.IdempotencyWindow))
+	}
+
+	if c.API.CollabSaveInterval <= 0 {
+		problems = append(problems, fmt.Sprintf("api.collab_save_interval must be positive, got %s (--collab-save-interval, NOTES_COLLAB_SAVE_INTERVAL)", c.API.CollabSaveInterval))
+	}
+
+	if c.Webhooks.Workers < 1 {
+		problems = append(problems, fmt.Sprintf("webhooks.workers must be at least 1, got %d (--webhook-workers, NOTES_WEBHOOK_WORKERS)", c.Webhooks.Workers))
+	}
+
+	if c.Webhooks.MaxAttempts < 1 {
+		problems = append(problems, fmt.Sprintf("webhooks.max_attempts must be at least 1, got %d (--webhook-max-attempts, NOTES_WEBHOOK_MAX_ATTEMPTS)", c.Webhooks.MaxAttempts))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+// Redacted returns a copy of the configuration that is safe to print.
+func (c Config) Redacted() Config {
+	if utils.IsSet(c.Database.SQL.Password) {
+		c.Database.SQL.Password = redacted
+	}
+
+	if utils.IsSet(c.Database.Encryption.Key) {
+		c.Database.Encryption.Key = redacted
+	}
+
+	return c
+}
+
+func (c Config) YAML() (string, error) {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+func (c *Config) readSecrets() error {
+	if utils.IsSet(c.Database.SQL.PasswordFile) {
+		password, err := readSecretFile(c.Database.SQL.PasswordFile)
+		if err != nil {
+			return err
+		}
+		c.Database.SQL.Password = password
+	}
+
+	if utils.IsSet(c.Database.Encryption.KeyFile) {
+		key, err := readSecretFile(c.Database.Encryption.KeyFile)
+		if err != nil {
+			return err
+		}
+		c.Database.Encryption.Key = key
+	}
+
+	return nil
+}
+
+func isOperation(name string) bool {
+	for _, operation := range database.Operations {
+		if operation == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortedKeys(m map[string]time.Duration) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func bind(fs *flag.FlagSet, c *Config, includeSecrets bool) {
+	for _, s := range settings {
+		if s.secret && !includeSecrets {
+			continue
+		}
+		s.bind(fs, c, s.flag, s.usage)
+	}
+}
+
+func loadFile(path string, c *Config) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := yaml.UnmarshalStrict(contents, c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func readSecretFile(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
@@ -0,0 +1,326 @@
+package config_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var (
+		tempDir string
+		env     map[string]string
+		err     error
+	)
+
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	writeFile := func(name, contents string) string {
+		path := filepath.Join(tempDir, name)
+		Expect(ioutil.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		tempDir, err = ioutil.TempDir("", "config_test")
+		Expect(err).NotTo(HaveOccurred())
+		env = map[string]string{}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	Context("Load", func() {
+		It("uses the defaults when nothing is set", func() {
+			cfg, err := config.Load("notes", []string{}, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg).To(Equal(config.Default()))
+			Expect(cfg.Validate()).To(Succeed())
+		})
+
+		It("reads values from the config file", func() {
+			path := writeFile("notes.yaml", `
+database:
+  type: sql
+  sql:
+    host: db.internal
+    username: Lyra
+    password: Pantalaimon
+server:
+  address: :8000
+  read_timeout: 5s
+`)
+
+			cfg, err := config.Load("notes", []string{"--config", path}, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Database.Type).To(Equal("sql"))
+			Expect(cfg.Database.SQL.Host).To(Equal("db.internal"))
+			Expect(cfg.Database.SQL.Port).To(Equal("3306"))
+			Expect(cfg.Database.SQL.Username).To(Equal("Lyra"))
+			Expect(cfg.Server.Address).To(Equal(":8000"))
+			Expect(cfg.Server.ReadTimeout).To(Equal(5 * time.Second))
+			Expect(cfg.Server.WriteTimeout).To(Equal(15 * time.Second))
+		})
+
+		It("gives environment variables precedence over the file and flags precedence over both", func() {
+			path := writeFile("notes.yaml", "server:\n  address: :8000\ndatabase:\n  directory: /file\n")
+			env["NOTES_CONFIG"] = path
+			env["NOTES_ADDRESS"] = ":9000"
+			env["NOTES_DIRECTORY"] = "/env"
+
+			cfg, err := config.Load("notes", []string{"--directory", "/flag"}, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Server.Address).To(Equal(":9000"))
+			Expect(cfg.Database.Directory).To(Equal("/flag"))
+		})
+
+		It("parses the flags of commands along with the configuration", func() {
+			var file string
+			cfg, err := config.LoadCommand("notes import", []string{"--file", "notes.zip", "--directory", "/flag"}, lookupEnv, func(fs *flag.FlagSet) {
+				fs.StringVar(&file, "file", "", "the file to import")
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file).To(Equal("notes.zip"))
+			Expect(cfg.Database.Directory).To(Equal("/flag"))
+		})
+
+		It("supports the legacy database environment variables", func() {
+			env["DB_USERNAME"] = "Lyra"
+			env["DB_PASSWORD"] = "Pantalaimon"
+
+			cfg, err := config.Load("notes", []string{"--db", "sql"}, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Database.SQL.Username).To(Equal("Lyra"))
+			Expect(cfg.Database.SQL.Password).To(Equal("Pantalaimon"))
+		})
+
+		It("reads per operation storage timeouts", func() {
+			path := writeFile("notes.yaml", "database:\n  operation_timeouts:\n    count_notes: 1m\n")
+			env["NOTES_DB_TIMEOUT"] = "2s"
+
+			cfg, err := config.Load("notes", []string{"--config", path, "--db-operation-timeouts", "create=500ms, list_active_notes=30s"}, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Database.Timeout).To(Equal(2 * time.Second))
+			Expect(cfg.Database.OperationTimeouts).To(Equal(map[string]time.Duration{
+				"create":            500 * time.Millisecond,
+				"list_active_notes": 30 * time.Second,
+			}))
+		})
+
+		It("reads whole number settings", func() {
+			env["NOTES_WEBHOOK_WORKERS"] = "8"
+
+			cfg, err := config.Load("notes", []string{"--webhook-max-attempts", "3"}, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Webhooks).To(Equal(config.WebhooksConfig{Workers: 8, MaxAttempts: 3}))
+		})
+
+		It("reads switches", func() {
+			cfg, err := config.Load("notes", nil, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Webhooks.AllowInternal).To(BeFalse())
+
+			cfg, err = config.Load("notes", []string{"--webhook-allow-internal"}, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Webhooks.AllowInternal).To(BeTrue())
+
+			env["NOTES_WEBHOOK_ALLOW_INTERNAL"] = "true"
+			cfg, err = config.Load("notes", nil, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Webhooks.AllowInternal).To(BeTrue())
+		})
+
+		It("reads lists of admins", func() {
+			path := writeFile("notes.yaml", "api:\n  admins: [giles]\n")
+
+			cfg, err := config.Load("notes", []string{"--config", path}, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.API.Admins).To(Equal([]string{"giles"}))
+
+			cfg, err = config.Load("notes", []string{"--admins", "giles, willow,"}, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.API.Admins).To(Equal([]string{"giles", "willow"}))
+		})
+
+		It("reads secrets from files", func() {
+			env["DB_PASSWORD"] = "ignored"
+			passwordFile := writeFile("password", "Pantalaimon\n")
+
+			cfg, err := config.Load("notes", []string{"--db-password-file", passwordFile}, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Database.SQL.Password).To(Equal("Pantalaimon"))
+		})
+
+		It("reads the encryption keys from a file", func() {
+			keyFile := writeFile("keys", "bmV3IGtleSB0aGF0IGlzIDMyIGJ5dGVzIGxvbmchISE=\nb2xkIGtleSB0aGF0IGlzIDMyIGJ5dGVzIGxvbmchISE=\n")
+
+			cfg, err := config.Load("notes", []string{"--encryption-key-file", keyFile}, lookupEnv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Validate()).To(Succeed())
+
+			keys, err := cfg.Database.Encryption.Keyring()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).NotTo(BeNil())
+		})
+
+		Context("when an error occurs", func() {
+			It("rejects unknown keys in the config file", func() {
+				path := writeFile("notes.yaml", "server:\n  adress: :8000\n")
+
+				_, err := config.Load("notes", []string{"--config", path}, lookupEnv)
+				Expect(err).To(MatchError(ContainSubstring("field adress not found")))
+			})
+
+			It("rejects invalid environment values", func() {
+				env["NOTES_READ_TIMEOUT"] = "soon"
+
+				_, err := config.Load("notes", []string{}, lookupEnv)
+				Expect(err).To(MatchError(ContainSubstring(`invalid value "soon" for NOTES_READ_TIMEOUT`)))
+			})
+
+			It("raises an error when the secret file is missing", func() {
+				_, err := config.Load("notes", []string{"--db-password-file", filepath.Join(tempDir, "missing")}, lookupEnv)
+				Expect(err).To(MatchError(ContainSubstring("failed to read secret file")))
+			})
+		})
+	})
+
+	Context("Validate", func() {
+		It("lists every problem", func() {
+			cfg := config.Default()
+			cfg.Database.Type = "sql"
+			cfg.Database.SQL.Port = "mysql"
+			cfg.Server.TLS.KeyFile = "tls.key"
+
+			err := cfg.Validate()
+			Expect(err).To(MatchError(ContainSubstring("database.sql.username must be set")))
+			Expect(err).To(MatchError(ContainSubstring("database.sql.password must be set")))
+			Expect(err).To(MatchError(ContainSubstring(`database.sql.port must be a number between 1 and 65535, got "mysql"`)))
+			Expect(err).To(MatchError(ContainSubstring("server.tls.cert_file and server.tls.key_file must be set together")))
+		})
+
+		It("rejects invalid encryption keys without showing them", func() {
+			cfg := config.Default()
+			cfg.Database.Encryption.Key = "c2hvcnQ="
+
+			err := cfg.Validate()
+			Expect(err).To(MatchError(ContainSubstring("database.encryption.key is invalid: encryption key 1 must be 32 random bytes, base64 encoded")))
+			Expect(err.Error()).NotTo(ContainSubstring("c2hvcnQ="))
+		})
+
+		It("rejects unknown database types", func() {
+			cfg := config.Default()
+			cfg.Database.Type = "mongo"
+
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("database.type must be `local` or `sql`, got \"mongo\"")))
+		})
+
+		It("rejects unknown storage operations", func() {
+			cfg := config.Default()
+			cfg.Database.OperationTimeouts = map[string]time.Duration{"archive": time.Second, "create": -time.Second}
+
+			err := cfg.Validate()
+			Expect(err).To(MatchError(ContainSubstring(`database.operation_timeouts has unknown operation "archive"`)))
+			Expect(err).To(MatchError(ContainSubstring("database.operation_timeouts.create must not be negative")))
+		})
+
+		It("rejects an invalid sunset date for the legacy routes", func() {
+			cfg := config.Default()
+			cfg.API.LegacySunset = "next year"
+
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring(`api.legacy_sunset must be a date like 2027-01-01, got "next year"`)))
+		})
+
+		It("rejects an idempotency window that is not positive", func() {
+			cfg := config.Default()
+			cfg.API.IdempotencyWindow = 0
+
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("api.idempotency_window must be positive, got 0s")))
+		})
+
+		It("rejects a collab save interval that is not positive", func() {
+			cfg := config.Default()
+			cfg.API.CollabSaveInterval = -time.Second
+
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("api.collab_save_interval must be positive, got -1s")))
+		})
+
+		It("rejects webhook settings below one", func() {
+			cfg := config.Default()
+			cfg.Webhooks.Workers = 0
+			cfg.Webhooks.MaxAttempts = -1
+
+			err := cfg.Validate()
+			Expect(err).To(MatchError(ContainSubstring("webhooks.workers must be at least 1, got 0")))
+			Expect(err).To(MatchError(ContainSubstring("webhooks.max_attempts must be at least 1, got -1")))
+		})
+
+		It("rejects invalid tracing settings", func() {
+			cfg := config.Default()
+			cfg.Tracing.Exporter = "jaeger"
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("tracing.exporter must be `none`, `stdout` or `otlp`, got \"jaeger\"")))
+
+			cfg.Tracing.Exporter = "otlp"
+			cfg.Tracing.Endpoint = "localhost:4318"
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("tracing.endpoint must be an http or https URL")))
+		})
+	})
+
+	Context("Backend", func() {
+		base := config.Default().Database
+
+		It("reads local backends", func() {
+			backend, err := base.Backend("local:/tmp/notes")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.Type).To(Equal("local"))
+			Expect(backend.Directory).To(Equal("/tmp/notes"))
+		})
+
+		It("reads sql backends, keeping the configured settings they leave out", func() {
+			base.SQL.Port = "3307"
+			backend, err := base.Backend("sql:root:s3cret@db.example.com/notes")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.Type).To(Equal("sql"))
+			Expect(backend.SQL).To(Equal(config.SQLConfig{Username: "root", Password: "s3cret", Host: "db.example.com", Port: "3307", Name: "notes"}))
+
+			backend, err = base.Backend("sql")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend.SQL).To(Equal(base.SQL))
+		})
+
+		It("rejects other backends without giving away passwords", func() {
+			_, err := base.Backend("mongo:somewhere")
+			Expect(err).To(MatchError(`backend must be local:<directory> or sql:<username>:<password>@<host>:<port>/<name>, got "mongo"`))
+
+			_, err = base.Backend("sql:root:s3cret@db.example.com:port/notes")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).NotTo(ContainSubstring("s3cret"))
+		})
+	})
+
+	Context("Redacted", func() {
+		It("hides secrets", func() {
+			cfg := config.Default()
+			cfg.Database.SQL.Password = "Pantalaimon"
+			cfg.Database.Encryption.Key = "bmV3IGtleSB0aGF0IGlzIDMyIGJ5dGVzIGxvbmchISE="
+
+			out, err := cfg.Redacted().YAML()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).NotTo(ContainSubstring("Pantalaimon"))
+			Expect(out).NotTo(ContainSubstring("bmV3IGtleSB0aGF0IGlzIDMyIGJ5dGVzIGxvbmchISE="))
+			Expect(out).To(ContainSubstring("password: <redacted>"))
+			Expect(out).To(ContainSubstring("read_timeout: 15s"))
+			Expect(cfg.Database.SQL.Password).To(Equal("Pantalaimon"))
+		})
+	})
+})
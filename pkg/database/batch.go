@@ -0,0 +1,37 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// Operation is one step of a batch: creating Note, changing the note with
+// Id through Apply, or deleting it.
+type Operation struct {
+	Kind  string
+	Id    string
+	Note  models.Note
+	Apply func(models.Note) (models.Note, error)
+}
+
+// BatchError reports the operation that made a batch fail. None of the
+// operations of a failed batch are applied.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d failed: %s", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
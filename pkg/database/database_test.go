@@ -0,0 +1,141 @@
+package database_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Database", func() {
+	Context("WithTimeouts", func() {
+		var (
+			fake_db *databasefakes.FakeDatabase
+			db      database.Database
+			body    io.ReadCloser
+		)
+
+		BeforeEach(func() {
+			fake_db = new(databasefakes.FakeDatabase)
+			db = database.WithTimeouts(fake_db, database.Timeouts{
+				Default:    time.Minute,
+				Operations: map[string]time.Duration{"list_active_notes": time.Hour, "count_notes": 0},
+			})
+			body = io.NopCloser(strings.NewReader(`{"username":"Buffy"}`))
+		})
+
+		It("gives operations the default deadline", func() {
+			fake_db.CreateReturns(models.Note{Id: "1"}, nil)
+
+			note, err := db.Create(context.Background(), body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(note.Id).To(Equal("1"))
+
+			ctx, gotBody := fake_db.CreateArgsForCall(0)
+			Expect(gotBody).To(Equal(body))
+			deadline, ok := ctx.Deadline()
+			Expect(ok).To(BeTrue())
+			Expect(time.Until(deadline)).To(BeNumerically("~", time.Minute, time.Second))
+		})
+
+		It("uses the timeout configured for an operation", func() {
+			_, err := db.ListActiveNotes(context.Background(), "Buffy")
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx, _ := fake_db.ListActiveNotesArgsForCall(0)
+			deadline, ok := ctx.Deadline()
+			Expect(ok).To(BeTrue())
+			Expect(time.Until(deadline)).To(BeNumerically("~", time.Hour, time.Second))
+		})
+
+		It("sets no deadline when the timeout is zero", func() {
+			_, _, err := db.CountNotes(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			_, ok := fake_db.CountNotesArgsForCall(0).Deadline()
+			Expect(ok).To(BeFalse())
+		})
+
+		It("sets no deadline for snapshots unless one is configured", func() {
+			Expect(db.Snapshot(context.Background(), func(database.Snapshot) error { return nil })).To(Succeed())
+
+			ctx, _ := fake_db.SnapshotArgsForCall(0)
+			_, ok := ctx.Deadline()
+			Expect(ok).To(BeFalse())
+
+			db = database.WithTimeouts(fake_db, database.Timeouts{Default: time.Minute, Operations: map[string]time.Duration{"snapshot": time.Hour}})
+			Expect(db.Snapshot(context.Background(), func(database.Snapshot) error { return nil })).To(Succeed())
+
+			ctx, _ = fake_db.SnapshotArgsForCall(1)
+			deadline, ok := ctx.Deadline()
+			Expect(ok).To(BeTrue())
+			Expect(time.Until(deadline)).To(BeNumerically("~", time.Hour, time.Second))
+		})
+
+		It("keeps an earlier deadline from the caller", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			Expect(db.Delete(ctx, "1", "Buffy")).To(Succeed())
+
+			got, _, _ := fake_db.DeleteArgsForCall(0)
+			deadline, ok := got.Deadline()
+			Expect(ok).To(BeTrue())
+			Expect(time.Until(deadline)).To(BeNumerically("<=", time.Second))
+		})
+
+		It("gives each note its own deadline rather than the whole iteration", func() {
+			db = database.WithTimeouts(fake_db, database.Timeouts{Default: 50 * time.Millisecond})
+			fake_db.EachNoteStub = func(ctx context.Context, _ string, fn func(models.Note) error) error {
+				for i := 0; i < 3; i++ {
+					time.Sleep(10 * time.Millisecond)
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+
+					if err := fn(models.Note{Id: fmt.Sprint(i)}); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			}
+
+			var ids []string
+			Expect(db.EachNote(context.Background(), "Buffy", func(note models.Note) error {
+				time.Sleep(40 * time.Millisecond)
+				ids = append(ids, note.Id)
+				return nil
+			})).To(Succeed())
+			Expect(ids).To(Equal([]string{"0", "1", "2"}))
+		})
+
+		It("times out a note that takes too long to read", func() {
+			db = database.WithTimeouts(fake_db, database.Timeouts{Default: 50 * time.Millisecond})
+			fake_db.EachNoteStub = func(ctx context.Context, _ string, fn func(models.Note) error) error {
+				if err := fn(models.Note{Id: "1"}); err != nil {
+					return err
+				}
+
+				<-ctx.Done()
+				return ctx.Err()
+			}
+
+			err := db.EachNote(context.Background(), "Buffy", func(models.Note) error { return nil })
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+
+		It("cancels the context once the operation returns", func() {
+			Expect(db.Ping(context.Background())).To(Succeed())
+
+			Expect(fake_db.PingArgsForCall(0).Err()).To(MatchError(context.Canceled))
+		})
+	})
+})
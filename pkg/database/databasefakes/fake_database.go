@@ -2,14 +2,80 @@
 package databasefakes
 
 import (
+	"context"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/m-rcd/notes/pkg/database"
 	"github.com/m-rcd/notes/pkg/models"
 )
 
 type FakeDatabase struct {
+	ApplyChangesStub        func(context.Context, string, []models.Change) ([]models.ChangeResult, error)
+	applyChangesMutex       sync.RWMutex
+	applyChangesArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 []models.Change
+	}
+	applyChangesReturns struct {
+		result1 []models.ChangeResult
+		result2 error
+	}
+	applyChangesReturnsOnCall map[int]struct {
+		result1 []models.ChangeResult
+		result2 error
+	}
+	BatchStub        func(context.Context, string, []database.Operation) ([]models.NoteResult, error)
+	batchMutex       sync.RWMutex
+	batchArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 []database.Operation
+	}
+	batchReturns struct {
+		result1 []models.NoteResult
+		result2 error
+	}
+	batchReturnsOnCall map[int]struct {
+		result1 []models.NoteResult
+		result2 error
+	}
+	ChangesStub        func(context.Context, string, uint64) ([]models.Change, uint64, error)
+	changesMutex       sync.RWMutex
+	changesArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 uint64
+	}
+	changesReturns struct {
+		result1 []models.Change
+		result2 uint64
+		result3 error
+	}
+	changesReturnsOnCall map[int]struct {
+		result1 []models.Change
+		result2 uint64
+		result3 error
+	}
+	ClaimIdempotencyKeyStub        func(context.Context, database.IdempotencyRecord, time.Time) (database.IdempotencyRecord, bool, error)
+	claimIdempotencyKeyMutex       sync.RWMutex
+	claimIdempotencyKeyArgsForCall []struct {
+		arg1 context.Context
+		arg2 database.IdempotencyRecord
+		arg3 time.Time
+	}
+	claimIdempotencyKeyReturns struct {
+		result1 database.IdempotencyRecord
+		result2 bool
+		result3 error
+	}
+	claimIdempotencyKeyReturnsOnCall map[int]struct {
+		result1 database.IdempotencyRecord
+		result2 bool
+		result3 error
+	}
 	CloseStub        func() error
 	closeMutex       sync.RWMutex
 	closeArgsForCall []struct {
@@ -20,10 +86,26 @@ type FakeDatabase struct {
 	closeReturnsOnCall map[int]struct {
 		result1 error
 	}
-	CreateStub        func(io.ReadCloser) (models.Note, error)
+	CountNotesStub        func(context.Context) (int, int, error)
+	countNotesMutex       sync.RWMutex
+	countNotesArgsForCall []struct {
+		arg1 context.Context
+	}
+	countNotesReturns struct {
+		result1 int
+		result2 int
+		result3 error
+	}
+	countNotesReturnsOnCall map[int]struct {
+		result1 int
+		result2 int
+		result3 error
+	}
+	CreateStub        func(context.Context, io.ReadCloser) (models.Note, error)
 	createMutex       sync.RWMutex
 	createArgsForCall []struct {
-		arg1 io.ReadCloser
+		arg1 context.Context
+		arg2 io.ReadCloser
 	}
 	createReturns struct {
 		result1 models.Note
@@ -33,11 +115,40 @@ type FakeDatabase struct {
 		result1 models.Note
 		result2 error
 	}
-	DeleteStub        func(string, io.ReadCloser) error
+	CreateWebhookStub        func(context.Context, models.Webhook) (models.Webhook, error)
+	createWebhookMutex       sync.RWMutex
+	createWebhookArgsForCall []struct {
+		arg1 context.Context
+		arg2 models.Webhook
+	}
+	createWebhookReturns struct {
+		result1 models.Webhook
+		result2 error
+	}
+	createWebhookReturnsOnCall map[int]struct {
+		result1 models.Webhook
+		result2 error
+	}
+	DataKeysStub        func(context.Context, string) ([]database.DataKey, error)
+	dataKeysMutex       sync.RWMutex
+	dataKeysArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	dataKeysReturns struct {
+		result1 []database.DataKey
+		result2 error
+	}
+	dataKeysReturnsOnCall map[int]struct {
+		result1 []database.DataKey
+		result2 error
+	}
+	DeleteStub        func(context.Context, string, string) error
 	deleteMutex       sync.RWMutex
 	deleteArgsForCall []struct {
-		arg1 string
-		arg2 io.ReadCloser
+		arg1 context.Context
+		arg2 string
+		arg3 string
 	}
 	deleteReturns struct {
 		result1 error
@@ -45,10 +156,37 @@ type FakeDatabase struct {
 	deleteReturnsOnCall map[int]struct {
 		result1 error
 	}
-	ListActiveNotesStub        func(io.ReadCloser) ([]models.Note, error)
+	DeleteWebhookStub        func(context.Context, string, string) error
+	deleteWebhookMutex       sync.RWMutex
+	deleteWebhookArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}
+	deleteWebhookReturns struct {
+		result1 error
+	}
+	deleteWebhookReturnsOnCall map[int]struct {
+		result1 error
+	}
+	EachNoteStub        func(context.Context, string, func(models.Note) error) error
+	eachNoteMutex       sync.RWMutex
+	eachNoteArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 func(models.Note) error
+	}
+	eachNoteReturns struct {
+		result1 error
+	}
+	eachNoteReturnsOnCall map[int]struct {
+		result1 error
+	}
+	ListActiveNotesStub        func(context.Context, string) ([]models.Note, error)
 	listActiveNotesMutex       sync.RWMutex
 	listActiveNotesArgsForCall []struct {
-		arg1 io.ReadCloser
+		arg1 context.Context
+		arg2 string
 	}
 	listActiveNotesReturns struct {
 		result1 []models.Note
@@ -58,10 +196,11 @@ type FakeDatabase struct {
 		result1 []models.Note
 		result2 error
 	}
-	ListArchivedNotesStub        func(io.ReadCloser) ([]models.Note, error)
+	ListArchivedNotesStub        func(context.Context, string) ([]models.Note, error)
 	listArchivedNotesMutex       sync.RWMutex
 	listArchivedNotesArgsForCall []struct {
-		arg1 io.ReadCloser
+		arg1 context.Context
+		arg2 string
 	}
 	listArchivedNotesReturns struct {
 		result1 []models.Note
@@ -71,6 +210,48 @@ type FakeDatabase struct {
 		result1 []models.Note
 		result2 error
 	}
+	ListDeliveriesStub        func(context.Context, string, string) ([]models.Delivery, error)
+	listDeliveriesMutex       sync.RWMutex
+	listDeliveriesArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}
+	listDeliveriesReturns struct {
+		result1 []models.Delivery
+		result2 error
+	}
+	listDeliveriesReturnsOnCall map[int]struct {
+		result1 []models.Delivery
+		result2 error
+	}
+	ListUsersStub        func(context.Context) ([]string, error)
+	listUsersMutex       sync.RWMutex
+	listUsersArgsForCall []struct {
+		arg1 context.Context
+	}
+	listUsersReturns struct {
+		result1 []string
+		result2 error
+	}
+	listUsersReturnsOnCall map[int]struct {
+		result1 []string
+		result2 error
+	}
+	ListWebhooksStub        func(context.Context, string) ([]models.Webhook, error)
+	listWebhooksMutex       sync.RWMutex
+	listWebhooksArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	listWebhooksReturns struct {
+		result1 []models.Webhook
+		result2 error
+	}
+	listWebhooksReturnsOnCall map[int]struct {
+		result1 []models.Webhook
+		result2 error
+	}
 	OpenStub        func() error
 	openMutex       sync.RWMutex
 	openArgsForCall []struct {
@@ -81,24 +262,409 @@ type FakeDatabase struct {
 	openReturnsOnCall map[int]struct {
 		result1 error
 	}
-	UpdateStub        func(string, io.ReadCloser) (models.Note, error)
-	updateMutex       sync.RWMutex
-	updateArgsForCall []struct {
-		arg1 string
-		arg2 io.ReadCloser
+	PatchStub        func(context.Context, string, string, func(models.Note) (models.Note, error)) (models.Note, error)
+	patchMutex       sync.RWMutex
+	patchArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+		arg4 func(models.Note) (models.Note, error)
+	}
+	patchReturns struct {
+		result1 models.Note
+		result2 error
 	}
-	updateReturns struct {
+	patchReturnsOnCall map[int]struct {
 		result1 models.Note
 		result2 error
 	}
-	updateReturnsOnCall map[int]struct {
+	PingStub        func(context.Context) error
+	pingMutex       sync.RWMutex
+	pingArgsForCall []struct {
+		arg1 context.Context
+	}
+	pingReturns struct {
+		result1 error
+	}
+	pingReturnsOnCall map[int]struct {
+		result1 error
+	}
+	PutNoteStub        func(context.Context, models.Note) (models.Note, bool, error)
+	putNoteMutex       sync.RWMutex
+	putNoteArgsForCall []struct {
+		arg1 context.Context
+		arg2 models.Note
+	}
+	putNoteReturns struct {
+		result1 models.Note
+		result2 bool
+		result3 error
+	}
+	putNoteReturnsOnCall map[int]struct {
 		result1 models.Note
+		result2 bool
+		result3 error
+	}
+	ReleaseIdempotencyKeyStub        func(context.Context, string) error
+	releaseIdempotencyKeyMutex       sync.RWMutex
+	releaseIdempotencyKeyArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	releaseIdempotencyKeyReturns struct {
+		result1 error
+	}
+	releaseIdempotencyKeyReturnsOnCall map[int]struct {
+		result1 error
+	}
+	SaveDataKeyStub        func(context.Context, database.DataKey) error
+	saveDataKeyMutex       sync.RWMutex
+	saveDataKeyArgsForCall []struct {
+		arg1 context.Context
+		arg2 database.DataKey
+	}
+	saveDataKeyReturns struct {
+		result1 error
+	}
+	saveDataKeyReturnsOnCall map[int]struct {
+		result1 error
+	}
+	SaveDeliveryStub        func(context.Context, models.Delivery) error
+	saveDeliveryMutex       sync.RWMutex
+	saveDeliveryArgsForCall []struct {
+		arg1 context.Context
+		arg2 models.Delivery
+	}
+	saveDeliveryReturns struct {
+		result1 error
+	}
+	saveDeliveryReturnsOnCall map[int]struct {
+		result1 error
+	}
+	SaveIdempotencyKeyStub        func(context.Context, database.IdempotencyRecord) error
+	saveIdempotencyKeyMutex       sync.RWMutex
+	saveIdempotencyKeyArgsForCall []struct {
+		arg1 context.Context
+		arg2 database.IdempotencyRecord
+	}
+	saveIdempotencyKeyReturns struct {
+		result1 error
+	}
+	saveIdempotencyKeyReturnsOnCall map[int]struct {
+		result1 error
+	}
+	SetArchivedStub        func(context.Context, string, []string, bool) ([]models.NoteResult, error)
+	setArchivedMutex       sync.RWMutex
+	setArchivedArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 []string
+		arg4 bool
+	}
+	setArchivedReturns struct {
+		result1 []models.NoteResult
+		result2 error
+	}
+	setArchivedReturnsOnCall map[int]struct {
+		result1 []models.NoteResult
 		result2 error
 	}
+	SnapshotStub        func(context.Context, func(database.Snapshot) error) error
+	snapshotMutex       sync.RWMutex
+	snapshotArgsForCall []struct {
+		arg1 context.Context
+		arg2 func(database.Snapshot) error
+	}
+	snapshotReturns struct {
+		result1 error
+	}
+	snapshotReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
+func (fake *FakeDatabase) ApplyChanges(arg1 context.Context, arg2 string, arg3 []models.Change) ([]models.ChangeResult, error) {
+	var arg3Copy []models.Change
+	if arg3 != nil {
+		arg3Copy = make([]models.Change, len(arg3))
+		copy(arg3Copy, arg3)
+	}
+	fake.applyChangesMutex.Lock()
+	ret, specificReturn := fake.applyChangesReturnsOnCall[len(fake.applyChangesArgsForCall)]
+	fake.applyChangesArgsForCall = append(fake.applyChangesArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 []models.Change
+	}{arg1, arg2, arg3Copy})
+	stub := fake.ApplyChangesStub
+	fakeReturns := fake.applyChangesReturns
+	fake.recordInvocation("ApplyChanges", []interface{}{arg1, arg2, arg3Copy})
+	fake.applyChangesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeDatabase) ApplyChangesCallCount() int {
+	fake.applyChangesMutex.RLock()
+	defer fake.applyChangesMutex.RUnlock()
+	return len(fake.applyChangesArgsForCall)
+}
+
+func (fake *FakeDatabase) ApplyChangesCalls(stub func(context.Context, string, []models.Change) ([]models.ChangeResult, error)) {
+	fake.applyChangesMutex.Lock()
+	defer fake.applyChangesMutex.Unlock()
+	fake.ApplyChangesStub = stub
+}
+
+func (fake *FakeDatabase) ApplyChangesArgsForCall(i int) (context.Context, string, []models.Change) {
+	fake.applyChangesMutex.RLock()
+	defer fake.applyChangesMutex.RUnlock()
+	argsForCall := fake.applyChangesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeDatabase) ApplyChangesReturns(result1 []models.ChangeResult, result2 error) {
+	fake.applyChangesMutex.Lock()
+	defer fake.applyChangesMutex.Unlock()
+	fake.ApplyChangesStub = nil
+	fake.applyChangesReturns = struct {
+		result1 []models.ChangeResult
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) ApplyChangesReturnsOnCall(i int, result1 []models.ChangeResult, result2 error) {
+	fake.applyChangesMutex.Lock()
+	defer fake.applyChangesMutex.Unlock()
+	fake.ApplyChangesStub = nil
+	if fake.applyChangesReturnsOnCall == nil {
+		fake.applyChangesReturnsOnCall = make(map[int]struct {
+			result1 []models.ChangeResult
+			result2 error
+		})
+	}
+	fake.applyChangesReturnsOnCall[i] = struct {
+		result1 []models.ChangeResult
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) Batch(arg1 context.Context, arg2 string, arg3 []database.Operation) ([]models.NoteResult, error) {
+	var arg3Copy []database.Operation
+	if arg3 != nil {
+		arg3Copy = make([]database.Operation, len(arg3))
+		copy(arg3Copy, arg3)
+	}
+	fake.batchMutex.Lock()
+	ret, specificReturn := fake.batchReturnsOnCall[len(fake.batchArgsForCall)]
+	fake.batchArgsForCall = append(fake.batchArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 []database.Operation
+	}{arg1, arg2, arg3Copy})
+	stub := fake.BatchStub
+	fakeReturns := fake.batchReturns
+	fake.recordInvocation("Batch", []interface{}{arg1, arg2, arg3Copy})
+	fake.batchMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeDatabase) BatchCallCount() int {
+	fake.batchMutex.RLock()
+	defer fake.batchMutex.RUnlock()
+	return len(fake.batchArgsForCall)
+}
+
+func (fake *FakeDatabase) BatchCalls(stub func(context.Context, string, []database.Operation) ([]models.NoteResult, error)) {
+	fake.batchMutex.Lock()
+	defer fake.batchMutex.Unlock()
+	fake.BatchStub = stub
+}
+
+func (fake *FakeDatabase) BatchArgsForCall(i int) (context.Context, string, []database.Operation) {
+	fake.batchMutex.RLock()
+	defer fake.batchMutex.RUnlock()
+	argsForCall := fake.batchArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeDatabase) BatchReturns(result1 []models.NoteResult, result2 error) {
+	fake.batchMutex.Lock()
+	defer fake.batchMutex.Unlock()
+	fake.BatchStub = nil
+	fake.batchReturns = struct {
+		result1 []models.NoteResult
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) BatchReturnsOnCall(i int, result1 []models.NoteResult, result2 error) {
+	fake.batchMutex.Lock()
+	defer fake.batchMutex.Unlock()
+	fake.BatchStub = nil
+	if fake.batchReturnsOnCall == nil {
+		fake.batchReturnsOnCall = make(map[int]struct {
+			result1 []models.NoteResult
+			result2 error
+		})
+	}
+	fake.batchReturnsOnCall[i] = struct {
+		result1 []models.NoteResult
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) Changes(arg1 context.Context, arg2 string, arg3 uint64) ([]models.Change, uint64, error) {
+	fake.changesMutex.Lock()
+	ret, specificReturn := fake.changesReturnsOnCall[len(fake.changesArgsForCall)]
+	fake.changesArgsForCall = append(fake.changesArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 uint64
+	}{arg1, arg2, arg3})
+	stub := fake.ChangesStub
+	fakeReturns := fake.changesReturns
+	fake.recordInvocation("Changes", []interface{}{arg1, arg2, arg3})
+	fake.changesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
+}
+
+func (fake *FakeDatabase) ChangesCallCount() int {
+	fake.changesMutex.RLock()
+	defer fake.changesMutex.RUnlock()
+	return len(fake.changesArgsForCall)
+}
+
+func (fake *FakeDatabase) ChangesCalls(stub func(context.Context, string, uint64) ([]models.Change, uint64, error)) {
+	fake.changesMutex.Lock()
+	defer fake.changesMutex.Unlock()
+	fake.ChangesStub = stub
+}
+
+func (fake *FakeDatabase) ChangesArgsForCall(i int) (context.Context, string, uint64) {
+	fake.changesMutex.RLock()
+	defer fake.changesMutex.RUnlock()
+	argsForCall := fake.changesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeDatabase) ChangesReturns(result1 []models.Change, result2 uint64, result3 error) {
+	fake.changesMutex.Lock()
+	defer fake.changesMutex.Unlock()
+	fake.ChangesStub = nil
+	fake.changesReturns = struct {
+		result1 []models.Change
+		result2 uint64
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeDatabase) ChangesReturnsOnCall(i int, result1 []models.Change, result2 uint64, result3 error) {
+	fake.changesMutex.Lock()
+	defer fake.changesMutex.Unlock()
+	fake.ChangesStub = nil
+	if fake.changesReturnsOnCall == nil {
+		fake.changesReturnsOnCall = make(map[int]struct {
+			result1 []models.Change
+			result2 uint64
+			result3 error
+		})
+	}
+	fake.changesReturnsOnCall[i] = struct {
+		result1 []models.Change
+		result2 uint64
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeDatabase) ClaimIdempotencyKey(arg1 context.Context, arg2 database.IdempotencyRecord, arg3 time.Time) (database.IdempotencyRecord, bool, error) {
+	fake.claimIdempotencyKeyMutex.Lock()
+	ret, specificReturn := fake.claimIdempotencyKeyReturnsOnCall[len(fake.claimIdempotencyKeyArgsForCall)]
+	fake.claimIdempotencyKeyArgsForCall = append(fake.claimIdempotencyKeyArgsForCall, struct {
+		arg1 context.Context
+		arg2 database.IdempotencyRecord
+		arg3 time.Time
+	}{arg1, arg2, arg3})
+	stub := fake.ClaimIdempotencyKeyStub
+	fakeReturns := fake.claimIdempotencyKeyReturns
+	fake.recordInvocation("ClaimIdempotencyKey", []interface{}{arg1, arg2, arg3})
+	fake.claimIdempotencyKeyMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
+}
+
+func (fake *FakeDatabase) ClaimIdempotencyKeyCallCount() int {
+	fake.claimIdempotencyKeyMutex.RLock()
+	defer fake.claimIdempotencyKeyMutex.RUnlock()
+	return len(fake.claimIdempotencyKeyArgsForCall)
+}
+
+func (fake *FakeDatabase) ClaimIdempotencyKeyCalls(stub func(context.Context, database.IdempotencyRecord, time.Time) (database.IdempotencyRecord, bool, error)) {
+	fake.claimIdempotencyKeyMutex.Lock()
+	defer fake.claimIdempotencyKeyMutex.Unlock()
+	fake.ClaimIdempotencyKeyStub = stub
+}
+
+func (fake *FakeDatabase) ClaimIdempotencyKeyArgsForCall(i int) (context.Context, database.IdempotencyRecord, time.Time) {
+	fake.claimIdempotencyKeyMutex.RLock()
+	defer fake.claimIdempotencyKeyMutex.RUnlock()
+	argsForCall := fake.claimIdempotencyKeyArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeDatabase) ClaimIdempotencyKeyReturns(result1 database.IdempotencyRecord, result2 bool, result3 error) {
+	fake.claimIdempotencyKeyMutex.Lock()
+	defer fake.claimIdempotencyKeyMutex.Unlock()
+	fake.ClaimIdempotencyKeyStub = nil
+	fake.claimIdempotencyKeyReturns = struct {
+		result1 database.IdempotencyRecord
+		result2 bool
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeDatabase) ClaimIdempotencyKeyReturnsOnCall(i int, result1 database.IdempotencyRecord, result2 bool, result3 error) {
+	fake.claimIdempotencyKeyMutex.Lock()
+	defer fake.claimIdempotencyKeyMutex.Unlock()
+	fake.ClaimIdempotencyKeyStub = nil
+	if fake.claimIdempotencyKeyReturnsOnCall == nil {
+		fake.claimIdempotencyKeyReturnsOnCall = make(map[int]struct {
+			result1 database.IdempotencyRecord
+			result2 bool
+			result3 error
+		})
+	}
+	fake.claimIdempotencyKeyReturnsOnCall[i] = struct {
+		result1 database.IdempotencyRecord
+		result2 bool
+		result3 error
+	}{result1, result2, result3}
+}
+
 func (fake *FakeDatabase) Close() error {
 	fake.closeMutex.Lock()
 	ret, specificReturn := fake.closeReturnsOnCall[len(fake.closeArgsForCall)]
@@ -152,18 +718,86 @@ func (fake *FakeDatabase) CloseReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeDatabase) Create(arg1 io.ReadCloser) (models.Note, error) {
+func (fake *FakeDatabase) CountNotes(arg1 context.Context) (int, int, error) {
+	fake.countNotesMutex.Lock()
+	ret, specificReturn := fake.countNotesReturnsOnCall[len(fake.countNotesArgsForCall)]
+	fake.countNotesArgsForCall = append(fake.countNotesArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.CountNotesStub
+	fakeReturns := fake.countNotesReturns
+	fake.recordInvocation("CountNotes", []interface{}{arg1})
+	fake.countNotesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
+}
+
+func (fake *FakeDatabase) CountNotesCallCount() int {
+	fake.countNotesMutex.RLock()
+	defer fake.countNotesMutex.RUnlock()
+	return len(fake.countNotesArgsForCall)
+}
+
+func (fake *FakeDatabase) CountNotesCalls(stub func(context.Context) (int, int, error)) {
+	fake.countNotesMutex.Lock()
+	defer fake.countNotesMutex.Unlock()
+	fake.CountNotesStub = stub
+}
+
+func (fake *FakeDatabase) CountNotesArgsForCall(i int) context.Context {
+	fake.countNotesMutex.RLock()
+	defer fake.countNotesMutex.RUnlock()
+	argsForCall := fake.countNotesArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeDatabase) CountNotesReturns(result1 int, result2 int, result3 error) {
+	fake.countNotesMutex.Lock()
+	defer fake.countNotesMutex.Unlock()
+	fake.CountNotesStub = nil
+	fake.countNotesReturns = struct {
+		result1 int
+		result2 int
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeDatabase) CountNotesReturnsOnCall(i int, result1 int, result2 int, result3 error) {
+	fake.countNotesMutex.Lock()
+	defer fake.countNotesMutex.Unlock()
+	fake.CountNotesStub = nil
+	if fake.countNotesReturnsOnCall == nil {
+		fake.countNotesReturnsOnCall = make(map[int]struct {
+			result1 int
+			result2 int
+			result3 error
+		})
+	}
+	fake.countNotesReturnsOnCall[i] = struct {
+		result1 int
+		result2 int
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeDatabase) Create(arg1 context.Context, arg2 io.ReadCloser) (models.Note, error) {
 	fake.createMutex.Lock()
 	ret, specificReturn := fake.createReturnsOnCall[len(fake.createArgsForCall)]
 	fake.createArgsForCall = append(fake.createArgsForCall, struct {
-		arg1 io.ReadCloser
-	}{arg1})
+		arg1 context.Context
+		arg2 io.ReadCloser
+	}{arg1, arg2})
 	stub := fake.CreateStub
 	fakeReturns := fake.createReturns
-	fake.recordInvocation("Create", []interface{}{arg1})
+	fake.recordInvocation("Create", []interface{}{arg1, arg2})
 	fake.createMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -177,17 +811,17 @@ func (fake *FakeDatabase) CreateCallCount() int {
 	return len(fake.createArgsForCall)
 }
 
-func (fake *FakeDatabase) CreateCalls(stub func(io.ReadCloser) (models.Note, error)) {
+func (fake *FakeDatabase) CreateCalls(stub func(context.Context, io.ReadCloser) (models.Note, error)) {
 	fake.createMutex.Lock()
 	defer fake.createMutex.Unlock()
 	fake.CreateStub = stub
 }
 
-func (fake *FakeDatabase) CreateArgsForCall(i int) io.ReadCloser {
+func (fake *FakeDatabase) CreateArgsForCall(i int) (context.Context, io.ReadCloser) {
 	fake.createMutex.RLock()
 	defer fake.createMutex.RUnlock()
 	argsForCall := fake.createArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeDatabase) CreateReturns(result1 models.Note, result2 error) {
@@ -216,80 +850,338 @@ func (fake *FakeDatabase) CreateReturnsOnCall(i int, result1 models.Note, result
 	}{result1, result2}
 }
 
-func (fake *FakeDatabase) Delete(arg1 string, arg2 io.ReadCloser) error {
-	fake.deleteMutex.Lock()
-	ret, specificReturn := fake.deleteReturnsOnCall[len(fake.deleteArgsForCall)]
-	fake.deleteArgsForCall = append(fake.deleteArgsForCall, struct {
-		arg1 string
-		arg2 io.ReadCloser
+func (fake *FakeDatabase) CreateWebhook(arg1 context.Context, arg2 models.Webhook) (models.Webhook, error) {
+	fake.createWebhookMutex.Lock()
+	ret, specificReturn := fake.createWebhookReturnsOnCall[len(fake.createWebhookArgsForCall)]
+	fake.createWebhookArgsForCall = append(fake.createWebhookArgsForCall, struct {
+		arg1 context.Context
+		arg2 models.Webhook
 	}{arg1, arg2})
-	stub := fake.DeleteStub
-	fakeReturns := fake.deleteReturns
-	fake.recordInvocation("Delete", []interface{}{arg1, arg2})
-	fake.deleteMutex.Unlock()
+	stub := fake.CreateWebhookStub
+	fakeReturns := fake.createWebhookReturns
+	fake.recordInvocation("CreateWebhook", []interface{}{arg1, arg2})
+	fake.createWebhookMutex.Unlock()
 	if stub != nil {
 		return stub(arg1, arg2)
 	}
 	if specificReturn {
-		return ret.result1
+		return ret.result1, ret.result2
 	}
-	return fakeReturns.result1
+	return fakeReturns.result1, fakeReturns.result2
 }
 
-func (fake *FakeDatabase) DeleteCallCount() int {
-	fake.deleteMutex.RLock()
-	defer fake.deleteMutex.RUnlock()
-	return len(fake.deleteArgsForCall)
+func (fake *FakeDatabase) CreateWebhookCallCount() int {
+	fake.createWebhookMutex.RLock()
+	defer fake.createWebhookMutex.RUnlock()
+	return len(fake.createWebhookArgsForCall)
 }
 
-func (fake *FakeDatabase) DeleteCalls(stub func(string, io.ReadCloser) error) {
-	fake.deleteMutex.Lock()
-	defer fake.deleteMutex.Unlock()
-	fake.DeleteStub = stub
+func (fake *FakeDatabase) CreateWebhookCalls(stub func(context.Context, models.Webhook) (models.Webhook, error)) {
+	fake.createWebhookMutex.Lock()
+	defer fake.createWebhookMutex.Unlock()
+	fake.CreateWebhookStub = stub
 }
 
-func (fake *FakeDatabase) DeleteArgsForCall(i int) (string, io.ReadCloser) {
-	fake.deleteMutex.RLock()
-	defer fake.deleteMutex.RUnlock()
-	argsForCall := fake.deleteArgsForCall[i]
+func (fake *FakeDatabase) CreateWebhookArgsForCall(i int) (context.Context, models.Webhook) {
+	fake.createWebhookMutex.RLock()
+	defer fake.createWebhookMutex.RUnlock()
+	argsForCall := fake.createWebhookArgsForCall[i]
 	return argsForCall.arg1, argsForCall.arg2
 }
 
-func (fake *FakeDatabase) DeleteReturns(result1 error) {
-	fake.deleteMutex.Lock()
-	defer fake.deleteMutex.Unlock()
-	fake.DeleteStub = nil
-	fake.deleteReturns = struct {
-		result1 error
-	}{result1}
+func (fake *FakeDatabase) CreateWebhookReturns(result1 models.Webhook, result2 error) {
+	fake.createWebhookMutex.Lock()
+	defer fake.createWebhookMutex.Unlock()
+	fake.CreateWebhookStub = nil
+	fake.createWebhookReturns = struct {
+		result1 models.Webhook
+		result2 error
+	}{result1, result2}
 }
 
-func (fake *FakeDatabase) DeleteReturnsOnCall(i int, result1 error) {
-	fake.deleteMutex.Lock()
-	defer fake.deleteMutex.Unlock()
-	fake.DeleteStub = nil
-	if fake.deleteReturnsOnCall == nil {
-		fake.deleteReturnsOnCall = make(map[int]struct {
-			result1 error
+func (fake *FakeDatabase) CreateWebhookReturnsOnCall(i int, result1 models.Webhook, result2 error) {
+	fake.createWebhookMutex.Lock()
+	defer fake.createWebhookMutex.Unlock()
+	fake.CreateWebhookStub = nil
+	if fake.createWebhookReturnsOnCall == nil {
+		fake.createWebhookReturnsOnCall = make(map[int]struct {
+			result1 models.Webhook
+			result2 error
 		})
 	}
-	fake.deleteReturnsOnCall[i] = struct {
-		result1 error
-	}{result1}
+	fake.createWebhookReturnsOnCall[i] = struct {
+		result1 models.Webhook
+		result2 error
+	}{result1, result2}
 }
 
-func (fake *FakeDatabase) ListActiveNotes(arg1 io.ReadCloser) ([]models.Note, error) {
+func (fake *FakeDatabase) DataKeys(arg1 context.Context, arg2 string) ([]database.DataKey, error) {
+	fake.dataKeysMutex.Lock()
+	ret, specificReturn := fake.dataKeysReturnsOnCall[len(fake.dataKeysArgsForCall)]
+	fake.dataKeysArgsForCall = append(fake.dataKeysArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.DataKeysStub
+	fakeReturns := fake.dataKeysReturns
+	fake.recordInvocation("DataKeys", []interface{}{arg1, arg2})
+	fake.dataKeysMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeDatabase) DataKeysCallCount() int {
+	fake.dataKeysMutex.RLock()
+	defer fake.dataKeysMutex.RUnlock()
+	return len(fake.dataKeysArgsForCall)
+}
+
+func (fake *FakeDatabase) DataKeysCalls(stub func(context.Context, string) ([]database.DataKey, error)) {
+	fake.dataKeysMutex.Lock()
+	defer fake.dataKeysMutex.Unlock()
+	fake.DataKeysStub = stub
+}
+
+func (fake *FakeDatabase) DataKeysArgsForCall(i int) (context.Context, string) {
+	fake.dataKeysMutex.RLock()
+	defer fake.dataKeysMutex.RUnlock()
+	argsForCall := fake.dataKeysArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeDatabase) DataKeysReturns(result1 []database.DataKey, result2 error) {
+	fake.dataKeysMutex.Lock()
+	defer fake.dataKeysMutex.Unlock()
+	fake.DataKeysStub = nil
+	fake.dataKeysReturns = struct {
+		result1 []database.DataKey
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) DataKeysReturnsOnCall(i int, result1 []database.DataKey, result2 error) {
+	fake.dataKeysMutex.Lock()
+	defer fake.dataKeysMutex.Unlock()
+	fake.DataKeysStub = nil
+	if fake.dataKeysReturnsOnCall == nil {
+		fake.dataKeysReturnsOnCall = make(map[int]struct {
+			result1 []database.DataKey
+			result2 error
+		})
+	}
+	fake.dataKeysReturnsOnCall[i] = struct {
+		result1 []database.DataKey
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) Delete(arg1 context.Context, arg2 string, arg3 string) error {
+	fake.deleteMutex.Lock()
+	ret, specificReturn := fake.deleteReturnsOnCall[len(fake.deleteArgsForCall)]
+	fake.deleteArgsForCall = append(fake.deleteArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.DeleteStub
+	fakeReturns := fake.deleteReturns
+	fake.recordInvocation("Delete", []interface{}{arg1, arg2, arg3})
+	fake.deleteMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDatabase) DeleteCallCount() int {
+	fake.deleteMutex.RLock()
+	defer fake.deleteMutex.RUnlock()
+	return len(fake.deleteArgsForCall)
+}
+
+func (fake *FakeDatabase) DeleteCalls(stub func(context.Context, string, string) error) {
+	fake.deleteMutex.Lock()
+	defer fake.deleteMutex.Unlock()
+	fake.DeleteStub = stub
+}
+
+func (fake *FakeDatabase) DeleteArgsForCall(i int) (context.Context, string, string) {
+	fake.deleteMutex.RLock()
+	defer fake.deleteMutex.RUnlock()
+	argsForCall := fake.deleteArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeDatabase) DeleteReturns(result1 error) {
+	fake.deleteMutex.Lock()
+	defer fake.deleteMutex.Unlock()
+	fake.DeleteStub = nil
+	fake.deleteReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) DeleteReturnsOnCall(i int, result1 error) {
+	fake.deleteMutex.Lock()
+	defer fake.deleteMutex.Unlock()
+	fake.DeleteStub = nil
+	if fake.deleteReturnsOnCall == nil {
+		fake.deleteReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) DeleteWebhook(arg1 context.Context, arg2 string, arg3 string) error {
+	fake.deleteWebhookMutex.Lock()
+	ret, specificReturn := fake.deleteWebhookReturnsOnCall[len(fake.deleteWebhookArgsForCall)]
+	fake.deleteWebhookArgsForCall = append(fake.deleteWebhookArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.DeleteWebhookStub
+	fakeReturns := fake.deleteWebhookReturns
+	fake.recordInvocation("DeleteWebhook", []interface{}{arg1, arg2, arg3})
+	fake.deleteWebhookMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDatabase) DeleteWebhookCallCount() int {
+	fake.deleteWebhookMutex.RLock()
+	defer fake.deleteWebhookMutex.RUnlock()
+	return len(fake.deleteWebhookArgsForCall)
+}
+
+func (fake *FakeDatabase) DeleteWebhookCalls(stub func(context.Context, string, string) error) {
+	fake.deleteWebhookMutex.Lock()
+	defer fake.deleteWebhookMutex.Unlock()
+	fake.DeleteWebhookStub = stub
+}
+
+func (fake *FakeDatabase) DeleteWebhookArgsForCall(i int) (context.Context, string, string) {
+	fake.deleteWebhookMutex.RLock()
+	defer fake.deleteWebhookMutex.RUnlock()
+	argsForCall := fake.deleteWebhookArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeDatabase) DeleteWebhookReturns(result1 error) {
+	fake.deleteWebhookMutex.Lock()
+	defer fake.deleteWebhookMutex.Unlock()
+	fake.DeleteWebhookStub = nil
+	fake.deleteWebhookReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) DeleteWebhookReturnsOnCall(i int, result1 error) {
+	fake.deleteWebhookMutex.Lock()
+	defer fake.deleteWebhookMutex.Unlock()
+	fake.DeleteWebhookStub = nil
+	if fake.deleteWebhookReturnsOnCall == nil {
+		fake.deleteWebhookReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteWebhookReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) EachNote(arg1 context.Context, arg2 string, arg3 func(models.Note) error) error {
+	fake.eachNoteMutex.Lock()
+	ret, specificReturn := fake.eachNoteReturnsOnCall[len(fake.eachNoteArgsForCall)]
+	fake.eachNoteArgsForCall = append(fake.eachNoteArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 func(models.Note) error
+	}{arg1, arg2, arg3})
+	stub := fake.EachNoteStub
+	fakeReturns := fake.eachNoteReturns
+	fake.recordInvocation("EachNote", []interface{}{arg1, arg2, arg3})
+	fake.eachNoteMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDatabase) EachNoteCallCount() int {
+	fake.eachNoteMutex.RLock()
+	defer fake.eachNoteMutex.RUnlock()
+	return len(fake.eachNoteArgsForCall)
+}
+
+func (fake *FakeDatabase) EachNoteCalls(stub func(context.Context, string, func(models.Note) error) error) {
+	fake.eachNoteMutex.Lock()
+	defer fake.eachNoteMutex.Unlock()
+	fake.EachNoteStub = stub
+}
+
+func (fake *FakeDatabase) EachNoteArgsForCall(i int) (context.Context, string, func(models.Note) error) {
+	fake.eachNoteMutex.RLock()
+	defer fake.eachNoteMutex.RUnlock()
+	argsForCall := fake.eachNoteArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeDatabase) EachNoteReturns(result1 error) {
+	fake.eachNoteMutex.Lock()
+	defer fake.eachNoteMutex.Unlock()
+	fake.EachNoteStub = nil
+	fake.eachNoteReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) EachNoteReturnsOnCall(i int, result1 error) {
+	fake.eachNoteMutex.Lock()
+	defer fake.eachNoteMutex.Unlock()
+	fake.EachNoteStub = nil
+	if fake.eachNoteReturnsOnCall == nil {
+		fake.eachNoteReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.eachNoteReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) ListActiveNotes(arg1 context.Context, arg2 string) ([]models.Note, error) {
 	fake.listActiveNotesMutex.Lock()
 	ret, specificReturn := fake.listActiveNotesReturnsOnCall[len(fake.listActiveNotesArgsForCall)]
 	fake.listActiveNotesArgsForCall = append(fake.listActiveNotesArgsForCall, struct {
-		arg1 io.ReadCloser
-	}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.ListActiveNotesStub
 	fakeReturns := fake.listActiveNotesReturns
-	fake.recordInvocation("ListActiveNotes", []interface{}{arg1})
+	fake.recordInvocation("ListActiveNotes", []interface{}{arg1, arg2})
 	fake.listActiveNotesMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -303,17 +1195,17 @@ func (fake *FakeDatabase) ListActiveNotesCallCount() int {
 	return len(fake.listActiveNotesArgsForCall)
 }
 
-func (fake *FakeDatabase) ListActiveNotesCalls(stub func(io.ReadCloser) ([]models.Note, error)) {
+func (fake *FakeDatabase) ListActiveNotesCalls(stub func(context.Context, string) ([]models.Note, error)) {
 	fake.listActiveNotesMutex.Lock()
 	defer fake.listActiveNotesMutex.Unlock()
 	fake.ListActiveNotesStub = stub
 }
 
-func (fake *FakeDatabase) ListActiveNotesArgsForCall(i int) io.ReadCloser {
+func (fake *FakeDatabase) ListActiveNotesArgsForCall(i int) (context.Context, string) {
 	fake.listActiveNotesMutex.RLock()
 	defer fake.listActiveNotesMutex.RUnlock()
 	argsForCall := fake.listActiveNotesArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeDatabase) ListActiveNotesReturns(result1 []models.Note, result2 error) {
@@ -342,18 +1234,19 @@ func (fake *FakeDatabase) ListActiveNotesReturnsOnCall(i int, result1 []models.N
 	}{result1, result2}
 }
 
-func (fake *FakeDatabase) ListArchivedNotes(arg1 io.ReadCloser) ([]models.Note, error) {
+func (fake *FakeDatabase) ListArchivedNotes(arg1 context.Context, arg2 string) ([]models.Note, error) {
 	fake.listArchivedNotesMutex.Lock()
 	ret, specificReturn := fake.listArchivedNotesReturnsOnCall[len(fake.listArchivedNotesArgsForCall)]
 	fake.listArchivedNotesArgsForCall = append(fake.listArchivedNotesArgsForCall, struct {
-		arg1 io.ReadCloser
-	}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.ListArchivedNotesStub
 	fakeReturns := fake.listArchivedNotesReturns
-	fake.recordInvocation("ListArchivedNotes", []interface{}{arg1})
+	fake.recordInvocation("ListArchivedNotes", []interface{}{arg1, arg2})
 	fake.listArchivedNotesMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -367,17 +1260,17 @@ func (fake *FakeDatabase) ListArchivedNotesCallCount() int {
 	return len(fake.listArchivedNotesArgsForCall)
 }
 
-func (fake *FakeDatabase) ListArchivedNotesCalls(stub func(io.ReadCloser) ([]models.Note, error)) {
+func (fake *FakeDatabase) ListArchivedNotesCalls(stub func(context.Context, string) ([]models.Note, error)) {
 	fake.listArchivedNotesMutex.Lock()
 	defer fake.listArchivedNotesMutex.Unlock()
 	fake.ListArchivedNotesStub = stub
 }
 
-func (fake *FakeDatabase) ListArchivedNotesArgsForCall(i int) io.ReadCloser {
+func (fake *FakeDatabase) ListArchivedNotesArgsForCall(i int) (context.Context, string) {
 	fake.listArchivedNotesMutex.RLock()
 	defer fake.listArchivedNotesMutex.RUnlock()
 	argsForCall := fake.listArchivedNotesArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeDatabase) ListArchivedNotesReturns(result1 []models.Note, result2 error) {
@@ -406,6 +1299,201 @@ func (fake *FakeDatabase) ListArchivedNotesReturnsOnCall(i int, result1 []models
 	}{result1, result2}
 }
 
+func (fake *FakeDatabase) ListDeliveries(arg1 context.Context, arg2 string, arg3 string) ([]models.Delivery, error) {
+	fake.listDeliveriesMutex.Lock()
+	ret, specificReturn := fake.listDeliveriesReturnsOnCall[len(fake.listDeliveriesArgsForCall)]
+	fake.listDeliveriesArgsForCall = append(fake.listDeliveriesArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.ListDeliveriesStub
+	fakeReturns := fake.listDeliveriesReturns
+	fake.recordInvocation("ListDeliveries", []interface{}{arg1, arg2, arg3})
+	fake.listDeliveriesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeDatabase) ListDeliveriesCallCount() int {
+	fake.listDeliveriesMutex.RLock()
+	defer fake.listDeliveriesMutex.RUnlock()
+	return len(fake.listDeliveriesArgsForCall)
+}
+
+func (fake *FakeDatabase) ListDeliveriesCalls(stub func(context.Context, string, string) ([]models.Delivery, error)) {
+	fake.listDeliveriesMutex.Lock()
+	defer fake.listDeliveriesMutex.Unlock()
+	fake.ListDeliveriesStub = stub
+}
+
+func (fake *FakeDatabase) ListDeliveriesArgsForCall(i int) (context.Context, string, string) {
+	fake.listDeliveriesMutex.RLock()
+	defer fake.listDeliveriesMutex.RUnlock()
+	argsForCall := fake.listDeliveriesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeDatabase) ListDeliveriesReturns(result1 []models.Delivery, result2 error) {
+	fake.listDeliveriesMutex.Lock()
+	defer fake.listDeliveriesMutex.Unlock()
+	fake.ListDeliveriesStub = nil
+	fake.listDeliveriesReturns = struct {
+		result1 []models.Delivery
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) ListDeliveriesReturnsOnCall(i int, result1 []models.Delivery, result2 error) {
+	fake.listDeliveriesMutex.Lock()
+	defer fake.listDeliveriesMutex.Unlock()
+	fake.ListDeliveriesStub = nil
+	if fake.listDeliveriesReturnsOnCall == nil {
+		fake.listDeliveriesReturnsOnCall = make(map[int]struct {
+			result1 []models.Delivery
+			result2 error
+		})
+	}
+	fake.listDeliveriesReturnsOnCall[i] = struct {
+		result1 []models.Delivery
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) ListUsers(arg1 context.Context) ([]string, error) {
+	fake.listUsersMutex.Lock()
+	ret, specificReturn := fake.listUsersReturnsOnCall[len(fake.listUsersArgsForCall)]
+	fake.listUsersArgsForCall = append(fake.listUsersArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.ListUsersStub
+	fakeReturns := fake.listUsersReturns
+	fake.recordInvocation("ListUsers", []interface{}{arg1})
+	fake.listUsersMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeDatabase) ListUsersCallCount() int {
+	fake.listUsersMutex.RLock()
+	defer fake.listUsersMutex.RUnlock()
+	return len(fake.listUsersArgsForCall)
+}
+
+func (fake *FakeDatabase) ListUsersCalls(stub func(context.Context) ([]string, error)) {
+	fake.listUsersMutex.Lock()
+	defer fake.listUsersMutex.Unlock()
+	fake.ListUsersStub = stub
+}
+
+func (fake *FakeDatabase) ListUsersArgsForCall(i int) context.Context {
+	fake.listUsersMutex.RLock()
+	defer fake.listUsersMutex.RUnlock()
+	argsForCall := fake.listUsersArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeDatabase) ListUsersReturns(result1 []string, result2 error) {
+	fake.listUsersMutex.Lock()
+	defer fake.listUsersMutex.Unlock()
+	fake.ListUsersStub = nil
+	fake.listUsersReturns = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) ListUsersReturnsOnCall(i int, result1 []string, result2 error) {
+	fake.listUsersMutex.Lock()
+	defer fake.listUsersMutex.Unlock()
+	fake.ListUsersStub = nil
+	if fake.listUsersReturnsOnCall == nil {
+		fake.listUsersReturnsOnCall = make(map[int]struct {
+			result1 []string
+			result2 error
+		})
+	}
+	fake.listUsersReturnsOnCall[i] = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) ListWebhooks(arg1 context.Context, arg2 string) ([]models.Webhook, error) {
+	fake.listWebhooksMutex.Lock()
+	ret, specificReturn := fake.listWebhooksReturnsOnCall[len(fake.listWebhooksArgsForCall)]
+	fake.listWebhooksArgsForCall = append(fake.listWebhooksArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.ListWebhooksStub
+	fakeReturns := fake.listWebhooksReturns
+	fake.recordInvocation("ListWebhooks", []interface{}{arg1, arg2})
+	fake.listWebhooksMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeDatabase) ListWebhooksCallCount() int {
+	fake.listWebhooksMutex.RLock()
+	defer fake.listWebhooksMutex.RUnlock()
+	return len(fake.listWebhooksArgsForCall)
+}
+
+func (fake *FakeDatabase) ListWebhooksCalls(stub func(context.Context, string) ([]models.Webhook, error)) {
+	fake.listWebhooksMutex.Lock()
+	defer fake.listWebhooksMutex.Unlock()
+	fake.ListWebhooksStub = stub
+}
+
+func (fake *FakeDatabase) ListWebhooksArgsForCall(i int) (context.Context, string) {
+	fake.listWebhooksMutex.RLock()
+	defer fake.listWebhooksMutex.RUnlock()
+	argsForCall := fake.listWebhooksArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeDatabase) ListWebhooksReturns(result1 []models.Webhook, result2 error) {
+	fake.listWebhooksMutex.Lock()
+	defer fake.listWebhooksMutex.Unlock()
+	fake.ListWebhooksStub = nil
+	fake.listWebhooksReturns = struct {
+		result1 []models.Webhook
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) ListWebhooksReturnsOnCall(i int, result1 []models.Webhook, result2 error) {
+	fake.listWebhooksMutex.Lock()
+	defer fake.listWebhooksMutex.Unlock()
+	fake.ListWebhooksStub = nil
+	if fake.listWebhooksReturnsOnCall == nil {
+		fake.listWebhooksReturnsOnCall = make(map[int]struct {
+			result1 []models.Webhook
+			result2 error
+		})
+	}
+	fake.listWebhooksReturnsOnCall[i] = struct {
+		result1 []models.Webhook
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeDatabase) Open() error {
 	fake.openMutex.Lock()
 	ret, specificReturn := fake.openReturnsOnCall[len(fake.openArgsForCall)]
@@ -459,19 +1547,21 @@ func (fake *FakeDatabase) OpenReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeDatabase) Update(arg1 string, arg2 io.ReadCloser) (models.Note, error) {
-	fake.updateMutex.Lock()
-	ret, specificReturn := fake.updateReturnsOnCall[len(fake.updateArgsForCall)]
-	fake.updateArgsForCall = append(fake.updateArgsForCall, struct {
-		arg1 string
-		arg2 io.ReadCloser
-	}{arg1, arg2})
-	stub := fake.UpdateStub
-	fakeReturns := fake.updateReturns
-	fake.recordInvocation("Update", []interface{}{arg1, arg2})
-	fake.updateMutex.Unlock()
+func (fake *FakeDatabase) Patch(arg1 context.Context, arg2 string, arg3 string, arg4 func(models.Note) (models.Note, error)) (models.Note, error) {
+	fake.patchMutex.Lock()
+	ret, specificReturn := fake.patchReturnsOnCall[len(fake.patchArgsForCall)]
+	fake.patchArgsForCall = append(fake.patchArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+		arg4 func(models.Note) (models.Note, error)
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.PatchStub
+	fakeReturns := fake.patchReturns
+	fake.recordInvocation("Patch", []interface{}{arg1, arg2, arg3, arg4})
+	fake.patchMutex.Unlock()
 	if stub != nil {
-		return stub(arg1, arg2)
+		return stub(arg1, arg2, arg3, arg4)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -479,68 +1569,619 @@ func (fake *FakeDatabase) Update(arg1 string, arg2 io.ReadCloser) (models.Note,
 	return fakeReturns.result1, fakeReturns.result2
 }
 
-func (fake *FakeDatabase) UpdateCallCount() int {
-	fake.updateMutex.RLock()
-	defer fake.updateMutex.RUnlock()
-	return len(fake.updateArgsForCall)
+func (fake *FakeDatabase) PatchCallCount() int {
+	fake.patchMutex.RLock()
+	defer fake.patchMutex.RUnlock()
+	return len(fake.patchArgsForCall)
 }
 
-func (fake *FakeDatabase) UpdateCalls(stub func(string, io.ReadCloser) (models.Note, error)) {
-	fake.updateMutex.Lock()
-	defer fake.updateMutex.Unlock()
-	fake.UpdateStub = stub
+func (fake *FakeDatabase) PatchCalls(stub func(context.Context, string, string, func(models.Note) (models.Note, error)) (models.Note, error)) {
+	fake.patchMutex.Lock()
+	defer fake.patchMutex.Unlock()
+	fake.PatchStub = stub
 }
 
-func (fake *FakeDatabase) UpdateArgsForCall(i int) (string, io.ReadCloser) {
-	fake.updateMutex.RLock()
-	defer fake.updateMutex.RUnlock()
-	argsForCall := fake.updateArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+func (fake *FakeDatabase) PatchArgsForCall(i int) (context.Context, string, string, func(models.Note) (models.Note, error)) {
+	fake.patchMutex.RLock()
+	defer fake.patchMutex.RUnlock()
+	argsForCall := fake.patchArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
 }
 
-func (fake *FakeDatabase) UpdateReturns(result1 models.Note, result2 error) {
-	fake.updateMutex.Lock()
-	defer fake.updateMutex.Unlock()
-	fake.UpdateStub = nil
-	fake.updateReturns = struct {
+func (fake *FakeDatabase) PatchReturns(result1 models.Note, result2 error) {
+	fake.patchMutex.Lock()
+	defer fake.patchMutex.Unlock()
+	fake.PatchStub = nil
+	fake.patchReturns = struct {
 		result1 models.Note
 		result2 error
 	}{result1, result2}
 }
 
-func (fake *FakeDatabase) UpdateReturnsOnCall(i int, result1 models.Note, result2 error) {
-	fake.updateMutex.Lock()
-	defer fake.updateMutex.Unlock()
-	fake.UpdateStub = nil
-	if fake.updateReturnsOnCall == nil {
-		fake.updateReturnsOnCall = make(map[int]struct {
+func (fake *FakeDatabase) PatchReturnsOnCall(i int, result1 models.Note, result2 error) {
+	fake.patchMutex.Lock()
+	defer fake.patchMutex.Unlock()
+	fake.PatchStub = nil
+	if fake.patchReturnsOnCall == nil {
+		fake.patchReturnsOnCall = make(map[int]struct {
 			result1 models.Note
 			result2 error
 		})
 	}
-	fake.updateReturnsOnCall[i] = struct {
+	fake.patchReturnsOnCall[i] = struct {
 		result1 models.Note
 		result2 error
 	}{result1, result2}
 }
 
+func (fake *FakeDatabase) Ping(arg1 context.Context) error {
+	fake.pingMutex.Lock()
+	ret, specificReturn := fake.pingReturnsOnCall[len(fake.pingArgsForCall)]
+	fake.pingArgsForCall = append(fake.pingArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.PingStub
+	fakeReturns := fake.pingReturns
+	fake.recordInvocation("Ping", []interface{}{arg1})
+	fake.pingMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDatabase) PingCallCount() int {
+	fake.pingMutex.RLock()
+	defer fake.pingMutex.RUnlock()
+	return len(fake.pingArgsForCall)
+}
+
+func (fake *FakeDatabase) PingCalls(stub func(context.Context) error) {
+	fake.pingMutex.Lock()
+	defer fake.pingMutex.Unlock()
+	fake.PingStub = stub
+}
+
+func (fake *FakeDatabase) PingArgsForCall(i int) context.Context {
+	fake.pingMutex.RLock()
+	defer fake.pingMutex.RUnlock()
+	argsForCall := fake.pingArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeDatabase) PingReturns(result1 error) {
+	fake.pingMutex.Lock()
+	defer fake.pingMutex.Unlock()
+	fake.PingStub = nil
+	fake.pingReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) PingReturnsOnCall(i int, result1 error) {
+	fake.pingMutex.Lock()
+	defer fake.pingMutex.Unlock()
+	fake.PingStub = nil
+	if fake.pingReturnsOnCall == nil {
+		fake.pingReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.pingReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) PutNote(arg1 context.Context, arg2 models.Note) (models.Note, bool, error) {
+	fake.putNoteMutex.Lock()
+	ret, specificReturn := fake.putNoteReturnsOnCall[len(fake.putNoteArgsForCall)]
+	fake.putNoteArgsForCall = append(fake.putNoteArgsForCall, struct {
+		arg1 context.Context
+		arg2 models.Note
+	}{arg1, arg2})
+	stub := fake.PutNoteStub
+	fakeReturns := fake.putNoteReturns
+	fake.recordInvocation("PutNote", []interface{}{arg1, arg2})
+	fake.putNoteMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
+}
+
+func (fake *FakeDatabase) PutNoteCallCount() int {
+	fake.putNoteMutex.RLock()
+	defer fake.putNoteMutex.RUnlock()
+	return len(fake.putNoteArgsForCall)
+}
+
+func (fake *FakeDatabase) PutNoteCalls(stub func(context.Context, models.Note) (models.Note, bool, error)) {
+	fake.putNoteMutex.Lock()
+	defer fake.putNoteMutex.Unlock()
+	fake.PutNoteStub = stub
+}
+
+func (fake *FakeDatabase) PutNoteArgsForCall(i int) (context.Context, models.Note) {
+	fake.putNoteMutex.RLock()
+	defer fake.putNoteMutex.RUnlock()
+	argsForCall := fake.putNoteArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeDatabase) PutNoteReturns(result1 models.Note, result2 bool, result3 error) {
+	fake.putNoteMutex.Lock()
+	defer fake.putNoteMutex.Unlock()
+	fake.PutNoteStub = nil
+	fake.putNoteReturns = struct {
+		result1 models.Note
+		result2 bool
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeDatabase) PutNoteReturnsOnCall(i int, result1 models.Note, result2 bool, result3 error) {
+	fake.putNoteMutex.Lock()
+	defer fake.putNoteMutex.Unlock()
+	fake.PutNoteStub = nil
+	if fake.putNoteReturnsOnCall == nil {
+		fake.putNoteReturnsOnCall = make(map[int]struct {
+			result1 models.Note
+			result2 bool
+			result3 error
+		})
+	}
+	fake.putNoteReturnsOnCall[i] = struct {
+		result1 models.Note
+		result2 bool
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeDatabase) ReleaseIdempotencyKey(arg1 context.Context, arg2 string) error {
+	fake.releaseIdempotencyKeyMutex.Lock()
+	ret, specificReturn := fake.releaseIdempotencyKeyReturnsOnCall[len(fake.releaseIdempotencyKeyArgsForCall)]
+	fake.releaseIdempotencyKeyArgsForCall = append(fake.releaseIdempotencyKeyArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.ReleaseIdempotencyKeyStub
+	fakeReturns := fake.releaseIdempotencyKeyReturns
+	fake.recordInvocation("ReleaseIdempotencyKey", []interface{}{arg1, arg2})
+	fake.releaseIdempotencyKeyMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDatabase) ReleaseIdempotencyKeyCallCount() int {
+	fake.releaseIdempotencyKeyMutex.RLock()
+	defer fake.releaseIdempotencyKeyMutex.RUnlock()
+	return len(fake.releaseIdempotencyKeyArgsForCall)
+}
+
+func (fake *FakeDatabase) ReleaseIdempotencyKeyCalls(stub func(context.Context, string) error) {
+	fake.releaseIdempotencyKeyMutex.Lock()
+	defer fake.releaseIdempotencyKeyMutex.Unlock()
+	fake.ReleaseIdempotencyKeyStub = stub
+}
+
+func (fake *FakeDatabase) ReleaseIdempotencyKeyArgsForCall(i int) (context.Context, string) {
+	fake.releaseIdempotencyKeyMutex.RLock()
+	defer fake.releaseIdempotencyKeyMutex.RUnlock()
+	argsForCall := fake.releaseIdempotencyKeyArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeDatabase) ReleaseIdempotencyKeyReturns(result1 error) {
+	fake.releaseIdempotencyKeyMutex.Lock()
+	defer fake.releaseIdempotencyKeyMutex.Unlock()
+	fake.ReleaseIdempotencyKeyStub = nil
+	fake.releaseIdempotencyKeyReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) ReleaseIdempotencyKeyReturnsOnCall(i int, result1 error) {
+	fake.releaseIdempotencyKeyMutex.Lock()
+	defer fake.releaseIdempotencyKeyMutex.Unlock()
+	fake.ReleaseIdempotencyKeyStub = nil
+	if fake.releaseIdempotencyKeyReturnsOnCall == nil {
+		fake.releaseIdempotencyKeyReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.releaseIdempotencyKeyReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) SaveDataKey(arg1 context.Context, arg2 database.DataKey) error {
+	fake.saveDataKeyMutex.Lock()
+	ret, specificReturn := fake.saveDataKeyReturnsOnCall[len(fake.saveDataKeyArgsForCall)]
+	fake.saveDataKeyArgsForCall = append(fake.saveDataKeyArgsForCall, struct {
+		arg1 context.Context
+		arg2 database.DataKey
+	}{arg1, arg2})
+	stub := fake.SaveDataKeyStub
+	fakeReturns := fake.saveDataKeyReturns
+	fake.recordInvocation("SaveDataKey", []interface{}{arg1, arg2})
+	fake.saveDataKeyMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDatabase) SaveDataKeyCallCount() int {
+	fake.saveDataKeyMutex.RLock()
+	defer fake.saveDataKeyMutex.RUnlock()
+	return len(fake.saveDataKeyArgsForCall)
+}
+
+func (fake *FakeDatabase) SaveDataKeyCalls(stub func(context.Context, database.DataKey) error) {
+	fake.saveDataKeyMutex.Lock()
+	defer fake.saveDataKeyMutex.Unlock()
+	fake.SaveDataKeyStub = stub
+}
+
+func (fake *FakeDatabase) SaveDataKeyArgsForCall(i int) (context.Context, database.DataKey) {
+	fake.saveDataKeyMutex.RLock()
+	defer fake.saveDataKeyMutex.RUnlock()
+	argsForCall := fake.saveDataKeyArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeDatabase) SaveDataKeyReturns(result1 error) {
+	fake.saveDataKeyMutex.Lock()
+	defer fake.saveDataKeyMutex.Unlock()
+	fake.SaveDataKeyStub = nil
+	fake.saveDataKeyReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) SaveDataKeyReturnsOnCall(i int, result1 error) {
+	fake.saveDataKeyMutex.Lock()
+	defer fake.saveDataKeyMutex.Unlock()
+	fake.SaveDataKeyStub = nil
+	if fake.saveDataKeyReturnsOnCall == nil {
+		fake.saveDataKeyReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.saveDataKeyReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) SaveDelivery(arg1 context.Context, arg2 models.Delivery) error {
+	fake.saveDeliveryMutex.Lock()
+	ret, specificReturn := fake.saveDeliveryReturnsOnCall[len(fake.saveDeliveryArgsForCall)]
+	fake.saveDeliveryArgsForCall = append(fake.saveDeliveryArgsForCall, struct {
+		arg1 context.Context
+		arg2 models.Delivery
+	}{arg1, arg2})
+	stub := fake.SaveDeliveryStub
+	fakeReturns := fake.saveDeliveryReturns
+	fake.recordInvocation("SaveDelivery", []interface{}{arg1, arg2})
+	fake.saveDeliveryMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDatabase) SaveDeliveryCallCount() int {
+	fake.saveDeliveryMutex.RLock()
+	defer fake.saveDeliveryMutex.RUnlock()
+	return len(fake.saveDeliveryArgsForCall)
+}
+
+func (fake *FakeDatabase) SaveDeliveryCalls(stub func(context.Context, models.Delivery) error) {
+	fake.saveDeliveryMutex.Lock()
+	defer fake.saveDeliveryMutex.Unlock()
+	fake.SaveDeliveryStub = stub
+}
+
+func (fake *FakeDatabase) SaveDeliveryArgsForCall(i int) (context.Context, models.Delivery) {
+	fake.saveDeliveryMutex.RLock()
+	defer fake.saveDeliveryMutex.RUnlock()
+	argsForCall := fake.saveDeliveryArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeDatabase) SaveDeliveryReturns(result1 error) {
+	fake.saveDeliveryMutex.Lock()
+	defer fake.saveDeliveryMutex.Unlock()
+	fake.SaveDeliveryStub = nil
+	fake.saveDeliveryReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) SaveDeliveryReturnsOnCall(i int, result1 error) {
+	fake.saveDeliveryMutex.Lock()
+	defer fake.saveDeliveryMutex.Unlock()
+	fake.SaveDeliveryStub = nil
+	if fake.saveDeliveryReturnsOnCall == nil {
+		fake.saveDeliveryReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.saveDeliveryReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) SaveIdempotencyKey(arg1 context.Context, arg2 database.IdempotencyRecord) error {
+	fake.saveIdempotencyKeyMutex.Lock()
+	ret, specificReturn := fake.saveIdempotencyKeyReturnsOnCall[len(fake.saveIdempotencyKeyArgsForCall)]
+	fake.saveIdempotencyKeyArgsForCall = append(fake.saveIdempotencyKeyArgsForCall, struct {
+		arg1 context.Context
+		arg2 database.IdempotencyRecord
+	}{arg1, arg2})
+	stub := fake.SaveIdempotencyKeyStub
+	fakeReturns := fake.saveIdempotencyKeyReturns
+	fake.recordInvocation("SaveIdempotencyKey", []interface{}{arg1, arg2})
+	fake.saveIdempotencyKeyMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDatabase) SaveIdempotencyKeyCallCount() int {
+	fake.saveIdempotencyKeyMutex.RLock()
+	defer fake.saveIdempotencyKeyMutex.RUnlock()
+	return len(fake.saveIdempotencyKeyArgsForCall)
+}
+
+func (fake *FakeDatabase) SaveIdempotencyKeyCalls(stub func(context.Context, database.IdempotencyRecord) error) {
+	fake.saveIdempotencyKeyMutex.Lock()
+	defer fake.saveIdempotencyKeyMutex.Unlock()
+	fake.SaveIdempotencyKeyStub = stub
+}
+
+func (fake *FakeDatabase) SaveIdempotencyKeyArgsForCall(i int) (context.Context, database.IdempotencyRecord) {
+	fake.saveIdempotencyKeyMutex.RLock()
+	defer fake.saveIdempotencyKeyMutex.RUnlock()
+	argsForCall := fake.saveIdempotencyKeyArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeDatabase) SaveIdempotencyKeyReturns(result1 error) {
+	fake.saveIdempotencyKeyMutex.Lock()
+	defer fake.saveIdempotencyKeyMutex.Unlock()
+	fake.SaveIdempotencyKeyStub = nil
+	fake.saveIdempotencyKeyReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) SaveIdempotencyKeyReturnsOnCall(i int, result1 error) {
+	fake.saveIdempotencyKeyMutex.Lock()
+	defer fake.saveIdempotencyKeyMutex.Unlock()
+	fake.SaveIdempotencyKeyStub = nil
+	if fake.saveIdempotencyKeyReturnsOnCall == nil {
+		fake.saveIdempotencyKeyReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.saveIdempotencyKeyReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) SetArchived(arg1 context.Context, arg2 string, arg3 []string, arg4 bool) ([]models.NoteResult, error) {
+	var arg3Copy []string
+	if arg3 != nil {
+		arg3Copy = make([]string, len(arg3))
+		copy(arg3Copy, arg3)
+	}
+	fake.setArchivedMutex.Lock()
+	ret, specificReturn := fake.setArchivedReturnsOnCall[len(fake.setArchivedArgsForCall)]
+	fake.setArchivedArgsForCall = append(fake.setArchivedArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 []string
+		arg4 bool
+	}{arg1, arg2, arg3Copy, arg4})
+	stub := fake.SetArchivedStub
+	fakeReturns := fake.setArchivedReturns
+	fake.recordInvocation("SetArchived", []interface{}{arg1, arg2, arg3Copy, arg4})
+	fake.setArchivedMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeDatabase) SetArchivedCallCount() int {
+	fake.setArchivedMutex.RLock()
+	defer fake.setArchivedMutex.RUnlock()
+	return len(fake.setArchivedArgsForCall)
+}
+
+func (fake *FakeDatabase) SetArchivedCalls(stub func(context.Context, string, []string, bool) ([]models.NoteResult, error)) {
+	fake.setArchivedMutex.Lock()
+	defer fake.setArchivedMutex.Unlock()
+	fake.SetArchivedStub = stub
+}
+
+func (fake *FakeDatabase) SetArchivedArgsForCall(i int) (context.Context, string, []string, bool) {
+	fake.setArchivedMutex.RLock()
+	defer fake.setArchivedMutex.RUnlock()
+	argsForCall := fake.setArchivedArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeDatabase) SetArchivedReturns(result1 []models.NoteResult, result2 error) {
+	fake.setArchivedMutex.Lock()
+	defer fake.setArchivedMutex.Unlock()
+	fake.SetArchivedStub = nil
+	fake.setArchivedReturns = struct {
+		result1 []models.NoteResult
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) SetArchivedReturnsOnCall(i int, result1 []models.NoteResult, result2 error) {
+	fake.setArchivedMutex.Lock()
+	defer fake.setArchivedMutex.Unlock()
+	fake.SetArchivedStub = nil
+	if fake.setArchivedReturnsOnCall == nil {
+		fake.setArchivedReturnsOnCall = make(map[int]struct {
+			result1 []models.NoteResult
+			result2 error
+		})
+	}
+	fake.setArchivedReturnsOnCall[i] = struct {
+		result1 []models.NoteResult
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDatabase) Snapshot(arg1 context.Context, arg2 func(database.Snapshot) error) error {
+	fake.snapshotMutex.Lock()
+	ret, specificReturn := fake.snapshotReturnsOnCall[len(fake.snapshotArgsForCall)]
+	fake.snapshotArgsForCall = append(fake.snapshotArgsForCall, struct {
+		arg1 context.Context
+		arg2 func(database.Snapshot) error
+	}{arg1, arg2})
+	stub := fake.SnapshotStub
+	fakeReturns := fake.snapshotReturns
+	fake.recordInvocation("Snapshot", []interface{}{arg1, arg2})
+	fake.snapshotMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeDatabase) SnapshotCallCount() int {
+	fake.snapshotMutex.RLock()
+	defer fake.snapshotMutex.RUnlock()
+	return len(fake.snapshotArgsForCall)
+}
+
+func (fake *FakeDatabase) SnapshotCalls(stub func(context.Context, func(database.Snapshot) error) error) {
+	fake.snapshotMutex.Lock()
+	defer fake.snapshotMutex.Unlock()
+	fake.SnapshotStub = stub
+}
+
+func (fake *FakeDatabase) SnapshotArgsForCall(i int) (context.Context, func(database.Snapshot) error) {
+	fake.snapshotMutex.RLock()
+	defer fake.snapshotMutex.RUnlock()
+	argsForCall := fake.snapshotArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeDatabase) SnapshotReturns(result1 error) {
+	fake.snapshotMutex.Lock()
+	defer fake.snapshotMutex.Unlock()
+	fake.SnapshotStub = nil
+	fake.snapshotReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeDatabase) SnapshotReturnsOnCall(i int, result1 error) {
+	fake.snapshotMutex.Lock()
+	defer fake.snapshotMutex.Unlock()
+	fake.SnapshotStub = nil
+	if fake.snapshotReturnsOnCall == nil {
+		fake.snapshotReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.snapshotReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeDatabase) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
+	fake.applyChangesMutex.RLock()
+	defer fake.applyChangesMutex.RUnlock()
+	fake.batchMutex.RLock()
+	defer fake.batchMutex.RUnlock()
+	fake.changesMutex.RLock()
+	defer fake.changesMutex.RUnlock()
+	fake.claimIdempotencyKeyMutex.RLock()
+	defer fake.claimIdempotencyKeyMutex.RUnlock()
 	fake.closeMutex.RLock()
 	defer fake.closeMutex.RUnlock()
+	fake.countNotesMutex.RLock()
+	defer fake.countNotesMutex.RUnlock()
 	fake.createMutex.RLock()
 	defer fake.createMutex.RUnlock()
+	fake.createWebhookMutex.RLock()
+	defer fake.createWebhookMutex.RUnlock()
+	fake.dataKeysMutex.RLock()
+	defer fake.dataKeysMutex.RUnlock()
 	fake.deleteMutex.RLock()
 	defer fake.deleteMutex.RUnlock()
+	fake.deleteWebhookMutex.RLock()
+	defer fake.deleteWebhookMutex.RUnlock()
+	fake.eachNoteMutex.RLock()
+	defer fake.eachNoteMutex.RUnlock()
 	fake.listActiveNotesMutex.RLock()
 	defer fake.listActiveNotesMutex.RUnlock()
 	fake.listArchivedNotesMutex.RLock()
 	defer fake.listArchivedNotesMutex.RUnlock()
+	fake.listDeliveriesMutex.RLock()
+	defer fake.listDeliveriesMutex.RUnlock()
+	fake.listUsersMutex.RLock()
+	defer fake.listUsersMutex.RUnlock()
+	fake.listWebhooksMutex.RLock()
+	defer fake.listWebhooksMutex.RUnlock()
 	fake.openMutex.RLock()
 	defer fake.openMutex.RUnlock()
-	fake.updateMutex.RLock()
-	defer fake.updateMutex.RUnlock()
+	fake.patchMutex.RLock()
+	defer fake.patchMutex.RUnlock()
+	fake.pingMutex.RLock()
+	defer fake.pingMutex.RUnlock()
+	fake.putNoteMutex.RLock()
+	defer fake.putNoteMutex.RUnlock()
+	fake.releaseIdempotencyKeyMutex.RLock()
+	defer fake.releaseIdempotencyKeyMutex.RUnlock()
+	fake.saveDataKeyMutex.RLock()
+	defer fake.saveDataKeyMutex.RUnlock()
+	fake.saveDeliveryMutex.RLock()
+	defer fake.saveDeliveryMutex.RUnlock()
+	fake.saveIdempotencyKeyMutex.RLock()
+	defer fake.saveIdempotencyKeyMutex.RUnlock()
+	fake.setArchivedMutex.RLock()
+	defer fake.setArchivedMutex.RUnlock()
+	fake.snapshotMutex.RLock()
+	defer fake.snapshotMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -0,0 +1,272 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package databasefakes
+
+import (
+	"context"
+	"sync"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+type FakeSnapshot struct {
+	EachNoteStub        func(context.Context, string, func(models.Note) error) error
+	eachNoteMutex       sync.RWMutex
+	eachNoteArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 func(models.Note) error
+	}
+	eachNoteReturns struct {
+		result1 error
+	}
+	eachNoteReturnsOnCall map[int]struct {
+		result1 error
+	}
+	EachWebhookStub        func(context.Context, func(models.Webhook) error) error
+	eachWebhookMutex       sync.RWMutex
+	eachWebhookArgsForCall []struct {
+		arg1 context.Context
+		arg2 func(models.Webhook) error
+	}
+	eachWebhookReturns struct {
+		result1 error
+	}
+	eachWebhookReturnsOnCall map[int]struct {
+		result1 error
+	}
+	ListUsersStub        func(context.Context) ([]string, error)
+	listUsersMutex       sync.RWMutex
+	listUsersArgsForCall []struct {
+		arg1 context.Context
+	}
+	listUsersReturns struct {
+		result1 []string
+		result2 error
+	}
+	listUsersReturnsOnCall map[int]struct {
+		result1 []string
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeSnapshot) EachNote(arg1 context.Context, arg2 string, arg3 func(models.Note) error) error {
+	fake.eachNoteMutex.Lock()
+	ret, specificReturn := fake.eachNoteReturnsOnCall[len(fake.eachNoteArgsForCall)]
+	fake.eachNoteArgsForCall = append(fake.eachNoteArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 func(models.Note) error
+	}{arg1, arg2, arg3})
+	stub := fake.EachNoteStub
+	fakeReturns := fake.eachNoteReturns
+	fake.recordInvocation("EachNote", []interface{}{arg1, arg2, arg3})
+	fake.eachNoteMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeSnapshot) EachNoteCallCount() int {
+	fake.eachNoteMutex.RLock()
+	defer fake.eachNoteMutex.RUnlock()
+	return len(fake.eachNoteArgsForCall)
+}
+
+func (fake *FakeSnapshot) EachNoteCalls(stub func(context.Context, string, func(models.Note) error) error) {
+	fake.eachNoteMutex.Lock()
+	defer fake.eachNoteMutex.Unlock()
+	fake.EachNoteStub = stub
+}
+
+func (fake *FakeSnapshot) EachNoteArgsForCall(i int) (context.Context, string, func(models.Note) error) {
+	fake.eachNoteMutex.RLock()
+	defer fake.eachNoteMutex.RUnlock()
+	argsForCall := fake.eachNoteArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeSnapshot) EachNoteReturns(result1 error) {
+	fake.eachNoteMutex.Lock()
+	defer fake.eachNoteMutex.Unlock()
+	fake.EachNoteStub = nil
+	fake.eachNoteReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSnapshot) EachNoteReturnsOnCall(i int, result1 error) {
+	fake.eachNoteMutex.Lock()
+	defer fake.eachNoteMutex.Unlock()
+	fake.EachNoteStub = nil
+	if fake.eachNoteReturnsOnCall == nil {
+		fake.eachNoteReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.eachNoteReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSnapshot) EachWebhook(arg1 context.Context, arg2 func(models.Webhook) error) error {
+	fake.eachWebhookMutex.Lock()
+	ret, specificReturn := fake.eachWebhookReturnsOnCall[len(fake.eachWebhookArgsForCall)]
+	fake.eachWebhookArgsForCall = append(fake.eachWebhookArgsForCall, struct {
+		arg1 context.Context
+		arg2 func(models.Webhook) error
+	}{arg1, arg2})
+	stub := fake.EachWebhookStub
+	fakeReturns := fake.eachWebhookReturns
+	fake.recordInvocation("EachWebhook", []interface{}{arg1, arg2})
+	fake.eachWebhookMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeSnapshot) EachWebhookCallCount() int {
+	fake.eachWebhookMutex.RLock()
+	defer fake.eachWebhookMutex.RUnlock()
+	return len(fake.eachWebhookArgsForCall)
+}
+
+func (fake *FakeSnapshot) EachWebhookCalls(stub func(context.Context, func(models.Webhook) error) error) {
+	fake.eachWebhookMutex.Lock()
+	defer fake.eachWebhookMutex.Unlock()
+	fake.EachWebhookStub = stub
+}
+
+func (fake *FakeSnapshot) EachWebhookArgsForCall(i int) (context.Context, func(models.Webhook) error) {
+	fake.eachWebhookMutex.RLock()
+	defer fake.eachWebhookMutex.RUnlock()
+	argsForCall := fake.eachWebhookArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeSnapshot) EachWebhookReturns(result1 error) {
+	fake.eachWebhookMutex.Lock()
+	defer fake.eachWebhookMutex.Unlock()
+	fake.EachWebhookStub = nil
+	fake.eachWebhookReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSnapshot) EachWebhookReturnsOnCall(i int, result1 error) {
+	fake.eachWebhookMutex.Lock()
+	defer fake.eachWebhookMutex.Unlock()
+	fake.EachWebhookStub = nil
+	if fake.eachWebhookReturnsOnCall == nil {
+		fake.eachWebhookReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.eachWebhookReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeSnapshot) ListUsers(arg1 context.Context) ([]string, error) {
+	fake.listUsersMutex.Lock()
+	ret, specificReturn := fake.listUsersReturnsOnCall[len(fake.listUsersArgsForCall)]
+	fake.listUsersArgsForCall = append(fake.listUsersArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.ListUsersStub
+	fakeReturns := fake.listUsersReturns
+	fake.recordInvocation("ListUsers", []interface{}{arg1})
+	fake.listUsersMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeSnapshot) ListUsersCallCount() int {
+	fake.listUsersMutex.RLock()
+	defer fake.listUsersMutex.RUnlock()
+	return len(fake.listUsersArgsForCall)
+}
+
+func (fake *FakeSnapshot) ListUsersCalls(stub func(context.Context) ([]string, error)) {
+	fake.listUsersMutex.Lock()
+	defer fake.listUsersMutex.Unlock()
+	fake.ListUsersStub = stub
+}
+
+func (fake *FakeSnapshot) ListUsersArgsForCall(i int) context.Context {
+	fake.listUsersMutex.RLock()
+	defer fake.listUsersMutex.RUnlock()
+	argsForCall := fake.listUsersArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeSnapshot) ListUsersReturns(result1 []string, result2 error) {
+	fake.listUsersMutex.Lock()
+	defer fake.listUsersMutex.Unlock()
+	fake.ListUsersStub = nil
+	fake.listUsersReturns = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeSnapshot) ListUsersReturnsOnCall(i int, result1 []string, result2 error) {
+	fake.listUsersMutex.Lock()
+	defer fake.listUsersMutex.Unlock()
+	fake.ListUsersStub = nil
+	if fake.listUsersReturnsOnCall == nil {
+		fake.listUsersReturnsOnCall = make(map[int]struct {
+			result1 []string
+			result2 error
+		})
+	}
+	fake.listUsersReturnsOnCall[i] = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeSnapshot) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.eachNoteMutex.RLock()
+	defer fake.eachNoteMutex.RUnlock()
+	fake.eachWebhookMutex.RLock()
+	defer fake.eachWebhookMutex.RUnlock()
+	fake.listUsersMutex.RLock()
+	defer fake.listUsersMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeSnapshot) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ database.Snapshot = new(FakeSnapshot)
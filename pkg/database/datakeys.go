@@ -0,0 +1,13 @@
+package database
+
+import "time"
+
+// DataKey is a key encrypting the content of a user's notes. It is stored
+// wrapped, that is encrypted, with the master key with the id MasterKey.
+type DataKey struct {
+	Username  string
+	Id        string
+	MasterKey string
+	Wrapped   []byte
+	CreatedAt time.Time
+}
@@ -1,20 +1,155 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"io"
+	"time"
 
 	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/utils"
 )
 
+// ErrNoteNotFound is returned for notes that do not exist, or belong to
+// another user.
+var ErrNoteNotFound = errors.New("note does not exist")
+
+// ErrWebhookNotFound is returned for webhooks that do not exist, or belong to
+// another user.
+var ErrWebhookNotFound = errors.New("webhook does not exist")
+
+// ErrInvalid is matched by the errors of requests the backends refuse, such
+// as notes without a name, as opposed to the backends failing.
+var ErrInvalid = errors.New("invalid request")
+
+// Invalid marks err as the fault of the request, keeping its message.
+func Invalid(err error) error {
+	return invalidError{err: err}
+}
+
+type invalidError struct {
+	err error
+}
+
+func (e invalidError) Error() string {
+	return e.err.Error()
+}
+
+func (e invalidError) Unwrap() error {
+	return e.err
+}
+
+func (e invalidError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// ValidateNote checks that the note has a name and a user.
+func ValidateNote(note models.Note) error {
+	if !utils.IsSet(note.Name) {
+		return Invalid(errors.New("name must be set"))
+	}
+
+	if !utils.IsSet(note.User.Username) {
+		return Invalid(errors.New("user must be set"))
+	}
+
+	return nil
+}
+
+// Stamped fills in the times of a note written with none, as created and
+// updated at now. A note created at a known time is taken not to have been
+// updated since.
+func Stamped(note models.Note, now time.Time) models.Note {
+	if note.Created.IsZero() {
+		note.Created = now
+	}
+
+	if note.Updated.IsZero() {
+		note.Updated = note.Created
+	}
+
+	return note
+}
+
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
 
 //counterfeiter:generate . Database
 type Database interface {
 	Open() error
 	Close() error
-	Create(body io.ReadCloser) (models.Note, error)
-	Update(id string, body io.ReadCloser) (models.Note, error)
-	Delete(id string, body io.ReadCloser) error
-	ListActiveNotes(body io.ReadCloser) ([]models.Note, error)
-	ListArchivedNotes(body io.ReadCloser) ([]models.Note, error)
+	Ping(ctx context.Context) error
+	Create(ctx context.Context, body io.ReadCloser) (models.Note, error)
+	// Patch replaces one of the user's notes with the result of apply, reading
+	// and writing it as a single step.
+	Patch(ctx context.Context, id string, username string, apply func(models.Note) (models.Note, error)) (models.Note, error)
+	// SetArchived archives or unarchives several of the user's notes and
+	// reports on each of them.
+	SetArchived(ctx context.Context, username string, ids []string, archived bool) ([]models.NoteResult, error)
+	Delete(ctx context.Context, id string, username string) error
+	// Batch runs the operations on the user's notes as a whole: if one of
+	// them fails, with a *BatchError, none are applied.
+	Batch(ctx context.Context, username string, operations []Operation) ([]models.NoteResult, error)
+	ListActiveNotes(ctx context.Context, username string) ([]models.Note, error)
+	ListArchivedNotes(ctx context.Context, username string) ([]models.Note, error)
+	// EachNote calls fn with each of the user's notes, active ones first,
+	// reading them one at a time, and stops at the first error fn returns.
+	EachNote(ctx context.Context, username string, fn func(models.Note) error) error
+	CountNotes(ctx context.Context) (active int, archived int, err error)
+	// ListUsers lists the users who have notes, in order.
+	ListUsers(ctx context.Context) ([]string, error)
+	// PutNote stores note as it is, replacing the user's note with its id,
+	// which is kept unless the backend cannot store it or it belongs to
+	// another user's note, in which case the note gets a new one.
+	PutNote(ctx context.Context, note models.Note) (stored models.Note, created bool, err error)
+	// Snapshot calls fn with a view of the notes and webhooks that nothing
+	// changes until fn returns.
+	Snapshot(ctx context.Context, fn func(Snapshot) error) error
+	// ClaimIdempotencyKey stores record unless a record with the same key
+	// that has not expired at now exists, in which case that one is returned
+	// and claimed is false.
+	ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord, now time.Time) (existing IdempotencyRecord, claimed bool, err error)
+	// SaveIdempotencyKey replaces the record with the same key.
+	SaveIdempotencyKey(ctx context.Context, record IdempotencyRecord) error
+	// ReleaseIdempotencyKey forgets the record with key, so it can be claimed
+	// again.
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	// CreateWebhook stores a webhook of its user and returns it with its id.
+	CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error)
+	ListWebhooks(ctx context.Context, username string) ([]models.Webhook, error)
+	// DeleteWebhook removes one of the user's webhooks and its deliveries.
+	DeleteWebhook(ctx context.Context, id string, username string) error
+	// SaveDelivery adds delivery to the log of its webhook, which keeps the
+	// last DeliveryLogSize attempts besides the dead ones.
+	SaveDelivery(ctx context.Context, delivery models.Delivery) error
+	// ListDeliveries lists the log of a webhook of the user, newest first.
+	ListDeliveries(ctx context.Context, webhookID string, username string) ([]models.Delivery, error)
+	// Changes lists the user's notes changed after the version since, oldest
+	// change first and with tombstones for the deleted ones, along with the
+	// version of their latest change. Since 0 lists every note instead,
+	// without tombstones.
+	Changes(ctx context.Context, username string, since uint64) (changes []models.Change, latest uint64, err error)
+	// ApplyChanges applies changes uploaded by a client to the user's notes
+	// one by one. Changes without an id create notes, and the others only
+	// apply if their version is still the one of the note, and are reported
+	// as conflicts otherwise.
+	ApplyChanges(ctx context.Context, username string, changes []models.Change) ([]models.ChangeResult, error)
+	// DataKeys lists the data keys of the user, oldest first.
+	DataKeys(ctx context.Context, username string) ([]DataKey, error)
+	// SaveDataKey stores key, replacing the user's data key with its id.
+	SaveDataKey(ctx context.Context, key DataKey) error
+}
+
+//counterfeiter:generate . Snapshot
+
+// Snapshot reads a database as it was at one point in time.
+type Snapshot interface {
+	// ListUsers lists the users who have notes, in order.
+	ListUsers(ctx context.Context) ([]string, error)
+	EachNote(ctx context.Context, username string, fn func(models.Note) error) error
+	// EachWebhook calls fn with each webhook of every user.
+	EachWebhook(ctx context.Context, fn func(models.Webhook) error) error
 }
+
+// DeliveryLogSize is how many delivery attempts are kept for each webhook,
+// not counting the dead ones.
+const DeliveryLogSize = 100
@@ -0,0 +1,33 @@
+package database
+
+import "time"
+
+// IdempotencyRecord remembers the response to the first request sent with an
+// idempotency key, so that retries of the request get the same response.
+type IdempotencyRecord struct {
+	// Key identifies the record. It is hashed by the caller, so it is safe to
+	// use as a file name.
+	Key string
+	// Fingerprint identifies the request that claimed the key.
+	Fingerprint string
+	// StatusCode is zero while the first request is being served.
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	// ExpiresAt is when the key can be claimed again.
+	ExpiresAt time.Time
+	// Username is whose data key seals Body when encryption is on. It is not
+	// stored, and is empty for requests that name no user.
+	Username string `json:"-"`
+}
+
+// Pending reports whether the first request with the key is still being
+// served.
+func (r IdempotencyRecord) Pending() bool {
+	return r.StatusCode == 0
+}
+
+// Expired reports whether the record is gone at now.
+func (r IdempotencyRecord) Expired(now time.Time) bool {
+	return !r.ExpiresAt.After(now)
+}
@@ -0,0 +1,77 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/m-rcd/notes/pkg/database"
+)
+
+func (l *LocalFileSystem) DataKeys(ctx context.Context, username string) ([]database.DataKey, error) {
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+
+	l.dataKeysMu.Lock()
+	defer l.dataKeysMu.Unlock()
+
+	return l.readDataKeys(ctx, username)
+}
+
+func (l *LocalFileSystem) SaveDataKey(ctx context.Context, key database.DataKey) error {
+	if err := validateUsername(key.Username); err != nil {
+		return err
+	}
+
+	l.dataKeysMu.Lock()
+	defer l.dataKeysMu.Unlock()
+
+	keys, err := l.readDataKeys(ctx, key.Username)
+	if err != nil {
+		return err
+	}
+
+	replaced := false
+	for i := range keys {
+		if keys[i].Id == key.Id {
+			keys[i], replaced = key, true
+		}
+	}
+	if !replaced {
+		keys = append(keys, key)
+	}
+
+	content, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(ctx, l.dataKeysDir+"/", l.dataKeysPath(key.Username), content)
+}
+
+// readDataKeys reads the keys of the user in the order they were added, the
+// oldest first.
+func (l *LocalFileSystem) readDataKeys(ctx context.Context, username string) ([]database.DataKey, error) {
+	keys := []database.DataKey{}
+
+	content, err := readFile(ctx, l.dataKeysPath(username))
+	if errors.Is(err, fs.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (l *LocalFileSystem) dataKeysPath(username string) string {
+	return filepath.Join(l.dataKeysDir, filepath.Base(username)+".json")
+}
@@ -0,0 +1,58 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// details is what the file of a note cannot hold: its tags and when it was
+// created and last updated. They are kept in a JSON file for each note, in
+// the details directory of its user, and notes without one have none.
+type details struct {
+	Tags    []string  `json:"tags,omitempty"`
+	Created time.Time `json:"created_at"`
+	Updated time.Time `json:"updated_at"`
+}
+
+func detailsPath(userDir string, id string) string {
+	return fmt.Sprintf("%sdetails/%s.json", userDir, id)
+}
+
+// readDetails sets the tags and times of the note from its details file.
+func readDetails(ctx context.Context, userDir string, note *models.Note) error {
+	content, err := readFile(ctx, detailsPath(userDir, note.Id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var d details
+	if err := json.Unmarshal(content, &d); err != nil {
+		return fmt.Errorf("invalid details of note %s: %w", note.Id, err)
+	}
+
+	note.Tags, note.Created, note.Updated = d.Tags, d.Created, d.Updated
+
+	return nil
+}
+
+func writeDetails(ctx context.Context, userDir string, note models.Note) error {
+	content, err := json.Marshal(details{Tags: note.Tags, Created: note.Created, Updated: note.Updated})
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(ctx, userDir, detailsPath(userDir, note.Id), content)
+}
+
+func removeDetails(ctx context.Context, userDir string, id string) error {
+	return removeAll(ctx, detailsPath(userDir, id))
+}
@@ -0,0 +1,133 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+)
+
+// keySweepEvery is how often the expired idempotency keys are cleared out.
+const keySweepEvery = time.Minute
+
+// errCorruptKey is returned for key files that cannot be read back, which are
+// claimed again like expired ones.
+var errCorruptKey = errors.New("idempotency key is corrupt")
+
+// ClaimIdempotencyKey saves record unless its key is still taken. It only
+// reads the file of that key; the others are left to sweepKeys.
+func (l *LocalFileSystem) ClaimIdempotencyKey(ctx context.Context, record database.IdempotencyRecord, now time.Time) (database.IdempotencyRecord, bool, error) {
+	l.keysMu.Lock()
+	defer l.keysMu.Unlock()
+
+	existing, err := l.readKey(ctx, record.Key)
+	if err == nil && !existing.Expired(now) {
+		return existing, false, nil
+	}
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errCorruptKey) {
+		return database.IdempotencyRecord{}, false, err
+	}
+
+	if err := l.writeKey(ctx, record); err != nil {
+		return database.IdempotencyRecord{}, false, err
+	}
+
+	return record, true, nil
+}
+
+func (l *LocalFileSystem) SaveIdempotencyKey(ctx context.Context, record database.IdempotencyRecord) error {
+	l.keysMu.Lock()
+	defer l.keysMu.Unlock()
+
+	return l.writeKey(ctx, record)
+}
+
+func (l *LocalFileSystem) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	l.keysMu.Lock()
+	defer l.keysMu.Unlock()
+
+	return removeAll(ctx, l.keyPath(key))
+}
+
+// sweepKeys removes the expired keys now and every keySweepEvery until stop
+// is closed, then closes done.
+func (l *LocalFileSystem) sweepKeys(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(keySweepEvery)
+	defer ticker.Stop()
+
+	for {
+		l.removeExpiredKeys(context.Background(), time.Now())
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// removeExpiredKeys removes the keys expired at now along with the files
+// that cannot be read back. Keys it fails to remove are left for the next
+// sweep. The lock is taken for one key at a time, so claims are not held up
+// by the whole directory.
+func (l *LocalFileSystem) removeExpiredKeys(ctx context.Context, now time.Time) {
+	files, err := readDir(ctx, l.keysDir)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		l.removeExpiredKey(ctx, strings.TrimSuffix(file.Name(), ".json"), now)
+	}
+}
+
+func (l *LocalFileSystem) removeExpiredKey(ctx context.Context, key string, now time.Time) {
+	l.keysMu.Lock()
+	defer l.keysMu.Unlock()
+
+	record, err := l.readKey(ctx, key)
+	if errors.Is(err, errCorruptKey) || (err == nil && record.Expired(now)) {
+		removeAll(ctx, l.keyPath(key))
+	}
+}
+
+func (l *LocalFileSystem) readKey(ctx context.Context, key string) (database.IdempotencyRecord, error) {
+	var record database.IdempotencyRecord
+
+	content, err := readFile(ctx, l.keyPath(key))
+	if err != nil {
+		return record, err
+	}
+
+	if err := json.Unmarshal(content, &record); err != nil {
+		return record, fmt.Errorf("%w: %s", errCorruptKey, err)
+	}
+
+	return record, nil
+}
+
+func (l *LocalFileSystem) writeKey(ctx context.Context, record database.IdempotencyRecord) error {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(ctx, l.keysDir+"/", l.keyPath(record.Key), content)
+}
+
+func (l *LocalFileSystem) keyPath(key string) string {
+	return filepath.Join(l.keysDir, filepath.Base(key)+".json")
+}
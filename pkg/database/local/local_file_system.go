@@ -1,157 +1,459 @@
 package local
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/m-rcd/notes/pkg/database"
 	"github.com/m-rcd/notes/pkg/models"
 	"github.com/m-rcd/notes/pkg/utils"
 	uuid "github.com/nu7hatch/gouuid"
 )
 
+// errNoUser is returned for operations on the notes of nobody.
+var errNoUser = database.Invalid(errors.New("user must be set"))
+
 type LocalFileSystem struct {
 	workDir string
+	// mu serialises the operations that change notes, which read a note
+	// before changing it or the change log of its user after, and holds
+	// them back during snapshots of other processes.
+	mu fileMutex
+	// syncDir holds the change log of each user, guarded by mu.
+	syncDir string
+	// keysDir holds the idempotency keys, one JSON file each, guarded by
+	// keysMu. The expired ones are swept in the background between Open and
+	// Close, which closes stopSweep and waits for sweepDone.
+	keysDir   string
+	keysMu    sync.Mutex
+	stopSweep chan struct{}
+	sweepDone chan struct{}
+	// hooksDir holds the webhooks, with a file for each and one for its
+	// deliveries, guarded by hooksMu.
+	hooksDir string
+	hooksMu  fileMutex
+	// dataKeysDir holds the data keys, a JSON file for each user, guarded by
+	// dataKeysMu.
+	dataKeysDir string
+	dataKeysMu  sync.Mutex
 }
 
 func NewLocalFileSystem(workDir string) *LocalFileSystem {
 	return &LocalFileSystem{
-		workDir: workDir + "/notes",
+		workDir:     workDir + "/notes",
+		keysDir:     workDir + "/idempotency",
+		hooksDir:    workDir + "/webhooks",
+		syncDir:     workDir + "/sync",
+		dataKeysDir: workDir + "/keys",
 	}
 }
 
+// Open creates the directories only their owner can enter, and closes off
+// the ones an older version created open to everyone, opens the files that
+// lock notes and webhooks for snapshots and starts sweeping expired
+// idempotency keys.
 func (l *LocalFileSystem) Open() error {
-	return os.MkdirAll(l.workDir, 0777)
+	for _, dir := range []string{l.workDir, l.keysDir, l.hooksDir, l.syncDir, l.dataKeysDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+
+		if err := os.Chmod(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	if err := l.mu.open(l.workDir + ".lock"); err != nil {
+		return err
+	}
+
+	if err := l.hooksMu.open(l.hooksDir + ".lock"); err != nil {
+		return err
+	}
+
+	l.stopSweep = make(chan struct{})
+	l.sweepDone = make(chan struct{})
+	go l.sweepKeys(l.stopSweep, l.sweepDone)
+
+	return nil
 }
 
 func (l *LocalFileSystem) Close() error {
-	return nil
+	if l.stopSweep != nil {
+		close(l.stopSweep)
+		<-l.sweepDone
+		l.stopSweep = nil
+	}
+
+	err := l.mu.close()
+	if hooksErr := l.hooksMu.close(); err == nil {
+		err = hooksErr
+	}
+
+	return err
 }
 
-func (l *LocalFileSystem) Create(body io.ReadCloser) (models.Note, error) {
-	var note models.Note
+func (l *LocalFileSystem) Ping(ctx context.Context) error {
+	_, span := startSpan(ctx, "fs.probe", l.workDir)
+	defer span.End()
 
-	reqBody, err := ioutil.ReadAll(body)
+	err := probe(l.workDir)
+	recordError(span, err)
+
+	return err
+}
+
+func probe(dir string) error {
+	probe, err := ioutil.TempFile(dir, ".ping")
 	if err != nil {
-		return note, err
+		return err
 	}
 
-	if err := json.Unmarshal(reqBody, &note); err != nil {
-		return note, err
+	_, err = probe.WriteString("ping")
+	if closeErr := probe.Close(); err == nil {
+		err = closeErr
 	}
 
-	if err := validateNote(note); err != nil {
-		return note, err
+	if removeErr := os.Remove(probe.Name()); err == nil {
+		err = removeErr
 	}
 
-	note.Id = newId()
+	return err
+}
+
+func (l *LocalFileSystem) Create(ctx context.Context, body io.ReadCloser) (models.Note, error) {
+	var note models.Note
 
-	activeDir := fmt.Sprintf("%s/%s/active/", l.workDir, note.User.Username)
-	if err := os.MkdirAll(activeDir, 0777); err != nil {
+	if err := decodeBody(ctx, body, &note); err != nil {
 		return note, err
 	}
 
-	fileName := fmt.Sprintf("%s_%s.txt", note.Name, note.Id)
-	filePath := fmt.Sprintf("%s%s", activeDir, fileName)
-	if err := ioutil.WriteFile(filePath, []byte(note.Content), 0777); err != nil {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	note, undo, err := l.create(ctx, note)
+	if err != nil {
 		return note, err
 	}
 
+	if err := l.record(ctx, note.User.Username, undo, logged{id: note.Id}); err != nil {
+		return models.Note{}, err
+	}
+
 	return note, nil
 }
 
-func (l *LocalFileSystem) Update(id string, body io.ReadCloser) (models.Note, error) {
-	var note models.Note
+// create saves a new note and returns a function removing it again.
+func (l *LocalFileSystem) create(ctx context.Context, note models.Note) (models.Note, func() error, error) {
+	if err := database.ValidateNote(note); err != nil {
+		return note, nil, err
+	}
 
-	reqBody, err := ioutil.ReadAll(body)
-	if err != nil {
-		return note, err
+	if err := validateName(note.Name); err != nil {
+		return note, nil, err
 	}
 
-	if err := json.Unmarshal(reqBody, &note); err != nil {
-		return note, err
+	if err := validateUsername(note.User.Username); err != nil {
+		return note, nil, err
 	}
 
-	note.Id = id
+	note.Id = newId()
+	now := time.Now().UTC()
+	note.Created, note.Updated = now, now
 
-	if note.Archived {
-		archivedNote, err := archive(l.workDir, note)
-		if err != nil {
-			return note, err
-		}
+	userDir := fmt.Sprintf("%s/%s/", l.workDir, note.User.Username)
+	activeDir := userDir + "active/"
+	if err := mkdirAll(ctx, activeDir); err != nil {
+		return note, nil, err
+	}
 
-		return archivedNote, nil
+	fileName := fmt.Sprintf("%s_%s.txt", note.Name, note.Id)
+	filePath := fmt.Sprintf("%s%s", activeDir, fileName)
+	if err := writeFile(ctx, filePath, []byte(note.Content)); err != nil {
+		return note, nil, err
 	}
 
-	dir := fmt.Sprintf("%s/%s/", l.workDir, note.User.Username)
-	if archived(dir, id) {
-		activeNote, err := unarchive(l.workDir, note)
-		if err != nil {
-			return note, err
+	undo := func() error {
+		if err := removeDetails(ctx, userDir, note.Id); err != nil {
+			return err
 		}
 
-		return activeNote, nil
+		return removeAll(ctx, filePath)
 	}
 
-	if err := validateNote(note); err != nil {
+	if err := writeDetails(ctx, userDir, note); err != nil {
+		undo()
+		return note, nil, err
+	}
+
+	return note, undo, nil
+}
+
+func (l *LocalFileSystem) Patch(ctx context.Context, id string, username string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+	if err := validateUsername(username); err != nil {
 		return models.Note{}, err
 	}
 
-	fileName := fmt.Sprintf("%s_%s.txt", note.Name, note.Id)
-	filePath := fmt.Sprintf("%s/%s/active/%s", l.workDir, note.User.Username, fileName)
-	if err := validateFileExists(filePath); err != nil {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	note, undo, err := l.patch(ctx, id, username, apply)
+	if err != nil {
 		return models.Note{}, err
 	}
 
-	if err := ioutil.WriteFile(filePath, []byte(note.Content), 0777); err != nil {
+	if err := l.record(ctx, username, undo, logged{id: id}); err != nil {
 		return models.Note{}, err
 	}
 
 	return note, nil
 }
 
-func (l *LocalFileSystem) Delete(id string, body io.ReadCloser) error {
-	reqBody, err := ioutil.ReadAll(body)
+// patch rewrites the note with the result of apply, stamped as updated now,
+// and returns a function restoring it as it was.
+func (l *LocalFileSystem) patch(ctx context.Context, id string, username string, apply func(models.Note) (models.Note, error)) (models.Note, func() error, error) {
+	userDir := fmt.Sprintf("%s/%s/", l.workDir, username)
+	existingNote, oldPath, err := findNote(ctx, userDir, id, username)
 	if err != nil {
-		return err
+		return models.Note{}, nil, err
+	}
+
+	note, err := apply(existingNote)
+	if err != nil {
+		return models.Note{}, nil, err
 	}
+	note.Created, note.Updated = existingNote.Created, time.Now().UTC()
 
-	var user models.User
-	if err := json.Unmarshal(reqBody, &user); err != nil {
+	return l.rewrite(ctx, userDir, existingNote, oldPath, note)
+}
+
+// rewrite replaces the stored note, whose file is at oldPath, with note and
+// returns a function restoring it as it was.
+func (l *LocalFileSystem) rewrite(ctx context.Context, userDir string, existingNote models.Note, oldPath string, note models.Note) (models.Note, func() error, error) {
+	if err := database.ValidateNote(note); err != nil {
+		return models.Note{}, nil, err
+	}
+
+	if note.Name != existingNote.Name {
+		if err := validateName(note.Name); err != nil {
+			return models.Note{}, nil, err
+		}
+	}
+
+	newPath := fmt.Sprintf("%s%s/%s_%s.txt", userDir, state(note.Archived), note.Name, note.Id)
+	if err := replaceFile(ctx, userDir, newPath, []byte(note.Content)); err != nil {
+		return models.Note{}, nil, err
+	}
+
+	undo := func() error {
+		if err := replaceFile(ctx, userDir, oldPath, []byte(existingNote.Content)); err != nil {
+			return err
+		}
+
+		if err := writeDetails(ctx, userDir, existingNote); err != nil {
+			return err
+		}
+
+		if newPath != oldPath {
+			return removeAll(ctx, newPath)
+		}
+
+		return nil
+	}
+
+	if err := writeDetails(ctx, userDir, note); err != nil {
+		undo()
+		return models.Note{}, nil, err
+	}
+
+	if newPath != oldPath {
+		if err := removeAll(ctx, oldPath); err != nil {
+			undo()
+			return models.Note{}, nil, err
+		}
+	}
+
+	return note, undo, nil
+}
+
+// SetArchived moves each note on its own, carrying on past the ones that fail.
+func (l *LocalFileSystem) SetArchived(ctx context.Context, username string, ids []string, archived bool) ([]models.NoteResult, error) {
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+
+	results := make([]models.NoteResult, 0, len(ids))
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		note, err := l.Patch(ctx, id, username, func(note models.Note) (models.Note, error) {
+			note.Archived = archived
+			return note, nil
+		})
+		if err != nil {
+			results = append(results, models.NoteResult{Id: id, Error: err.Error()})
+			continue
+		}
+
+		results = append(results, models.NoteResult{Id: id, Note: &note})
+	}
+
+	return results, nil
+}
+
+func (l *LocalFileSystem) Delete(ctx context.Context, id string, username string) error {
+	if err := validateUsername(username); err != nil {
 		return err
 	}
 
-	fileName, err := findFile(fmt.Sprintf("%s/%s/active/", l.workDir, user.Username), id)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	undo, err := l.delete(ctx, id, username)
 	if err != nil {
 		return err
 	}
 
-	return os.RemoveAll(fmt.Sprintf("%s/%s/active/%s", l.workDir, user.Username, fileName))
+	return l.record(ctx, username, undo, logged{id: id, deleted: true})
 }
 
-func (l *LocalFileSystem) ListActiveNotes(body io.ReadCloser) ([]models.Note, error) {
-	reqBody, err := ioutil.ReadAll(body)
+// delete removes an active or archived note and returns a function putting
+// it back.
+func (l *LocalFileSystem) delete(ctx context.Context, id string, username string) (func() error, error) {
+	userDir := fmt.Sprintf("%s/%s/", l.workDir, username)
+	note, path, err := findNote(ctx, userDir, id, username)
 	if err != nil {
-		return []models.Note{}, err
+		return nil, err
+	}
+	content := []byte(note.Content)
+
+	if err := removeAll(ctx, path); err != nil {
+		return nil, err
+	}
+
+	undo := func() error {
+		if err := writeFile(ctx, path, content); err != nil {
+			return err
+		}
+
+		return writeDetails(ctx, userDir, note)
+	}
+
+	if err := removeDetails(ctx, userDir, id); err != nil {
+		undo()
+		return nil, err
+	}
+
+	return undo, nil
+}
+
+// Batch runs the operations one after the other and, if one fails, undoes
+// the ones before it.
+func (l *LocalFileSystem) Batch(ctx context.Context, username string, operations []database.Operation) ([]models.NoteResult, error) {
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		results = make([]models.NoteResult, 0, len(operations))
+		undos   []func() error
+	)
+
+	for i, operation := range operations {
+		var (
+			note models.Note
+			undo func() error
+			err  error
+		)
+
+		if err = ctx.Err(); err == nil {
+			switch operation.Kind {
+			case database.OperationCreate:
+				note, undo, err = l.create(ctx, operation.Note)
+			case database.OperationUpdate:
+				note, undo, err = l.patch(ctx, operation.Id, username, operation.Apply)
+			case database.OperationDelete:
+				undo, err = l.delete(ctx, operation.Id, username)
+			default:
+				err = fmt.Errorf("unknown operation %q", operation.Kind)
+			}
+		}
+
+		if err != nil {
+			if rollbackErr := rollback(undos); rollbackErr != nil {
+				err = fmt.Errorf("%s, and undoing the operations before it failed: %w", err, rollbackErr)
+			}
+
+			return nil, &database.BatchError{Index: i, Err: err}
+		}
+
+		undos = append(undos, undo)
+
+		result := models.NoteResult{Id: operation.Id}
+		if operation.Kind != database.OperationDelete {
+			result.Id = note.Id
+			result.Note = &note
+		}
+		results = append(results, result)
+	}
+
+	changes := make([]logged, 0, len(results))
+	for i, result := range results {
+		changes = append(changes, logged{id: result.Id, deleted: operations[i].Kind == database.OperationDelete})
+	}
+
+	undo := func() error {
+		return rollback(undos)
 	}
+	if err := l.record(ctx, username, undo, changes...); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// rollback runs the undo functions, latest first, carrying on past failures.
+func rollback(undos []func() error) error {
+	var failed error
+	for i := len(undos) - 1; i >= 0; i-- {
+		if err := undos[i](); err != nil && failed == nil {
+			failed = err
+		}
+	}
+
+	return failed
+}
 
-	var user models.User
-	if err := json.Unmarshal(reqBody, &user); err != nil {
+func (l *LocalFileSystem) ListActiveNotes(ctx context.Context, username string) ([]models.Note, error) {
+	if err := validateUsername(username); err != nil {
 		return []models.Note{}, err
 	}
 
-	dir := fmt.Sprintf("%s/%s/active/", l.workDir, user.Username)
-	files, err := ioutil.ReadDir(dir)
+	user := models.User{Username: username}
+	userDir := fmt.Sprintf("%s/%s/", l.workDir, user.Username)
+	files, err := readDir(ctx, userDir+"active/")
+	if errors.Is(err, fs.ErrNotExist) {
+		return []models.Note{}, nil
+	}
 	if err != nil {
 		return []models.Note{}, err
 	}
 
-	notes, err := listNotes(dir, files, user, false)
+	notes, err := listNotes(ctx, userDir, files, user, false)
 	if err != nil {
 		return []models.Note{}, err
 	}
@@ -159,24 +461,22 @@ func (l *LocalFileSystem) ListActiveNotes(body io.ReadCloser) ([]models.Note, er
 	return notes, nil
 }
 
-func (l *LocalFileSystem) ListArchivedNotes(body io.ReadCloser) ([]models.Note, error) {
-	reqBody, err := ioutil.ReadAll(body)
-	if err != nil {
+func (l *LocalFileSystem) ListArchivedNotes(ctx context.Context, username string) ([]models.Note, error) {
+	if err := validateUsername(username); err != nil {
 		return []models.Note{}, err
 	}
 
-	var user models.User
-	if err := json.Unmarshal(reqBody, &user); err != nil {
-		return []models.Note{}, err
+	user := models.User{Username: username}
+	userDir := fmt.Sprintf("%s/%s/", l.workDir, user.Username)
+	files, err := readDir(ctx, userDir+"archived/")
+	if errors.Is(err, fs.ErrNotExist) {
+		return []models.Note{}, nil
 	}
-
-	dir := fmt.Sprintf("%s/%s/archived/", l.workDir, user.Username)
-	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return []models.Note{}, err
 	}
 
-	notes, err := listNotes(dir, files, user, true)
+	notes, err := listNotes(ctx, userDir, files, user, true)
 	if err != nil {
 		return []models.Note{}, err
 	}
@@ -184,11 +484,167 @@ func (l *LocalFileSystem) ListArchivedNotes(body io.ReadCloser) ([]models.Note,
 	return notes, nil
 }
 
-func listNotes(dir string, files []fs.FileInfo, user models.User, archived bool) ([]models.Note, error) {
+// EachNote reads the note files one at a time, in the order of their names.
+func (l *LocalFileSystem) EachNote(ctx context.Context, username string, fn func(models.Note) error) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+
+	user := models.User{Username: username}
+	userDir := fmt.Sprintf("%s/%s/", l.workDir, username)
+	for _, archived := range []bool{false, true} {
+		files, err := readDir(ctx, userDir+state(archived)+"/")
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			notes, err := listNotes(ctx, userDir, []fs.FileInfo{file}, user, archived)
+			if err != nil {
+				return err
+			}
+
+			if err := fn(notes[0]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *LocalFileSystem) CountNotes(ctx context.Context) (int, int, error) {
+	var active, archived int
+
+	users, err := readDir(ctx, l.workDir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+
+		if !user.IsDir() {
+			continue
+		}
+
+		activeCount, err := countFiles(ctx, fmt.Sprintf("%s/%s/active/", l.workDir, user.Name()))
+		if err != nil {
+			return 0, 0, err
+		}
+
+		archivedCount, err := countFiles(ctx, fmt.Sprintf("%s/%s/archived/", l.workDir, user.Name()))
+		if err != nil {
+			return 0, 0, err
+		}
+
+		active += activeCount
+		archived += archivedCount
+	}
+
+	return active, archived, nil
+}
+
+func (l *LocalFileSystem) ListUsers(ctx context.Context) ([]string, error) {
+	entries, err := readDir(ctx, l.workDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	users := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			users = append(users, entry.Name())
+		}
+	}
+
+	return users, nil
+}
+
+// PutNote keeps the id of the note when it can be part of its file name, and
+// keeps its times unless they are unset.
+func (l *LocalFileSystem) PutNote(ctx context.Context, note models.Note) (models.Note, bool, error) {
+	if err := database.ValidateNote(note); err != nil {
+		return models.Note{}, false, err
+	}
+
+	if err := validateName(note.Name); err != nil {
+		return models.Note{}, false, err
+	}
+
+	if err := validateUsername(note.User.Username); err != nil {
+		return models.Note{}, false, err
+	}
+
+	if strings.ContainsAny(note.Id, "_./") || !utils.IsSet(note.Id) {
+		note.Id = newId()
+	}
+	note = database.Stamped(note, time.Now().UTC())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	username := note.User.Username
+	userDir := fmt.Sprintf("%s/%s/", l.workDir, username)
+
+	var (
+		undo    func() error
+		created bool
+	)
+	if existingNote, oldPath, err := findNote(ctx, userDir, note.Id, username); err == nil {
+		if note, undo, err = l.rewrite(ctx, userDir, existingNote, oldPath, note); err != nil {
+			return models.Note{}, false, err
+		}
+	} else {
+		if err := ctx.Err(); err != nil {
+			return models.Note{}, false, err
+		}
+
+		path := fmt.Sprintf("%s%s/%s_%s.txt", userDir, state(note.Archived), note.Name, note.Id)
+		if err := replaceFile(ctx, userDir, path, []byte(note.Content)); err != nil {
+			return models.Note{}, false, err
+		}
+
+		undo = func() error {
+			if err := removeDetails(ctx, userDir, note.Id); err != nil {
+				return err
+			}
+
+			return removeAll(ctx, path)
+		}
+
+		if err := writeDetails(ctx, userDir, note); err != nil {
+			undo()
+			return models.Note{}, false, err
+		}
+		created = true
+	}
+
+	if err := l.record(ctx, username, undo, logged{id: note.Id}); err != nil {
+		return models.Note{}, false, err
+	}
+
+	return note, created, nil
+}
+
+// listNotes reads the files of the user's active or archived notes.
+func listNotes(ctx context.Context, userDir string, files []fs.FileInfo, user models.User, archived bool) ([]models.Note, error) {
 	notes := []models.Note{}
 	for _, file := range files {
-		path := fmt.Sprintf("%s/%s", dir, file.Name())
-		content, err := os.ReadFile(path)
+		if err := ctx.Err(); err != nil {
+			return []models.Note{}, err
+		}
+
+		path := fmt.Sprintf("%s%s/%s", userDir, state(archived), file.Name())
+		content, err := readFile(ctx, path)
 		if err != nil {
 			return []models.Note{}, err
 		}
@@ -201,7 +657,11 @@ func listNotes(dir string, files []fs.FileInfo, user models.User, archived bool)
 			Archived: archived,
 		}
 
-		if err := validateNote(note); err != nil {
+		if err := database.ValidateNote(note); err != nil {
+			return []models.Note{}, err
+		}
+
+		if err := readDetails(ctx, userDir, &note); err != nil {
 			return []models.Note{}, err
 		}
 
@@ -211,72 +671,93 @@ func listNotes(dir string, files []fs.FileInfo, user models.User, archived bool)
 	return notes, nil
 }
 
-func archive(dir string, note models.Note) (models.Note, error) {
-	oldPath := fmt.Sprintf("%s/%s/active/", dir, note.User.Username)
-	newPath := fmt.Sprintf("%s/%s/archived/", dir, note.User.Username)
-	archivedNote, err := moveNote(note, oldPath, newPath)
-	if err != nil {
-		return models.Note{}, err
-	}
+// findNote looks for the note among the user's active notes, then their
+// archived ones, and returns it with the path of its file.
+func findNote(ctx context.Context, userDir string, id string, username string) (models.Note, string, error) {
+	for _, archived := range []bool{false, true} {
+		dir := fmt.Sprintf("%s%s/", userDir, state(archived))
+		fileName, err := findFile(ctx, dir, id)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return models.Note{}, "", ctxErr
+			}
 
-	return archivedNote, nil
-}
+			continue
+		}
 
-func unarchive(dir string, note models.Note) (models.Note, error) {
-	oldPath := fmt.Sprintf("%s/%s/archived/", dir, note.User.Username)
-	newPath := fmt.Sprintf("%s/%s/active/", dir, note.User.Username)
+		path := dir + fileName
+		content, err := readFile(ctx, path)
+		if err != nil {
+			return models.Note{}, "", err
+		}
 
-	activeNote, err := moveNote(note, oldPath, newPath)
-	if err != nil {
-		return models.Note{}, err
-	}
+		note := models.Note{
+			Id:       id,
+			Name:     strings.Split(fileName, "_")[0],
+			Content:  string(content),
+			User:     models.User{Username: username},
+			Archived: archived,
+		}
 
-	return activeNote, nil
-}
+		if err := readDetails(ctx, userDir, &note); err != nil {
+			return models.Note{}, "", err
+		}
 
-func moveNote(note models.Note, from, to string) (models.Note, error) {
-	fileName, err := findFile(from, note.Id)
-	if err != nil {
-		return models.Note{}, err
+		return note, path, nil
 	}
 
-	fromFile := fmt.Sprintf("%s%s", from, fileName)
-	toFile := fmt.Sprintf("%s%s", to, fileName)
+	return models.Note{}, "", database.ErrNoteNotFound
+}
 
-	if err := os.MkdirAll(to, 0777); err != nil {
-		return models.Note{}, err
+// replaceFile writes data to a temporary file in tmpDir and renames it over
+// path, so that a note is never seen half written.
+func replaceFile(ctx context.Context, tmpDir string, path string, data []byte) error {
+	if err := mkdirAll(ctx, filepath.Dir(path)); err != nil {
+		return err
 	}
 
-	if err := os.Rename(fromFile, toFile); err != nil {
-		return models.Note{}, err
+	tmpPath := fmt.Sprintf("%s.%s.tmp", tmpDir, newId())
+	if err := writeFile(ctx, tmpPath, data); err != nil {
+		return err
 	}
 
-	note.Name = strings.Split(fileName, "_")[0]
-	oldContent, err := os.ReadFile(toFile)
-	if err != nil {
-		return models.Note{}, err
+	if err := rename(ctx, tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
 	}
 
-	note.Content = string(oldContent)
-
-	return note, nil
+	return nil
 }
 
-func validateNote(note models.Note) error {
-	if !utils.IsSet(note.Name) {
-		return errors.New("name must be set")
+func state(archived bool) string {
+	if archived {
+		return "archived"
 	}
 
-	if !utils.IsSet(note.User.Username) {
-		return errors.New("user must be set")
+	return "active"
+}
+
+// validateName rejects the names that cannot be part of a file name
+// `<name>_<id>.txt`, which would be read back as another note or written
+// outside the user's directory.
+func validateName(name string) error {
+	if strings.ContainsAny(name, `_/\`) || strings.Contains(name, "..") {
+		return database.Invalid(errors.New(`name must not contain "_", "/", "\" or ".."`))
 	}
 
 	return nil
 }
 
-func validateFileExists(path string) error {
-	if _, err := os.Stat(path); err != nil {
-		return fmt.Errorf("file does not exist: %s", err)
+// validateUsername rejects the users whose name cannot be a directory of
+// their own, which would read or write the notes of another user or files
+// outside the notes.
+func validateUsername(username string) error {
+	if !utils.IsSet(username) {
+		return errNoUser
+	}
+
+	if strings.ContainsAny(username, `/\`) || strings.Contains(username, "..") {
+		return database.Invalid(errors.New(`username must not contain "/", "\" or ".."`))
 	}
 
 	return nil
@@ -288,15 +769,22 @@ func newId() string {
 	return id.String()
 }
 
-func findFile(dir string, id string) (string, error) {
+func findFile(ctx context.Context, dir string, id string) (string, error) {
 	var fileName string
 
-	files, err := ioutil.ReadDir(dir)
+	ctx, span := startSpan(ctx, "fs.find", dir)
+	defer span.End()
+
+	files, err := readDir(ctx, dir)
 	if err != nil {
 		return "", err
 	}
 
 	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
 		name := strings.Split(strings.Split(file.Name(), "_")[1], ".")[0]
 		if name == id {
 			fileName = file.Name()
@@ -310,11 +798,14 @@ func findFile(dir string, id string) (string, error) {
 	return fileName, nil
 }
 
-func archived(dir string, id string) bool {
-	file, err := findFile(dir+"archived/", id)
+func countFiles(ctx context.Context, dir string) (int, error) {
+	files, err := readDir(ctx, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
 	if err != nil {
-		return false
+		return 0, err
 	}
 
-	return utils.IsSet(file)
+	return len(files), nil
 }
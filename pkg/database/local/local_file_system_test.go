@@ -1,12 +1,16 @@
 package local_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/m-rcd/notes/pkg/database"
 	"github.com/m-rcd/notes/pkg/database/local"
@@ -15,6 +19,8 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+var ctx = context.Background()
+
 var _ = Describe("LocalFileSystem", func() {
 	var (
 		db      database.Database
@@ -32,6 +38,7 @@ var _ = Describe("LocalFileSystem", func() {
 	})
 
 	AfterEach(func() {
+		Expect(db.Close()).To(Succeed())
 		Expect(os.RemoveAll(tempDir)).To(Succeed())
 	})
 
@@ -40,20 +47,40 @@ var _ = Describe("LocalFileSystem", func() {
 			note := models.Note{Name: "Note1", Content: "Miawwww", User: models.User{Username: "Casper"}}
 			r := buildReader(note)
 
-			newNote, err := db.Create(r)
+			newNote, err := db.Create(ctx, r)
 			Expect(err).NotTo(HaveOccurred())
 			filepath := fmt.Sprintf("%s/notes/%s/active/%s_%s.txt", tempDir, newNote.User.Username, newNote.Name, newNote.Id)
 			Expect(filepath).To(BeAnExistingFile())
 		})
 
+		It("keeps the tags and stamps the note as created and updated now", func() {
+			before := time.Now()
+			note := models.Note{Name: "Note1", Content: "Miawwww", User: models.User{Username: "Casper"}, Tags: []string{"ghosts", "cats"}, Created: time.Unix(1, 0)}
+
+			newNote, err := db.Create(ctx, buildReader(note))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newNote.Tags).To(Equal([]string{"ghosts", "cats"}))
+			Expect(newNote.Created).To(BeTemporally(">=", before))
+			Expect(newNote.Updated).To(Equal(newNote.Created))
+			Expect(fmt.Sprintf("%s/notes/Casper/details/%s.json", tempDir, newNote.Id)).To(BeAnExistingFile())
+
+			Expect(db.ListActiveNotes(ctx, "Casper")).To(Equal([]models.Note{newNote}))
+		})
+
 		Context("when error occurs", func() {
+			It("refuses bodies that are not JSON", func() {
+				_, err = db.Create(ctx, io.NopCloser(strings.NewReader("{")))
+				Expect(err).To(MatchError(database.ErrInvalid))
+			})
+
 			Context("when name is not set", func() {
 				It("does not create a note file and raises an error", func() {
 					note := models.Note{Name: "", Content: "Miawwww", User: models.User{Username: "Casper"}}
 					r := buildReader(note)
 
-					_, err = db.Create(r)
+					_, err = db.Create(ctx, r)
 					Expect(err).To(MatchError("name must be set"))
+					Expect(err).To(MatchError(database.ErrInvalid))
 				})
 			})
 
@@ -62,14 +89,57 @@ var _ = Describe("LocalFileSystem", func() {
 					note := models.Note{Name: "Note1", Content: "Miawwww", User: models.User{Username: ""}}
 					r := buildReader(note)
 
-					_, err = db.Create(r)
+					_, err = db.Create(ctx, r)
 					Expect(err).To(MatchError("user must be set"))
 				})
 			})
+
+			Context("when the name cannot be part of a file name", func() {
+				for _, name := range []string{"Note_1", "Note/1", `Note\1`, "..", "Note..1"} {
+					name := name
+
+					It(fmt.Sprintf("does not create a note file for %q and raises an error", name), func() {
+						_, err = db.Create(ctx, buildReader(models.Note{Name: name, Content: "Miawwww", User: models.User{Username: "Casper"}}))
+						Expect(err).To(MatchError(`name must not contain "_", "/", "\" or ".."`))
+						Expect(err).To(MatchError(database.ErrInvalid))
+
+						_, _, err = db.PutNote(ctx, models.Note{Id: "1", Name: name, User: models.User{Username: "Casper"}})
+						Expect(err).To(MatchError(`name must not contain "_", "/", "\" or ".."`))
+
+						users, err := db.ListUsers(ctx)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(users).To(BeEmpty())
+					})
+				}
+			})
+
+			Context("when the username cannot be a directory", func() {
+				for _, username := range []string{"..", "../Casper", "Casper/notes", `Casper\notes`} {
+					username := username
+
+					It(fmt.Sprintf("does not touch files for %q and raises an error", username), func() {
+						_, err = db.Create(ctx, buildReader(models.Note{Name: "Note1", Content: "Miawwww", User: models.User{Username: username}}))
+						Expect(err).To(MatchError(`username must not contain "/", "\" or ".."`))
+						Expect(err).To(MatchError(database.ErrInvalid))
+
+						_, err = db.ListActiveNotes(ctx, username)
+						Expect(err).To(MatchError(database.ErrInvalid))
+
+						Expect(db.Delete(ctx, "1", username)).To(MatchError(database.ErrInvalid))
+
+						_, _, err = db.Changes(ctx, username, 0)
+						Expect(err).To(MatchError(database.ErrInvalid))
+
+						users, err := db.ListUsers(ctx)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(users).To(BeEmpty())
+					})
+				}
+			})
 		})
 	})
 
-	Context("UPDATE", func() {
+	Context("PATCH", func() {
 		var existingNote models.Note
 
 		BeforeEach(func() {
@@ -77,60 +147,150 @@ var _ = Describe("LocalFileSystem", func() {
 			existingNote = createNote(note, db)
 		})
 
-		It("updates a previously saved note", func() {
-			note := models.Note{Name: "Note1", Content: "BOOOO", User: models.User{Username: "Casper"}}
-			r := buildReader(note)
+		It("renames, clears and archives the note in one step", func() {
+			note, err := db.Patch(ctx, existingNote.Id, "Casper", func(note models.Note) (models.Note, error) {
+				Expect(note).To(Equal(existingNote))
+				note.Name = "Note2"
+				note.Content = ""
+				note.Archived = true
+				return note, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(note.Name).To(Equal("Note2"))
 
-			_, err = db.Update(existingNote.Id, r)
+			Expect(fmt.Sprintf("%s/notes/Casper/active/Note1_%s.txt", tempDir, existingNote.Id)).NotTo(BeAnExistingFile())
+			content, err := os.ReadFile(fmt.Sprintf("%s/notes/Casper/archived/Note2_%s.txt", tempDir, existingNote.Id))
 			Expect(err).NotTo(HaveOccurred())
-			filepath := fmt.Sprintf("%s/notes/%s/active/%s_%s.txt", tempDir, existingNote.User.Username, existingNote.Name, existingNote.Id)
-			content, err := os.ReadFile(filepath)
+			Expect(content).To(BeEmpty())
+
+			notes, err := db.ListArchivedNotes(ctx, "Casper")
 			Expect(err).NotTo(HaveOccurred())
-			Expect(string(content)).To(Equal("BOOOO"))
+			Expect(notes).To(Equal([]models.Note{note}))
 		})
 
-		Context("when an error occurs", func() {
-			Context("when file does not exist", func() {
-				It("does not update the note and raises an error", func() {
-					note := models.Note{Name: "Note2", Content: "BOOOO", User: models.User{Username: "Casper"}}
-					r := buildReader(note)
+		It("keeps when the note was created and stamps it as updated now", func() {
+			note, err := db.Patch(ctx, existingNote.Id, "Casper", func(note models.Note) (models.Note, error) {
+				note.Tags = []string{"ghosts"}
+				note.Created, note.Updated = time.Time{}, time.Time{}
+				return note, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(note.Tags).To(Equal([]string{"ghosts"}))
+			Expect(note.Created).To(Equal(existingNote.Created))
+			Expect(note.Updated).To(BeTemporally(">", existingNote.Updated))
+
+			Expect(db.ListActiveNotes(ctx, "Casper")).To(Equal([]models.Note{note}))
+		})
+
+		It("reads notes written before notes had details as having none", func() {
+			Expect(os.RemoveAll(fmt.Sprintf("%s/notes/Casper/details", tempDir))).To(Succeed())
 
-					_, err = db.Update(existingNote.Id, r)
-					Expect(err).To(MatchError(ContainSubstring("file does not exist")))
-					filepath := fmt.Sprintf("%s/notes/%s/active/%s_%s.txt", tempDir, existingNote.User.Username, existingNote.Name, existingNote.Id)
-					content, err := os.ReadFile(filepath)
-					Expect(err).NotTo(HaveOccurred())
-					Expect(string(content)).To(Equal("Miaaaww"))
+			notes, err := db.ListActiveNotes(ctx, "Casper")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(HaveLen(1))
+			Expect(notes[0].Tags).To(BeNil())
+			Expect(notes[0].Created.IsZero()).To(BeTrue())
+		})
+
+		Context("when an error occurs", func() {
+			It("leaves the note alone when the change fails", func() {
+				_, err := db.Patch(ctx, existingNote.Id, "Casper", func(note models.Note) (models.Note, error) {
+					return models.Note{}, errors.New("invalid patch")
 				})
+				Expect(err).To(MatchError("invalid patch"))
+
+				notes, err := db.ListActiveNotes(ctx, "Casper")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(notes).To(Equal([]models.Note{existingNote}))
 			})
 
-			Context("when name is not set", func() {
-				It("does not update the note and raises an error", func() {
-					note := models.Note{Name: "", Content: "BOOOO", User: models.User{Username: "Casper"}}
-					r := buildReader(note)
+			It("raises an error when the user has no such note", func() {
+				_, err := db.Patch(ctx, existingNote.Id, "Lyra", func(note models.Note) (models.Note, error) { return note, nil })
+				Expect(err).To(MatchError("note does not exist"))
+			})
 
-					_, err = db.Update(existingNote.Id, r)
-					Expect(err).To(MatchError("name must be set"))
-					filepath := fmt.Sprintf("%s/notes/%s/active/%s_%s.txt", tempDir, existingNote.User.Username, existingNote.Name, existingNote.Id)
-					content, err := os.ReadFile(filepath)
-					Expect(err).NotTo(HaveOccurred())
-					Expect(string(content)).To(Equal("Miaaaww"))
+			It("does not rename the note to a name that cannot be part of a file name", func() {
+				_, err := db.Patch(ctx, existingNote.Id, "Casper", func(note models.Note) (models.Note, error) {
+					note.Name = "Note_2"
+					return note, nil
 				})
+				Expect(err).To(MatchError(`name must not contain "_", "/", "\" or ".."`))
+
+				notes, err := db.ListActiveNotes(ctx, "Casper")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(notes).To(Equal([]models.Note{existingNote}))
 			})
+		})
+	})
 
-			Context("when user is not set", func() {
-				It("does not update the note and raises an error", func() {
-					note := models.Note{Name: "Note1", Content: "BOOOO", User: models.User{Username: ""}}
-					r := buildReader(note)
+	Context("SET ARCHIVED", func() {
+		It("moves each note it can and reports the others", func() {
+			note1 := createNote(models.Note{Name: "Note1", Content: "Kirjava", User: models.User{Username: "Lyra"}}, db)
+			note2 := createNote(models.Note{Name: "Note2", Content: "Pantalaimon", User: models.User{Username: "Lyra"}}, db)
 
-					_, err = db.Update(existingNote.Id, r)
-					Expect(err).To(MatchError("user must be set"))
-					filepath := fmt.Sprintf("%s/notes/%s/active/%s_%s.txt", tempDir, existingNote.User.Username, existingNote.Name, existingNote.Id)
-					content, err := os.ReadFile(filepath)
-					Expect(err).NotTo(HaveOccurred())
-					Expect(string(content)).To(Equal("Miaaaww"))
-				})
+			results, err := db.SetArchived(ctx, "Lyra", []string{note1.Id, "missing", note2.Id}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(3))
+			Expect(results[0].Note.Archived).To(BeTrue())
+			Expect(results[1]).To(Equal(models.NoteResult{Id: "missing", Error: "note does not exist"}))
+			Expect(results[2].Note.Archived).To(BeTrue())
+
+			notes, err := db.ListArchivedNotes(ctx, "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(HaveLen(2))
+
+			results, err = db.SetArchived(ctx, "Lyra", []string{note1.Id}, false)
+			Expect(err).NotTo(HaveOccurred())
+			unarchived := *results[0].Note
+			Expect(unarchived.Updated).To(BeTemporally(">", note1.Updated))
+			unarchived.Updated = note1.Updated
+			Expect(unarchived).To(Equal(note1))
+		})
+	})
+
+	Context("BATCH", func() {
+		var existingNote, otherNote models.Note
+
+		BeforeEach(func() {
+			existingNote = createNote(models.Note{Name: "Note1", Content: "Kirjava", User: models.User{Username: "Lyra"}}, db)
+			otherNote = createNote(models.Note{Name: "Note2", Content: "Pantalaimon", User: models.User{Username: "Lyra"}}, db)
+		})
+
+		rename := func(note models.Note) (models.Note, error) {
+			note.Name = "Renamed"
+			return note, nil
+		}
+
+		It("runs every operation", func() {
+			results, err := db.Batch(ctx, "Lyra", []database.Operation{
+				{Kind: database.OperationCreate, Note: models.Note{Name: "Note3", Content: "Iorek", User: models.User{Username: "Lyra"}}},
+				{Kind: database.OperationUpdate, Id: existingNote.Id, Apply: rename},
+				{Kind: database.OperationDelete, Id: otherNote.Id},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(3))
+			Expect(results[0].Id).NotTo(BeEmpty())
+			Expect(results[1].Note.Name).To(Equal("Renamed"))
+			Expect(results[2]).To(Equal(models.NoteResult{Id: otherNote.Id}))
+
+			notes, err := db.ListActiveNotes(ctx, "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(ConsistOf(*results[0].Note, *results[1].Note))
+		})
+
+		It("undoes the operations before the one that failed", func() {
+			_, err := db.Batch(ctx, "Lyra", []database.Operation{
+				{Kind: database.OperationCreate, Note: models.Note{Name: "Note3", Content: "Iorek", User: models.User{Username: "Lyra"}}},
+				{Kind: database.OperationUpdate, Id: existingNote.Id, Apply: rename},
+				{Kind: database.OperationDelete, Id: otherNote.Id},
+				{Kind: database.OperationDelete, Id: "missing"},
 			})
+			Expect(err).To(MatchError("operation 3 failed: note does not exist"))
+			Expect(err).To(MatchError(database.ErrNoteNotFound))
+
+			notes, err := db.ListActiveNotes(ctx, "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(ConsistOf(existingNote, otherNote))
 		})
 	})
 
@@ -143,23 +303,30 @@ var _ = Describe("LocalFileSystem", func() {
 		})
 
 		It("deletes a note", func() {
-			user := models.User{Username: "Casper"}
-			r := buildReader(user)
-
-			err = db.Delete(existingNote.Id, r)
+			err = db.Delete(ctx, existingNote.Id, "Casper")
 			Expect(err).NotTo(HaveOccurred())
 			filepath := fmt.Sprintf("%s/notes/%s/active/%s_%s.txt", tempDir, existingNote.User.Username, existingNote.Name, existingNote.Id)
 
 			Expect(filepath).NotTo(BeAnExistingFile())
+			Expect(fmt.Sprintf("%s/notes/Casper/details/%s.json", tempDir, existingNote.Id)).NotTo(BeAnExistingFile())
+		})
+
+		It("deletes an archived note", func() {
+			archiveNote(existingNote, db)
+
+			Expect(db.Delete(ctx, existingNote.Id, "Casper")).To(Succeed())
+			filepath := fmt.Sprintf("%s/notes/%s/archived/%s_%s.txt", tempDir, existingNote.User.Username, existingNote.Name, existingNote.Id)
+			Expect(filepath).NotTo(BeAnExistingFile())
+
+			notes, err := db.ListArchivedNotes(ctx, "Casper")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(BeEmpty())
 		})
 
 		Context("when errors occur", func() {
 			It("does not delete the file and raises an error", func() {
-				user := models.User{Username: "Casper"}
-				r := buildReader(user)
-
-				err = db.Delete("123", r)
-				Expect(err).To(MatchError(ContainSubstring("file does not exist")))
+				err = db.Delete(ctx, "123", "Casper")
+				Expect(err).To(MatchError(database.ErrNoteNotFound))
 				filepath := fmt.Sprintf("%s/notes/%s/active/%s_%s.txt", tempDir, existingNote.User.Username, existingNote.Name, existingNote.Id)
 
 				Expect(filepath).To(BeAnExistingFile())
@@ -176,11 +343,7 @@ var _ = Describe("LocalFileSystem", func() {
 		})
 
 		It("archives a note", func() {
-			note := models.Note{Archived: true, User: models.User{Username: "Casper"}}
-			r := buildReader(note)
-
-			updatedNote, err := db.Update(existingNote.Id, r)
-			Expect(err).NotTo(HaveOccurred())
+			updatedNote := archiveNote(existingNote, db)
 			activeFilepath := fmt.Sprintf("%s/notes/%s/active/%s_%s.txt", tempDir, existingNote.User.Username, existingNote.Name, existingNote.Id)
 			archivedFilePath := fmt.Sprintf("%s/notes/%s/archived/%s_%s.txt", tempDir, existingNote.User.Username, existingNote.Name, existingNote.Id)
 			Expect(activeFilepath).NotTo(BeAnExistingFile())
@@ -190,43 +353,17 @@ var _ = Describe("LocalFileSystem", func() {
 			Expect(updatedNote.Content).To(Equal(existingNote.Content))
 		})
 
-		Context("when content and name are passed as attributes", func() {
-			It("archives the note but does not update name/content", func() {
-				note := models.Note{Name: "Note2", Content: "NewContent", Archived: true, User: models.User{Username: "Casper"}}
-				r := buildReader(note)
-
-				updatedNote, err := db.Update(existingNote.Id, r)
-				Expect(err).NotTo(HaveOccurred())
-				activeFilepath := fmt.Sprintf("%s/notes/%s/active/%s_%s.txt", tempDir, existingNote.User.Username, existingNote.Name, existingNote.Id)
-				archivedFilePath := fmt.Sprintf("%s/notes/%s/archived/%s_%s.txt", tempDir, existingNote.User.Username, existingNote.Name, existingNote.Id)
-				Expect(activeFilepath).NotTo(BeAnExistingFile())
-				Expect(archivedFilePath).To(BeAnExistingFile())
-				Expect(updatedNote.Archived).To(BeTrue())
-				Expect(updatedNote.Name).To(Equal(existingNote.Name))
-				Expect(updatedNote.Content).To(Equal(existingNote.Content))
-			})
-		})
-
 		Context("UNARCHIVE", func() {
 			var archivedNote models.Note
 
 			BeforeEach(func() {
-				note := models.Note{Name: "Note1", Content: "Miaaaww", User: models.User{Username: "Casper"}}
-				archivedNote = createNote(note, db)
-
-				updatedNote := models.Note{Name: "Note2", Content: "NewContent", Archived: true, User: models.User{Username: "Casper"}}
-				rr := buildReader(updatedNote)
-
-				archivedNote, err = db.Update(existingNote.Id, rr)
-				Expect(err).NotTo(HaveOccurred())
+				archivedNote = archiveNote(existingNote, db)
 			})
 
 			It("unarchives a note", func() {
-				note := models.Note{Archived: false, User: models.User{Username: "Casper"}}
-				r := buildReader(note)
-
-				updatedNote, err := db.Update(archivedNote.Id, r)
+				results, err := db.SetArchived(ctx, "Casper", []string{archivedNote.Id}, false)
 				Expect(err).NotTo(HaveOccurred())
+				updatedNote := *results[0].Note
 				activeFilepath := fmt.Sprintf("%s/notes/%s/active/%s_%s.txt", tempDir, archivedNote.User.Username, archivedNote.Name, archivedNote.Id)
 				archivedFilePath := fmt.Sprintf("%s/notes/%s/archived/%s_%s.txt", tempDir, archivedNote.User.Username, archivedNote.Name, archivedNote.Id)
 				Expect(activeFilepath).To(BeAnExistingFile())
@@ -252,15 +389,20 @@ var _ = Describe("LocalFileSystem", func() {
 		})
 
 		It("returns a list of active notes", func() {
-			user := models.User{Username: "Lyra"}
-			r := buildReader(user)
+			createNote(models.Note{Name: "Note3", Content: "Iorek", User: models.User{Username: "Will"}}, db)
 
-			notes, err := db.ListActiveNotes(r)
+			notes, err := db.ListActiveNotes(ctx, "Lyra")
 			Expect(err).NotTo(HaveOccurred())
 			Expect(len(notes)).To(Equal(2))
 			Expect(notes[0]).To(Equal(note1))
 			Expect(notes[1]).To(Equal(note2))
 		})
+
+		It("returns an empty list for a user without active notes", func() {
+			notes, err := db.ListActiveNotes(ctx, "Will")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(BeEmpty())
+		})
 	})
 
 	Context("LIST archived notes", func() {
@@ -276,32 +418,487 @@ var _ = Describe("LocalFileSystem", func() {
 			noteData2 := models.Note{Name: "Note2", Content: "Pantalaimon", User: models.User{Username: "Lyra"}}
 			note2 = createNote(noteData2, db)
 
-			updatedNote1 := models.Note{Archived: true, User: models.User{Username: "Lyra"}}
+			archivedNote1 = archiveNote(note1, db)
+			archivedNote2 = archiveNote(note2, db)
+		})
+
+		It("returns a list of archived notes", func() {
+			notes, err := db.ListArchivedNotes(ctx, "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(notes)).To(Equal(2))
+			Expect(notes[0]).To(Equal(archivedNote1))
+			Expect(notes[1]).To(Equal(archivedNote2))
+		})
 
-			rr1 := buildReader(updatedNote1)
+		It("returns an empty list for a user who never archived a note", func() {
+			createNote(models.Note{Name: "Note3", Content: "Iorek", User: models.User{Username: "Will"}}, db)
 
-			archivedNote1, err = db.Update(note1.Id, rr1)
+			notes, err := db.ListArchivedNotes(ctx, "Will")
 			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(BeEmpty())
+		})
+	})
 
-			updatedNote2 := models.Note{Archived: true, User: models.User{Username: "Lyra"}}
-			rr2 := buildReader(updatedNote2)
+	Context("EACH note", func() {
+		It("calls fn with the active notes, then the archived ones", func() {
+			active := createNote(models.Note{Name: "Note1", Content: "Kirjava", User: models.User{Username: "Lyra"}}, db)
+			archived := createNote(models.Note{Name: "Note2", Content: "Pantalaimon", User: models.User{Username: "Lyra"}}, db)
+			archived = archiveNote(archived, db)
+
+			var notes []models.Note
+			Expect(db.EachNote(ctx, "Lyra", func(note models.Note) error {
+				notes = append(notes, note)
+				return nil
+			})).To(Succeed())
+			Expect(notes).To(Equal([]models.Note{active, archived}))
+		})
 
-			archivedNote2, err = db.Update(note2.Id, rr2)
+		It("stops when fn fails", func() {
+			createNote(models.Note{Name: "Note1", User: models.User{Username: "Lyra"}}, db)
+			createNote(models.Note{Name: "Note2", User: models.User{Username: "Lyra"}}, db)
+
+			calls := 0
+			err := db.EachNote(ctx, "Lyra", func(models.Note) error {
+				calls++
+				return errors.New("enough")
+			})
+			Expect(err).To(MatchError("enough"))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("has nothing to call fn with for users without notes", func() {
+			Expect(db.EachNote(ctx, "Will", func(models.Note) error {
+				return errors.New("there are no notes")
+			})).To(Succeed())
+		})
+	})
+
+	Context("LIST users", func() {
+		It("lists the users who have notes", func() {
+			createNote(models.Note{Name: "Note1", User: models.User{Username: "Will"}}, db)
+			createNote(models.Note{Name: "Note1", User: models.User{Username: "Lyra"}}, db)
+
+			users, err := db.ListUsers(ctx)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(users).To(Equal([]string{"Lyra", "Will"}))
 		})
+	})
 
-		It("returns a list of archived notes", func() {
-			user := models.User{Username: "Lyra"}
-			r := buildReader(user)
+	Context("PUT note", func() {
+		It("stores the note with its id, tags and times", func() {
+			note := models.Note{Id: "42", Name: "Note1", Content: "Kirjava", Archived: true, User: models.User{Username: "Lyra"},
+				Tags: []string{"daemons"}, Created: time.Unix(1, 0).UTC(), Updated: time.Unix(2, 0).UTC()}
 
-			notes, err := db.ListArchivedNotes(r)
+			stored, created, err := db.PutNote(ctx, note)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(len(notes)).To(Equal(2))
-			Expect(notes[0]).To(Equal(archivedNote1))
-			Expect(notes[1]).To(Equal(archivedNote2))
+			Expect(created).To(BeTrue())
+			Expect(stored).To(Equal(note))
+			Expect(fmt.Sprintf("%s/notes/Lyra/archived/Note1_42.txt", tempDir)).To(BeAnExistingFile())
+			Expect(db.ListArchivedNotes(ctx, "Lyra")).To(Equal([]models.Note{note}))
+
+			changes, _, err := db.Changes(ctx, "Lyra", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+		})
+
+		It("replaces the user's note with the id", func() {
+			existing := createNote(models.Note{Name: "Note1", Content: "Kirjava", User: models.User{Username: "Lyra"}}, db)
+
+			stored, created, err := db.PutNote(ctx, models.Note{Id: existing.Id, Name: "Note2", Content: "Pantalaimon", Archived: true, User: models.User{Username: "Lyra"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeFalse())
+			Expect(stored.Id).To(Equal(existing.Id))
+
+			notes, err := db.ListArchivedNotes(ctx, "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(Equal([]models.Note{stored}))
+
+			notes, err = db.ListActiveNotes(ctx, "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(BeEmpty())
+		})
+
+		It("gives a new id when the id cannot be part of a file name", func() {
+			stored, created, err := db.PutNote(ctx, models.Note{Id: "../1", Name: "Note1", User: models.User{Username: "Lyra"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeTrue())
+			Expect(stored.Id).NotTo(Equal("../1"))
+		})
+	})
+
+	Context("SNAPSHOT", func() {
+		It("pauses writes until fn returns", func() {
+			createNote(models.Note{Name: "Note1", Content: "Kirjava", User: models.User{Username: "Lyra"}}, db)
+			_, err := db.CreateWebhook(ctx, models.Webhook{URL: "https://example.com", User: models.User{Username: "Lyra"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			created := make(chan struct{})
+			err = db.Snapshot(ctx, func(snapshot database.Snapshot) error {
+				go func() {
+					defer GinkgoRecover()
+					createNote(models.Note{Name: "Note2", User: models.User{Username: "Lyra"}}, db)
+					close(created)
+				}()
+				Consistently(created, "100ms").ShouldNot(BeClosed())
+
+				users, err := snapshot.ListUsers(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(users).To(Equal([]string{"Lyra"}))
+
+				notes := 0
+				Expect(snapshot.EachNote(ctx, "Lyra", func(models.Note) error {
+					notes++
+					return nil
+				})).To(Succeed())
+				Expect(notes).To(Equal(1))
+
+				webhooks := 0
+				Expect(snapshot.EachWebhook(ctx, func(models.Webhook) error {
+					webhooks++
+					return nil
+				})).To(Succeed())
+				Expect(webhooks).To(Equal(1))
+
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(created).Should(BeClosed())
+		})
+
+		It("pauses the writes of other processes opened on the directory", func() {
+			other := local.NewLocalFileSystem(tempDir)
+			Expect(other.Open()).To(Succeed())
+			defer other.Close()
+
+			created := make(chan struct{})
+			err := db.Snapshot(ctx, func(snapshot database.Snapshot) error {
+				go func() {
+					defer GinkgoRecover()
+					createNote(models.Note{Name: "Note1", User: models.User{Username: "Lyra"}}, other)
+					close(created)
+				}()
+				Consistently(created, "100ms").ShouldNot(BeClosed())
+
+				users, err := snapshot.ListUsers(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(users).To(BeEmpty())
+
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(created).Should(BeClosed())
+		})
+	})
+
+	Context("when the context is cancelled", func() {
+		It("stops listing notes", func() {
+			createNote(models.Note{Name: "Note1", Content: "Kirjava", User: models.User{Username: "Lyra"}}, db)
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+
+			_, err := db.ListActiveNotes(cancelled, "Lyra")
+			Expect(err).To(MatchError(context.Canceled))
+
+			_, _, err = db.CountNotes(cancelled)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+	})
+
+	Context("IDEMPOTENCY keys", func() {
+		var (
+			// now is the real time, which the background sweep goes by.
+			now    = time.Now()
+			record = database.IdempotencyRecord{Key: "abc", Fingerprint: "first", ExpiresAt: now.Add(time.Minute)}
+		)
+
+		It("claims a key once", func() {
+			existing, claimed, err := db.ClaimIdempotencyKey(ctx, record, now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claimed).To(BeTrue())
+			Expect(existing).To(Equal(record))
+
+			retry := record
+			retry.Fingerprint = "second"
+			existing, claimed, err = db.ClaimIdempotencyKey(ctx, retry, now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claimed).To(BeFalse())
+			Expect(existing.Fingerprint).To(Equal("first"))
+			Expect(existing.Pending()).To(BeTrue())
+		})
+
+		It("keeps the saved response", func() {
+			_, _, err := db.ClaimIdempotencyKey(ctx, record, now)
+			Expect(err).NotTo(HaveOccurred())
+
+			saved := record
+			saved.StatusCode = 201
+			saved.ContentType = "application/json"
+			saved.Body = []byte(`{"status_code":201}`)
+			saved.ExpiresAt = now.Add(time.Hour)
+			Expect(db.SaveIdempotencyKey(ctx, saved)).To(Succeed())
+
+			existing, claimed, err := db.ClaimIdempotencyKey(ctx, record, now.Add(30*time.Minute))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claimed).To(BeFalse())
+			Expect(existing.StatusCode).To(Equal(201))
+			Expect(existing.Body).To(Equal(saved.Body))
+			Expect(existing.ExpiresAt.Equal(saved.ExpiresAt)).To(BeTrue())
+		})
+
+		It("lets expired and released keys be claimed again", func() {
+			_, _, err := db.ClaimIdempotencyKey(ctx, record, now)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, claimed, err := db.ClaimIdempotencyKey(ctx, record, now.Add(time.Minute))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claimed).To(BeTrue())
+
+			Expect(db.ReleaseIdempotencyKey(ctx, record.Key)).To(Succeed())
+			_, claimed, err = db.ClaimIdempotencyKey(ctx, record, now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claimed).To(BeTrue())
+		})
+
+		It("claims keys whose file is corrupt", func() {
+			Expect(ioutil.WriteFile(fmt.Sprintf("%s/idempotency/abc.json", tempDir), []byte("{"), 0600)).To(Succeed())
+
+			existing, claimed, err := db.ClaimIdempotencyKey(ctx, record, now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claimed).To(BeTrue())
+			Expect(existing).To(Equal(record))
+		})
+
+		It("sweeps out expired and corrupt keys in the background", func() {
+			expired := record
+			expired.ExpiresAt = now.Add(-time.Minute)
+			_, _, err := db.ClaimIdempotencyKey(ctx, expired, now.Add(-time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+
+			other := record
+			other.Key = "def"
+			_, _, err = db.ClaimIdempotencyKey(ctx, other, now)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.WriteFile(fmt.Sprintf("%s/idempotency/ghi.json", tempDir), []byte("{"), 0600)).To(Succeed())
+
+			Expect(db.Close()).To(Succeed())
+			Expect(db.Open()).To(Succeed())
+
+			Eventually(fmt.Sprintf("%s/idempotency/abc.json", tempDir)).ShouldNot(BeAnExistingFile())
+			Eventually(fmt.Sprintf("%s/idempotency/ghi.json", tempDir)).ShouldNot(BeAnExistingFile())
+			Expect(fmt.Sprintf("%s/idempotency/def.json", tempDir)).To(BeAnExistingFile())
+		})
+	})
+
+	Context("WEBHOOKS", func() {
+		webhook := models.Webhook{User: models.User{Username: "Casper"}, URL: "https://example.com/hooks", Events: []string{"note.created"}, Secret: "meow"}
+
+		It("creates, lists and deletes the webhooks of a user", func() {
+			created, err := db.CreateWebhook(ctx, webhook)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created.Id).NotTo(BeEmpty())
+
+			other := webhook
+			other.User.Username = "Lyra"
+			_, err = db.CreateWebhook(ctx, other)
+			Expect(err).NotTo(HaveOccurred())
+
+			webhooks, err := db.ListWebhooks(ctx, "Casper")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhooks).To(Equal([]models.Webhook{created}))
+
+			Expect(db.SaveDelivery(ctx, models.Delivery{Id: "a", WebhookId: created.Id, Attempt: 1})).To(Succeed())
+			Expect(db.DeleteWebhook(ctx, created.Id, "Lyra")).To(MatchError("webhook does not exist"))
+			Expect(db.DeleteWebhook(ctx, created.Id, "Casper")).To(Succeed())
+			Expect(db.DeleteWebhook(ctx, created.Id, "Casper")).To(MatchError("webhook does not exist"))
+
+			webhooks, err = db.ListWebhooks(ctx, "Casper")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhooks).To(BeEmpty())
+			Expect(fmt.Sprintf("%s/webhooks/%s.deliveries.json", tempDir, created.Id)).NotTo(BeAnExistingFile())
+		})
+
+		It("keeps the last deliveries and every dead one, newest first", func() {
+			Expect(db.SaveDelivery(ctx, models.Delivery{Id: "dead", WebhookId: "1", Attempt: 5, Dead: true, Payload: "{}"})).To(Succeed())
+			for i := 0; i < database.DeliveryLogSize+1; i++ {
+				Expect(db.SaveDelivery(ctx, models.Delivery{Id: fmt.Sprint(i), WebhookId: "1", Attempt: 1})).To(Succeed())
+			}
+
+			deliveries, err := db.ListDeliveries(ctx, "1", "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deliveries).To(HaveLen(database.DeliveryLogSize + 1))
+			Expect(deliveries[0].Id).To(Equal(fmt.Sprint(database.DeliveryLogSize)))
+			Expect(deliveries[database.DeliveryLogSize-1].Id).To(Equal("1"))
+			Expect(deliveries[database.DeliveryLogSize].Id).To(Equal("dead"))
+		})
+
+		It("lists no deliveries for webhooks that have none", func() {
+			deliveries, err := db.ListDeliveries(ctx, "1", "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deliveries).To(BeEmpty())
+		})
+	})
+
+	Context("SYNC", func() {
+		var note models.Note
+
+		BeforeEach(func() {
+			note = createNote(models.Note{Name: "Note1", Content: "Kirjava", User: models.User{Username: "Lyra"}}, db)
+		})
+
+		It("gives every change the next version of the user and keeps tombstones", func() {
+			other := createNote(models.Note{Name: "Note2", Content: "Pantalaimon", User: models.User{Username: "Lyra"}}, db)
+			createNote(models.Note{Name: "Note3", User: models.User{Username: "Will"}}, db)
+
+			changes, latest, err := db.Changes(ctx, "Lyra", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(latest).To(Equal(uint64(2)))
+			Expect(changes).To(Equal([]models.Change{{Id: note.Id, Version: 1, Note: &note}, {Id: other.Id, Version: 2, Note: &other}}))
+
+			archived, err := db.Patch(ctx, note.Id, "Lyra", func(note models.Note) (models.Note, error) {
+				note.Archived = true
+				return note, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.Delete(ctx, other.Id, "Lyra")).To(Succeed())
+
+			changes, latest, err = db.Changes(ctx, "Lyra", 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(latest).To(Equal(uint64(4)))
+			Expect(changes).To(Equal([]models.Change{{Id: note.Id, Version: 3, Note: &archived}, {Id: other.Id, Version: 4, Deleted: true}}))
+
+			changes, _, err = db.Changes(ctx, "Lyra", 4)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes).To(BeEmpty())
+		})
+
+		It("records nothing for a batch that is undone", func() {
+			_, err := db.Batch(ctx, "Lyra", []database.Operation{
+				{Kind: database.OperationCreate, Note: models.Note{Name: "Note2", User: models.User{Username: "Lyra"}}},
+				{Kind: database.OperationDelete, Id: "missing"},
+			})
+			Expect(err).To(HaveOccurred())
+
+			_, latest, err := db.Changes(ctx, "Lyra", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(latest).To(Equal(uint64(1)))
+		})
+
+		It("applies changes made on top of the current version and reports conflicts", func() {
+			edited := note
+			edited.Content = "offline"
+			created := models.Note{Name: "Note2", Content: "Iorek", User: models.User{Username: "Lyra"}}
+
+			results, err := db.ApplyChanges(ctx, "Lyra", []models.Change{
+				{Id: note.Id, Version: 1, Note: &edited},
+				{Note: &created},
+				{Id: note.Id, Version: 1, Deleted: true},
+				{Id: "missing", Deleted: true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(4))
+			Expect(results[0].Note.Updated).To(BeTemporally(">", note.Updated))
+			edited.Updated = results[0].Note.Updated
+			Expect(results[0]).To(Equal(models.ChangeResult{Change: models.Change{Id: note.Id, Version: 2, Note: &edited}}))
+			Expect(results[1].Version).To(Equal(uint64(3)))
+			Expect(results[1].Note.Content).To(Equal("Iorek"))
+			Expect(results[2]).To(Equal(models.ChangeResult{Change: models.Change{Id: note.Id, Version: 2, Note: &edited}, Conflict: true}))
+			Expect(results[3]).To(Equal(models.ChangeResult{Change: models.Change{Id: "missing"}, Error: "note does not exist"}))
+			created = *results[1].Note
+
+			results, err = db.ApplyChanges(ctx, "Lyra", []models.Change{{Id: note.Id, Version: 2, Deleted: true}, {Id: note.Id, Version: 4, Deleted: true}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(Equal([]models.ChangeResult{
+				{Change: models.Change{Id: note.Id, Version: 4, Deleted: true}},
+				{Change: models.Change{Id: note.Id, Version: 4, Deleted: true}},
+			}))
+
+			notes, err := db.ListActiveNotes(ctx, "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(ConsistOf(created))
 		})
 	})
 
+	Context("DATA keys", func() {
+		It("lists the keys of a user oldest first, replacing the ones saved again", func() {
+			first := database.DataKey{Username: "Lyra", Id: "a1", MasterKey: "m1", Wrapped: []byte("wrapped"), CreatedAt: time.Unix(1, 0).UTC()}
+			second := database.DataKey{Username: "Lyra", Id: "b2", MasterKey: "m1", Wrapped: []byte("other"), CreatedAt: time.Unix(2, 0).UTC()}
+			Expect(db.SaveDataKey(ctx, first)).To(Succeed())
+			Expect(db.SaveDataKey(ctx, second)).To(Succeed())
+			Expect(db.SaveDataKey(ctx, database.DataKey{Username: "Will", Id: "c3", MasterKey: "m1", Wrapped: []byte("his")})).To(Succeed())
+
+			first.MasterKey, first.Wrapped = "m2", []byte("rewrapped")
+			Expect(db.SaveDataKey(ctx, first)).To(Succeed())
+
+			keys, err := db.DataKeys(ctx, "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(Equal([]database.DataKey{first, second}))
+		})
+
+		It("has no keys for a new user", func() {
+			keys, err := db.DataKeys(ctx, "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(BeEmpty())
+		})
+	})
+
+	Context("PERMISSIONS", func() {
+		It("keeps the notes to the owner of the directory", func() {
+			note := createNote(models.Note{Name: "Note1", Content: "Kirjava", User: models.User{Username: "Lyra"}}, db)
+
+			for _, dir := range []string{"notes", "notes/Lyra/active", "sync", "keys", "webhooks", "idempotency"} {
+				info, err := os.Stat(filepath.Join(tempDir, dir))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0700)), dir)
+			}
+
+			info, err := os.Stat(fmt.Sprintf("%s/notes/Lyra/active/Note1_%s.txt", tempDir, note.Id))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		It("closes off the directories created open to everyone", func() {
+			Expect(os.Chmod(tempDir+"/notes", 0777)).To(Succeed())
+
+			Expect(local.NewLocalFileSystem(tempDir).Open()).To(Succeed())
+
+			info, err := os.Stat(tempDir + "/notes")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0700)))
+		})
+	})
+
+	Context("PING", func() {
+		It("succeeds when the notes directory is writable", func() {
+			Expect(db.Ping(ctx)).To(Succeed())
+
+			files, err := ioutil.ReadDir(tempDir + "/notes")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(BeEmpty())
+		})
+
+		It("fails when the notes directory is missing", func() {
+			Expect(os.RemoveAll(tempDir + "/notes")).To(Succeed())
+
+			Expect(db.Ping(ctx)).NotTo(Succeed())
+		})
+	})
+
+	Context("COUNT notes", func() {
+		It("counts the active and archived notes of every user", func() {
+			createNote(models.Note{Name: "Note1", Content: "Kirjava", User: models.User{Username: "Lyra"}}, db)
+			createNote(models.Note{Name: "Note2", Content: "Pantalaimon", User: models.User{Username: "Will"}}, db)
+			note := createNote(models.Note{Name: "Note3", Content: "Iorek", User: models.User{Username: "Lyra"}}, db)
+
+			archiveNote(note, db)
+
+			active, archived, err := db.CountNotes(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(active).To(Equal(2))
+			Expect(archived).To(Equal(1))
+		})
+	})
 })
 
 func buildReader(data interface{}) io.ReadCloser {
@@ -313,7 +910,14 @@ func buildReader(data interface{}) io.ReadCloser {
 
 func createNote(note models.Note, db database.Database) models.Note {
 	r := buildReader(note)
-	note, err := db.Create(r)
+	note, err := db.Create(ctx, r)
 	Expect(err).NotTo(HaveOccurred())
 	return note
 }
+
+func archiveNote(note models.Note, db database.Database) models.Note {
+	results, err := db.SetArchived(ctx, note.User.Username, []string{note.Id}, true)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(results[0].Error).To(BeEmpty())
+	return *results[0].Note
+}
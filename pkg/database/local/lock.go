@@ -0,0 +1,70 @@
+package local
+
+import (
+	"os"
+	"sync"
+)
+
+// fileMutex is a mutex that also holds a lock on a file while it is locked.
+// Every process that changes the directory shares the file lock, and a
+// snapshot takes it alone, so that a snapshot taken by another process, such
+// as `notes backup`, holds back the changes of a running server too.
+type fileMutex struct {
+	sync.Mutex
+	file *os.File
+}
+
+// open opens the lock file at path, until then the mutex only holds back
+// this process.
+func (m *fileMutex) open(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	m.file = file
+
+	return nil
+}
+
+func (m *fileMutex) close() error {
+	if m.file == nil {
+		return nil
+	}
+
+	err := m.file.Close()
+	m.file = nil
+
+	return err
+}
+
+// Lock waits for the snapshots of other processes. Failing to lock the file
+// still leaves the writes of this process serialised, so it is not reported.
+func (m *fileMutex) Lock() {
+	m.Mutex.Lock()
+	if m.file != nil {
+		lockFile(m.file, false)
+	}
+}
+
+// LockAll also waits for the changes of other processes, and holds them back
+// until Unlock.
+func (m *fileMutex) LockAll() error {
+	m.Mutex.Lock()
+	if m.file == nil {
+		return nil
+	}
+
+	if err := lockFile(m.file, true); err != nil {
+		m.Mutex.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (m *fileMutex) Unlock() {
+	if m.file != nil {
+		unlockFile(m.file)
+	}
+	m.Mutex.Unlock()
+}
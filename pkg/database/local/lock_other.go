@@ -0,0 +1,14 @@
+//go:build !unix
+
+package local
+
+import "os"
+
+// Other systems only hold back the changes of the same process.
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
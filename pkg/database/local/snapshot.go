@@ -0,0 +1,40 @@
+package local
+
+import (
+	"context"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// Snapshot pauses the writes to notes and webhooks until fn returns, so that
+// fn reads the files as they were when it was called. Writes of other
+// processes opened on the same directory are paused too.
+func (l *LocalFileSystem) Snapshot(ctx context.Context, fn func(database.Snapshot) error) error {
+	if err := l.mu.LockAll(); err != nil {
+		return err
+	}
+	defer l.mu.Unlock()
+	if err := l.hooksMu.LockAll(); err != nil {
+		return err
+	}
+	defer l.hooksMu.Unlock()
+
+	return fn(snapshot{l})
+}
+
+type snapshot struct {
+	l *LocalFileSystem
+}
+
+func (s snapshot) ListUsers(ctx context.Context) ([]string, error) {
+	return s.l.ListUsers(ctx)
+}
+
+func (s snapshot) EachNote(ctx context.Context, username string, fn func(models.Note) error) error {
+	return s.l.EachNote(ctx, username, fn)
+}
+
+func (s snapshot) EachWebhook(ctx context.Context, fn func(models.Webhook) error) error {
+	return s.l.eachWebhook(ctx, fn)
+}
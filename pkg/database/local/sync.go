@@ -0,0 +1,267 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/patch"
+)
+
+// changeLog is the change sequence of a user: Seq is the version of their
+// latest change, and Notes the version of the latest change to each note.
+type changeLog struct {
+	Seq   uint64                `json:"seq"`
+	Notes map[string]noteChange `json:"notes"`
+}
+
+type noteChange struct {
+	Version uint64 `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// logged is a change to a note, to be recorded in the log of its user.
+type logged struct {
+	id      string
+	deleted bool
+}
+
+func (l *LocalFileSystem) Changes(ctx context.Context, username string, since uint64) ([]models.Change, uint64, error) {
+	if err := validateUsername(username); err != nil {
+		return []models.Change{}, 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	log, err := l.readLog(ctx, username)
+	if err != nil {
+		return []models.Change{}, 0, err
+	}
+
+	var changes []models.Change
+	if since == 0 {
+		changes, err = l.snapshot(ctx, username, log)
+	} else {
+		changes, err = l.changesSince(ctx, username, log, since)
+	}
+	if err != nil {
+		return []models.Change{}, 0, err
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Version != changes[j].Version {
+			return changes[i].Version < changes[j].Version
+		}
+
+		return changes[i].Id < changes[j].Id
+	})
+
+	return changes, log.Seq, nil
+}
+
+// snapshot lists every note of the user with the version of its latest
+// change, which is 0 for notes that have not changed since there was a log.
+func (l *LocalFileSystem) snapshot(ctx context.Context, username string, log changeLog) ([]models.Change, error) {
+	user := models.User{Username: username}
+
+	changes := []models.Change{}
+	userDir := fmt.Sprintf("%s/%s/", l.workDir, username)
+	for _, archived := range []bool{false, true} {
+		files, err := readDir(ctx, userDir+state(archived)+"/")
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		notes, err := listNotes(ctx, userDir, files, user, archived)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range notes {
+			changes = append(changes, models.Change{Id: notes[i].Id, Version: log.Notes[notes[i].Id].Version, Note: &notes[i]})
+		}
+	}
+
+	return changes, nil
+}
+
+func (l *LocalFileSystem) changesSince(ctx context.Context, username string, log changeLog, since uint64) ([]models.Change, error) {
+	changes := []models.Change{}
+	for id, entry := range log.Notes {
+		if entry.Version <= since {
+			continue
+		}
+
+		change, err := l.current(ctx, username, log, id)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// ApplyChanges applies each change on its own, undoing it if it cannot be
+// recorded, and carries on past the ones that fail.
+func (l *LocalFileSystem) ApplyChanges(ctx context.Context, username string, changes []models.Change) ([]models.ChangeResult, error) {
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	results := make([]models.ChangeResult, 0, len(changes))
+	for _, change := range changes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		result, err := l.apply(ctx, username, change)
+		if err != nil {
+			result = models.ChangeResult{Change: models.Change{Id: change.Id}, Error: err.Error()}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (l *LocalFileSystem) apply(ctx context.Context, username string, change models.Change) (models.ChangeResult, error) {
+	var (
+		id   = change.Id
+		undo func() error
+	)
+
+	if id == "" {
+		note, undoCreate, err := l.create(ctx, *change.Note)
+		if err != nil {
+			return models.ChangeResult{}, err
+		}
+		id, undo = note.Id, undoCreate
+	} else {
+		log, err := l.readLog(ctx, username)
+		if err != nil {
+			return models.ChangeResult{}, err
+		}
+
+		current, err := l.current(ctx, username, log, id)
+		if err != nil {
+			return models.ChangeResult{}, err
+		}
+
+		result, done, err := database.CheckChange(current, change)
+		if done {
+			return result, err
+		}
+
+		if change.Deleted {
+			undo, err = l.delete(ctx, id, username)
+		} else {
+			_, undo, err = l.patch(ctx, id, username, patch.Replace(*change.Note))
+		}
+		if err != nil {
+			return models.ChangeResult{}, err
+		}
+	}
+
+	if err := l.record(ctx, username, undo, logged{id: id, deleted: change.Deleted}); err != nil {
+		return models.ChangeResult{}, err
+	}
+
+	log, err := l.readLog(ctx, username)
+	if err != nil {
+		return models.ChangeResult{}, err
+	}
+
+	current, err := l.current(ctx, username, log, id)
+	if err != nil {
+		return models.ChangeResult{}, err
+	}
+
+	return models.ChangeResult{Change: current}, nil
+}
+
+// current reads the note with the version of its latest change in log, or
+// its tombstone.
+func (l *LocalFileSystem) current(ctx context.Context, username string, log changeLog, id string) (models.Change, error) {
+	entry := log.Notes[id]
+	if entry.Deleted {
+		return models.Change{Id: id, Version: entry.Version, Deleted: true}, nil
+	}
+
+	note, _, err := findNote(ctx, fmt.Sprintf("%s/%s/", l.workDir, username), id, username)
+	if err != nil {
+		return models.Change{}, err
+	}
+
+	return models.Change{Id: id, Version: entry.Version, Note: &note}, nil
+}
+
+// record adds the changes to the log of the user, running undo when that
+// fails so that no change goes unrecorded.
+func (l *LocalFileSystem) record(ctx context.Context, username string, undo func() error, changes ...logged) error {
+	log, err := l.readLog(ctx, username)
+	if err == nil {
+		for _, change := range changes {
+			log.Seq++
+			log.Notes[change.id] = noteChange{Version: log.Seq, Deleted: change.deleted}
+		}
+
+		err = l.writeLog(ctx, username, log)
+	}
+
+	if err != nil && undo != nil {
+		undo()
+	}
+
+	return err
+}
+
+func (l *LocalFileSystem) readLog(ctx context.Context, username string) (changeLog, error) {
+	log := changeLog{Notes: map[string]noteChange{}}
+
+	content, err := readFile(ctx, l.logPath(username))
+	if errors.Is(err, fs.ErrNotExist) {
+		return log, nil
+	}
+	if err != nil {
+		return changeLog{}, err
+	}
+
+	if err := json.Unmarshal(content, &log); err != nil {
+		return changeLog{}, err
+	}
+
+	if log.Notes == nil {
+		log.Notes = map[string]noteChange{}
+	}
+
+	return log, nil
+}
+
+func (l *LocalFileSystem) writeLog(ctx context.Context, username string, log changeLog) error {
+	content, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(ctx, l.syncDir+"/", l.logPath(username), content)
+}
+
+func (l *LocalFileSystem) logPath(username string) string {
+	return filepath.Join(l.syncDir, filepath.Base(username)+".json")
+}
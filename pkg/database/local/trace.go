@@ -0,0 +1,110 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/m-rcd/notes/pkg/database"
+)
+
+var tracer = otel.Tracer("github.com/m-rcd/notes/pkg/database/local")
+
+func startSpan(ctx context.Context, name, path string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attribute.String("file.path", path)))
+}
+
+func readDir(ctx context.Context, dir string) ([]fs.FileInfo, error) {
+	_, span := startSpan(ctx, "fs.readdir", dir)
+	defer span.End()
+
+	files, err := ioutil.ReadDir(dir)
+	recordError(span, err)
+	span.SetAttributes(attribute.Int("file.count", len(files)))
+
+	return files, err
+}
+
+func readFile(ctx context.Context, path string) ([]byte, error) {
+	_, span := startSpan(ctx, "fs.read", path)
+	defer span.End()
+
+	content, err := os.ReadFile(path)
+	recordError(span, err)
+
+	return content, err
+}
+
+func writeFile(ctx context.Context, path string, content []byte) error {
+	_, span := startSpan(ctx, "fs.write", path)
+	defer span.End()
+
+	err := ioutil.WriteFile(path, content, 0600)
+	recordError(span, err)
+
+	return err
+}
+
+func mkdirAll(ctx context.Context, dir string) error {
+	_, span := startSpan(ctx, "fs.mkdir", dir)
+	defer span.End()
+
+	err := os.MkdirAll(dir, 0700)
+	recordError(span, err)
+
+	return err
+}
+
+func rename(ctx context.Context, from, to string) error {
+	_, span := startSpan(ctx, "fs.rename", from)
+	defer span.End()
+	span.SetAttributes(attribute.String("file.destination", to))
+
+	err := os.Rename(from, to)
+	recordError(span, err)
+
+	return err
+}
+
+func removeAll(ctx context.Context, path string) error {
+	_, span := startSpan(ctx, "fs.remove", path)
+	defer span.End()
+
+	err := os.RemoveAll(path)
+	recordError(span, err)
+
+	return err
+}
+
+func decodeBody(ctx context.Context, body io.ReadCloser, v interface{}) error {
+	_, span := tracer.Start(ctx, "decode body")
+	defer span.End()
+
+	reqBody, err := ioutil.ReadAll(body)
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+
+	if err = json.Unmarshal(reqBody, v); err != nil {
+		recordError(span, err)
+		return database.Invalid(err)
+	}
+
+	return nil
+}
+
+func recordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,163 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+const deliveriesSuffix = ".deliveries.json"
+
+func (l *LocalFileSystem) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	webhook.Id = newId()
+	if err := l.writeHook(ctx, webhook.Id+".json", webhook); err != nil {
+		return models.Webhook{}, err
+	}
+
+	return webhook, nil
+}
+
+func (l *LocalFileSystem) ListWebhooks(ctx context.Context, username string) ([]models.Webhook, error) {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	webhooks := []models.Webhook{}
+	err := l.eachWebhook(ctx, func(webhook models.Webhook) error {
+		if webhook.User.Username == username {
+			webhooks = append(webhooks, webhook)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return []models.Webhook{}, err
+	}
+
+	return webhooks, nil
+}
+
+// eachWebhook reads the webhooks one at a time, with hooksMu held.
+func (l *LocalFileSystem) eachWebhook(ctx context.Context, fn func(models.Webhook) error) error {
+	files, err := readDir(ctx, l.hooksDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") || strings.HasSuffix(file.Name(), deliveriesSuffix) {
+			continue
+		}
+
+		var webhook models.Webhook
+		if err := l.readHook(ctx, file.Name(), &webhook); err != nil {
+			return err
+		}
+
+		if err := fn(webhook); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *LocalFileSystem) DeleteWebhook(ctx context.Context, id string, username string) error {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	var webhook models.Webhook
+	err := l.readHook(ctx, filepath.Base(id)+".json", &webhook)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && webhook.User.Username != username) {
+		return database.ErrWebhookNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := removeAll(ctx, l.hookPath(webhook.Id+deliveriesSuffix)); err != nil {
+		return err
+	}
+
+	return removeAll(ctx, l.hookPath(webhook.Id+".json"))
+}
+
+// SaveDelivery rewrites the log of the webhook with the delivery first.
+func (l *LocalFileSystem) SaveDelivery(ctx context.Context, delivery models.Delivery) error {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	deliveries, err := l.readDeliveries(ctx, delivery.WebhookId)
+	if err != nil {
+		return err
+	}
+
+	kept := []models.Delivery{delivery}
+	attempts := 0
+	if !delivery.Dead {
+		attempts++
+	}
+	for _, existing := range deliveries {
+		if !existing.Dead {
+			if attempts == database.DeliveryLogSize {
+				continue
+			}
+			attempts++
+		}
+
+		kept = append(kept, existing)
+	}
+
+	return l.writeHook(ctx, filepath.Base(delivery.WebhookId)+deliveriesSuffix, kept)
+}
+
+func (l *LocalFileSystem) ListDeliveries(ctx context.Context, webhookID string, username string) ([]models.Delivery, error) {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	return l.readDeliveries(ctx, webhookID)
+}
+
+func (l *LocalFileSystem) readDeliveries(ctx context.Context, webhookID string) ([]models.Delivery, error) {
+	deliveries := []models.Delivery{}
+
+	err := l.readHook(ctx, filepath.Base(webhookID)+deliveriesSuffix, &deliveries)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []models.Delivery{}, nil
+	}
+
+	return deliveries, err
+}
+
+func (l *LocalFileSystem) readHook(ctx context.Context, name string, v interface{}) error {
+	content, err := readFile(ctx, l.hookPath(name))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, v)
+}
+
+func (l *LocalFileSystem) writeHook(ctx context.Context, name string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(ctx, l.hooksDir+"/", l.hookPath(name), content)
+}
+
+func (l *LocalFileSystem) hookPath(name string) string {
+	return filepath.Join(l.hooksDir, name)
+}
@@ -0,0 +1,39 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+)
+
+// DataKeys reads the keys of the user in the order they were created.
+// Creation times are stored as Unix nanoseconds.
+func (s *SQL) DataKeys(ctx context.Context, username string) ([]database.DataKey, error) {
+	rows, err := s.query(ctx, "SELECT id, master_key, wrapped_key, created_at FROM data_keys WHERE username=? ORDER BY created_at, id", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []database.DataKey{}
+	for rows.Next() {
+		key := database.DataKey{Username: username}
+		var createdAt int64
+		if err := rows.Scan(&key.Id, &key.MasterKey, &key.Wrapped, &createdAt); err != nil {
+			return nil, err
+		}
+		key.CreatedAt = time.Unix(0, createdAt)
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *SQL) SaveDataKey(ctx context.Context, key database.DataKey) error {
+	_, err := s.exec(ctx, "INSERT INTO data_keys(username, id, master_key, wrapped_key, created_at) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE master_key=VALUES(master_key), wrapped_key=VALUES(wrapped_key)",
+		key.Username, key.Id, key.MasterKey, key.Wrapped, key.CreatedAt.UnixNano())
+
+	return err
+}
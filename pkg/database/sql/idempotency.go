@@ -0,0 +1,69 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+)
+
+// ClaimIdempotencyKey clears out the expired keys, then inserts record unless
+// its key is still taken. Expiry times are stored as Unix nanoseconds.
+func (s *SQL) ClaimIdempotencyKey(ctx context.Context, record database.IdempotencyRecord, now time.Time) (database.IdempotencyRecord, bool, error) {
+	if _, err := s.exec(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= ?", now.UnixNano()); err != nil {
+		return database.IdempotencyRecord{}, false, err
+	}
+
+	result, err := s.exec(ctx, "INSERT IGNORE INTO idempotency_keys(idempotency_key, fingerprint, status_code, content_type, body, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		record.Key, record.Fingerprint, record.StatusCode, record.ContentType, body(record), record.ExpiresAt.UnixNano())
+	if err != nil {
+		return database.IdempotencyRecord{}, false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return database.IdempotencyRecord{}, false, err
+	}
+
+	if inserted == 1 {
+		return record, true, nil
+	}
+
+	existing := database.IdempotencyRecord{Key: record.Key}
+	var expiresAt int64
+	row := s.queryRow(ctx, "SELECT fingerprint, status_code, content_type, body, expires_at FROM idempotency_keys WHERE idempotency_key=?", record.Key)
+	if err := row.Scan(&existing.Fingerprint, &existing.StatusCode, &existing.ContentType, &existing.Body, &expiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return database.IdempotencyRecord{}, false, errors.New("idempotency key was released while being claimed")
+		}
+
+		return database.IdempotencyRecord{}, false, err
+	}
+	existing.ExpiresAt = time.Unix(0, expiresAt)
+
+	return existing, false, nil
+}
+
+func (s *SQL) SaveIdempotencyKey(ctx context.Context, record database.IdempotencyRecord) error {
+	_, err := s.exec(ctx, "UPDATE idempotency_keys SET fingerprint=?, status_code=?, content_type=?, body=?, expires_at=? WHERE idempotency_key=?",
+		record.Fingerprint, record.StatusCode, record.ContentType, body(record), record.ExpiresAt.UnixNano(), record.Key)
+
+	return err
+}
+
+func (s *SQL) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := s.exec(ctx, "DELETE FROM idempotency_keys WHERE idempotency_key=?", key)
+
+	return err
+}
+
+// body keeps the NOT NULL column happy for records without a response yet.
+func body(record database.IdempotencyRecord) []byte {
+	if record.Body == nil {
+		return []byte{}
+	}
+
+	return record.Body
+}
@@ -4,8 +4,87 @@ const CreateNoteTable = `
 CREATE TABLE if not exists notes (
     id INT unsigned NOT NULL AUTO_INCREMENT, 
     name VARCHAR(150) NOT NULL, 
-    content VARCHAR(150) NOT NULL, 
+    content TEXT NOT NULL, 
 	archived BOOLEAN NOT NULL,
 	username VARCHAR(150) NOT NULL,
+    tags TEXT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    updated_at BIGINT NOT NULL DEFAULT 0,
     PRIMARY KEY     (id)  
     );`
+
+// WidenNoteContent makes room for encrypted content in the notes tables
+// created when content was at most 150 characters.
+const WidenNoteContent = `ALTER TABLE notes MODIFY content TEXT NOT NULL`
+
+// AddNoteDetails adds the tags and timestamps to the notes tables created
+// before notes had them. The notes already there have no tags, and 0 for
+// times they were not stamped with.
+const AddNoteDetails = `ALTER TABLE notes ADD COLUMN tags TEXT NOT NULL, ADD COLUMN created_at BIGINT NOT NULL DEFAULT 0, ADD COLUMN updated_at BIGINT NOT NULL DEFAULT 0`
+
+const CreateIdempotencyKeyTable = `
+CREATE TABLE if not exists idempotency_keys (
+    idempotency_key CHAR(64) NOT NULL,
+    fingerprint CHAR(64) NOT NULL,
+    status_code INT NOT NULL,
+    content_type VARCHAR(150) NOT NULL,
+    body MEDIUMBLOB NOT NULL,
+    expires_at BIGINT NOT NULL,
+    PRIMARY KEY     (idempotency_key),
+    INDEX           (expires_at)
+    );`
+
+const CreateWebhookTable = `
+CREATE TABLE if not exists webhooks (
+    id INT unsigned NOT NULL AUTO_INCREMENT,
+    username VARCHAR(150) NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    events VARCHAR(255) NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    PRIMARY KEY     (id),
+    INDEX           (username)
+    );`
+
+const CreateDeliveryTable = `
+CREATE TABLE if not exists webhook_deliveries (
+    seq BIGINT unsigned NOT NULL AUTO_INCREMENT,
+    id CHAR(36) NOT NULL,
+    webhook_id INT unsigned NOT NULL,
+    event_id VARCHAR(64) NOT NULL,
+    event_type VARCHAR(32) NOT NULL,
+    attempt INT NOT NULL,
+    status_code INT NOT NULL,
+    error TEXT NOT NULL,
+    dead BOOLEAN NOT NULL,
+    payload MEDIUMTEXT NOT NULL,
+    delivered_at BIGINT NOT NULL,
+    PRIMARY KEY     (seq),
+    INDEX           (webhook_id, dead)
+    );`
+
+const CreateSyncSequenceTable = `
+CREATE TABLE if not exists sync_sequences (
+    username VARCHAR(150) NOT NULL,
+    seq BIGINT unsigned NOT NULL,
+    PRIMARY KEY     (username)
+    );`
+
+const CreateNoteChangeTable = `
+CREATE TABLE if not exists note_changes (
+    username VARCHAR(150) NOT NULL,
+    note_id INT unsigned NOT NULL,
+    seq BIGINT unsigned NOT NULL,
+    deleted BOOLEAN NOT NULL,
+    PRIMARY KEY     (username, note_id),
+    INDEX           (username, seq)
+    );`
+
+const CreateDataKeyTable = `
+CREATE TABLE if not exists data_keys (
+    username VARCHAR(150) NOT NULL,
+    id CHAR(16) NOT NULL,
+    master_key CHAR(8) NOT NULL,
+    wrapped_key VARBINARY(255) NOT NULL,
+    created_at BIGINT NOT NULL,
+    PRIMARY KEY     (username, id)
+    );`
@@ -0,0 +1,71 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Snapshot reads in a read-only repeatable read transaction, which sees the
+// rows as they were at its first read whatever is written meanwhile.
+func (s *SQL) Snapshot(ctx context.Context, fn func(database.Snapshot) error) error {
+	ctx, span := tracer.Start(ctx, "sql.snapshot", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	tx, err := s.Db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+
+	if err := fn(snapshot{s: s, tx: tx}); err != nil {
+		tx.Rollback()
+		recordError(span, err)
+		return err
+	}
+
+	err = tx.Commit()
+	recordError(span, err)
+
+	return err
+}
+
+type snapshot struct {
+	s  *SQL
+	tx *sql.Tx
+}
+
+func (s snapshot) ListUsers(ctx context.Context) ([]string, error) {
+	return s.s.listUsersOn(ctx, s.tx)
+}
+
+func (s snapshot) EachNote(ctx context.Context, username string, fn func(models.Note) error) error {
+	return s.s.eachNoteOn(ctx, s.tx, username, fn)
+}
+
+func (s snapshot) EachWebhook(ctx context.Context, fn func(models.Webhook) error) error {
+	rows, err := s.s.queryOn(ctx, s.tx, "SELECT id, username, url, events, secret FROM webhooks ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var webhook models.Webhook
+		var events string
+		if err := rows.Scan(&webhook.Id, &webhook.User.Username, &webhook.URL, &events, &webhook.Secret); err != nil {
+			return err
+		}
+		webhook.Events = strings.Split(events, ",")
+
+		if err := fn(webhook); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -1,17 +1,20 @@
 package sql
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 
+	"github.com/m-rcd/notes/pkg/database"
 	"github.com/m-rcd/notes/pkg/models"
-	"github.com/m-rcd/notes/pkg/utils"
 )
 
 type SQL struct {
@@ -20,19 +23,21 @@ type SQL struct {
 	password string
 	address  string
 	port     string
+	name     string
 }
 
-func NewSQL(username, password, address, port string) *SQL {
+func NewSQL(username, password, address, port, name string) *SQL {
 	return &SQL{
 		username: username,
 		password: password,
 		address:  address,
 		port:     port,
+		name:     name,
 	}
 }
 
 func (s *SQL) Open() error {
-	connString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", s.username, s.password, s.address, s.port, "notes")
+	connString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", s.username, s.password, s.address, s.port, s.name)
 	db, err := sql.Open("mysql", connString)
 	if err != nil {
 		return err
@@ -40,10 +45,34 @@ func (s *SQL) Open() error {
 
 	s.Db = db
 
-	if _, err := s.Db.Exec(CreateNoteTable); err != nil {
+	for _, table := range []string{CreateNoteTable, CreateIdempotencyKeyTable, CreateWebhookTable, CreateDeliveryTable, CreateSyncSequenceTable, CreateNoteChangeTable, CreateDataKeyTable} {
+		if _, err := s.Db.Exec(table); err != nil {
+			return err
+		}
+	}
+
+	var contentType string
+	if err := s.Db.QueryRow("SELECT DATA_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'notes' AND COLUMN_NAME = 'content'").Scan(&contentType); err != nil {
 		return err
 	}
 
+	if !strings.EqualFold(contentType, "text") {
+		if _, err := s.Db.Exec(WidenNoteContent); err != nil {
+			return err
+		}
+	}
+
+	var details int
+	if err := s.Db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'notes' AND COLUMN_NAME = 'tags'").Scan(&details); err != nil {
+		return err
+	}
+
+	if details == 0 {
+		if _, err := s.Db.Exec(AddNoteDetails); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -51,20 +80,53 @@ func (s *SQL) Close() error {
 	return s.Db.Close()
 }
 
-func (s *SQL) Create(body io.ReadCloser) (models.Note, error) {
+func (s *SQL) Ping(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "sql.ping")
+	defer span.End()
+
+	err := s.Db.PingContext(ctx)
+	recordError(span, err)
+
+	return err
+}
+
+func (s *SQL) Create(ctx context.Context, body io.ReadCloser) (models.Note, error) {
 	var note models.Note
 
-	reqBody, err := ioutil.ReadAll(body)
+	if err := decodeBody(ctx, body, &note); err != nil {
+		return models.Note{}, err
+	}
+
+	err := s.transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if note, err = s.createIn(ctx, tx, note); err != nil {
+			return err
+		}
+
+		return s.recordChange(ctx, tx, note.User.Username, note.Id, false)
+	})
 	if err != nil {
 		return models.Note{}, err
 	}
 
-	if err := json.Unmarshal(reqBody, &note); err != nil {
+	return note, nil
+}
+
+func (s *SQL) createIn(ctx context.Context, tx *sql.Tx, note models.Note) (models.Note, error) {
+	if err := database.ValidateNote(note); err != nil {
+		return models.Note{}, err
+	}
+
+	now := time.Now().UTC()
+	note.Created, note.Updated = now, now
+
+	tags, err := encodeTags(note.Tags)
+	if err != nil {
 		return models.Note{}, err
 	}
 
-	sql := fmt.Sprintf("INSERT INTO notes(name, content, username, archived) VALUES ('%s', '%s', '%s', '%v')", note.Name, note.Content, note.User.Username, 0)
-	savedNote, err := s.Db.Exec(sql)
+	savedNote, err := s.execOn(ctx, tx, "INSERT INTO notes(name, content, username, archived, tags, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		note.Name, note.Content, note.User.Username, 0, tags, unixNano(note.Created), unixNano(note.Updated))
 	if err != nil {
 		return models.Note{}, err
 	}
@@ -79,71 +141,213 @@ func (s *SQL) Create(body io.ReadCloser) (models.Note, error) {
 	return note, nil
 }
 
-func (s *SQL) Update(id string, body io.ReadCloser) (models.Note, error) {
-	var existingNote models.Note
+func (s *SQL) Patch(ctx context.Context, id string, username string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+	var note models.Note
 
-	result := s.Db.QueryRow("SELECT id, name, content, archived FROM notes WHERE id=" + id)
-	if err := result.Scan(&existingNote.Id, &existingNote.Name, &existingNote.Content, &existingNote.Archived); err != nil {
+	err := s.transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if note, err = s.patchIn(ctx, tx, id, username, apply); err != nil {
+			return err
+		}
+
+		return s.recordChange(ctx, tx, username, id, false)
+	})
+	if err != nil {
 		return models.Note{}, err
 	}
 
-	var note models.Note
-	reqBody, err := ioutil.ReadAll(body)
+	return note, nil
+}
+
+// patchIn reads the note and writes back the result of apply, stamped as
+// updated now, locking the row until tx ends.
+func (s *SQL) patchIn(ctx context.Context, tx *sql.Tx, id string, username string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+	var existingNote models.Note
+
+	result := s.queryRowOn(ctx, tx, "SELECT "+noteColumns+" FROM notes WHERE id=? AND username=? FOR UPDATE", id, username)
+	err := scanNote(result, &existingNote)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Note{}, database.ErrNoteNotFound
+	}
 	if err != nil {
 		return models.Note{}, err
 	}
 
-	if err := json.Unmarshal(reqBody, &note); err != nil {
+	note, err := apply(existingNote)
+	if err != nil {
 		return models.Note{}, err
 	}
 
-	if !utils.IsSet(note.Name) {
-		note.Name = existingNote.Name
+	archived := 0
+	if note.Archived {
+		archived = 1
 	}
 
-	if !utils.IsSet(note.Content) {
-		note.Content = existingNote.Content
+	note.Created, note.Updated = existingNote.Created, time.Now().UTC()
+
+	tags, err := encodeTags(note.Tags)
+	if err != nil {
+		return models.Note{}, err
 	}
 
-	if !utils.IsSet(note.User.Username) {
-		note.User = existingNote.User
+	if _, err := s.execOn(ctx, tx, "UPDATE notes SET name=?, content=?, archived=?, tags=?, updated_at=? WHERE id=?", note.Name, note.Content, archived, tags, unixNano(note.Updated), id); err != nil {
+		return models.Note{}, err
 	}
 
-	note.Id = id
+	return note, nil
+}
 
-	if note.Archived {
-		if err := archive(s, note); err != nil {
-			return models.Note{}, err
+func (s *SQL) SetArchived(ctx context.Context, username string, ids []string, archived bool) ([]models.NoteResult, error) {
+	var results []models.NoteResult
+
+	err := s.transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		args := []interface{}{username}
+		for _, id := range ids {
+			args = append(args, id)
 		}
+		in := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
 
-		return note, nil
-	}
+		rows, err := s.queryOn(ctx, tx, fmt.Sprintf("SELECT "+noteColumns+" FROM notes WHERE username=? AND id IN (%s) FOR UPDATE", in), args...)
+		if err != nil {
+			return err
+		}
 
-	if !note.Archived && existingNote.Archived {
-		if err := unarchive(s, note); err != nil {
-			return models.Note{}, err
+		notes, err := listNotes(rows)
+		rows.Close()
+		if err != nil {
+			return err
 		}
 
-		return note, nil
-	}
+		found := map[string]models.Note{}
+		for _, note := range notes {
+			found[note.Id] = note
+		}
 
-	if _, err := s.Db.Exec("UPDATE notes set name=?, content=?, archived=? where id=?", note.Name, note.Content, 0, id); err != nil {
-		return models.Note{}, err
+		now := time.Now().UTC()
+		if len(notes) > 0 {
+			value := 0
+			if archived {
+				value = 1
+			}
+
+			args := []interface{}{value, unixNano(now), username}
+			for _, note := range notes {
+				args = append(args, note.Id)
+			}
+			in := strings.TrimSuffix(strings.Repeat("?, ", len(notes)), ", ")
+
+			if _, err := s.execOn(ctx, tx, fmt.Sprintf("UPDATE notes SET archived=?, updated_at=? WHERE username=? AND id IN (%s)", in), args...); err != nil {
+				return err
+			}
+		}
+
+		for _, note := range notes {
+			if err := s.recordChange(ctx, tx, username, note.Id, false); err != nil {
+				return err
+			}
+		}
+
+		results = make([]models.NoteResult, 0, len(ids))
+		for _, id := range ids {
+			note, ok := found[id]
+			if !ok {
+				results = append(results, models.NoteResult{Id: id, Error: "note does not exist"})
+				continue
+			}
+
+			note.Archived, note.Updated = archived, now
+			results = append(results, models.NoteResult{Id: id, Note: &note})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return note, nil
+	return results, nil
+}
+
+func (s *SQL) Delete(ctx context.Context, id string, username string) error {
+	return s.transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if err := s.deleteIn(ctx, tx, id, username); err != nil {
+			return err
+		}
+
+		return s.recordChange(ctx, tx, username, id, true)
+	})
 }
 
-func (s *SQL) Delete(id string, body io.ReadCloser) error {
-	if _, err := s.Db.Exec("DELETE FROM notes WHERE id = ?", id); err != nil {
+func (s *SQL) deleteIn(ctx context.Context, tx *sql.Tx, id string, username string) error {
+	result, err := s.execOn(ctx, tx, "DELETE FROM notes WHERE id = ? AND username = ?", id, username)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
 		return err
 	}
 
+	if deleted == 0 {
+		return database.ErrNoteNotFound
+	}
+
 	return nil
 }
 
-func (s *SQL) ListActiveNotes(body io.ReadCloser) ([]models.Note, error) {
-	result, err := s.Db.Query("SELECT * FROM notes WHERE archived=0")
+func (s *SQL) Batch(ctx context.Context, username string, operations []database.Operation) ([]models.NoteResult, error) {
+	var results []models.NoteResult
+
+	err := s.transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		results = make([]models.NoteResult, 0, len(operations))
+		for i, operation := range operations {
+			var (
+				note models.Note
+				err  error
+			)
+
+			switch operation.Kind {
+			case database.OperationCreate:
+				note, err = s.createIn(ctx, tx, operation.Note)
+			case database.OperationUpdate:
+				note, err = s.patchIn(ctx, tx, operation.Id, username, operation.Apply)
+			case database.OperationDelete:
+				err = s.deleteIn(ctx, tx, operation.Id, username)
+			default:
+				err = fmt.Errorf("unknown operation %q", operation.Kind)
+			}
+			if err != nil {
+				return &database.BatchError{Index: i, Err: err}
+			}
+
+			result := models.NoteResult{Id: operation.Id}
+			if operation.Kind != database.OperationDelete {
+				result.Id = note.Id
+				result.Note = &note
+			}
+			results = append(results, result)
+		}
+
+		// The changes are recorded last so that, like every other write, the
+		// batch locks the notes before the user's sequence.
+		for i, result := range results {
+			if err := s.recordChange(ctx, tx, username, result.Id, operations[i].Kind == database.OperationDelete); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *SQL) ListActiveNotes(ctx context.Context, username string) ([]models.Note, error) {
+	result, err := s.query(ctx, "SELECT "+noteColumns+" FROM notes WHERE archived=0 AND username=?", username)
 	if err != nil {
 		return []models.Note{}, err
 	}
@@ -157,8 +361,8 @@ func (s *SQL) ListActiveNotes(body io.ReadCloser) ([]models.Note, error) {
 	return notes, nil
 }
 
-func (s *SQL) ListArchivedNotes(body io.ReadCloser) ([]models.Note, error) {
-	result, err := s.Db.Query("SELECT * FROM notes WHERE archived=1")
+func (s *SQL) ListArchivedNotes(ctx context.Context, username string) ([]models.Note, error) {
+	result, err := s.query(ctx, "SELECT "+noteColumns+" FROM notes WHERE archived=1 AND username=?", username)
 	if err != nil {
 		return []models.Note{}, err
 	}
@@ -172,30 +376,144 @@ func (s *SQL) ListArchivedNotes(body io.ReadCloser) ([]models.Note, error) {
 	return notes, nil
 }
 
-func archive(s *SQL, note models.Note) error {
-	if _, err := s.Db.Exec("UPDATE notes set archived=? where id=?", 1, note.Id); err != nil {
+// EachNote scans the notes as fn takes them, keeping the rows open until it
+// is done with the last one.
+func (s *SQL) EachNote(ctx context.Context, username string, fn func(models.Note) error) error {
+	return s.eachNoteOn(ctx, s.Db, username, fn)
+}
+
+func (s *SQL) eachNoteOn(ctx context.Context, c conn, username string, fn func(models.Note) error) error {
+	result, err := s.queryOn(ctx, c, "SELECT "+noteColumns+" FROM notes WHERE username=? ORDER BY archived, id", username)
+	if err != nil {
 		return err
 	}
+	defer result.Close()
 
-	return nil
+	for result.Next() {
+		var note models.Note
+		if err := scanNote(result, &note); err != nil {
+			return err
+		}
+
+		if err := fn(note); err != nil {
+			return err
+		}
+	}
+
+	return result.Err()
 }
 
-func unarchive(s *SQL, note models.Note) error {
-	if _, err := s.Db.Exec("UPDATE notes set archived=? where id=?", 0, note.Id); err != nil {
-		return err
+func (s *SQL) CountNotes(ctx context.Context) (int, int, error) {
+	var active, archived int
+
+	result := s.queryRow(ctx, "SELECT COALESCE(SUM(archived=0), 0), COALESCE(SUM(archived=1), 0) FROM notes")
+	if err := result.Scan(&active, &archived); err != nil {
+		return 0, 0, err
 	}
 
-	return nil
+	return active, archived, nil
+}
+
+func (s *SQL) ListUsers(ctx context.Context) ([]string, error) {
+	return s.listUsersOn(ctx, s.Db)
+}
+
+func (s *SQL) listUsersOn(ctx context.Context, c conn) ([]string, error) {
+	result, err := s.queryOn(ctx, c, "SELECT DISTINCT username FROM notes ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	users := []string{}
+	for result.Next() {
+		var user string
+		if err := result.Scan(&user); err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, result.Err()
+}
+
+// PutNote keeps the id of the note when it is a number that is free or the
+// id of one of the user's notes, and keeps its times unless they are unset.
+func (s *SQL) PutNote(ctx context.Context, note models.Note) (models.Note, bool, error) {
+	if err := database.ValidateNote(note); err != nil {
+		return models.Note{}, false, err
+	}
+
+	archived := 0
+	if note.Archived {
+		archived = 1
+	}
+
+	note = database.Stamped(note, time.Now().UTC())
+	tags, err := encodeTags(note.Tags)
+	if err != nil {
+		return models.Note{}, false, err
+	}
+
+	created := false
+	err = s.transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var owner string
+		id, err := strconv.ParseUint(note.Id, 10, 32)
+		if err == nil && id > 0 {
+			err = s.queryRowOn(ctx, tx, "SELECT username FROM notes WHERE id=? FOR UPDATE", id).Scan(&owner)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+
+		if owner != "" || errors.Is(err, sql.ErrNoRows) {
+			note.Id = strconv.FormatUint(id, 10)
+		}
+
+		switch {
+		case err == nil && owner == note.User.Username:
+			if _, err := s.execOn(ctx, tx, "UPDATE notes SET name=?, content=?, archived=?, tags=?, created_at=?, updated_at=? WHERE id=?",
+				note.Name, note.Content, archived, tags, unixNano(note.Created), unixNano(note.Updated), id); err != nil {
+				return err
+			}
+		case errors.Is(err, sql.ErrNoRows):
+			if _, err := s.execOn(ctx, tx, "INSERT INTO notes(id, name, content, username, archived, tags, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+				id, note.Name, note.Content, note.User.Username, archived, tags, unixNano(note.Created), unixNano(note.Updated)); err != nil {
+				return err
+			}
+			created = true
+		default:
+			saved, err := s.execOn(ctx, tx, "INSERT INTO notes(name, content, username, archived, tags, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				note.Name, note.Content, note.User.Username, archived, tags, unixNano(note.Created), unixNano(note.Updated))
+			if err != nil {
+				return err
+			}
+
+			newId, err := saved.LastInsertId()
+			if err != nil {
+				return err
+			}
+
+			note.Id = strconv.FormatInt(newId, 10)
+			created = true
+		}
+
+		return s.recordChange(ctx, tx, note.User.Username, note.Id, false)
+	})
+	if err != nil {
+		return models.Note{}, false, err
+	}
+
+	return note, created, nil
 }
 
 func listNotes(result *sql.Rows) ([]models.Note, error) {
-	var (
-		notes []models.Note
-		note  models.Note
-	)
+	var notes []models.Note
 
 	for result.Next() {
-		if err := result.Scan(&note.Id, &note.Name, &note.Content, &note.Archived, &note.User.Username); err != nil {
+		var note models.Note
+		if err := scanNote(result, &note); err != nil {
 			return []models.Note{}, err
 		}
 		notes = append(notes, note)
@@ -203,3 +521,62 @@ func listNotes(result *sql.Rows) ([]models.Note, error) {
 
 	return notes, nil
 }
+
+// noteColumns are the columns scanNote reads, in its order.
+const noteColumns = "id, name, content, archived, username, tags, created_at, updated_at"
+
+func scanNote(row interface{ Scan(...interface{}) error }, note *models.Note) error {
+	var (
+		tags             string
+		created, updated int64
+	)
+
+	if err := row.Scan(&note.Id, &note.Name, &note.Content, &note.Archived, &note.User.Username, &tags, &created, &updated); err != nil {
+		return err
+	}
+
+	return details(note, tags, created, updated)
+}
+
+// details sets the tags and times of a note from their columns.
+func details(note *models.Note, tags string, created int64, updated int64) error {
+	note.Tags = nil
+	if tags != "" {
+		if err := json.Unmarshal([]byte(tags), &note.Tags); err != nil {
+			return fmt.Errorf("invalid tags of note %s: %w", note.Id, err)
+		}
+	}
+
+	note.Created, note.Updated = fromUnixNano(created), fromUnixNano(updated)
+
+	return nil
+}
+
+// encodeTags stores tags as a JSON list, since they can hold commas, and no
+// tags as an empty string.
+func encodeTags(tags []string) (string, error) {
+	if len(tags) == 0 {
+		return "", nil
+	}
+
+	encoded, err := json.Marshal(tags)
+
+	return string(encoded), err
+}
+
+// Note times are stored as Unix nanoseconds, and 0 for unset times.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+func fromUnixNano(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nanos).UTC()
+}
@@ -1,12 +1,16 @@
 package sql_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"regexp"
 	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/m-rcd/notes/pkg/database"
 	"github.com/m-rcd/notes/pkg/database/sql"
 	"github.com/m-rcd/notes/pkg/models"
 
@@ -16,6 +20,8 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 )
 
+var ctx = context.Background()
+
 var _ = Describe("Sql", func() {
 	var (
 		id       = "1"
@@ -23,167 +29,840 @@ var _ = Describe("Sql", func() {
 		content  = "Miawww"
 		username = "Casper"
 		archived = false
+		columns  = []string{"id", "name", "content", "archived", "username", "tags", "created_at", "updated_at"}
+		created  = time.Unix(1, 0).UTC()
+		updated  = time.Unix(2, 0).UTC()
 	)
 
+	// expectChange expects the change to the note to be recorded as the
+	// user's version.
+	expectChange := func(mock sqlmock.Sqlmock, username string, id string, version int64, deleted bool) {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO sync_sequences(username, seq) VALUES (?, 1) ON DUPLICATE KEY UPDATE seq = seq + 1")).WithArgs(username).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT seq FROM sync_sequences WHERE username=?")).WithArgs(username).WillReturnRows(sqlmock.NewRows([]string{"seq"}).AddRow(version))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO note_changes(username, note_id, seq, deleted) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE seq=VALUES(seq), deleted=VALUES(deleted)")).
+			WithArgs(username, id, version, deleted).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
 	Context("Create", func() {
 		It("creates a new note", func() {
-			s := sql.NewSQL("username", "password", "127.0.0.1", "3306")
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
 			db, mock, err := sqlmock.New()
 			Expect(err).NotTo(HaveOccurred())
 			s.Db = db
 			defer db.Close()
 
-			note := models.Note{Name: name, Content: content, Archived: archived, User: models.User{Username: username}}
+			note := models.Note{Name: name, Content: content, Archived: archived, User: models.User{Username: username}, Tags: []string{"ghosts, friendly"}}
 			bytes, err := json.Marshal(note)
 			Expect(err).NotTo(HaveOccurred())
 			reader := io.NopCloser(strings.NewReader(string(bytes)))
-			mock.ExpectExec("INSERT INTO notes").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO notes(name, content, username, archived, tags, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)")).
+				WithArgs(name, content, username, 0, `["ghosts, friendly"]`, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			expectChange(mock, username, "1", 4, false)
 			mock.ExpectCommit()
 
-			newNote, err := s.Create(reader)
+			before := time.Now()
+			newNote, err := s.Create(ctx, reader)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(newNote.Name).To(Equal(name))
+			Expect(newNote.Tags).To(Equal(note.Tags))
+			Expect(newNote.Created).To(BeTemporally(">=", before))
+			Expect(newNote.Updated).To(Equal(newNote.Created))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("refuses notes without a name or user", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			_, err = s.Create(ctx, io.NopCloser(strings.NewReader(`{"content":"Miawww","user":{"username":"Casper"}}`)))
+			Expect(err).To(MatchError("name must be set"))
+			Expect(err).To(MatchError(database.ErrInvalid))
+
+			_, err = s.Create(ctx, io.NopCloser(strings.NewReader(`{"name":"Note1"}`)))
+			Expect(err).To(MatchError("user must be set"))
+
+			_, err = s.Create(ctx, io.NopCloser(strings.NewReader(`{`)))
+			Expect(err).To(MatchError(database.ErrInvalid))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
 		})
 	})
 
-	Context("Update", func() {
-		It("updates a previously saved note", func() {
-			s := sql.NewSQL("username", "password", "127.0.0.1", "3306")
+	Context("Patch", func() {
+		It("applies the change to the stored note in a transaction", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
 			db, mock, err := sqlmock.New()
 			Expect(err).NotTo(HaveOccurred())
 			s.Db = db
 			defer db.Close()
-			existingNote := models.Note{Id: id, Name: name, Content: content, Archived: archived, User: models.User{Username: username}}
 
-			requestData := models.Note{Name: name, Content: "updated", Archived: archived, User: models.User{Username: username}}
-			bytes, err := json.Marshal(requestData)
+			rows := sqlmock.NewRows(columns).
+				AddRow(id, name, content, true, username, `["ghosts"]`, created.UnixNano(), updated.UnixNano())
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, content, archived, username, tags, created_at, updated_at FROM notes WHERE id=? AND username=? FOR UPDATE")).WithArgs(id, username).WillReturnRows(rows)
+			mock.ExpectExec(regexp.QuoteMeta("UPDATE notes SET name=?, content=?, archived=?, tags=?, updated_at=? WHERE id=?")).
+				WithArgs(name, "", 0, `["ghosts","cats"]`, sqlmock.AnyArg(), id).WillReturnResult(sqlmock.NewResult(1, 1))
+			expectChange(mock, username, id, 3, false)
+			mock.ExpectCommit()
+
+			note, err := s.Patch(ctx, id, username, func(note models.Note) (models.Note, error) {
+				Expect(note).To(Equal(models.Note{Id: id, Name: name, Content: content, Archived: true, User: models.User{Username: username},
+					Tags: []string{"ghosts"}, Created: created, Updated: updated}))
+				note.Content = ""
+				note.Archived = false
+				note.Tags = append(note.Tags, "cats")
+				note.Created = time.Time{}
+				return note, nil
+			})
 			Expect(err).NotTo(HaveOccurred())
-			reader := io.NopCloser(strings.NewReader(string(bytes)))
+			Expect(note.Content).To(BeEmpty())
+			Expect(note.Created).To(Equal(created))
+			Expect(note.Updated).To(BeTemporally(">", updated))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("rolls back when the change fails", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			rows := sqlmock.NewRows(columns).
+				AddRow(id, name, content, false, username, "", 0, 0)
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, name, content, archived, username, tags, created_at, updated_at FROM notes").WillReturnRows(rows)
+			mock.ExpectRollback()
+
+			_, err = s.Patch(ctx, id, username, func(note models.Note) (models.Note, error) {
+				return models.Note{}, errors.New("name must be set")
+			})
+			Expect(err).To(MatchError("name must be set"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("raises an error when the user has no such note", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, name, content, archived, username, tags, created_at, updated_at FROM notes").WithArgs(id, "Lyra").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectRollback()
+
+			_, err = s.Patch(ctx, id, "Lyra", func(note models.Note) (models.Note, error) { return note, nil })
+			Expect(err).To(MatchError("note does not exist"))
+		})
+	})
+
+	Context("SetArchived", func() {
+		It("archives the notes that exist in one transaction and reports on each", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			rows := sqlmock.NewRows(columns).
+				AddRow("1", name, content, false, username, "", created.UnixNano(), updated.UnixNano()).
+				AddRow("3", "Note3", "Boo", false, username, "", 0, 0)
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, content, archived, username, tags, created_at, updated_at FROM notes WHERE username=? AND id IN (?, ?, ?) FOR UPDATE")).WithArgs(username, "1", "2", "3").WillReturnRows(rows)
+			mock.ExpectExec(regexp.QuoteMeta("UPDATE notes SET archived=?, updated_at=? WHERE username=? AND id IN (?, ?)")).WithArgs(1, sqlmock.AnyArg(), username, "1", "3").WillReturnResult(sqlmock.NewResult(0, 2))
+			expectChange(mock, username, "1", 5, false)
+			expectChange(mock, username, "3", 6, false)
+			mock.ExpectCommit()
+
+			results, err := s.SetArchived(ctx, username, []string{"1", "2", "3"}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(3))
+			archivedNote := *results[0].Note
+			Expect(archivedNote.Updated).To(BeTemporally(">", updated))
+			archivedNote.Updated = updated
+			Expect(archivedNote).To(Equal(models.Note{Id: "1", Name: name, Content: content, Archived: true, User: models.User{Username: username}, Created: created, Updated: updated}))
+			Expect(results[1]).To(Equal(models.NoteResult{Id: "2", Error: "note does not exist"}))
+			Expect(results[2].Note.Archived).To(BeTrue())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("changes nothing when the update fails", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			rows := sqlmock.NewRows(columns).AddRow("1", name, content, true, username, "", 0, 0)
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, name, content, archived, username, tags, created_at, updated_at FROM notes").WillReturnRows(rows)
+			mock.ExpectExec("UPDATE notes").WillReturnError(errors.New("deadlock"))
+			mock.ExpectRollback()
+
+			_, err = s.SetArchived(ctx, username, []string{"1"}, false)
+			Expect(err).To(MatchError("deadlock"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
 
-			rows := sqlmock.NewRows([]string{"id", "name", "content", "archived"}).
-				AddRow(existingNote.Id, existingNote.Name, existingNote.Content, existingNote.Archived)
-			mock.ExpectQuery("SELECT id, name, content, archived FROM notes WHERE id=" + id).WillReturnRows(rows)
+	Context("Batch", func() {
+		It("runs every operation in one transaction", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
 
-			mock.ExpectExec("UPDATE notes").WithArgs(requestData.Name, requestData.Content, 0, existingNote.Id).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO notes").WithArgs("Note2", "Boo", username, 0, "", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(7, 1))
+			mock.ExpectQuery("SELECT id, name, content, archived, username, tags, created_at, updated_at FROM notes").WithArgs(id, username).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(id, name, content, false, username, "", 0, 0))
+			mock.ExpectExec("UPDATE notes").WithArgs(name, "updated", 0, "", sqlmock.AnyArg(), id).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("DELETE FROM notes").WithArgs("2", username).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectChange(mock, username, "7", 1, false)
+			expectChange(mock, username, id, 2, false)
+			expectChange(mock, username, "2", 3, true)
 			mock.ExpectCommit()
 
-			updatedNote, err := s.Update(existingNote.Id, reader)
+			results, err := s.Batch(ctx, username, []database.Operation{
+				{Kind: database.OperationCreate, Note: models.Note{Name: "Note2", Content: "Boo", User: models.User{Username: username}}},
+				{Kind: database.OperationUpdate, Id: id, Apply: func(note models.Note) (models.Note, error) {
+					note.Content = "updated"
+					return note, nil
+				}},
+				{Kind: database.OperationDelete, Id: "2"},
+			})
 			Expect(err).NotTo(HaveOccurred())
-			Expect(updatedNote.Content).To(Equal(requestData.Content))
+			Expect(results).To(HaveLen(3))
+			Expect(results[0].Id).To(Equal("7"))
+			Expect(results[1].Note.Content).To(Equal("updated"))
+			Expect(results[2]).To(Equal(models.NoteResult{Id: "2"}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("rolls back and names the operation that failed", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO notes").WillReturnResult(sqlmock.NewResult(7, 1))
+			mock.ExpectExec("DELETE FROM notes").WithArgs("2", username).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+
+			_, err = s.Batch(ctx, username, []database.Operation{
+				{Kind: database.OperationCreate, Note: models.Note{Name: "Note2", User: models.User{Username: username}}},
+				{Kind: database.OperationDelete, Id: "2"},
+			})
+			Expect(err).To(MatchError("operation 1 failed: note does not exist"))
+
+			var batchErr *database.BatchError
+			Expect(errors.As(err, &batchErr)).To(BeTrue())
+			Expect(batchErr.Index).To(Equal(1))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
 		})
 	})
 
 	Context("Delete", func() {
-		It("deletes a note", func() {
-			s := sql.NewSQL("username", "password", "127.0.0.1", "3306")
+		It("deletes a note, whether it is active or archived", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
 			db, mock, err := sqlmock.New()
 			Expect(err).NotTo(HaveOccurred())
 			s.Db = db
 			defer db.Close()
-			existingNote := models.Note{Id: id, Name: name, Content: content, Archived: archived, User: models.User{Username: username}}
-			mock.ExpectExec("DELETE FROM notes WHERE id = ?").WithArgs(existingNote.Id).WillReturnResult(sqlmock.NewResult(1, 1))
+			existingNote := models.Note{Id: id, Name: name, Content: content, Archived: true, User: models.User{Username: username}}
+			mock.ExpectBegin()
+			mock.ExpectExec("^"+regexp.QuoteMeta("DELETE FROM notes WHERE id = ? AND username = ?")+"$").WithArgs(existingNote.Id, username).WillReturnResult(sqlmock.NewResult(1, 1))
+			expectChange(mock, username, id, 8, true)
 			mock.ExpectCommit()
 
-			reader := io.NopCloser(strings.NewReader(""))
+			err = s.Delete(ctx, existingNote.Id, username)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("raises an error when the user has no such note", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM notes WHERE id = ? AND username = ?")).WithArgs(id, "Lyra").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+
+			Expect(s.Delete(ctx, id, "Lyra")).To(MatchError("note does not exist"))
+		})
+	})
+
+	Context("List active notes", func() {
+		It("lists active notes", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+			existingNote := models.Note{Id: id, Name: name, Content: content, Archived: archived, User: models.User{Username: username}, Tags: []string{"ghosts"}, Created: created, Updated: updated}
+
+			rows := sqlmock.NewRows(columns).
+				AddRow(existingNote.Id, existingNote.Name, existingNote.Content, existingNote.Archived, existingNote.User.Username, `["ghosts"]`, created.UnixNano(), updated.UnixNano())
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, content, archived, username, tags, created_at, updated_at FROM notes WHERE archived=0 AND username=?")).WithArgs(username).WillReturnRows(rows)
+
+			list, err := s.ListActiveNotes(ctx, username)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list[0]).To(Equal(existingNote))
+		})
+	})
+
+	Context("List archived notes", func() {
+		It("lists archived notes", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+			existingNote := models.Note{Id: id, Name: name, Content: content, Archived: true, User: models.User{Username: username}}
 
-			err = s.Delete(existingNote.Id, reader)
+			rows := sqlmock.NewRows(columns).
+				AddRow(existingNote.Id, existingNote.Name, existingNote.Content, existingNote.Archived, existingNote.User.Username, "", 0, 0)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, content, archived, username, tags, created_at, updated_at FROM notes WHERE archived=1 AND username=?")).WithArgs(username).WillReturnRows(rows)
+
+			list, err := s.ListArchivedNotes(ctx, username)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(list[0]).To(Equal(existingNote))
 		})
 	})
 
-	Context("Archive", func() {
-		It("archives a note", func() {
-			s := sql.NewSQL("username", "password", "127.0.0.1", "3306")
+	Context("Each note", func() {
+		It("calls fn with each note until it fails", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
 			db, mock, err := sqlmock.New()
 			Expect(err).NotTo(HaveOccurred())
 			s.Db = db
 			defer db.Close()
-			existingNote := models.Note{Id: id, Name: name, Content: content, Archived: archived, User: models.User{Username: username}}
 
-			requestData := models.Note{Archived: true, User: models.User{Username: username}}
-			bytes, err := json.Marshal(requestData)
+			rows := sqlmock.NewRows(columns).
+				AddRow("1", name, content, false, username, "", 0, 0).
+				AddRow("2", name, content, true, username, "", 0, 0).
+				AddRow("3", name, content, true, username, "", 0, 0)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, content, archived, username, tags, created_at, updated_at FROM notes WHERE username=? ORDER BY archived, id")).WithArgs(username).WillReturnRows(rows)
+
+			var ids []string
+			err = s.EachNote(ctx, username, func(note models.Note) error {
+				ids = append(ids, note.Id)
+				if note.Archived {
+					return errors.New("enough")
+				}
+
+				return nil
+			})
+			Expect(err).To(MatchError("enough"))
+			Expect(ids).To(Equal([]string{"1", "2"}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Context("List users", func() {
+		It("lists the users who have notes", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
 			Expect(err).NotTo(HaveOccurred())
-			reader := io.NopCloser(strings.NewReader(string(bytes)))
+			s.Db = db
+			defer db.Close()
+
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT username FROM notes ORDER BY username")).
+				WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("Casper").AddRow("Wendy"))
+
+			users, err := s.ListUsers(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(users).To(Equal([]string{"Casper", "Wendy"}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Context("Put note", func() {
+		var (
+			s    *sql.SQL
+			mock sqlmock.Sqlmock
+			note models.Note
+		)
+
+		owner := regexp.QuoteMeta("SELECT username FROM notes WHERE id=? FOR UPDATE")
+		insert := regexp.QuoteMeta("INSERT INTO notes(name, content, username, archived, tags, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)")
+
+		BeforeEach(func() {
+			s = sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, m, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			mock = m
+			note = models.Note{Id: "7", Name: name, Content: content, Archived: true, User: models.User{Username: username}, Tags: []string{"ghosts"}, Created: created, Updated: updated}
+		})
+
+		AfterEach(func() {
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			s.Db.Close()
+		})
+
+		It("inserts the note with its id when it is free", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(owner).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"username"}))
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO notes(id, name, content, username, archived, tags, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")).
+				WithArgs(7, name, content, username, 1, `["ghosts"]`, created.UnixNano(), updated.UnixNano()).WillReturnResult(sqlmock.NewResult(7, 1))
+			expectChange(mock, username, "7", 1, false)
+			mock.ExpectCommit()
+
+			stored, created, err := s.PutNote(ctx, note)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeTrue())
+			Expect(stored).To(Equal(note))
+		})
 
-			rows := sqlmock.NewRows([]string{"id", "name", "content", "archived"}).
-				AddRow(existingNote.Id, existingNote.Name, existingNote.Content, existingNote.Archived)
-			mock.ExpectQuery("SELECT id, name, content, archived FROM notes WHERE id=1").WillReturnRows(rows)
+		It("replaces the user's note with the id", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(owner).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow(username))
+			mock.ExpectExec(regexp.QuoteMeta("UPDATE notes SET name=?, content=?, archived=?, tags=?, created_at=?, updated_at=? WHERE id=?")).
+				WithArgs(name, content, 1, `["ghosts"]`, created.UnixNano(), updated.UnixNano(), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectChange(mock, username, "7", 2, false)
+			mock.ExpectCommit()
 
-			mock.ExpectExec("UPDATE notes").WithArgs(1, existingNote.Id).WillReturnResult(sqlmock.NewResult(1, 1))
+			stored, created, err := s.PutNote(ctx, note)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeFalse())
+			Expect(stored.Id).To(Equal("7"))
+		})
+
+		It("gives a new id when the id is another user's", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(owner).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("Wendy"))
+			mock.ExpectExec(insert).WithArgs(name, content, username, 1, `["ghosts"]`, created.UnixNano(), updated.UnixNano()).WillReturnResult(sqlmock.NewResult(12, 1))
+			expectChange(mock, username, "12", 1, false)
 			mock.ExpectCommit()
 
-			updatedNote, err := s.Update(existingNote.Id, reader)
+			stored, created, err := s.PutNote(ctx, note)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(updatedNote.Archived).To(Equal(requestData.Archived))
+			Expect(created).To(BeTrue())
+			Expect(stored.Id).To(Equal("12"))
+		})
+
+		It("gives a new id when the id is not a number", func() {
+			note.Id = "5f1c-uuid"
+			note.Created, note.Updated = time.Time{}, time.Time{}
+			mock.ExpectBegin()
+			mock.ExpectExec(insert).WithArgs(name, content, username, 1, `["ghosts"]`, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(13, 1))
+			expectChange(mock, username, "13", 1, false)
+			mock.ExpectCommit()
+
+			before := time.Now()
+			stored, _, err := s.PutNote(ctx, note)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored.Id).To(Equal("13"))
+			Expect(stored.Created).To(BeTemporally(">=", before))
+			Expect(stored.Updated).To(Equal(stored.Created))
+		})
+
+		It("rejects notes without a name", func() {
+			note.Name = ""
+			_, _, err := s.PutNote(ctx, note)
+			Expect(err).To(MatchError("name must be set"))
 		})
 	})
 
-	Context("Unarchive", func() {
-		It("unarchives a note", func() {
-			s := sql.NewSQL("username", "password", "127.0.0.1", "3306")
+	Context("Snapshot", func() {
+		It("reads the notes and webhooks in one read-only transaction", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
 			db, mock, err := sqlmock.New()
 			Expect(err).NotTo(HaveOccurred())
 			s.Db = db
 			defer db.Close()
-			existingNote := models.Note{Id: id, Name: name, Content: content, Archived: true, User: models.User{Username: username}}
 
-			requestData := models.Note{Archived: false, User: models.User{Username: username}}
-			bytes, err := json.Marshal(requestData)
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT username FROM notes ORDER BY username")).
+				WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow(username))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, content, archived, username, tags, created_at, updated_at FROM notes WHERE username=? ORDER BY archived, id")).WithArgs(username).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow("1", name, content, false, username, "", 0, 0))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, username, url, events, secret FROM webhooks ORDER BY id")).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "username", "url", "events", "secret"}).AddRow("4", username, "https://example.com", "note.created,note.deleted", "shh"))
+			mock.ExpectCommit()
+
+			var (
+				users    []string
+				notes    []models.Note
+				webhooks []models.Webhook
+			)
+			err = s.Snapshot(ctx, func(snapshot database.Snapshot) error {
+				if users, err = snapshot.ListUsers(ctx); err != nil {
+					return err
+				}
+
+				if err := snapshot.EachNote(ctx, username, func(note models.Note) error {
+					notes = append(notes, note)
+					return nil
+				}); err != nil {
+					return err
+				}
+
+				return snapshot.EachWebhook(ctx, func(webhook models.Webhook) error {
+					webhooks = append(webhooks, webhook)
+					return nil
+				})
+			})
 			Expect(err).NotTo(HaveOccurred())
-			reader := io.NopCloser(strings.NewReader(string(bytes)))
+			Expect(users).To(Equal([]string{username}))
+			Expect(notes).To(Equal([]models.Note{{Id: "1", Name: name, Content: content, User: models.User{Username: username}}}))
+			Expect(webhooks).To(Equal([]models.Webhook{{Id: "4", User: models.User{Username: username}, URL: "https://example.com", Events: []string{"note.created", "note.deleted"}, Secret: "shh"}}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("rolls back when fn fails", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			Expect(s.Snapshot(ctx, func(database.Snapshot) error {
+				return errors.New("enough")
+			})).To(MatchError("enough"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Context("Ping", func() {
+		It("pings the database", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+			Expect(s.Ping(ctx)).To(MatchError("connection refused"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Context("when the context is cancelled", func() {
+		It("cancels the query", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			rows := sqlmock.NewRows(columns)
+			mock.ExpectQuery("FROM notes WHERE archived=0").WillDelayFor(time.Second).WillReturnRows(rows)
+
+			timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+
+			_, err = s.ListActiveNotes(timeout, username)
+			Expect(err).To(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Context("Idempotency keys", func() {
+		var (
+			now    = time.Unix(1000, 0)
+			record = database.IdempotencyRecord{Key: "abc", Fingerprint: "first", ExpiresAt: time.Unix(1060, 0)}
+		)
+
+		It("claims a key that is not taken", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
 
-			rows := sqlmock.NewRows([]string{"id", "name", "content", "archived"}).
-				AddRow(existingNote.Id, existingNote.Name, existingNote.Content, existingNote.Archived)
-			mock.ExpectQuery("SELECT id, name, content, archived FROM notes WHERE id=" + id).WillReturnRows(rows)
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE expires_at <= ?")).WithArgs(now.UnixNano()).WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec("INSERT IGNORE INTO idempotency_keys").WithArgs("abc", "first", 0, "", []byte{}, record.ExpiresAt.UnixNano()).WillReturnResult(sqlmock.NewResult(0, 1))
 
-			mock.ExpectExec("UPDATE notes").WithArgs(0, existingNote.Id).WillReturnResult(sqlmock.NewResult(1, 1))
+			existing, claimed, err := s.ClaimIdempotencyKey(ctx, record, now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claimed).To(BeTrue())
+			Expect(existing).To(Equal(record))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("returns the record holding the key", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("INSERT IGNORE INTO idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+			rows := sqlmock.NewRows([]string{"fingerprint", "status_code", "content_type", "body", "expires_at"}).
+				AddRow("first", 201, "application/json", []byte(`{}`), time.Unix(2000, 0).UnixNano())
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT fingerprint, status_code, content_type, body, expires_at FROM idempotency_keys WHERE idempotency_key=?")).WithArgs("abc").WillReturnRows(rows)
+
+			existing, claimed, err := s.ClaimIdempotencyKey(ctx, record, now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claimed).To(BeFalse())
+			Expect(existing).To(Equal(database.IdempotencyRecord{Key: "abc", Fingerprint: "first", StatusCode: 201, ContentType: "application/json", Body: []byte(`{}`), ExpiresAt: time.Unix(2000, 0)}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("saves and releases keys", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			saved := record
+			saved.StatusCode = 201
+			saved.Body = []byte(`{}`)
+			mock.ExpectExec(regexp.QuoteMeta("UPDATE idempotency_keys SET fingerprint=?, status_code=?, content_type=?, body=?, expires_at=? WHERE idempotency_key=?")).
+				WithArgs("first", 201, "", []byte(`{}`), record.ExpiresAt.UnixNano(), "abc").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE idempotency_key=?")).WithArgs("abc").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			Expect(s.SaveIdempotencyKey(ctx, saved)).To(Succeed())
+			Expect(s.ReleaseIdempotencyKey(ctx, "abc")).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Context("Webhooks", func() {
+		webhook := models.Webhook{User: models.User{Username: "Lyra"}, URL: "https://example.com/hooks", Events: []string{"note.created", "note.deleted"}, Secret: "dust"}
+
+		It("creates and lists webhooks", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO webhooks(username, url, events, secret) VALUES (?, ?, ?, ?)")).
+				WithArgs("Lyra", webhook.URL, "note.created,note.deleted", "dust").WillReturnResult(sqlmock.NewResult(3, 1))
+			rows := sqlmock.NewRows([]string{"id", "username", "url", "events", "secret"}).
+				AddRow(3, "Lyra", webhook.URL, "note.created,note.deleted", "dust")
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, username, url, events, secret FROM webhooks WHERE username=?")).WithArgs("Lyra").WillReturnRows(rows)
+
+			created, err := s.CreateWebhook(ctx, webhook)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created.Id).To(Equal("3"))
+
+			webhooks, err := s.ListWebhooks(ctx, "Lyra")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhooks).To(Equal([]models.Webhook{created}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("deletes a webhook with its deliveries", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM webhooks WHERE id = ? AND username = ?")).WithArgs("3", "Lyra").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM webhook_deliveries WHERE webhook_id = ?")).WithArgs("3").WillReturnResult(sqlmock.NewResult(0, 4))
 			mock.ExpectCommit()
+			mock.ExpectBegin()
+			mock.ExpectExec("DELETE FROM webhooks").WithArgs("3", "Will").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
 
-			updatedNote, err := s.Update(existingNote.Id, reader)
+			Expect(s.DeleteWebhook(ctx, "3", "Lyra")).To(Succeed())
+			Expect(s.DeleteWebhook(ctx, "3", "Will")).To(MatchError("webhook does not exist"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("saves deliveries and trims the log", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
 			Expect(err).NotTo(HaveOccurred())
-			Expect(updatedNote.Archived).To(Equal(requestData.Archived))
+			s.Db = db
+			defer db.Close()
+
+			delivery := models.Delivery{Id: "a", WebhookId: "3", EventId: "e-1", EventType: "note.created", Attempt: 2, StatusCode: 500, Error: "receiver answered 500 Internal Server Error", Time: time.Unix(1000, 0).UTC()}
+			mock.ExpectExec("INSERT INTO webhook_deliveries").
+				WithArgs("a", "3", "e-1", "note.created", 2, 500, delivery.Error, false, "", time.Unix(1000, 0).UnixNano()).WillReturnResult(sqlmock.NewResult(9, 1))
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM webhook_deliveries WHERE webhook_id = ? AND dead = 0 AND seq <= (SELECT seq FROM (SELECT seq FROM webhook_deliveries WHERE webhook_id = ? AND dead = 0 ORDER BY seq DESC LIMIT 1 OFFSET ?) AS oldest)")).
+				WithArgs("3", "3", database.DeliveryLogSize).WillReturnResult(sqlmock.NewResult(0, 1))
+			rows := sqlmock.NewRows([]string{"id", "webhook_id", "event_id", "event_type", "attempt", "status_code", "error", "dead", "payload", "delivered_at"}).
+				AddRow("a", "3", "e-1", "note.created", 2, 500, delivery.Error, false, "", time.Unix(1000, 0).UnixNano())
+			mock.ExpectQuery(regexp.QuoteMeta("FROM webhook_deliveries WHERE webhook_id=? ORDER BY seq DESC")).WithArgs("3").WillReturnRows(rows)
+
+			Expect(s.SaveDelivery(ctx, delivery)).To(Succeed())
+			deliveries, err := s.ListDeliveries(ctx, "3", "Buffy")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deliveries).To(Equal([]models.Delivery{delivery}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
 		})
 	})
 
-	Context("List active notes", func() {
-		It("lists active notes", func() {
-			s := sql.NewSQL("username", "password", "127.0.0.1", "3306")
+	Context("Sync", func() {
+		current := regexp.QuoteMeta("SELECT n.id, n.name, n.content, n.archived, n.username, n.tags, n.created_at, n.updated_at, COALESCE(c.seq, 0) FROM notes n LEFT JOIN note_changes c ON c.username = n.username AND c.note_id = n.id WHERE n.id=? AND n.username=? FOR UPDATE")
+		noteColumns := []string{"id", "name", "content", "archived", "username", "tags", "created_at", "updated_at", "seq"}
+
+		It("lists the changes after a version with tombstones", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
 			db, mock, err := sqlmock.New()
 			Expect(err).NotTo(HaveOccurred())
 			s.Db = db
 			defer db.Close()
-			existingNote := models.Note{Id: id, Name: name, Content: content, Archived: archived, User: models.User{Username: username}}
 
-			reader := io.NopCloser(strings.NewReader(""))
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT seq FROM sync_sequences WHERE username=?")).WithArgs(username).WillReturnRows(sqlmock.NewRows([]string{"seq"}).AddRow(9))
+			rows := sqlmock.NewRows([]string{"note_id", "name", "content", "archived", "username", "tags", "created_at", "updated_at", "seq", "deleted"}).
+				AddRow("1", name, content, false, username, `["ghosts"]`, created.UnixNano(), updated.UnixNano(), 7, false).
+				AddRow("2", nil, nil, nil, nil, nil, nil, nil, 9, true)
+			mock.ExpectQuery(regexp.QuoteMeta("FROM note_changes c LEFT JOIN notes n ON n.id = c.note_id AND c.deleted = 0 WHERE c.username=? AND c.seq > ? ORDER BY c.seq")).WithArgs(username, 5).WillReturnRows(rows)
+			mock.ExpectCommit()
+
+			changes, latest, err := s.Changes(ctx, username, 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(latest).To(Equal(uint64(9)))
+			Expect(changes).To(Equal([]models.Change{
+				{Id: "1", Version: 7, Note: &models.Note{Id: "1", Name: name, Content: content, User: models.User{Username: username}, Tags: []string{"ghosts"}, Created: created, Updated: updated}},
+				{Id: "2", Version: 9, Deleted: true},
+			}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("lists every note when there is no version", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
 
-			rows := sqlmock.NewRows([]string{"id", "name", "content", "archived", "username"}).
-				AddRow(existingNote.Id, existingNote.Name, existingNote.Content, existingNote.Archived, existingNote.User.Username)
-			mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM notes WHERE archived=0")).WillReturnRows(rows)
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT seq FROM sync_sequences").WithArgs(username).WillReturnRows(sqlmock.NewRows([]string{"seq"}))
+			rows := sqlmock.NewRows([]string{"id", "name", "content", "archived", "username", "tags", "created_at", "updated_at", "seq", "deleted"}).
+				AddRow("1", name, content, true, username, "", 0, 0, 0, false)
+			mock.ExpectQuery(regexp.QuoteMeta("FROM notes n LEFT JOIN note_changes c ON c.username = n.username AND c.note_id = n.id WHERE n.username=? ORDER BY 9, n.id")).WithArgs(username).WillReturnRows(rows)
+			mock.ExpectCommit()
 
-			list, err := s.ListActiveNotes(reader)
+			changes, latest, err := s.Changes(ctx, username, 0)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(list[0]).To(Equal(existingNote))
+			Expect(latest).To(BeZero())
+			Expect(changes).To(Equal([]models.Change{
+				{Id: "1", Note: &models.Note{Id: "1", Name: name, Content: content, Archived: true, User: models.User{Username: username}}},
+			}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("applies a change made on top of the current version", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(current).WithArgs(id, username).WillReturnRows(sqlmock.NewRows(noteColumns).AddRow(id, name, content, false, username, "", created.UnixNano(), created.UnixNano(), 4))
+			mock.ExpectQuery("SELECT id, name, content, archived, username, tags, created_at, updated_at FROM notes").WithArgs(id, username).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(id, name, content, false, username, "", created.UnixNano(), created.UnixNano()))
+			mock.ExpectExec("UPDATE notes").WithArgs(name, "offline", 1, "", sqlmock.AnyArg(), id).WillReturnResult(sqlmock.NewResult(0, 1))
+			expectChange(mock, username, id, 10, false)
+			mock.ExpectQuery(current).WithArgs(id, username).WillReturnRows(sqlmock.NewRows(noteColumns).AddRow(id, name, "offline", true, username, "", created.UnixNano(), updated.UnixNano(), 10))
+			mock.ExpectCommit()
+
+			note := models.Note{Name: name, Content: "offline", Archived: true, User: models.User{Username: username}}
+			results, err := s.ApplyChanges(ctx, username, []models.Change{{Id: id, Version: 4, Note: &note}})
+			Expect(err).NotTo(HaveOccurred())
+
+			note.Id, note.Created, note.Updated = id, created, updated
+			Expect(results).To(Equal([]models.ChangeResult{{Change: models.Change{Id: id, Version: 10, Note: &note}}}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("reports a conflict when the note changed since the client's version", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(current).WithArgs("2", username).WillReturnRows(sqlmock.NewRows(noteColumns))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT seq FROM note_changes WHERE username=? AND note_id=? AND deleted=1")).WithArgs(username, "2").WillReturnRows(sqlmock.NewRows([]string{"seq"}).AddRow(8))
+			mock.ExpectCommit()
+			mock.ExpectBegin()
+			mock.ExpectQuery(current).WithArgs("3", username).WillReturnRows(sqlmock.NewRows(noteColumns))
+			mock.ExpectQuery("SELECT seq FROM note_changes").WithArgs(username, "3").WillReturnRows(sqlmock.NewRows([]string{"seq"}))
+			mock.ExpectRollback()
+
+			note := models.Note{Name: name, User: models.User{Username: username}}
+			results, err := s.ApplyChanges(ctx, username, []models.Change{{Id: "2", Version: 6, Note: &note}, {Id: "3", Deleted: true}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(Equal([]models.ChangeResult{
+				{Change: models.Change{Id: "2", Version: 8, Deleted: true}, Conflict: true},
+				{Change: models.Change{Id: "3"}, Error: "note does not exist"},
+			}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
 		})
 	})
 
-	Context("List archived notes", func() {
-		It("lists archived notes", func() {
-			s := sql.NewSQL("username", "password", "127.0.0.1", "3306")
+	Context("Data keys", func() {
+		It("lists the keys of a user oldest first", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
 			db, mock, err := sqlmock.New()
 			Expect(err).NotTo(HaveOccurred())
 			s.Db = db
 			defer db.Close()
-			existingNote := models.Note{Id: id, Name: name, Content: content, Archived: true, User: models.User{Username: username}}
 
-			reader := io.NopCloser(strings.NewReader(""))
+			createdAt := time.Unix(0, 1700000000000000000)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, master_key, wrapped_key, created_at FROM data_keys WHERE username=? ORDER BY created_at, id")).WithArgs(username).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "master_key", "wrapped_key", "created_at"}).AddRow("a1", "m1", []byte("wrapped"), createdAt.UnixNano()))
 
-			rows := sqlmock.NewRows([]string{"id", "name", "content", "archived", "username"}).
-				AddRow(existingNote.Id, existingNote.Name, existingNote.Content, existingNote.Archived, existingNote.User.Username)
-			mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM notes WHERE archived=1")).WillReturnRows(rows)
+			keys, err := s.DataKeys(ctx, username)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(Equal([]database.DataKey{{Username: username, Id: "a1", MasterKey: "m1", Wrapped: []byte("wrapped"), CreatedAt: createdAt}}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
 
-			list, err := s.ListArchivedNotes(reader)
+		It("replaces the wrapping of a key saved again", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
 			Expect(err).NotTo(HaveOccurred())
-			Expect(list[0]).To(Equal(existingNote))
+			s.Db = db
+			defer db.Close()
+
+			key := database.DataKey{Username: username, Id: "a1", MasterKey: "m2", Wrapped: []byte("rewrapped"), CreatedAt: time.Unix(0, 42)}
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO data_keys(username, id, master_key, wrapped_key, created_at) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE master_key=VALUES(master_key), wrapped_key=VALUES(wrapped_key)")).
+				WithArgs(username, "a1", "m2", []byte("rewrapped"), int64(42)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			Expect(s.SaveDataKey(ctx, key)).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Context("Count notes", func() {
+		It("counts active and archived notes", func() {
+			s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+			db, mock, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			s.Db = db
+			defer db.Close()
+
+			rows := sqlmock.NewRows([]string{"active", "archived"}).AddRow(3, 2)
+			mock.ExpectQuery("SELECT COALESCE").WillReturnRows(rows)
+
+			active, archived, err := s.CountNotes(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(active).To(Equal(3))
+			Expect(archived).To(Equal(2))
 		})
 	})
 })
@@ -0,0 +1,203 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/patch"
+)
+
+// recordChange gives the change to the note the next version in the user's
+// sequence. The row of the sequence stays locked until tx ends, so versions
+// are committed in order and readers never skip one.
+func (s *SQL) recordChange(ctx context.Context, tx *sql.Tx, username string, id string, deleted bool) error {
+	if _, err := s.execOn(ctx, tx, "INSERT INTO sync_sequences(username, seq) VALUES (?, 1) ON DUPLICATE KEY UPDATE seq = seq + 1", username); err != nil {
+		return err
+	}
+
+	var version uint64
+	if err := s.queryRowOn(ctx, tx, "SELECT seq FROM sync_sequences WHERE username=?", username).Scan(&version); err != nil {
+		return err
+	}
+
+	_, err := s.execOn(ctx, tx, "INSERT INTO note_changes(username, note_id, seq, deleted) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE seq=VALUES(seq), deleted=VALUES(deleted)", username, id, version, deleted)
+
+	return err
+}
+
+// Changes reads the sequence and the changes in one transaction, so that
+// they are from the same snapshot.
+func (s *SQL) Changes(ctx context.Context, username string, since uint64) ([]models.Change, uint64, error) {
+	var (
+		changes []models.Change
+		latest  uint64
+	)
+
+	err := s.transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		err := s.queryRowOn(ctx, tx, "SELECT seq FROM sync_sequences WHERE username=?", username).Scan(&latest)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		var rows *sql.Rows
+		if since == 0 {
+			rows, err = s.queryOn(ctx, tx, "SELECT n.id, n.name, n.content, n.archived, n.username, n.tags, n.created_at, n.updated_at, COALESCE(c.seq, 0), 0 FROM notes n LEFT JOIN note_changes c ON c.username = n.username AND c.note_id = n.id WHERE n.username=? ORDER BY 9, n.id", username)
+		} else {
+			rows, err = s.queryOn(ctx, tx, "SELECT c.note_id, n.name, n.content, n.archived, n.username, n.tags, n.created_at, n.updated_at, c.seq, c.deleted FROM note_changes c LEFT JOIN notes n ON n.id = c.note_id AND c.deleted = 0 WHERE c.username=? AND c.seq > ? ORDER BY c.seq", username, since)
+		}
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		changes, err = listChanges(rows)
+
+		return err
+	})
+	if err != nil {
+		return []models.Change{}, 0, err
+	}
+
+	return changes, latest, nil
+}
+
+// ApplyChanges applies each change in its own transaction, so that one
+// failing leaves the others applied.
+func (s *SQL) ApplyChanges(ctx context.Context, username string, changes []models.Change) ([]models.ChangeResult, error) {
+	results := make([]models.ChangeResult, 0, len(changes))
+	for _, change := range changes {
+		var result models.ChangeResult
+
+		err := s.transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+			var err error
+			result, err = s.applyIn(ctx, tx, username, change)
+
+			return err
+		})
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+
+			result = models.ChangeResult{Change: models.Change{Id: change.Id}, Error: err.Error()}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (s *SQL) applyIn(ctx context.Context, tx *sql.Tx, username string, change models.Change) (models.ChangeResult, error) {
+	id := change.Id
+
+	if id == "" {
+		note, err := s.createIn(ctx, tx, *change.Note)
+		if err != nil {
+			return models.ChangeResult{}, err
+		}
+		id = note.Id
+	} else {
+		current, err := s.currentIn(ctx, tx, username, id)
+		if err != nil {
+			return models.ChangeResult{}, err
+		}
+
+		result, done, err := database.CheckChange(current, change)
+		if done {
+			return result, err
+		}
+
+		if change.Deleted {
+			err = s.deleteIn(ctx, tx, id, username)
+		} else {
+			_, err = s.patchIn(ctx, tx, id, username, patch.Replace(*change.Note))
+		}
+		if err != nil {
+			return models.ChangeResult{}, err
+		}
+	}
+
+	if err := s.recordChange(ctx, tx, username, id, change.Deleted); err != nil {
+		return models.ChangeResult{}, err
+	}
+
+	current, err := s.currentIn(ctx, tx, username, id)
+	if err != nil {
+		return models.ChangeResult{}, err
+	}
+
+	return models.ChangeResult{Change: current}, nil
+}
+
+// currentIn reads the note with the version of its latest change, or its
+// tombstone, locking the note until tx ends.
+func (s *SQL) currentIn(ctx context.Context, tx *sql.Tx, username string, id string) (models.Change, error) {
+	var (
+		note             models.Note
+		version          uint64
+		tags             string
+		created, updated int64
+	)
+
+	result := s.queryRowOn(ctx, tx, "SELECT n.id, n.name, n.content, n.archived, n.username, n.tags, n.created_at, n.updated_at, COALESCE(c.seq, 0) FROM notes n LEFT JOIN note_changes c ON c.username = n.username AND c.note_id = n.id WHERE n.id=? AND n.username=? FOR UPDATE", id, username)
+	err := result.Scan(&note.Id, &note.Name, &note.Content, &note.Archived, &note.User.Username, &tags, &created, &updated, &version)
+	if err == nil {
+		if err := details(&note, tags, created, updated); err != nil {
+			return models.Change{}, err
+		}
+
+		return models.Change{Id: note.Id, Version: version, Note: &note}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return models.Change{}, err
+	}
+
+	err = s.queryRowOn(ctx, tx, "SELECT seq FROM note_changes WHERE username=? AND note_id=? AND deleted=1", username, id).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Change{}, database.ErrNoteNotFound
+	}
+	if err != nil {
+		return models.Change{}, err
+	}
+
+	return models.Change{Id: id, Version: version, Deleted: true}, nil
+}
+
+func listChanges(rows *sql.Rows) ([]models.Change, error) {
+	changes := []models.Change{}
+
+	for rows.Next() {
+		var (
+			change                        models.Change
+			name, content, username, tags sql.NullString
+			archived                      sql.NullBool
+			created, updated              sql.NullInt64
+		)
+
+		if err := rows.Scan(&change.Id, &name, &content, &archived, &username, &tags, &created, &updated, &change.Version, &change.Deleted); err != nil {
+			return []models.Change{}, err
+		}
+
+		if !change.Deleted {
+			change.Note = &models.Note{
+				Id:       change.Id,
+				Name:     name.String,
+				Content:  content.String,
+				Archived: archived.Bool,
+				User:     models.User{Username: username.String},
+			}
+
+			if err := details(change.Note, tags.String, created.Int64, updated.Int64); err != nil {
+				return []models.Change{}, err
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
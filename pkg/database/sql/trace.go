@@ -0,0 +1,124 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/m-rcd/notes/pkg/database"
+)
+
+var tracer = otel.Tracer("github.com/m-rcd/notes/pkg/database/sql")
+
+// conn is satisfied by both *sql.DB and *sql.Tx.
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// transaction runs fn in a transaction, which is committed if fn succeeds and
+// rolled back otherwise.
+func (s *SQL) transaction(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, span := tracer.Start(ctx, "sql.transaction", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+
+	if err := fn(ctx, tx); err != nil {
+		tx.Rollback()
+		recordError(span, err)
+		return err
+	}
+
+	err = tx.Commit()
+	recordError(span, err)
+
+	return err
+}
+
+func (s *SQL) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.execOn(ctx, s.Db, query, args...)
+}
+
+func (s *SQL) execOn(ctx context.Context, c conn, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := s.startSpan(ctx, "sql.exec", query)
+	defer span.End()
+
+	result, err := c.ExecContext(ctx, query, args...)
+	recordError(span, err)
+
+	return result, err
+}
+
+func (s *SQL) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.queryOn(ctx, s.Db, query, args...)
+}
+
+func (s *SQL) queryOn(ctx context.Context, c conn, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := s.startSpan(ctx, "sql.query", query)
+	defer span.End()
+
+	rows, err := c.QueryContext(ctx, query, args...)
+	recordError(span, err)
+
+	return rows, err
+}
+
+func (s *SQL) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.queryRowOn(ctx, s.Db, query, args...)
+}
+
+func (s *SQL) queryRowOn(ctx context.Context, c conn, query string, args ...interface{}) *sql.Row {
+	ctx, span := s.startSpan(ctx, "sql.query", query)
+	defer span.End()
+
+	row := c.QueryRowContext(ctx, query, args...)
+	recordError(span, row.Err())
+
+	return row
+}
+
+func (s *SQL) startSpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemMySQL,
+		semconv.DBNameKey.String(s.name),
+		semconv.DBStatementKey.String(query),
+	))
+}
+
+func decodeBody(ctx context.Context, body io.ReadCloser, v interface{}) error {
+	_, span := tracer.Start(ctx, "decode body")
+	defer span.End()
+
+	reqBody, err := ioutil.ReadAll(body)
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+
+	if err = json.Unmarshal(reqBody, v); err != nil {
+		recordError(span, err)
+		return database.Invalid(err)
+	}
+
+	return nil
+}
+
+func recordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
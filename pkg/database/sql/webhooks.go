@@ -0,0 +1,112 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// CreateWebhook stores the events of the webhook as a comma separated list.
+func (s *SQL) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	result, err := s.exec(ctx, "INSERT INTO webhooks(username, url, events, secret) VALUES (?, ?, ?, ?)",
+		webhook.User.Username, webhook.URL, strings.Join(webhook.Events, ","), webhook.Secret)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	webhook.Id = strconv.FormatInt(id, 10)
+
+	return webhook, nil
+}
+
+func (s *SQL) ListWebhooks(ctx context.Context, username string) ([]models.Webhook, error) {
+	rows, err := s.query(ctx, "SELECT id, username, url, events, secret FROM webhooks WHERE username=?", username)
+	if err != nil {
+		return []models.Webhook{}, err
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		var webhook models.Webhook
+		var events string
+		if err := rows.Scan(&webhook.Id, &webhook.User.Username, &webhook.URL, &events, &webhook.Secret); err != nil {
+			return []models.Webhook{}, err
+		}
+		webhook.Events = strings.Split(events, ",")
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes the webhook and its deliveries in one transaction.
+func (s *SQL) DeleteWebhook(ctx context.Context, id string, username string) error {
+	return s.transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := s.execOn(ctx, tx, "DELETE FROM webhooks WHERE id = ? AND username = ?", id, username)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if deleted == 0 {
+			return database.ErrWebhookNotFound
+		}
+
+		_, err = s.execOn(ctx, tx, "DELETE FROM webhook_deliveries WHERE webhook_id = ?", id)
+
+		return err
+	})
+}
+
+// SaveDelivery adds the delivery, then drops the attempts that fell off the
+// end of the log. Times are stored as Unix nanoseconds.
+func (s *SQL) SaveDelivery(ctx context.Context, delivery models.Delivery) error {
+	_, err := s.exec(ctx, "INSERT INTO webhook_deliveries(id, webhook_id, event_id, event_type, attempt, status_code, error, dead, payload, delivered_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		delivery.Id, delivery.WebhookId, delivery.EventId, delivery.EventType, delivery.Attempt, delivery.StatusCode, delivery.Error, delivery.Dead, delivery.Payload, delivery.Time.UnixNano())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.exec(ctx, "DELETE FROM webhook_deliveries WHERE webhook_id = ? AND dead = 0 AND seq <= (SELECT seq FROM (SELECT seq FROM webhook_deliveries WHERE webhook_id = ? AND dead = 0 ORDER BY seq DESC LIMIT 1 OFFSET ?) AS oldest)",
+		delivery.WebhookId, delivery.WebhookId, database.DeliveryLogSize)
+
+	return err
+}
+
+func (s *SQL) ListDeliveries(ctx context.Context, webhookID string, username string) ([]models.Delivery, error) {
+	rows, err := s.query(ctx, "SELECT id, webhook_id, event_id, event_type, attempt, status_code, error, dead, payload, delivered_at FROM webhook_deliveries WHERE webhook_id=? ORDER BY seq DESC", webhookID)
+	if err != nil {
+		return []models.Delivery{}, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.Delivery{}
+	for rows.Next() {
+		var delivery models.Delivery
+		var deliveredAt int64
+		if err := rows.Scan(&delivery.Id, &delivery.WebhookId, &delivery.EventId, &delivery.EventType, &delivery.Attempt, &delivery.StatusCode, &delivery.Error, &delivery.Dead, &delivery.Payload, &deliveredAt); err != nil {
+			return []models.Delivery{}, err
+		}
+		delivery.Time = time.Unix(0, deliveredAt).UTC()
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
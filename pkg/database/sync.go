@@ -0,0 +1,25 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// CheckChange compares a change uploaded by a client with the note as it
+// currently is, and is done when there is nothing left to apply: the change
+// conflicts, deletes a note that is already deleted, or cannot be applied.
+func CheckChange(current models.Change, change models.Change) (result models.ChangeResult, done bool, err error) {
+	switch {
+	case current.Version != change.Version:
+		return models.ChangeResult{Change: current, Conflict: true}, true, nil
+	case current.Deleted && change.Deleted:
+		return models.ChangeResult{Change: current}, true, nil
+	case current.Deleted:
+		return models.ChangeResult{}, true, ErrNoteNotFound
+	case change.Deleted && current.Note != nil && current.Note.Archived:
+		return models.ChangeResult{}, true, errors.New("archived notes cannot be deleted")
+	}
+
+	return models.ChangeResult{}, false, nil
+}
@@ -0,0 +1,292 @@
+package database
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// Operations names the storage operations that can be given their own
+// timeout.
+var Operations = []string{
+	"ping",
+	"create",
+	"patch",
+	"set_archived",
+	"delete",
+	"batch",
+	"list_active_notes",
+	"list_archived_notes",
+	"each_note",
+	"count_notes",
+	"list_users",
+	"put_note",
+	"snapshot",
+	"claim_idempotency_key",
+	"save_idempotency_key",
+	"release_idempotency_key",
+	"create_webhook",
+	"list_webhooks",
+	"delete_webhook",
+	"save_delivery",
+	"list_deliveries",
+	"changes",
+	"apply_changes",
+	"data_keys",
+	"save_data_key",
+}
+
+// Timeouts bounds how long each storage operation may take. Operations not
+// listed use Default, except snapshot, which lasts as long as a backup takes
+// and has no deadline unless it is listed. A zero duration means no
+// deadline.
+type Timeouts struct {
+	Default    time.Duration
+	Operations map[string]time.Duration
+}
+
+type timeoutDatabase struct {
+	db       Database
+	timeouts Timeouts
+}
+
+// WithTimeouts wraps db so that every operation runs with a deadline, on top
+// of any the caller's context already has.
+func WithTimeouts(db Database, timeouts Timeouts) Database {
+	return &timeoutDatabase{db: db, timeouts: timeouts}
+}
+
+func (t *timeoutDatabase) context(ctx context.Context, operation string) (context.Context, context.CancelFunc) {
+	timeout := t.timeout(operation)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (t *timeoutDatabase) timeout(operation string) time.Duration {
+	timeout, ok := t.timeouts.Operations[operation]
+	if !ok {
+		timeout = t.timeouts.Default
+	}
+
+	return timeout
+}
+
+func (t *timeoutDatabase) Open() error {
+	return t.db.Open()
+}
+
+func (t *timeoutDatabase) Close() error {
+	return t.db.Close()
+}
+
+func (t *timeoutDatabase) Ping(ctx context.Context) error {
+	ctx, cancel := t.context(ctx, "ping")
+	defer cancel()
+
+	return t.db.Ping(ctx)
+}
+
+func (t *timeoutDatabase) Create(ctx context.Context, body io.ReadCloser) (models.Note, error) {
+	ctx, cancel := t.context(ctx, "create")
+	defer cancel()
+
+	return t.db.Create(ctx, body)
+}
+
+func (t *timeoutDatabase) Patch(ctx context.Context, id string, username string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+	ctx, cancel := t.context(ctx, "patch")
+	defer cancel()
+
+	return t.db.Patch(ctx, id, username, apply)
+}
+
+func (t *timeoutDatabase) SetArchived(ctx context.Context, username string, ids []string, archived bool) ([]models.NoteResult, error) {
+	ctx, cancel := t.context(ctx, "set_archived")
+	defer cancel()
+
+	return t.db.SetArchived(ctx, username, ids, archived)
+}
+
+func (t *timeoutDatabase) Delete(ctx context.Context, id string, username string) error {
+	ctx, cancel := t.context(ctx, "delete")
+	defer cancel()
+
+	return t.db.Delete(ctx, id, username)
+}
+
+func (t *timeoutDatabase) Batch(ctx context.Context, username string, operations []Operation) ([]models.NoteResult, error) {
+	ctx, cancel := t.context(ctx, "batch")
+	defer cancel()
+
+	return t.db.Batch(ctx, username, operations)
+}
+
+func (t *timeoutDatabase) ListActiveNotes(ctx context.Context, username string) ([]models.Note, error) {
+	ctx, cancel := t.context(ctx, "list_active_notes")
+	defer cancel()
+
+	return t.db.ListActiveNotes(ctx, username)
+}
+
+func (t *timeoutDatabase) ListArchivedNotes(ctx context.Context, username string) ([]models.Note, error) {
+	ctx, cancel := t.context(ctx, "list_archived_notes")
+	defer cancel()
+
+	return t.db.ListArchivedNotes(ctx, username)
+}
+
+// EachNote bounds the wait for each note rather than the whole iteration,
+// which lasts as long as fn takes with the notes, such as streaming them to
+// a client.
+func (t *timeoutDatabase) EachNote(ctx context.Context, username string, fn func(models.Note) error) error {
+	timeout := t.timeout("each_note")
+	if timeout <= 0 {
+		return t.db.EachNote(ctx, username, fn)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var expired int32
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&expired, 1)
+		cancel()
+	})
+	defer timer.Stop()
+
+	err := t.db.EachNote(ctx, username, func(note models.Note) error {
+		if !timer.Stop() {
+			return context.DeadlineExceeded
+		}
+		defer timer.Reset(timeout)
+
+		return fn(note)
+	})
+	if err != nil && atomic.LoadInt32(&expired) == 1 {
+		return context.DeadlineExceeded
+	}
+
+	return err
+}
+
+func (t *timeoutDatabase) CountNotes(ctx context.Context) (int, int, error) {
+	ctx, cancel := t.context(ctx, "count_notes")
+	defer cancel()
+
+	return t.db.CountNotes(ctx)
+}
+
+func (t *timeoutDatabase) ListUsers(ctx context.Context) ([]string, error) {
+	ctx, cancel := t.context(ctx, "list_users")
+	defer cancel()
+
+	return t.db.ListUsers(ctx)
+}
+
+func (t *timeoutDatabase) PutNote(ctx context.Context, note models.Note) (models.Note, bool, error) {
+	ctx, cancel := t.context(ctx, "put_note")
+	defer cancel()
+
+	return t.db.PutNote(ctx, note)
+}
+
+func (t *timeoutDatabase) Snapshot(ctx context.Context, fn func(Snapshot) error) error {
+	if _, ok := t.timeouts.Operations["snapshot"]; !ok {
+		return t.db.Snapshot(ctx, fn)
+	}
+
+	ctx, cancel := t.context(ctx, "snapshot")
+	defer cancel()
+
+	return t.db.Snapshot(ctx, fn)
+}
+
+func (t *timeoutDatabase) ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord, now time.Time) (IdempotencyRecord, bool, error) {
+	ctx, cancel := t.context(ctx, "claim_idempotency_key")
+	defer cancel()
+
+	return t.db.ClaimIdempotencyKey(ctx, record, now)
+}
+
+func (t *timeoutDatabase) SaveIdempotencyKey(ctx context.Context, record IdempotencyRecord) error {
+	ctx, cancel := t.context(ctx, "save_idempotency_key")
+	defer cancel()
+
+	return t.db.SaveIdempotencyKey(ctx, record)
+}
+
+func (t *timeoutDatabase) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	ctx, cancel := t.context(ctx, "release_idempotency_key")
+	defer cancel()
+
+	return t.db.ReleaseIdempotencyKey(ctx, key)
+}
+
+func (t *timeoutDatabase) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	ctx, cancel := t.context(ctx, "create_webhook")
+	defer cancel()
+
+	return t.db.CreateWebhook(ctx, webhook)
+}
+
+func (t *timeoutDatabase) ListWebhooks(ctx context.Context, username string) ([]models.Webhook, error) {
+	ctx, cancel := t.context(ctx, "list_webhooks")
+	defer cancel()
+
+	return t.db.ListWebhooks(ctx, username)
+}
+
+func (t *timeoutDatabase) DeleteWebhook(ctx context.Context, id string, username string) error {
+	ctx, cancel := t.context(ctx, "delete_webhook")
+	defer cancel()
+
+	return t.db.DeleteWebhook(ctx, id, username)
+}
+
+func (t *timeoutDatabase) SaveDelivery(ctx context.Context, delivery models.Delivery) error {
+	ctx, cancel := t.context(ctx, "save_delivery")
+	defer cancel()
+
+	return t.db.SaveDelivery(ctx, delivery)
+}
+
+func (t *timeoutDatabase) ListDeliveries(ctx context.Context, webhookID string, username string) ([]models.Delivery, error) {
+	ctx, cancel := t.context(ctx, "list_deliveries")
+	defer cancel()
+
+	return t.db.ListDeliveries(ctx, webhookID, username)
+}
+
+func (t *timeoutDatabase) Changes(ctx context.Context, username string, since uint64) ([]models.Change, uint64, error) {
+	ctx, cancel := t.context(ctx, "changes")
+	defer cancel()
+
+	return t.db.Changes(ctx, username, since)
+}
+
+func (t *timeoutDatabase) ApplyChanges(ctx context.Context, username string, changes []models.Change) ([]models.ChangeResult, error) {
+	ctx, cancel := t.context(ctx, "apply_changes")
+	defer cancel()
+
+	return t.db.ApplyChanges(ctx, username, changes)
+}
+
+func (t *timeoutDatabase) DataKeys(ctx context.Context, username string) ([]DataKey, error) {
+	ctx, cancel := t.context(ctx, "data_keys")
+	defer cancel()
+
+	return t.db.DataKeys(ctx, username)
+}
+
+func (t *timeoutDatabase) SaveDataKey(ctx context.Context, key DataKey) error {
+	ctx, cancel := t.context(ctx, "save_data_key")
+	defer cancel()
+
+	return t.db.SaveDataKey(ctx, key)
+}
@@ -0,0 +1,624 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// prefix starts encrypted content, which goes on with the id of the data key
+// and the base64 encoded nonce and ciphertext. Content without it is from
+// before encryption was turned on, and is read as it is.
+const prefix = "enc:v1:"
+
+type encryptingDatabase struct {
+	db   database.Database
+	keys *Keyring
+
+	mu    sync.Mutex
+	users map[string]*dataKeys
+}
+
+// dataKeys are the unwrapped data keys of a user, by id. The current one
+// encrypts their notes.
+type dataKeys struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// Encrypting wraps db so that the content of notes is stored encrypted with
+// a data key of their user, which db stores wrapped with a master key of
+// keys. Users get a data key the first time one of their notes is written.
+func Encrypting(db database.Database, keys *Keyring) database.Database {
+	return newEncrypting(db, keys)
+}
+
+func newEncrypting(db database.Database, keys *Keyring) *encryptingDatabase {
+	return &encryptingDatabase{db: db, keys: keys, users: map[string]*dataKeys{}}
+}
+
+func (e *encryptingDatabase) Open() error {
+	return e.db.Open()
+}
+
+func (e *encryptingDatabase) Close() error {
+	return e.db.Close()
+}
+
+func (e *encryptingDatabase) Ping(ctx context.Context) error {
+	return e.db.Ping(ctx)
+}
+
+func (e *encryptingDatabase) Create(ctx context.Context, body io.ReadCloser) (models.Note, error) {
+	body, err := e.encryptBody(ctx, body)
+	if err != nil {
+		return models.Note{}, err
+	}
+
+	note, err := e.db.Create(ctx, body)
+	if err != nil {
+		return note, err
+	}
+
+	return e.decryptNote(ctx, note.User.Username, note)
+}
+
+func (e *encryptingDatabase) Patch(ctx context.Context, id string, username string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+	note, err := e.db.Patch(ctx, id, username, e.applying(ctx, username, apply))
+	if err != nil {
+		return models.Note{}, err
+	}
+
+	return e.decryptNote(ctx, username, note)
+}
+
+func (e *encryptingDatabase) SetArchived(ctx context.Context, username string, ids []string, archived bool) ([]models.NoteResult, error) {
+	results, err := e.db.SetArchived(ctx, username, ids, archived)
+	if err != nil {
+		return results, err
+	}
+
+	return results, e.decryptResults(ctx, username, results)
+}
+
+func (e *encryptingDatabase) Delete(ctx context.Context, id string, username string) error {
+	return e.db.Delete(ctx, id, username)
+}
+
+func (e *encryptingDatabase) Batch(ctx context.Context, username string, operations []database.Operation) ([]models.NoteResult, error) {
+	encrypted := make([]database.Operation, len(operations))
+	for i, operation := range operations {
+		encrypted[i] = operation
+
+		switch {
+		case operation.Kind == database.OperationCreate:
+			note, err := e.encryptNote(ctx, operation.Note.User.Username, operation.Note)
+			if err != nil {
+				return nil, &database.BatchError{Index: i, Err: err}
+			}
+			encrypted[i].Note = note
+		case operation.Apply != nil:
+			encrypted[i].Apply = e.applying(ctx, username, operation.Apply)
+		}
+	}
+
+	results, err := e.db.Batch(ctx, username, encrypted)
+	if err != nil {
+		return results, err
+	}
+
+	if err := e.decryptResults(ctx, username, results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (e *encryptingDatabase) ListActiveNotes(ctx context.Context, username string) ([]models.Note, error) {
+	notes, err := e.db.ListActiveNotes(ctx, username)
+	if err != nil {
+		return notes, err
+	}
+
+	return e.decryptNotes(ctx, username, notes)
+}
+
+func (e *encryptingDatabase) ListArchivedNotes(ctx context.Context, username string) ([]models.Note, error) {
+	notes, err := e.db.ListArchivedNotes(ctx, username)
+	if err != nil {
+		return notes, err
+	}
+
+	return e.decryptNotes(ctx, username, notes)
+}
+
+func (e *encryptingDatabase) EachNote(ctx context.Context, username string, fn func(models.Note) error) error {
+	return e.db.EachNote(ctx, username, e.decrypting(ctx, username, fn))
+}
+
+func (e *encryptingDatabase) CountNotes(ctx context.Context) (int, int, error) {
+	return e.db.CountNotes(ctx)
+}
+
+func (e *encryptingDatabase) ListUsers(ctx context.Context) ([]string, error) {
+	return e.db.ListUsers(ctx)
+}
+
+func (e *encryptingDatabase) PutNote(ctx context.Context, note models.Note) (models.Note, bool, error) {
+	note, err := e.encryptNote(ctx, note.User.Username, note)
+	if err != nil {
+		return models.Note{}, false, err
+	}
+
+	stored, created, err := e.db.PutNote(ctx, note)
+	if err != nil {
+		return models.Note{}, false, err
+	}
+
+	stored, err = e.decryptNote(ctx, stored.User.Username, stored)
+
+	return stored, created, err
+}
+
+func (e *encryptingDatabase) Snapshot(ctx context.Context, fn func(database.Snapshot) error) error {
+	return e.db.Snapshot(ctx, func(snapshot database.Snapshot) error {
+		return fn(decryptingSnapshot{Snapshot: snapshot, e: e})
+	})
+}
+
+// ClaimIdempotencyKey decrypts the stored response when it is for the same
+// request, which is the only time it is replayed.
+func (e *encryptingDatabase) ClaimIdempotencyKey(ctx context.Context, record database.IdempotencyRecord, now time.Time) (database.IdempotencyRecord, bool, error) {
+	existing, claimed, err := e.db.ClaimIdempotencyKey(ctx, record, now)
+	if err != nil || claimed || existing.Fingerprint != record.Fingerprint || record.Username == "" {
+		return existing, claimed, err
+	}
+
+	body, err := e.decrypt(ctx, record.Username, "idempotency response", string(existing.Body))
+	if err != nil {
+		return database.IdempotencyRecord{}, false, err
+	}
+	existing.Body = []byte(body)
+
+	return existing, false, nil
+}
+
+// SaveIdempotencyKey encrypts the response with the data key of the user the
+// request was about. Responses to requests naming no user are kept as they
+// are.
+func (e *encryptingDatabase) SaveIdempotencyKey(ctx context.Context, record database.IdempotencyRecord) error {
+	if record.Username != "" && len(record.Body) > 0 {
+		body, err := e.encrypt(ctx, record.Username, record.Body)
+		if err != nil {
+			return err
+		}
+		record.Body = []byte(body)
+	}
+
+	return e.db.SaveIdempotencyKey(ctx, record)
+}
+
+func (e *encryptingDatabase) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return e.db.ReleaseIdempotencyKey(ctx, key)
+}
+
+func (e *encryptingDatabase) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	return e.db.CreateWebhook(ctx, webhook)
+}
+
+func (e *encryptingDatabase) ListWebhooks(ctx context.Context, username string) ([]models.Webhook, error) {
+	return e.db.ListWebhooks(ctx, username)
+}
+
+func (e *encryptingDatabase) DeleteWebhook(ctx context.Context, id string, username string) error {
+	return e.db.DeleteWebhook(ctx, id, username)
+}
+
+// SaveDelivery encrypts the payload kept by dead deliveries with the data key
+// of the owner of the webhook.
+func (e *encryptingDatabase) SaveDelivery(ctx context.Context, delivery models.Delivery) error {
+	if delivery.Payload != "" {
+		payload, err := e.encrypt(ctx, delivery.Username, []byte(delivery.Payload))
+		if err != nil {
+			return err
+		}
+		delivery.Payload = payload
+	}
+
+	return e.db.SaveDelivery(ctx, delivery)
+}
+
+func (e *encryptingDatabase) ListDeliveries(ctx context.Context, webhookID string, username string) ([]models.Delivery, error) {
+	deliveries, err := e.db.ListDeliveries(ctx, webhookID, username)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, delivery := range deliveries {
+		if deliveries[i].Payload, err = e.decrypt(ctx, username, "delivery "+delivery.Id, delivery.Payload); err != nil {
+			return nil, err
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (e *encryptingDatabase) Changes(ctx context.Context, username string, since uint64) ([]models.Change, uint64, error) {
+	changes, latest, err := e.db.Changes(ctx, username, since)
+	if err != nil {
+		return changes, latest, err
+	}
+
+	for i := range changes {
+		if changes[i].Note == nil {
+			continue
+		}
+
+		note, err := e.decryptNote(ctx, username, *changes[i].Note)
+		if err != nil {
+			return []models.Change{}, 0, err
+		}
+		changes[i].Note = &note
+	}
+
+	return changes, latest, nil
+}
+
+func (e *encryptingDatabase) ApplyChanges(ctx context.Context, username string, changes []models.Change) ([]models.ChangeResult, error) {
+	encrypted := make([]models.Change, len(changes))
+	for i, change := range changes {
+		encrypted[i] = change
+		if change.Note == nil {
+			continue
+		}
+
+		note, err := e.encryptNote(ctx, username, *change.Note)
+		if err != nil {
+			return nil, err
+		}
+		encrypted[i].Note = &note
+	}
+
+	results, err := e.db.ApplyChanges(ctx, username, encrypted)
+	if err != nil {
+		return results, err
+	}
+
+	for i := range results {
+		if results[i].Note == nil {
+			continue
+		}
+
+		note, err := e.decryptNote(ctx, username, *results[i].Note)
+		if err != nil {
+			return nil, err
+		}
+		results[i].Note = &note
+	}
+
+	return results, nil
+}
+
+func (e *encryptingDatabase) DataKeys(ctx context.Context, username string) ([]database.DataKey, error) {
+	return e.db.DataKeys(ctx, username)
+}
+
+func (e *encryptingDatabase) SaveDataKey(ctx context.Context, key database.DataKey) error {
+	return e.db.SaveDataKey(ctx, key)
+}
+
+type decryptingSnapshot struct {
+	database.Snapshot
+	e *encryptingDatabase
+}
+
+func (s decryptingSnapshot) EachNote(ctx context.Context, username string, fn func(models.Note) error) error {
+	return s.Snapshot.EachNote(ctx, username, s.e.decrypting(ctx, username, fn))
+}
+
+// applying wraps apply so that it changes the note decrypted, and the note
+// it returns is written encrypted.
+func (e *encryptingDatabase) applying(ctx context.Context, username string, apply func(models.Note) (models.Note, error)) func(models.Note) (models.Note, error) {
+	return func(existing models.Note) (models.Note, error) {
+		existing, err := e.decryptNote(ctx, username, existing)
+		if err != nil {
+			return models.Note{}, err
+		}
+
+		note, err := apply(existing)
+		if err != nil {
+			return models.Note{}, err
+		}
+
+		return e.encryptNote(ctx, username, note)
+	}
+}
+
+func (e *encryptingDatabase) decrypting(ctx context.Context, username string, fn func(models.Note) error) func(models.Note) error {
+	return func(note models.Note) error {
+		note, err := e.decryptNote(ctx, username, note)
+		if err != nil {
+			return err
+		}
+
+		return fn(note)
+	}
+}
+
+// encryptBody encrypts the content of the note in a request body.
+func (e *encryptingDatabase) encryptBody(ctx context.Context, body io.ReadCloser) (io.ReadCloser, error) {
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var note models.Note
+	if err := json.Unmarshal(content, &note); err != nil {
+		return nil, err
+	}
+
+	if note, err = e.encryptNote(ctx, note.User.Username, note); err != nil {
+		return nil, err
+	}
+
+	if content, err = json.Marshal(note); err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (e *encryptingDatabase) decryptNotes(ctx context.Context, username string, notes []models.Note) ([]models.Note, error) {
+	for i := range notes {
+		note, err := e.decryptNote(ctx, username, notes[i])
+		if err != nil {
+			return []models.Note{}, err
+		}
+		notes[i] = note
+	}
+
+	return notes, nil
+}
+
+func (e *encryptingDatabase) decryptResults(ctx context.Context, username string, results []models.NoteResult) error {
+	for i := range results {
+		if results[i].Note == nil {
+			continue
+		}
+
+		note, err := e.decryptNote(ctx, username, *results[i].Note)
+		if err != nil {
+			return err
+		}
+		results[i].Note = &note
+	}
+
+	return nil
+}
+
+// encryptNote encrypts the content of the note with the current data key of
+// the user. Empty content is left empty, since the backends take it to mean
+// that the content is not changing.
+func (e *encryptingDatabase) encryptNote(ctx context.Context, username string, note models.Note) (models.Note, error) {
+	if note.Content == "" {
+		return note, nil
+	}
+
+	content, err := e.encrypt(ctx, username, []byte(note.Content))
+	if err != nil {
+		return models.Note{}, err
+	}
+
+	note.Content = content
+
+	return note, nil
+}
+
+// decryptNote decrypts the content of the note.
+func (e *encryptingDatabase) decryptNote(ctx context.Context, username string, note models.Note) (models.Note, error) {
+	content, err := e.decrypt(ctx, username, "note "+note.Id, note.Content)
+	if err != nil {
+		return models.Note{}, err
+	}
+
+	note.Content = content
+
+	return note, nil
+}
+
+// encrypt seals plain with the current data key of the user.
+func (e *encryptingDatabase) encrypt(ctx context.Context, username string, plain []byte) (string, error) {
+	if username == "" {
+		return "", database.Invalid(errors.New("user must be set"))
+	}
+
+	keys, err := e.dataKeys(ctx, username, false)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := seal(keys.aeads[keys.current], plain, []byte(username))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + keys.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens content sealed by encrypt, reloading the data keys of the
+// user when it was encrypted with one they did not have yet. What names the
+// content in errors.
+func (e *encryptingDatabase) decrypt(ctx context.Context, username string, what string, content string) (string, error) {
+	id, sealed, encrypted, err := parse(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", what, err)
+	}
+	if !encrypted {
+		return content, nil
+	}
+
+	keys, err := e.dataKeys(ctx, username, false)
+	if err != nil {
+		return "", err
+	}
+
+	aead, ok := keys.aeads[id]
+	if !ok {
+		if keys, err = e.dataKeys(ctx, username, true); err != nil {
+			return "", err
+		}
+
+		if aead, ok = keys.aeads[id]; !ok {
+			return "", fmt.Errorf("%s is encrypted with data key %s, which the configured master keys cannot unwrap", what, id)
+		}
+	}
+
+	plain, err := open(aead, sealed, []byte(username))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s", what)
+	}
+
+	return string(plain), nil
+}
+
+// parse splits encrypted content into the id of its data key and the
+// ciphertext, and reports whether content is encrypted at all.
+func parse(content string) (string, []byte, bool, error) {
+	if !strings.HasPrefix(content, prefix) {
+		return "", nil, false, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(content, prefix), ":", 2)
+	if len(parts) != 2 {
+		return "", nil, true, errors.New("encrypted content is malformed")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, true, errors.New("encrypted content is malformed")
+	}
+
+	return parts[0], sealed, true, nil
+}
+
+// dataKeys returns the data keys of the user, read and unwrapped once and
+// then kept, unless refresh is set. Data keys wrapped with a master key that
+// is no longer configured are left out, and a user without any usable data
+// key is given a new one.
+func (e *encryptingDatabase) dataKeys(ctx context.Context, username string, refresh bool) (*dataKeys, error) {
+	if !refresh {
+		e.mu.Lock()
+		keys, ok := e.users[username]
+		e.mu.Unlock()
+
+		if ok {
+			return keys, nil
+		}
+	}
+
+	stored, err := e.db.DataKeys(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &dataKeys{aeads: map[string]cipher.AEAD{}}
+	for _, key := range stored {
+		plain, err := e.keys.unwrap(key)
+		if errors.Is(err, errUnknownMasterKey) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := newAEAD(plain)
+		if err != nil {
+			return nil, err
+		}
+
+		keys.aeads[key.Id] = aead
+		keys.current = key.Id
+	}
+
+	if keys.current == "" {
+		if err := e.addDataKey(ctx, username, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	e.mu.Lock()
+	e.users[username] = keys
+	e.mu.Unlock()
+
+	return keys, nil
+}
+
+// rotate gives the user a new data key, which becomes their current one.
+func (e *encryptingDatabase) rotate(ctx context.Context, username string) (*dataKeys, error) {
+	keys, err := e.dataKeys(ctx, username, true)
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := &dataKeys{aeads: map[string]cipher.AEAD{}}
+	for id, aead := range keys.aeads {
+		rotated.aeads[id] = aead
+	}
+
+	if err := e.addDataKey(ctx, username, rotated); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.users[username] = rotated
+	e.mu.Unlock()
+
+	return rotated, nil
+}
+
+// addDataKey makes a new data key, stores it wrapped and adds it to keys as
+// the current one.
+func (e *encryptingDatabase) addDataKey(ctx context.Context, username string, keys *dataKeys) error {
+	plain := make([]byte, KeySize)
+	if _, err := rand.Read(plain); err != nil {
+		return err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	key, err := e.keys.wrap(database.DataKey{Username: username, Id: hex.EncodeToString(id), CreatedAt: time.Now()}, plain)
+	if err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(plain)
+	if err != nil {
+		return err
+	}
+
+	if err := e.db.SaveDataKey(ctx, key); err != nil {
+		return err
+	}
+
+	keys.aeads[key.Id] = aead
+	keys.current = key.Id
+
+	return nil
+}
@@ -0,0 +1,423 @@
+package encryption_test
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/database/local"
+	"github.com/m-rcd/notes/pkg/database/sql"
+	"github.com/m-rcd/notes/pkg/encryption"
+	"github.com/m-rcd/notes/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func newKey() string {
+	key := make([]byte, encryption.KeySize)
+	_, err := rand.Read(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func parseKeys(keys ...string) *encryption.Keyring {
+	keyring, err := encryption.ParseKeys(strings.Join(keys, ","))
+	Expect(err).NotTo(HaveOccurred())
+
+	return keyring
+}
+
+// sealedArg matches an encrypted argument, and keeps it so that it can be
+// read back.
+type sealedArg struct {
+	value string
+}
+
+func (a *sealedArg) Match(v driver.Value) bool {
+	switch v := v.(type) {
+	case string:
+		a.value = v
+	case []byte:
+		a.value = string(v)
+	default:
+		return false
+	}
+
+	return strings.HasPrefix(a.value, "enc:v1:")
+}
+
+var _ = Describe("Encrypting", func() {
+	var (
+		ctx    = context.Background()
+		dir    string
+		raw    database.Database
+		db     database.Database
+		master string
+	)
+
+	body := func(note models.Note) io.ReadCloser {
+		content, err := json.Marshal(note)
+		Expect(err).NotTo(HaveOccurred())
+
+		return ioutil.NopCloser(strings.NewReader(string(content)))
+	}
+
+	create := func(db database.Database, username, content string) models.Note {
+		note, err := db.Create(ctx, body(models.Note{Name: "note", Content: content, User: models.User{Username: username}}))
+		Expect(err).NotTo(HaveOccurred())
+
+		return note
+	}
+
+	stored := func(username string) []models.Note {
+		var notes []models.Note
+		Expect(raw.EachNote(ctx, username, func(note models.Note) error {
+			notes = append(notes, note)
+			return nil
+		})).To(Succeed())
+
+		return notes
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "encryption_test")
+		Expect(err).NotTo(HaveOccurred())
+
+		raw = local.NewLocalFileSystem(dir)
+		Expect(raw.Open()).To(Succeed())
+
+		master = newKey()
+		db = encryption.Encrypting(raw, parseKeys(master))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("stores the content encrypted and reads it decrypted", func() {
+		note := create(db, "Casper", "Miawww")
+		Expect(note.Content).To(Equal("Miawww"))
+
+		notes := stored("Casper")
+		Expect(notes).To(HaveLen(1))
+		Expect(notes[0].Content).To(HavePrefix("enc:v1:"))
+		Expect(notes[0].Content).NotTo(ContainSubstring("Miawww"))
+
+		active, err := db.ListActiveNotes(ctx, "Casper")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(active).To(ConsistOf(note))
+	})
+
+	It("gives each user their own data key", func() {
+		create(db, "Casper", "Miawww")
+		create(db, "Sabriel", "Woof")
+
+		casper, err := raw.DataKeys(ctx, "Casper")
+		Expect(err).NotTo(HaveOccurred())
+		sabriel, err := raw.DataKeys(ctx, "Sabriel")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(casper).To(HaveLen(1))
+		Expect(sabriel).To(HaveLen(1))
+		Expect(casper[0].Id).NotTo(Equal(sabriel[0].Id))
+		Expect(casper[0].MasterKey).To(Equal(parseKeys(master).Current()))
+	})
+
+	It("reads notes stored before encryption was turned on", func() {
+		note := create(raw, "Casper", "Miawww")
+
+		notes, err := db.ListActiveNotes(ctx, "Casper")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(notes).To(ConsistOf(note))
+	})
+
+	It("decrypts the notes it patches and encrypts the result", func() {
+		note := create(db, "Casper", "Miawww")
+
+		patched, err := db.Patch(ctx, note.Id, "Casper", func(existing models.Note) (models.Note, error) {
+			Expect(existing.Content).To(Equal("Miawww"))
+			existing.Content += "!"
+			return existing, nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched.Content).To(Equal("Miawww!"))
+		Expect(stored("Casper")[0].Content).To(HavePrefix("enc:v1:"))
+	})
+
+	It("encrypts the notes of batches and synced changes", func() {
+		note := create(db, "Casper", "Miawww")
+
+		results, err := db.Batch(ctx, "Casper", []database.Operation{
+			{Kind: database.OperationCreate, Note: models.Note{Name: "batched", Content: "Purr", User: models.User{Username: "Casper"}}},
+			{Kind: database.OperationUpdate, Id: note.Id, Apply: func(existing models.Note) (models.Note, error) {
+				existing.Content = strings.ToUpper(existing.Content)
+				return existing, nil
+			}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].Note.Content).To(Equal("Purr"))
+		Expect(results[1].Note.Content).To(Equal("MIAWWW"))
+
+		changed := models.Note{Name: "synced", Content: "Hiss", User: models.User{Username: "Casper"}}
+		applied, err := db.ApplyChanges(ctx, "Casper", []models.Change{{Note: &changed}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(applied[0].Error).To(BeEmpty())
+		Expect(applied[0].Note.Content).To(Equal("Hiss"))
+		Expect(changed.Content).To(Equal("Hiss"))
+
+		for _, note := range stored("Casper") {
+			Expect(note.Content).To(HavePrefix("enc:v1:"))
+		}
+
+		changes, _, err := db.Changes(ctx, "Casper", 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		var contents []string
+		for _, change := range changes {
+			contents = append(contents, change.Note.Content)
+		}
+		Expect(contents).To(ConsistOf("Purr", "MIAWWW", "Hiss"))
+	})
+
+	It("decrypts the notes of snapshots", func() {
+		create(db, "Casper", "Miawww")
+
+		var contents []string
+		Expect(db.Snapshot(ctx, func(snapshot database.Snapshot) error {
+			return snapshot.EachNote(ctx, "Casper", func(note models.Note) error {
+				contents = append(contents, note.Content)
+				return nil
+			})
+		})).To(Succeed())
+		Expect(contents).To(ConsistOf("Miawww"))
+	})
+
+	It("refuses content that was tampered with", func() {
+		note := create(db, "Casper", "Miawww")
+
+		_, err := raw.Patch(ctx, note.Id, "Casper", func(existing models.Note) (models.Note, error) {
+			i := strings.LastIndex(existing.Content, ":") + 1
+			sealed, err := base64.StdEncoding.DecodeString(existing.Content[i:])
+			Expect(err).NotTo(HaveOccurred())
+			sealed[len(sealed)-1] ^= 1
+			existing.Content = existing.Content[:i] + base64.StdEncoding.EncodeToString(sealed)
+			return existing, nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = db.ListActiveNotes(ctx, "Casper")
+		Expect(err).To(MatchError("failed to decrypt note " + note.Id))
+	})
+
+	It("refuses the content of another user's note", func() {
+		note := create(db, "Casper", "Miawww")
+		create(db, "Sabriel", "Woof")
+
+		_, err := raw.Patch(ctx, note.Id, "Casper", func(existing models.Note) (models.Note, error) {
+			existing.Content = stored("Sabriel")[0].Content
+			return existing, nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = db.ListActiveNotes(ctx, "Casper")
+		Expect(err).To(MatchError(ContainSubstring("note " + note.Id + " is encrypted with data key")))
+	})
+
+	It("cannot read notes without the master key of their data key", func() {
+		note := create(db, "Casper", "Miawww")
+
+		other := encryption.Encrypting(raw, parseKeys(newKey()))
+		_, err := other.ListActiveNotes(ctx, "Casper")
+		Expect(err).To(MatchError(ContainSubstring("note " + note.Id + " is encrypted with data key")))
+	})
+
+	It("finds data keys added by another server", func() {
+		create(db, "Casper", "Miawww")
+
+		other := encryption.Encrypting(raw, parseKeys(master))
+		Expect(encryption.Reencrypt(ctx, raw, parseKeys(master), true, func(encryption.Result) {})).To(Succeed())
+		create(other, "Casper", "Purr")
+
+		notes, err := db.ListActiveNotes(ctx, "Casper")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(notes).To(HaveLen(2))
+	})
+
+	It("stores idempotency responses and delivery payloads encrypted", func() {
+		record := database.IdempotencyRecord{Key: "abc", Fingerprint: "first", ExpiresAt: time.Now().Add(time.Hour), Username: "Casper"}
+		_, claimed, err := db.ClaimIdempotencyKey(ctx, record, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claimed).To(BeTrue())
+
+		record.StatusCode = 201
+		record.Body = []byte(`{"content":"Miawww"}`)
+		Expect(db.SaveIdempotencyKey(ctx, record)).To(Succeed())
+
+		delivery := models.Delivery{Id: "d", WebhookId: "1", Dead: true, Payload: `{"content":"Purr"}`, Username: "Casper"}
+		Expect(db.SaveDelivery(ctx, delivery)).To(Succeed())
+
+		Expect(filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+
+			content, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).NotTo(ContainSubstring("Miawww"), path)
+			Expect(string(content)).NotTo(ContainSubstring("Purr"), path)
+			Expect(string(content)).NotTo(ContainSubstring(base64.StdEncoding.EncodeToString(record.Body)), path)
+
+			return nil
+		})).To(Succeed())
+
+		existing, claimed, err := db.ClaimIdempotencyKey(ctx, record, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claimed).To(BeFalse())
+		Expect(string(existing.Body)).To(Equal(`{"content":"Miawww"}`))
+
+		other := record
+		other.Fingerprint = "second"
+		existing, _, err = db.ClaimIdempotencyKey(ctx, other, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(existing.Body)).To(HavePrefix("enc:v1:"))
+
+		deliveries, err := db.ListDeliveries(ctx, "1", "Casper")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deliveries).To(HaveLen(1))
+		Expect(deliveries[0].Payload).To(Equal(`{"content":"Purr"}`))
+	})
+
+	It("stores idempotency responses and delivery payloads encrypted in SQL", func() {
+		s := sql.NewSQL("username", "password", "127.0.0.1", "3306", "notes")
+		conn, mock, err := sqlmock.New()
+		Expect(err).NotTo(HaveOccurred())
+		s.Db = conn
+		defer conn.Close()
+		db := encryption.Encrypting(s, parseKeys(master))
+
+		record := database.IdempotencyRecord{Key: "abc", Fingerprint: "first", StatusCode: 201, Body: []byte(`{"content":"Miawww"}`), ExpiresAt: time.Unix(2000, 0), Username: "Casper"}
+		delivery := models.Delivery{Id: "d", WebhookId: "1", Dead: true, Payload: `{"content":"Purr"}`, Time: time.Unix(1000, 0).UTC(), Username: "Casper"}
+
+		body := &sealedArg{}
+		payload := &sealedArg{}
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, master_key, wrapped_key, created_at FROM data_keys WHERE username=? ORDER BY created_at, id")).WithArgs("Casper").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "master_key", "wrapped_key", "created_at"}))
+		mock.ExpectExec("INSERT INTO data_keys").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE idempotency_keys SET fingerprint=?, status_code=?, content_type=?, body=?, expires_at=? WHERE idempotency_key=?")).
+			WithArgs("first", 201, "", body, record.ExpiresAt.UnixNano(), "abc").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO webhook_deliveries").
+			WithArgs("d", "1", "", "", 0, 0, "", true, payload, delivery.Time.UnixNano()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("DELETE FROM webhook_deliveries").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(db.SaveIdempotencyKey(ctx, record)).To(Succeed())
+		Expect(db.SaveDelivery(ctx, delivery)).To(Succeed())
+		Expect(body.value).NotTo(ContainSubstring("Miawww"))
+		Expect(payload.value).NotTo(ContainSubstring("Purr"))
+
+		mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT IGNORE INTO idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT fingerprint, status_code, content_type, body, expires_at FROM idempotency_keys").
+			WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status_code", "content_type", "body", "expires_at"}).
+				AddRow("first", 201, "", []byte(body.value), record.ExpiresAt.UnixNano()))
+		mock.ExpectQuery("FROM webhook_deliveries").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "webhook_id", "event_id", "event_type", "attempt", "status_code", "error", "dead", "payload", "delivered_at"}).
+				AddRow("d", "1", "", "", 0, 0, "", true, payload.value, delivery.Time.UnixNano()))
+
+		existing, _, err := db.ClaimIdempotencyKey(ctx, record, time.Unix(1500, 0))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(existing.Body)).To(Equal(`{"content":"Miawww"}`))
+
+		deliveries, err := db.ListDeliveries(ctx, "1", "Casper")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deliveries[0].Payload).To(Equal(`{"content":"Purr"}`))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	Describe("Reencrypt", func() {
+		reencrypt := func(keys *encryption.Keyring, rotate bool) []encryption.Result {
+			var results []encryption.Result
+			Expect(encryption.Reencrypt(ctx, raw, keys, rotate, func(result encryption.Result) {
+				results = append(results, result)
+			})).To(Succeed())
+
+			return results
+		}
+
+		It("encrypts the notes stored in plaintext", func() {
+			create(raw, "Casper", "Miawww")
+			create(db, "Casper", "Purr")
+
+			results := reencrypt(parseKeys(master), false)
+			Expect(results).To(Equal([]encryption.Result{{User: "Casper", Encrypted: 1}}))
+
+			for _, note := range stored("Casper") {
+				Expect(note.Content).To(HavePrefix("enc:v1:"))
+			}
+			Expect(reencrypt(parseKeys(master), false)).To(Equal([]encryption.Result{{User: "Casper"}}))
+		})
+
+		It("wraps the data keys with a new master key so the old one can go", func() {
+			note := create(db, "Casper", "Miawww")
+			before := stored("Casper")[0].Content
+
+			rotated := newKey()
+			results := reencrypt(parseKeys(rotated, master), false)
+			Expect(results).To(Equal([]encryption.Result{{User: "Casper", Rewrapped: 1}}))
+			Expect(stored("Casper")[0].Content).To(Equal(before))
+
+			notes, err := encryption.Encrypting(raw, parseKeys(rotated)).ListActiveNotes(ctx, "Casper")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(ConsistOf(note))
+		})
+
+		It("encrypts every note again with a new data key", func() {
+			note := create(db, "Casper", "Miawww")
+			create(db, "Sabriel", "Woof")
+			before := stored("Casper")[0].Content
+
+			results := reencrypt(parseKeys(master), true)
+			Expect(results).To(ConsistOf(
+				encryption.Result{User: "Casper", Encrypted: 1},
+				encryption.Result{User: "Sabriel", Encrypted: 1},
+			))
+			Expect(stored("Casper")[0].Content).NotTo(Equal(before))
+
+			keys, err := raw.DataKeys(ctx, "Casper")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(HaveLen(2))
+
+			notes, err := encryption.Encrypting(raw, parseKeys(master)).ListActiveNotes(ctx, "Casper")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notes).To(HaveLen(1))
+			Expect(notes[0].Updated).To(BeTemporally(">=", note.Updated))
+			note.Updated = notes[0].Updated
+			Expect(notes).To(ConsistOf(note))
+		})
+
+		It("reports the data keys and notes it cannot unwrap", func() {
+			note := create(db, "Casper", "Miawww")
+
+			results := reencrypt(parseKeys(newKey()), false)
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Encrypted).To(Equal(0))
+			Expect(results[0].Errors).To(HaveLen(2))
+			Expect(results[0].Errors[0]).To(ContainSubstring("master key is not configured"))
+			Expect(results[0].Errors[1]).To(HavePrefix("note " + note.Id + ": "))
+		})
+	})
+})
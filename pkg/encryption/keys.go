@@ -0,0 +1,149 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/m-rcd/notes/pkg/database"
+)
+
+// KeySize is the size in bytes of the master and data keys, which are
+// AES-256 keys.
+const KeySize = 32
+
+// errUnknownMasterKey is returned when unwrapping a data key wrapped with a
+// master key that is not in the keyring.
+var errUnknownMasterKey = errors.New("master key is not configured")
+
+// Keyring holds the master keys wrapping the data keys. The first one wraps
+// new data keys, and the others are only kept to unwrap the data keys they
+// wrapped until these are wrapped again.
+type Keyring struct {
+	keys []masterKey
+}
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// ParseKeys reads base64 encoded master keys separated by commas or
+// newlines, the current one first. Errors never hold a key.
+func ParseKeys(s string) (*Keyring, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(fields) == 0 {
+		return nil, errors.New("no encryption key given")
+	}
+
+	keyring := &Keyring{}
+	seen := map[string]bool{}
+	for i, field := range fields {
+		key, err := base64.StdEncoding.DecodeString(field)
+		if err != nil || len(key) != KeySize {
+			return nil, fmt.Errorf("encryption key %d must be %d random bytes, base64 encoded", i+1, KeySize)
+		}
+
+		id := keyID(key)
+		if seen[id] {
+			return nil, fmt.Errorf("encryption key %d is given twice", i+1)
+		}
+		seen[id] = true
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+
+		keyring.keys = append(keyring.keys, masterKey{id: id, aead: aead})
+	}
+
+	return keyring, nil
+}
+
+// Current is the id of the master key wrapping new data keys.
+func (k *Keyring) Current() string {
+	return k.keys[0].id
+}
+
+// wrap encrypts plain, the data key, with the current master key and stores
+// it in key.
+func (k *Keyring) wrap(key database.DataKey, plain []byte) (database.DataKey, error) {
+	sealed, err := seal(k.keys[0].aead, plain, wrappingData(key))
+	if err != nil {
+		return database.DataKey{}, err
+	}
+
+	key.MasterKey = k.keys[0].id
+	key.Wrapped = sealed
+
+	return key, nil
+}
+
+func (k *Keyring) unwrap(key database.DataKey) ([]byte, error) {
+	for _, master := range k.keys {
+		if master.id != key.MasterKey {
+			continue
+		}
+
+		plain, err := open(master.aead, key.Wrapped, wrappingData(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key %s of %s", key.Id, key.Username)
+		}
+
+		return plain, nil
+	}
+
+	return nil, fmt.Errorf("data key %s of %s is wrapped with master key %s: %w", key.Id, key.Username, key.MasterKey, errUnknownMasterKey)
+}
+
+// wrappingData ties a wrapped data key to its user and id, so that it cannot
+// be passed off as another key.
+func wrappingData(key database.DataKey) []byte {
+	return []byte(key.Username + "\x00" + key.Id)
+}
+
+// keyID names a master key by the start of its SHA-256, which gives nothing
+// of the key away.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+
+	return hex.EncodeToString(sum[:4])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plain with a random nonce, which it puts in front of the
+// ciphertext.
+func seal(aead cipher.AEAD, plain []byte, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plain, data), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, data []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], data)
+}
@@ -0,0 +1,53 @@
+package encryption_test
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/m-rcd/notes/pkg/encryption"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseKeys", func() {
+	It("takes the first of several keys as the current one", func() {
+		first, second := newKey(), newKey()
+
+		keys, err := encryption.ParseKeys(first + "\n" + second + "\n")
+		Expect(err).NotTo(HaveOccurred())
+
+		other, err := encryption.ParseKeys(first + "," + newKey())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys.Current()).To(Equal(other.Current()))
+
+		last, err := encryption.ParseKeys(second)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys.Current()).NotTo(Equal(last.Current()))
+	})
+
+	It("rejects keys of the wrong size without giving them away", func() {
+		short := base64.StdEncoding.EncodeToString([]byte("too short"))
+
+		_, err := encryption.ParseKeys(newKey() + "," + short)
+		Expect(err).To(MatchError("encryption key 2 must be 32 random bytes, base64 encoded"))
+		Expect(err.Error()).NotTo(ContainSubstring(short))
+	})
+
+	It("rejects keys that are not base64", func() {
+		_, err := encryption.ParseKeys("not a key!")
+		Expect(err).To(MatchError(ContainSubstring("encryption key 1 must be")))
+	})
+
+	It("rejects a key given twice", func() {
+		key := newKey()
+
+		_, err := encryption.ParseKeys(strings.Join([]string{key, newKey(), key}, "\n"))
+		Expect(err).To(MatchError("encryption key 3 is given twice"))
+	})
+
+	It("needs a key", func() {
+		_, err := encryption.ParseKeys(" \n")
+		Expect(err).To(MatchError("no encryption key given"))
+	})
+})
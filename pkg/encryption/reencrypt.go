@@ -0,0 +1,115 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// Result reports on bringing the keys and notes of one user up to date.
+type Result struct {
+	User string
+	// Rewrapped counts the data keys wrapped again with the current master
+	// key.
+	Rewrapped int
+	// Encrypted counts the notes encrypted again with the current data key.
+	Encrypted int
+	Errors    []string
+}
+
+// Reencrypt wraps the data keys of every user in db that are wrapped with an
+// older master key with the current one, then encrypts their notes stored in
+// plaintext or with another data key with their current data key. With
+// rotate, every user gets a new data key first, so that all of their notes
+// are encrypted again. db must be the backend itself, not wrapped with
+// Encrypting. done is called with the result for each user.
+func Reencrypt(ctx context.Context, db database.Database, keys *Keyring, rotate bool, done func(Result)) error {
+	e := newEncrypting(db, keys)
+
+	users, err := db.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		result, err := e.reencrypt(ctx, user, rotate)
+		if err != nil {
+			return fmt.Errorf("failed to re-encrypt the notes of %s: %w", user, err)
+		}
+
+		done(result)
+	}
+
+	return nil
+}
+
+func (e *encryptingDatabase) reencrypt(ctx context.Context, username string, rotate bool) (Result, error) {
+	result := Result{User: username}
+
+	stored, err := e.db.DataKeys(ctx, username)
+	if err != nil {
+		return result, err
+	}
+
+	for _, key := range stored {
+		if key.MasterKey == e.keys.Current() {
+			continue
+		}
+
+		plain, err := e.keys.unwrap(key)
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+
+		if key, err = e.keys.wrap(key, plain); err != nil {
+			return result, err
+		}
+
+		if err := e.db.SaveDataKey(ctx, key); err != nil {
+			return result, err
+		}
+		result.Rewrapped++
+	}
+
+	var keys *dataKeys
+	if rotate {
+		keys, err = e.rotate(ctx, username)
+	} else {
+		keys, err = e.dataKeys(ctx, username, true)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	var stale []string
+	err = e.db.EachNote(ctx, username, func(note models.Note) error {
+		if id, _, encrypted, err := parse(note.Content); note.Content != "" && (err != nil || !encrypted || id != keys.current) {
+			stale = append(stale, note.Id)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	for _, id := range stale {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		_, err := e.Patch(ctx, id, username, func(note models.Note) (models.Note, error) {
+			return note, nil
+		})
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("note %s: %s", id, err))
+			continue
+		}
+		result.Encrypted++
+	}
+
+	return result, nil
+}
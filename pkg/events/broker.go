@@ -0,0 +1,184 @@
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+const (
+	NoteCreated    = "note.created"
+	NoteUpdated    = "note.updated"
+	NoteArchived   = "note.archived"
+	NoteUnarchived = "note.unarchived"
+	NoteDeleted    = "note.deleted"
+)
+
+// Types lists the types of events, in the order notes go through them.
+var Types = []string{NoteCreated, NoteUpdated, NoteArchived, NoteUnarchived, NoteDeleted}
+
+// DefaultLogSize is how many events a broker keeps for clients resuming a
+// feed.
+const DefaultLogSize = 1000
+
+// subscriberBuffer is how many events a subscriber can fall behind before it
+// is dropped. Dropped subscribers resume from the log when they reconnect.
+const subscriberBuffer = 64
+
+// Event is a change to one of a user's notes. Deleted notes only have their
+// id and user set.
+type Event struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Note models.Note `json:"note"`
+	Time time.Time   `json:"time"`
+
+	seq uint64
+}
+
+// Broker hands the changes to notes to the feeds of their users. It keeps the
+// last events in a bounded log so that feeds can resume where they stopped.
+type Broker struct {
+	// epoch tells the event ids of this broker from those of a previous run
+	// of the server, which cannot be resumed from.
+	epoch string
+
+	mu          sync.Mutex
+	seq         uint64
+	log         []Event
+	size        int
+	subscribers map[*subscriber]struct{}
+	listeners   []func(Event)
+	closed      bool
+}
+
+type subscriber struct {
+	username string
+	events   chan Event
+}
+
+func NewBroker(size int) *Broker {
+	return &Broker{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:        size,
+		subscribers: map[*subscriber]struct{}{},
+	}
+}
+
+// Publish records that note changed and sends the event to the feeds of its
+// user.
+func (b *Broker) Publish(eventType string, note models.Note) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event := Event{ID: fmt.Sprintf("%s-%d", b.epoch, b.seq), Type: eventType, Note: note, Time: time.Now().UTC(), seq: b.seq}
+
+	b.log = append(b.log, event)
+	if len(b.log) > b.size {
+		b.log = b.log[len(b.log)-b.size:]
+	}
+
+	for _, listener := range b.listeners {
+		listener(event)
+	}
+
+	for s := range b.subscribers {
+		if s.username != note.User.Username {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			b.drop(s)
+		}
+	}
+
+	return event
+}
+
+// Subscribe starts a feed of the changes to the notes of username. When
+// lastEventID is set, the events after it that are still in the log come
+// first; resumed is false when some of them may be gone, or the id is not
+// one of this broker's. The events channel is closed when the feed is
+// dropped for falling behind or the broker is closed, and cancel ends it.
+func (b *Broker) Subscribe(username string, lastEventID string) (backlog []Event, resumed bool, events <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := &subscriber{username: username, events: make(chan Event, subscriberBuffer)}
+	if b.closed {
+		close(s.events)
+	} else {
+		b.subscribers[s] = struct{}{}
+	}
+
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[s]; ok {
+			b.drop(s)
+		}
+	}
+
+	if lastEventID == "" {
+		return nil, true, s.events, cancel
+	}
+
+	last, ok := b.parse(lastEventID)
+	if !ok || last > b.seq {
+		return nil, false, s.events, cancel
+	}
+
+	resumed = len(b.log) == 0 || b.log[0].seq <= last+1
+	for _, event := range b.log {
+		if event.seq > last && event.Note.User.Username == username {
+			backlog = append(backlog, event)
+		}
+	}
+
+	return backlog, resumed, s.events, cancel
+}
+
+// Listen calls fn with every event published from now on, whoever the note
+// belongs to, in order. fn is called while publishing, so it must not block
+// or use the broker.
+func (b *Broker) Listen(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.listeners = append(b.listeners, fn)
+}
+
+// Close ends every feed. Feeds started afterwards end straight away.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subscribers {
+		b.drop(s)
+	}
+}
+
+func (b *Broker) drop(s *subscriber) {
+	delete(b.subscribers, s)
+	close(s.events)
+}
+
+func (b *Broker) parse(id string) (uint64, bool) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 || parts[0] != b.epoch {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+
+	return seq, err == nil
+}
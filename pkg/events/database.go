@@ -0,0 +1,246 @@
+package events
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+type publishingDatabase struct {
+	db     database.Database
+	broker *Broker
+}
+
+// Publishing wraps db so that every change it makes to a note is published
+// to broker once it has been stored.
+func Publishing(db database.Database, broker *Broker) database.Database {
+	return &publishingDatabase{db: db, broker: broker}
+}
+
+func (p *publishingDatabase) Open() error {
+	return p.db.Open()
+}
+
+func (p *publishingDatabase) Close() error {
+	return p.db.Close()
+}
+
+func (p *publishingDatabase) Ping(ctx context.Context) error {
+	return p.db.Ping(ctx)
+}
+
+func (p *publishingDatabase) Create(ctx context.Context, body io.ReadCloser) (models.Note, error) {
+	note, err := p.db.Create(ctx, body)
+	if err == nil {
+		p.broker.Publish(NoteCreated, note)
+	}
+
+	return note, err
+}
+
+func (p *publishingDatabase) Patch(ctx context.Context, id string, username string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+	var before models.Note
+	note, err := p.db.Patch(ctx, id, username, func(existing models.Note) (models.Note, error) {
+		before = existing
+		return apply(existing)
+	})
+	if err == nil {
+		p.broker.Publish(change(before, note), note)
+	}
+
+	return note, err
+}
+
+func (p *publishingDatabase) SetArchived(ctx context.Context, username string, ids []string, archived bool) ([]models.NoteResult, error) {
+	results, err := p.db.SetArchived(ctx, username, ids, archived)
+	if err != nil {
+		return results, err
+	}
+
+	eventType := NoteUnarchived
+	if archived {
+		eventType = NoteArchived
+	}
+
+	for _, result := range results {
+		if result.Note != nil && result.Error == "" {
+			p.broker.Publish(eventType, *result.Note)
+		}
+	}
+
+	return results, err
+}
+
+func (p *publishingDatabase) Delete(ctx context.Context, id string, username string) error {
+	err := p.db.Delete(ctx, id, username)
+	if err == nil {
+		p.broker.Publish(NoteDeleted, deleted(id, username))
+	}
+
+	return err
+}
+
+func (p *publishingDatabase) Batch(ctx context.Context, username string, operations []database.Operation) ([]models.NoteResult, error) {
+	befores := make([]models.Note, len(operations))
+	watched := make([]database.Operation, len(operations))
+	for i, operation := range operations {
+		watched[i] = operation
+		if operation.Apply == nil {
+			continue
+		}
+
+		i, apply := i, operation.Apply
+		watched[i].Apply = func(existing models.Note) (models.Note, error) {
+			befores[i] = existing
+			return apply(existing)
+		}
+	}
+
+	results, err := p.db.Batch(ctx, username, watched)
+	if err != nil {
+		return results, err
+	}
+
+	for i, operation := range operations {
+		switch {
+		case operation.Kind == database.OperationDelete:
+			p.broker.Publish(NoteDeleted, deleted(operation.Id, username))
+		case i >= len(results) || results[i].Note == nil:
+		case operation.Kind == database.OperationCreate:
+			p.broker.Publish(NoteCreated, *results[i].Note)
+		default:
+			p.broker.Publish(change(befores[i], *results[i].Note), *results[i].Note)
+		}
+	}
+
+	return results, err
+}
+
+func (p *publishingDatabase) ListActiveNotes(ctx context.Context, username string) ([]models.Note, error) {
+	return p.db.ListActiveNotes(ctx, username)
+}
+
+func (p *publishingDatabase) ListArchivedNotes(ctx context.Context, username string) ([]models.Note, error) {
+	return p.db.ListArchivedNotes(ctx, username)
+}
+
+func (p *publishingDatabase) EachNote(ctx context.Context, username string, fn func(models.Note) error) error {
+	return p.db.EachNote(ctx, username, fn)
+}
+
+func (p *publishingDatabase) CountNotes(ctx context.Context) (int, int, error) {
+	return p.db.CountNotes(ctx)
+}
+
+func (p *publishingDatabase) ListUsers(ctx context.Context) ([]string, error) {
+	return p.db.ListUsers(ctx)
+}
+
+func (p *publishingDatabase) PutNote(ctx context.Context, note models.Note) (models.Note, bool, error) {
+	stored, created, err := p.db.PutNote(ctx, note)
+	if err == nil {
+		if created {
+			p.broker.Publish(NoteCreated, stored)
+		} else {
+			p.broker.Publish(NoteUpdated, stored)
+		}
+	}
+
+	return stored, created, err
+}
+
+func (p *publishingDatabase) Snapshot(ctx context.Context, fn func(database.Snapshot) error) error {
+	return p.db.Snapshot(ctx, fn)
+}
+
+func (p *publishingDatabase) ClaimIdempotencyKey(ctx context.Context, record database.IdempotencyRecord, now time.Time) (database.IdempotencyRecord, bool, error) {
+	return p.db.ClaimIdempotencyKey(ctx, record, now)
+}
+
+func (p *publishingDatabase) SaveIdempotencyKey(ctx context.Context, record database.IdempotencyRecord) error {
+	return p.db.SaveIdempotencyKey(ctx, record)
+}
+
+func (p *publishingDatabase) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return p.db.ReleaseIdempotencyKey(ctx, key)
+}
+
+func (p *publishingDatabase) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	return p.db.CreateWebhook(ctx, webhook)
+}
+
+func (p *publishingDatabase) ListWebhooks(ctx context.Context, username string) ([]models.Webhook, error) {
+	return p.db.ListWebhooks(ctx, username)
+}
+
+func (p *publishingDatabase) DeleteWebhook(ctx context.Context, id string, username string) error {
+	return p.db.DeleteWebhook(ctx, id, username)
+}
+
+func (p *publishingDatabase) SaveDelivery(ctx context.Context, delivery models.Delivery) error {
+	return p.db.SaveDelivery(ctx, delivery)
+}
+
+func (p *publishingDatabase) ListDeliveries(ctx context.Context, webhookID string, username string) ([]models.Delivery, error) {
+	return p.db.ListDeliveries(ctx, webhookID, username)
+}
+
+func (p *publishingDatabase) Changes(ctx context.Context, username string, since uint64) ([]models.Change, uint64, error) {
+	return p.db.Changes(ctx, username, since)
+}
+
+// ApplyChanges publishes the changes that were applied. Updates are all
+// published as such, since the notes they replaced are not known.
+func (p *publishingDatabase) ApplyChanges(ctx context.Context, username string, changes []models.Change) ([]models.ChangeResult, error) {
+	results, err := p.db.ApplyChanges(ctx, username, changes)
+	if err != nil {
+		return results, err
+	}
+
+	for i, result := range results {
+		if i >= len(changes) || result.Conflict || result.Error != "" {
+			continue
+		}
+
+		switch {
+		case result.Deleted:
+			if result.Version != changes[i].Version {
+				p.broker.Publish(NoteDeleted, deleted(result.Id, username))
+			}
+		case result.Note == nil:
+		case changes[i].Id == "":
+			p.broker.Publish(NoteCreated, *result.Note)
+		default:
+			p.broker.Publish(NoteUpdated, *result.Note)
+		}
+	}
+
+	return results, err
+}
+
+func (p *publishingDatabase) DataKeys(ctx context.Context, username string) ([]database.DataKey, error) {
+	return p.db.DataKeys(ctx, username)
+}
+
+func (p *publishingDatabase) SaveDataKey(ctx context.Context, key database.DataKey) error {
+	return p.db.SaveDataKey(ctx, key)
+}
+
+// change names what happened to a note that went from before to after.
+func change(before, after models.Note) string {
+	switch {
+	case !before.Archived && after.Archived:
+		return NoteArchived
+	case before.Archived && !after.Archived:
+		return NoteUnarchived
+	}
+
+	return NoteUpdated
+}
+
+func deleted(id string, username string) models.Note {
+	return models.Note{Id: id, User: models.User{Username: username}}
+}
@@ -0,0 +1,307 @@
+package events_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/events"
+	"github.com/m-rcd/notes/pkg/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var ctx = context.Background()
+
+var _ = Describe("Events", func() {
+	var (
+		broker *events.Broker
+		buffy  = models.User{Username: "Buffy"}
+		note   = models.Note{Id: "1", Name: "Vampires", Content: "I SLAY", User: buffy}
+	)
+
+	BeforeEach(func() {
+		broker = events.NewBroker(3)
+	})
+
+	Context("Broker", func() {
+		It("sends events to the feeds of the note's user", func() {
+			_, _, buffys, cancel := broker.Subscribe("Buffy", "")
+			defer cancel()
+			_, _, spikes, cancel := broker.Subscribe("Spike", "")
+			defer cancel()
+
+			published := broker.Publish(events.NoteCreated, note)
+
+			Expect(buffys).To(Receive(Equal(published)))
+			Expect(spikes).NotTo(Receive())
+		})
+
+		It("hands every event to its listeners", func() {
+			var heard []events.Event
+			broker.Listen(func(event events.Event) {
+				heard = append(heard, event)
+			})
+
+			first := broker.Publish(events.NoteCreated, note)
+			second := broker.Publish(events.NoteCreated, models.Note{Id: "2", User: models.User{Username: "Spike"}})
+
+			Expect(heard).To(Equal([]events.Event{first, second}))
+		})
+
+		It("resumes after the last event a feed got", func() {
+			first := broker.Publish(events.NoteCreated, note)
+			broker.Publish(events.NoteCreated, models.Note{Id: "2", User: models.User{Username: "Spike"}})
+			third := broker.Publish(events.NoteUpdated, note)
+
+			backlog, resumed, _, cancel := broker.Subscribe("Buffy", first.ID)
+			defer cancel()
+			Expect(resumed).To(BeTrue())
+			Expect(backlog).To(Equal([]events.Event{third}))
+		})
+
+		It("says when events after the last one are gone from the log", func() {
+			first := broker.Publish(events.NoteCreated, note)
+			for i := 0; i < 4; i++ {
+				broker.Publish(events.NoteUpdated, note)
+			}
+
+			backlog, resumed, _, cancel := broker.Subscribe("Buffy", first.ID)
+			defer cancel()
+			Expect(resumed).To(BeFalse())
+			Expect(backlog).To(HaveLen(3))
+
+			_, resumed, _, cancel = broker.Subscribe("Buffy", "from-another-run")
+			defer cancel()
+			Expect(resumed).To(BeFalse())
+		})
+
+		It("drops feeds that fall behind", func() {
+			_, _, feed, cancel := broker.Subscribe("Buffy", "")
+			defer cancel()
+
+			for i := 0; i < 100; i++ {
+				broker.Publish(events.NoteUpdated, note)
+			}
+
+			Eventually(feed).Should(BeClosed())
+		})
+
+		It("ends every feed when closed", func() {
+			_, _, feed, cancel := broker.Subscribe("Buffy", "")
+			defer cancel()
+
+			broker.Close()
+			Expect(feed).To(BeClosed())
+
+			_, _, late, cancel := broker.Subscribe("Buffy", "")
+			defer cancel()
+			Expect(late).To(BeClosed())
+		})
+	})
+
+	Context("Publishing", func() {
+		var (
+			fake_db *databasefakes.FakeDatabase
+			db      database.Database
+			feed    <-chan events.Event
+			cancel  func()
+		)
+
+		BeforeEach(func() {
+			fake_db = new(databasefakes.FakeDatabase)
+			db = events.Publishing(fake_db, broker)
+			_, _, feed, cancel = broker.Subscribe("Buffy", "")
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("publishes created and deleted notes", func() {
+			fake_db.CreateReturns(note, nil)
+
+			_, err := db.Create(ctx, io.NopCloser(strings.NewReader(`{}`)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.Delete(ctx, "1", "Buffy")).To(Succeed())
+
+			Expect(feed).To(Receive(And(HaveField("Type", events.NoteCreated), HaveField("Note", note))))
+			Expect(feed).To(Receive(And(HaveField("Type", events.NoteDeleted), HaveField("Note", models.Note{Id: "1", User: buffy}))))
+		})
+
+		It("tells archiving from other changes", func() {
+			archived := note
+			archived.Archived = true
+			fake_db.PatchStub = func(_ context.Context, _ string, _ string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+				return apply(note)
+			}
+
+			_, err := db.Patch(ctx, "1", "Buffy", func(models.Note) (models.Note, error) { return archived, nil })
+			Expect(err).NotTo(HaveOccurred())
+			_, err = db.Patch(ctx, "1", "Buffy", func(n models.Note) (models.Note, error) { return n, nil })
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(feed).To(Receive(HaveField("Type", events.NoteArchived)))
+			Expect(feed).To(Receive(HaveField("Type", events.NoteUpdated)))
+		})
+
+		It("publishes each note changed by a bulk operation", func() {
+			fake_db.SetArchivedReturns([]models.NoteResult{{Id: "1", Note: &note}, {Id: "2", Error: "note does not exist"}}, nil)
+			fake_db.BatchReturns([]models.NoteResult{{Id: "3", Note: &note}, {Id: "4"}}, nil)
+
+			_, err := db.SetArchived(ctx, "Buffy", []string{"1", "2"}, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = db.Batch(ctx, "Buffy", []database.Operation{
+				{Kind: database.OperationCreate, Note: note},
+				{Kind: database.OperationDelete, Id: "4"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(feed).To(Receive(HaveField("Type", events.NoteUnarchived)))
+			Expect(feed).To(Receive(HaveField("Type", events.NoteCreated)))
+			Expect(feed).To(Receive(And(HaveField("Type", events.NoteDeleted), HaveField("Note.Id", "4"))))
+			Expect(feed).NotTo(Receive())
+		})
+
+		It("publishes the synced changes that were applied", func() {
+			fake_db.ApplyChangesReturns([]models.ChangeResult{
+				{Change: models.Change{Id: "1", Version: 5, Note: &note}},
+				{Change: models.Change{Id: "2", Version: 6, Note: &note}},
+				{Change: models.Change{Id: "3", Version: 7, Deleted: true}},
+				{Change: models.Change{Id: "4", Version: 2, Deleted: true}},
+				{Change: models.Change{Id: "5", Version: 3, Note: &note}, Conflict: true},
+			}, nil)
+
+			_, err := db.ApplyChanges(ctx, "Buffy", []models.Change{
+				{Note: &note},
+				{Id: "2", Version: 4, Note: &note},
+				{Id: "3", Version: 4, Deleted: true},
+				{Id: "4", Version: 2, Deleted: true},
+				{Id: "5", Version: 1, Note: &note},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(feed).To(Receive(HaveField("Type", events.NoteCreated)))
+			Expect(feed).To(Receive(HaveField("Type", events.NoteUpdated)))
+			Expect(feed).To(Receive(And(HaveField("Type", events.NoteDeleted), HaveField("Note.Id", "3"))))
+			Expect(feed).NotTo(Receive())
+		})
+
+		It("publishes nothing when the change fails", func() {
+			fake_db.DeleteReturns(errors.New("note does not exist"))
+			fake_db.BatchReturns(nil, &database.BatchError{Index: 0, Err: errors.New("note does not exist")})
+
+			Expect(db.Delete(ctx, "1", "Buffy")).NotTo(Succeed())
+			_, err := db.Batch(ctx, "Buffy", []database.Operation{{Kind: database.OperationDelete, Id: "1"}})
+			Expect(err).To(HaveOccurred())
+
+			Expect(feed).NotTo(Receive())
+		})
+	})
+
+	Context("Handler", func() {
+		var (
+			server *httptest.Server
+			bodies []io.Closer
+		)
+
+		BeforeEach(func() {
+			server = httptest.NewServer(events.NewHandler(broker))
+			bodies = nil
+		})
+
+		AfterEach(func() {
+			broker.Close()
+			for _, body := range bodies {
+				body.Close()
+			}
+			server.Close()
+		})
+
+		stream := func(path, lastEventID string) (*http.Response, *bufio.Reader) {
+			req, err := http.NewRequest("GET", server.URL+path, nil)
+			Expect(err).NotTo(HaveOccurred())
+			if lastEventID != "" {
+				req.Header.Set("Last-Event-ID", lastEventID)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			bodies = append(bodies, resp.Body)
+
+			return resp, bufio.NewReader(resp.Body)
+		}
+
+		next := func(reader *bufio.Reader) string {
+			var message bytes.Buffer
+			for {
+				line, err := reader.ReadString('\n')
+				Expect(err).NotTo(HaveOccurred())
+				if line == "\n" {
+					return message.String()
+				}
+				message.WriteString(line)
+			}
+		}
+
+		It("streams the changes to the user's notes", func() {
+			resp, reader := stream("/events?username=Buffy", "")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+			Expect(next(reader)).To(Equal("retry: 1000\n"))
+
+			broker.Publish(events.NoteCreated, models.Note{Id: "2", User: models.User{Username: "Spike"}})
+			event := broker.Publish(events.NoteCreated, note)
+
+			message := next(reader)
+			Expect(message).To(HavePrefix("id: " + event.ID + "\nevent: note.created\ndata: {"))
+			Expect(message).To(ContainSubstring(`"note":{"id":"1","name":"Vampires","content":"I SLAY","user":{"username":"Buffy"},"archived":false,`))
+		})
+
+		It("keeps streaming past the server's write timeout", func() {
+			server.Close()
+			server = httptest.NewUnstartedServer(events.NewHandler(broker))
+			server.Config.WriteTimeout = 100 * time.Millisecond
+			server.Start()
+
+			_, reader := stream("/events?username=Buffy", "")
+			next(reader)
+
+			time.Sleep(250 * time.Millisecond)
+			event := broker.Publish(events.NoteCreated, note)
+			Expect(next(reader)).To(HavePrefix("id: " + event.ID + "\n"))
+
+			time.Sleep(250 * time.Millisecond)
+			event = broker.Publish(events.NoteUpdated, note)
+			Expect(next(reader)).To(HavePrefix("id: " + event.ID + "\n"))
+		})
+
+		It("resumes from the Last-Event-ID header", func() {
+			first := broker.Publish(events.NoteCreated, note)
+			second := broker.Publish(events.NoteDeleted, models.Note{Id: "1", User: buffy})
+
+			_, reader := stream("/events?username=Buffy", first.ID)
+			next(reader)
+			Expect(next(reader)).To(HavePrefix("id: " + second.ID + "\nevent: note.deleted\n"))
+		})
+
+		It("tells clients to fetch their notes again when events were missed", func() {
+			_, reader := stream("/events?username=Buffy", "unknown-1")
+			next(reader)
+			Expect(next(reader)).To(Equal("event: reset\ndata: {}\n"))
+		})
+
+		It("needs to know whose notes to stream", func() {
+			resp, _ := stream("/events", "")
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
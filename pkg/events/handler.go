@@ -0,0 +1,130 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/logging"
+	"github.com/m-rcd/notes/pkg/responses"
+)
+
+const (
+	// Reset tells a client resuming a feed that events may have been missed,
+	// so it should fetch the notes again.
+	Reset = "reset"
+
+	heartbeat = 15 * time.Second
+	// writeWait is how long a stream may take to send each write, in place of
+	// the server's write timeout, which would cut streams off. It outlasts the
+	// heartbeat, so streams stay open while nothing happens.
+	writeWait = 2 * heartbeat
+	// retry is how long clients wait before reconnecting, in milliseconds.
+	retry = 1000
+)
+
+// Handler streams the changes to the caller's notes as Server-Sent Events,
+// starting after the `Last-Event-ID` header when a client reconnects.
+type Handler struct {
+	broker *Broker
+}
+
+// NewHandler serves the feeds of broker.
+func NewHandler(broker *Broker) *Handler {
+	return &Handler{broker: broker}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	owner, err := auth.Owner(r)
+	if err != nil {
+		fail(w, auth.OwnerFailure(err))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		fail(w, responses.Failure("streaming is not supported"))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	log := logging.FromContext(r.Context())
+
+	// Servers that cannot move the deadline keep their own, which is only
+	// logged once per stream.
+	controller := http.NewResponseController(w)
+	stuck := false
+	extend := func() {
+		if err := controller.SetWriteDeadline(time.Now().Add(writeWait)); err != nil && !stuck {
+			stuck = true
+			log.WithError(err).Warn("cannot extend the write deadline of the stream")
+		}
+	}
+
+	backlog, resumed, events, cancel := h.broker.Subscribe(owner, r.Header.Get("Last-Event-ID"))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	extend()
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", retry)
+	if !resumed {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", Reset)
+	}
+
+	for _, event := range backlog {
+		if err := send(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(heartbeat)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			extend()
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			extend()
+			if err := send(w, event); err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+func send(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+
+	return err
+}
+
+func fail(w http.ResponseWriter, response responses.JsonNoteResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.StatusCode)
+
+	json.NewEncoder(w).Encode(response)
+}
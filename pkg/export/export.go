@@ -0,0 +1,85 @@
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/markdown"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// Zip writes the user's notes to w as a ZIP with a Markdown file for each,
+// in an active/ or archived/ folder, reading and compressing them one at a
+// time. The archive is only finished when every note made it in, so one
+// that fails half way is not mistaken for a complete export.
+func Zip(ctx context.Context, w io.Writer, db database.Database, username string) error {
+	archive := zip.NewWriter(w)
+	names := map[string]bool{}
+	now := time.Now()
+
+	err := db.EachNote(ctx, username, func(note models.Note) error {
+		content, err := markdown.Encode(note)
+		if err != nil {
+			return err
+		}
+
+		file, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     fileName(names, note),
+			Method:   zip.Deflate,
+			Modified: now,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = file.Write(content)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// fileName names the file of the note after it, falling back to its id
+// when another note of the folder has the same name.
+func fileName(names map[string]bool, note models.Note) string {
+	dir := "active/"
+	if note.Archived {
+		dir = "archived/"
+	}
+
+	name := dir + clean(note.Name) + ".md"
+	if names[name] {
+		name = fmt.Sprintf("%s%s_%s.md", dir, clean(note.Name), note.Id)
+	}
+	names[name] = true
+
+	return name
+}
+
+// clean replaces the characters that are not allowed in file names on
+// some systems.
+func clean(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '-'
+		}
+
+		return r
+	}, name)
+
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return "note"
+	}
+
+	return name
+}
@@ -0,0 +1,91 @@
+package export_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/export"
+	"github.com/m-rcd/notes/pkg/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Export", func() {
+	var (
+		fakeDb *databasefakes.FakeDatabase
+		out    bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		fakeDb = new(databasefakes.FakeDatabase)
+		out.Reset()
+	})
+
+	notes := func(notes ...models.Note) func(context.Context, string, func(models.Note) error) error {
+		return func(_ context.Context, _ string, fn func(models.Note) error) error {
+			for _, note := range notes {
+				if err := fn(note); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+	}
+
+	read := func() map[string]string {
+		archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+		Expect(err).NotTo(HaveOccurred())
+
+		files := map[string]string{}
+		for _, file := range archive.File {
+			r, err := file.Open()
+			Expect(err).NotTo(HaveOccurred())
+			content, err := ioutil.ReadAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			files[file.Name] = string(content)
+		}
+
+		return files
+	}
+
+	It("writes a Markdown file for each note, by state", func() {
+		fakeDb.EachNoteStub = notes(
+			models.Note{Id: "1", Name: "Stakes", Content: "Pointy."},
+			models.Note{Id: "2", Name: "Stakes", Content: "Wooden."},
+			models.Note{Id: "3", Name: "Demons/Hellmouth", Content: "Below the library.", Archived: true},
+		)
+
+		Expect(export.Zip(context.Background(), &out, fakeDb, "Buffy")).To(Succeed())
+		Expect(read()).To(Equal(map[string]string{
+			"active/Stakes.md":             "---\nid: \"1\"\nname: Stakes\narchived: false\n---\nPointy.",
+			"active/Stakes_2.md":           "---\nid: \"2\"\nname: Stakes\narchived: false\n---\nWooden.",
+			"archived/Demons-Hellmouth.md": "---\nid: \"3\"\nname: Demons/Hellmouth\narchived: true\n---\nBelow the library.",
+		}))
+
+		_, username, _ := fakeDb.EachNoteArgsForCall(0)
+		Expect(username).To(Equal("Buffy"))
+	})
+
+	It("writes an empty archive when there are no notes", func() {
+		Expect(export.Zip(context.Background(), &out, fakeDb, "Buffy")).To(Succeed())
+		Expect(read()).To(BeEmpty())
+	})
+
+	It("leaves the archive unfinished when reading the notes fails", func() {
+		fakeDb.EachNoteStub = func(_ context.Context, _ string, fn func(models.Note) error) error {
+			Expect(fn(models.Note{Id: "1", Name: "Stakes"})).To(Succeed())
+			return errors.New("disk on fire")
+		}
+
+		Expect(export.Zip(context.Background(), &out, fakeDb, "Buffy")).To(MatchError("disk on fire"))
+
+		_, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,13 +1,23 @@
 package handler
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/bulk"
 	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/logging"
 	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/patch"
 	"github.com/m-rcd/notes/pkg/responses"
 )
 
@@ -24,11 +34,23 @@ func New(db database.Database) Handler {
 func (h *Handler) CreateNewNote(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		json.NewEncoder(w).Encode(responses.BadRequest(err.Error()))
+		return
+	}
+
+	if err := auth.CheckBody(r.Context(), body); err != nil {
+		json.NewEncoder(w).Encode(auth.OwnerFailure(err))
+		return
+	}
+
 	var response responses.JsonNoteResponse
-	newNote, err := h.db.Create(r.Body)
+	newNote, err := h.db.Create(r.Context(), ioutil.NopCloser(bytes.NewReader(body)))
 	if err != nil {
-		response = responses.Failure(err.Error())
+		response = failure(logging.FromContext(r.Context()), err, "failed to create note")
 	} else {
+		logging.SetUser(r.Context(), newNote.User.Username)
 		response = responses.Success([]models.Note{newNote}, "The note was successfully created")
 	}
 
@@ -36,27 +58,175 @@ func (h *Handler) CreateNewNote(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) UpdateNote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := mux.Vars(r)["id"]
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		json.NewEncoder(w).Encode(auth.OwnerFailure(err))
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		json.NewEncoder(w).Encode(responses.BadRequest(err.Error()))
+		return
+	}
+
+	var change patch.Func
+	if patch.Supports(r.Header.Get("Content-Type")) {
+		change, err = patch.New(r.Header.Get("Content-Type"), body)
+	} else {
+		change, err = patch.Fields(body)
+	}
+	if err != nil {
+		json.NewEncoder(w).Encode(responses.BadRequest(err.Error()))
+		return
+	}
+
+	h.applyChange(w, r, id, owner, change, "The note was successfully updated")
+}
+
+func (h *Handler) ReplaceNote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := mux.Vars(r)["id"]
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		json.NewEncoder(w).Encode(auth.OwnerFailure(err))
+		return
+	}
+
+	var note models.Note
+	if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+		json.NewEncoder(w).Encode(responses.BadRequest(err.Error()))
+		return
+	}
+
+	h.applyChange(w, r, id, owner, patch.Replace(note), "The note was successfully updated")
+}
+
+func (h *Handler) ArchiveNote(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, true, "The note was successfully archived")
+}
+
+func (h *Handler) UnarchiveNote(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, false, "The note was successfully unarchived")
+}
+
+func (h *Handler) setArchived(w http.ResponseWriter, r *http.Request, archived bool, message string) {
+	w.Header().Set("Content-Type", "application/json")
+
 	id := mux.Vars(r)["id"]
 
+	owner, err := auth.Owner(r)
+	if err != nil {
+		json.NewEncoder(w).Encode(auth.OwnerFailure(err))
+		return
+	}
+
+	h.applyChange(w, r, id, owner, patch.Archived(archived), message)
+}
+
+func (h *Handler) ArchiveNotes(w http.ResponseWriter, r *http.Request) {
+	h.setArchivedBulk(w, r, true, "archived")
+}
+
+func (h *Handler) UnarchiveNotes(w http.ResponseWriter, r *http.Request) {
+	h.setArchivedBulk(w, r, false, "unarchived")
+}
+
+// setArchivedBulk changes the notes selected by the request and answers with
+// a result for each of them.
+func (h *Handler) setArchivedBulk(w http.ResponseWriter, r *http.Request, archived bool, done string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		json.NewEncoder(w).Encode(auth.OwnerFailure(err))
+		return
+	}
+
+	request, err := bulk.Decode(r.Body)
+	if err != nil {
+		json.NewEncoder(w).Encode(responses.BadRequest(err.Error()))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	results, err := bulk.SetArchived(r.Context(), h.db, owner, request, archived)
+	if err != nil {
+		json.NewEncoder(w).Encode(failure(logging.FromContext(r.Context()), err, "failed to change notes"))
+		return
+	}
+
+	json.NewEncoder(w).Encode(responses.Results(results, done))
+}
+
+// BatchNotes runs a list of creates, updates and deletes together.
+func (h *Handler) BatchNotes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	owner, err := auth.Owner(r)
+	if err != nil {
+		json.NewEncoder(w).Encode(auth.OwnerFailure(err))
+		return
+	}
+
+	operations, err := bulk.DecodeBatch(r.Body, owner)
+	if err != nil {
+		json.NewEncoder(w).Encode(responses.BadRequest(err.Error()))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	results, err := bulk.Batch(r.Context(), h.db, owner, operations)
+
+	var batchErr *database.BatchError
+	switch {
+	case errors.As(err, &batchErr):
+		logging.FromContext(r.Context()).WithError(err).Warn("batch not applied")
+		json.NewEncoder(w).Encode(responses.NotApplied(results, err))
+	case err != nil:
+		json.NewEncoder(w).Encode(failure(logging.FromContext(r.Context()), err, "failed to apply batch"))
+	default:
+		json.NewEncoder(w).Encode(responses.Applied(results))
+	}
+}
+
+func (h *Handler) applyChange(w http.ResponseWriter, r *http.Request, id string, owner string, change patch.Func, message string) {
+	logging.SetUser(r.Context(), owner)
+
 	var response responses.JsonNoteResponse
-	note, err := h.db.Update(id, r.Body)
+	note, err := h.db.Patch(r.Context(), id, owner, change)
 	if err != nil {
-		response = responses.Failure(err.Error())
+		response = failure(logging.FromContext(r.Context()).WithField("id", id), err, "failed to update note")
 	} else {
-		response = responses.Success([]models.Note{note}, "The note was successfully updated")
+		response = responses.Success([]models.Note{note}, message)
 	}
 
 	json.NewEncoder(w).Encode(response)
 }
 
 func (h *Handler) DeleteNote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
 	id := mux.Vars(r)["id"]
 
 	var response responses.JsonNoteResponse
-	err := h.db.Delete(id, r.Body)
+	owner, err := auth.Owner(r)
+	if err != nil {
+		json.NewEncoder(w).Encode(auth.OwnerFailure(err))
+		return
+	}
+
+	err = h.db.Delete(r.Context(), id, owner)
 	if err != nil {
-		response = responses.Failure(err.Error())
+		response = failure(logging.FromContext(r.Context()).WithField("id", id), err, "failed to delete note")
 	} else {
+		logging.SetUser(r.Context(), owner)
 		response = responses.Success([]models.Note{}, "The note was successfully deleted")
 	}
 
@@ -64,11 +234,20 @@ func (h *Handler) DeleteNote(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) ListActiveNotes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
 	var response responses.JsonNoteResponse
 
-	notes, err := h.db.ListActiveNotes(r.Body)
+	owner, err := auth.Owner(r)
 	if err != nil {
-		response = responses.Failure(err.Error())
+		json.NewEncoder(w).Encode(auth.OwnerFailure(err))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	notes, err := h.db.ListActiveNotes(r.Context(), owner)
+	if err != nil {
+		response = failure(logging.FromContext(r.Context()), err, "failed to list active notes")
 		json.NewEncoder(w).Encode(response)
 	} else {
 		json.NewEncoder(w).Encode(notes)
@@ -76,17 +255,45 @@ func (h *Handler) ListActiveNotes(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) ListArchivedNotes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
 	var response responses.JsonNoteResponse
 
-	notes, err := h.db.ListArchivedNotes(r.Body)
+	owner, err := auth.Owner(r)
+	if err != nil {
+		json.NewEncoder(w).Encode(auth.OwnerFailure(err))
+		return
+	}
+
+	logging.SetUser(r.Context(), owner)
+	notes, err := h.db.ListArchivedNotes(r.Context(), owner)
 	if err != nil {
-		response = responses.Failure(err.Error())
+		response = failure(logging.FromContext(r.Context()), err, "failed to list archived notes")
 		json.NewEncoder(w).Encode(response)
 	} else {
 		json.NewEncoder(w).Encode(notes)
 	}
 }
 
+// failure logs a storage error and builds the response for it. Requests
+// abandoned by the client are not logged as errors. Like every response of
+// these routes, it is sent with HTTP status 200 and only gives its status in
+// `status_code`, which is what existing clients read.
+func failure(log *logrus.Entry, err error, message string) responses.JsonNoteResponse {
+	response := responses.Error(err)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		log.WithError(err).Warn(message + ": request cancelled")
+	case response.StatusCode < http.StatusInternalServerError:
+		log.WithError(err).Warn(message)
+	default:
+		log.WithError(err).Error(message)
+	}
+
+	return response
+}
+
 func (h *Handler) HomePage(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Welcome to Note!")
 }
@@ -2,11 +2,15 @@ package handler_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/database"
 	"github.com/m-rcd/notes/pkg/database/databasefakes"
 	"github.com/m-rcd/notes/pkg/handler"
 	"github.com/m-rcd/notes/pkg/models"
@@ -61,6 +65,25 @@ var _ = Describe("Handler", func() {
 				Expect(response.StatusCode).To(Equal(500))
 				Expect(response.Message).To(Equal("Not created"))
 			})
+
+			It("does not create a note an authenticated user names someone else in", func() {
+				fake_db := new(databasefakes.FakeDatabase)
+
+				h := handler.New(fake_db)
+				r := httptest.NewRecorder()
+				postData := bytes.NewBuffer([]byte(`{"name":"Vampires","content":"I SLAY","user":{"username":"Spike"}}`))
+				req, err := http.NewRequest("POST", "http://localhost:10000/note", postData)
+				Expect(err).NotTo(HaveOccurred())
+				req = req.WithContext(auth.WithUser(req.Context(), models.User{Username: "Buffy"}))
+
+				h.CreateNewNote(r, req)
+				Expect(fake_db.CreateCallCount()).To(BeZero())
+				var response responses.JsonNoteResponse
+
+				json.Unmarshal(r.Body.Bytes(), &response)
+				Expect(response.StatusCode).To(Equal(403))
+				Expect(response.Message).To(Equal("user does not match client certificate"))
+			})
 		})
 	})
 
@@ -74,10 +97,14 @@ var _ = Describe("Handler", func() {
 			r := httptest.NewRecorder()
 			h := handler.New(fake_db)
 
-			note := models.Note{Id: "1", Name: "Vampires", Content: "I SLAY A LOT", User: models.User{Username: "Buffy"}}
-			fake_db.UpdateReturns(note, nil)
+			note := models.Note{Id: "1", Name: "Vampires", Content: "I SLAY", User: models.User{Username: "Buffy"}}
+			fake_db.PatchStub = func(_ context.Context, _ string, _ string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+				return apply(note)
+			}
 			h.UpdateNote(r, req)
-			Expect(fake_db.UpdateCallCount()).To(Equal(1))
+			Expect(fake_db.PatchCallCount()).To(Equal(1))
+			_, _, username, _ := fake_db.PatchArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
 			var response responses.JsonNoteResponse
 
 			json.Unmarshal(r.Body.Bytes(), &response)
@@ -97,9 +124,9 @@ var _ = Describe("Handler", func() {
 				req, err := http.NewRequest("POST", "http://localhost:10000/note/1", patchData)
 				Expect(err).NotTo(HaveOccurred())
 
-				fake_db.UpdateReturns(models.Note{}, errors.New("Not updated"))
+				fake_db.PatchReturns(models.Note{}, errors.New("Not updated"))
 				h.UpdateNote(r, req)
-				Expect(fake_db.UpdateCallCount()).To(Equal(1))
+				Expect(fake_db.PatchCallCount()).To(Equal(1))
 				var response responses.JsonNoteResponse
 
 				json.Unmarshal(r.Body.Bytes(), &response)
@@ -107,6 +134,137 @@ var _ = Describe("Handler", func() {
 				Expect(response.StatusCode).To(Equal(500))
 				Expect(response.Message).To(Equal("Not updated"))
 			})
+
+			It("does not find the notes of other users", func() {
+				fake_db := new(databasefakes.FakeDatabase)
+
+				h := handler.New(fake_db)
+				r := httptest.NewRecorder()
+				req, err := http.NewRequest("PATCH", "http://localhost:10000/note/1", bytes.NewBufferString(`{"content":"I SLAY","user":{"username":"Spike"}}`))
+				Expect(err).NotTo(HaveOccurred())
+
+				fake_db.PatchReturns(models.Note{}, database.ErrNoteNotFound)
+				h.UpdateNote(r, req)
+				Expect(r.Code).To(Equal(http.StatusOK))
+
+				var response responses.JsonNoteResponse
+				Expect(json.Unmarshal(r.Body.Bytes(), &response)).To(Succeed())
+				Expect(response.StatusCode).To(Equal(404))
+				_, _, username, _ := fake_db.PatchArgsForCall(0)
+				Expect(username).To(Equal("Spike"))
+			})
+		})
+	})
+
+	Context("#UpdateNote with a patch document", func() {
+		var (
+			fake_db *databasefakes.FakeDatabase
+			note    = models.Note{Id: "1", Name: "Vampires", Content: "I SLAY", User: models.User{Username: "Buffy"}}
+		)
+
+		BeforeEach(func() {
+			fake_db = new(databasefakes.FakeDatabase)
+			fake_db.PatchStub = func(_ context.Context, _ string, _ string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+				return apply(note)
+			}
+		})
+
+		patchNote := func(body string) (*httptest.ResponseRecorder, responses.JsonNoteResponse) {
+			req, err := http.NewRequest("PATCH", "http://localhost:10000/note/1?username=Buffy", bytes.NewBufferString(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			r := httptest.NewRecorder()
+			h := handler.New(fake_db)
+
+			h.UpdateNote(r, req)
+
+			var response responses.JsonNoteResponse
+			Expect(json.Unmarshal(r.Body.Bytes(), &response)).To(Succeed())
+			return r, response
+		}
+
+		It("clears the fields set to null", func() {
+			r, response := patchNote(`{"content":null}`)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.Data[0].Content).To(BeEmpty())
+		})
+
+		It("rejects changes that leave the note invalid", func() {
+			r, response := patchNote(`{"name":null}`)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			Expect(response.StatusCode).To(Equal(400))
+			Expect(response.Message).To(Equal("invalid patch: name must be set"))
+		})
+	})
+
+	Context("#ArchiveNotes", func() {
+		It("archives the notes matching the filter", func() {
+			fake_db := new(databasefakes.FakeDatabase)
+			note := models.Note{Id: "1", Name: "Vampires", User: models.User{Username: "Buffy"}}
+			fake_db.ListActiveNotesReturns([]models.Note{note}, nil)
+			note.Archived = true
+			fake_db.SetArchivedReturns([]models.NoteResult{{Id: "1", Note: &note}}, nil)
+
+			req, err := http.NewRequest("POST", "http://localhost:10000/notes/archive", bytes.NewBufferString(`{"username":"Buffy","filter":{"name":"Vampires"}}`))
+			Expect(err).NotTo(HaveOccurred())
+			r := httptest.NewRecorder()
+			h := handler.New(fake_db)
+
+			h.ArchiveNotes(r, req)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			_, username, ids, archived := fake_db.SetArchivedArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+			Expect(ids).To(Equal([]string{"1"}))
+			Expect(archived).To(BeTrue())
+
+			var response responses.JsonResultsResponse
+			Expect(json.Unmarshal(r.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Type).To(Equal("success"))
+			Expect(response.Message).To(Equal("1 of 1 notes were archived"))
+		})
+	})
+
+	Context("#BatchNotes", func() {
+		It("handles batch request", func() {
+			fake_db := new(databasefakes.FakeDatabase)
+			fake_db.BatchReturns([]models.NoteResult{{Id: "1"}}, nil)
+
+			req, err := http.NewRequest("POST", "http://localhost:10000/notes/batch", bytes.NewBufferString(`{"username":"Buffy","operations":[{"op":"delete","id":"1"}]}`))
+			Expect(err).NotTo(HaveOccurred())
+			r := httptest.NewRecorder()
+			h := handler.New(fake_db)
+
+			h.BatchNotes(r, req)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			_, username, operations := fake_db.BatchArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+			Expect(operations).To(HaveLen(1))
+
+			var response responses.JsonResultsResponse
+			json.Unmarshal(r.Body.Bytes(), &response)
+			Expect(response.Type).To(Equal("success"))
+			Expect(response.Results).To(Equal([]models.NoteResult{{Id: "1"}}))
+		})
+	})
+
+	Context("#ReplaceNote", func() {
+		It("handles PUT request", func() {
+			fake_db := new(databasefakes.FakeDatabase)
+			fake_db.PatchReturns(models.Note{Id: "1", Name: "Vampires", User: models.User{Username: "Buffy"}}, nil)
+
+			req, err := http.NewRequest("PUT", "http://localhost:10000/note/1", bytes.NewBufferString(`{"name":"Vampires","user":{"username":"Buffy"}}`))
+			Expect(err).NotTo(HaveOccurred())
+			r := httptest.NewRecorder()
+			h := handler.New(fake_db)
+
+			h.ReplaceNote(r, req)
+			Expect(fake_db.PatchCallCount()).To(Equal(1))
+			_, _, username, _ := fake_db.PatchArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+
+			var response responses.JsonNoteResponse
+			json.Unmarshal(r.Body.Bytes(), &response)
+			Expect(response.Message).To(Equal("The note was successfully updated"))
 		})
 	})
 
@@ -155,6 +313,27 @@ var _ = Describe("Handler", func() {
 		})
 	})
 
+	Context("when a storage operation times out", func() {
+		It("responds with a gateway timeout in the body", func() {
+			fake_db := new(databasefakes.FakeDatabase)
+
+			h := handler.New(fake_db)
+			r := httptest.NewRecorder()
+			req, err := http.NewRequest("DELETE", "http://localhost:10000/note/1", bytes.NewBuffer([]byte(`{"username":"Buffy"}`)))
+			Expect(err).NotTo(HaveOccurred())
+
+			fake_db.DeleteReturns(fmt.Errorf("failed to scan notes: %w", context.DeadlineExceeded))
+			h.DeleteNote(r, req)
+			Expect(r.Code).To(Equal(http.StatusOK))
+			var response responses.JsonNoteResponse
+
+			json.Unmarshal(r.Body.Bytes(), &response)
+			Expect(response.Type).To(Equal("failed"))
+			Expect(response.StatusCode).To(Equal(504))
+			Expect(response.Message).To(Equal("the storage operation timed out"))
+		})
+	})
+
 	Context("#ListActiveNotes", func() {
 		It("handles GET request", func() {
 			fake_db := new(databasefakes.FakeDatabase)
@@ -179,6 +358,43 @@ var _ = Describe("Handler", func() {
 		})
 	})
 
+	Context("when the user is given as a query parameter", func() {
+		It("lists their notes without a body", func() {
+			fake_db := new(databasefakes.FakeDatabase)
+
+			req, err := http.NewRequest("GET", "http://localhost:10000/notes/active?username=Buffy", nil)
+			Expect(err).NotTo(HaveOccurred())
+			r := httptest.NewRecorder()
+			h := handler.New(fake_db)
+
+			h.ListActiveNotes(r, req)
+			Expect(fake_db.ListActiveNotesCallCount()).To(Equal(1))
+			_, username := fake_db.ListActiveNotesArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+		})
+	})
+
+	Context("when no user is given", func() {
+		It("does not delete the note", func() {
+			fake_db := new(databasefakes.FakeDatabase)
+
+			req, err := http.NewRequest("DELETE", "http://localhost:10000/note/1", nil)
+			Expect(err).NotTo(HaveOccurred())
+			r := httptest.NewRecorder()
+			h := handler.New(fake_db)
+
+			h.DeleteNote(r, req)
+			Expect(fake_db.DeleteCallCount()).To(Equal(0))
+			Expect(r.Code).To(Equal(http.StatusOK))
+
+			var response responses.JsonNoteResponse
+			json.Unmarshal(r.Body.Bytes(), &response)
+			Expect(response.Type).To(Equal("failed"))
+			Expect(response.StatusCode).To(Equal(400))
+			Expect(response.Message).To(Equal("user must be set"))
+		})
+	})
+
 	Context("#ListArchivedNotes", func() {
 		It("handles GET request", func() {
 			fake_db := new(databasefakes.FakeDatabase)
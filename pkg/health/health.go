@@ -0,0 +1,75 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/m-rcd/notes/pkg/database"
+)
+
+const (
+	statusOK           = "ok"
+	statusError        = "error"
+	statusShuttingDown = "shutting down"
+)
+
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Response struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+type Checker struct {
+	db           database.Database
+	shuttingDown int32
+}
+
+func New(db database.Database) *Checker {
+	return &Checker{db: db}
+}
+
+// ShuttingDown makes the server report itself as not ready, so that load
+// balancers stop sending it traffic while in-flight requests are drained.
+func (c *Checker) ShuttingDown() {
+	atomic.StoreInt32(&c.shuttingDown, 1)
+}
+
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, http.StatusOK, Response{Status: statusOK})
+}
+
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	response := Response{
+		Status: statusOK,
+		Components: map[string]ComponentStatus{
+			"server":   {Status: statusOK},
+			"database": {Status: statusOK},
+		},
+	}
+	statusCode := http.StatusOK
+
+	if atomic.LoadInt32(&c.shuttingDown) == 1 {
+		response.Components["server"] = ComponentStatus{Status: statusShuttingDown}
+		response.Status = statusError
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	if err := c.db.Ping(r.Context()); err != nil {
+		response.Components["database"] = ComponentStatus{Status: statusError, Error: err.Error()}
+		response.Status = statusError
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	writeResponse(w, statusCode, response)
+}
+
+func writeResponse(w http.ResponseWriter, statusCode int, response Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,75 @@
+package health_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/health"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	var (
+		fake_db *databasefakes.FakeDatabase
+		checker *health.Checker
+	)
+
+	BeforeEach(func() {
+		fake_db = new(databasefakes.FakeDatabase)
+		checker = health.New(fake_db)
+	})
+
+	get := func(handle http.HandlerFunc) (int, health.Response) {
+		r := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://localhost:10000/", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		handle(r, req)
+
+		var response health.Response
+		Expect(json.Unmarshal(r.Body.Bytes(), &response)).To(Succeed())
+		return r.Code, response
+	}
+
+	Context("#Liveness", func() {
+		It("reports ok without checking the database", func() {
+			fake_db.PingReturns(errors.New("connection refused"))
+
+			code, response := get(checker.Liveness)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response.Status).To(Equal("ok"))
+			Expect(fake_db.PingCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("#Readiness", func() {
+		It("reports ready when the database is reachable", func() {
+			code, response := get(checker.Readiness)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response.Status).To(Equal("ok"))
+			Expect(response.Components).To(HaveKeyWithValue("database", health.ComponentStatus{Status: "ok"}))
+			Expect(response.Components).To(HaveKeyWithValue("server", health.ComponentStatus{Status: "ok"}))
+		})
+
+		It("reports not ready when the database is unreachable", func() {
+			fake_db.PingReturns(errors.New("connection refused"))
+
+			code, response := get(checker.Readiness)
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+			Expect(response.Status).To(Equal("error"))
+			Expect(response.Components).To(HaveKeyWithValue("database", health.ComponentStatus{Status: "error", Error: "connection refused"}))
+		})
+
+		It("reports not ready while shutting down", func() {
+			checker.ShuttingDown()
+
+			code, response := get(checker.Readiness)
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+			Expect(response.Components).To(HaveKeyWithValue("server", health.ComponentStatus{Status: "shutting down"}))
+		})
+	})
+})
@@ -0,0 +1,89 @@
+package importer
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// enexTime is how Evernote writes the dates of notes.
+const enexTime = "20060102T150405Z"
+
+type enexNote struct {
+	Title   string   `xml:"title"`
+	Content string   `xml:"content"`
+	Created string   `xml:"created"`
+	Updated string   `xml:"updated"`
+	Tags    []string `xml:"tag"`
+}
+
+// readENEX reads the notes of an Evernote export one at a time, so that
+// their attachments are never all in memory.
+func readENEX(r io.Reader) ([]Item, error) {
+	decoder := xml.NewDecoder(r)
+
+	root := ""
+	items := []Item{}
+	for len(items) <= MaxItems {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if root == "" {
+			root = start.Name.Local
+			if root != "en-export" {
+				return nil, fmt.Errorf("expected an en-export document, got %q", root)
+			}
+			continue
+		}
+
+		if start.Name.Local != "note" {
+			continue
+		}
+
+		var note enexNote
+		if err := decoder.DecodeElement(&note, &start); err != nil {
+			return nil, err
+		}
+
+		items = append(items, note.item(len(items)))
+	}
+
+	if root == "" {
+		return nil, errors.New("expected an en-export document")
+	}
+
+	return items, nil
+}
+
+func (n enexNote) item(i int) Item {
+	name := strings.TrimSpace(n.Title)
+	item := Item{Source: fmt.Sprintf("[%d] %s", i, name)}
+
+	content, err := enmlToMarkdown(n.Content)
+	if err != nil {
+		item.Err = err
+		return item
+	}
+
+	created, _ := time.Parse(enexTime, strings.TrimSpace(n.Created))
+	updated, _ := time.Parse(enexTime, strings.TrimSpace(n.Updated))
+
+	item.Note = models.Note{Name: name, Content: content, Tags: n.Tags, Created: created, Updated: updated}
+
+	return item
+}
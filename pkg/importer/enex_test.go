@@ -0,0 +1,111 @@
+package importer_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/importer"
+	"github.com/m-rcd/notes/pkg/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ENEX", func() {
+	const enex = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export4.dtd">
+<en-export export-date="20200501T100000Z" application="Evernote" version="10.0">
+  <note>
+    <title>Patrol</title>
+    <created>20200501T100000Z</created>
+    <updated>20200502T113000Z</updated>
+    <tag>sunnydale</tag>
+    <tag>nights</tag>
+    <content><![CDATA[<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note>
+  <h1>Tonight</h1>
+  <div>Check the <b>cemetery</b> and&nbsp;the <a href="https://example.com/bronze">Bronze</a>.</div>
+  <div><en-todo checked="true"/>Stakes</div>
+  <div><en-todo/>Holy water</div>
+  <ul>
+    <li>Restfield</li>
+    <li>Shady Hill <i>if time</i>
+      <ol><li>North gate</li></ol>
+    </li>
+  </ul>
+  <pre>grr
+  argh</pre>
+  <en-media type="image/png" hash="abc"/>
+  <hr/>
+  <blockquote>Into every generation<br/>a slayer is born.</blockquote>
+</en-note>]]></content>
+    <resource><data encoding="base64">iVBORw0KGgo=</data></resource>
+  </note>
+  <note>
+    <title>Broken</title>
+    <content><![CDATA[<en-note><div>Unclosed &bogus; entity</div></en-note>]]></content>
+  </note>
+</en-export>`
+
+	It("converts the notes to Markdown, keeping their tags and dates", func() {
+		items, err := importer.Read(strings.NewReader(enex), "", importer.MediaXML)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(items).To(HaveLen(2))
+
+		Expect(items[0]).To(Equal(importer.Item{Source: "[0] Patrol", Note: models.Note{Name: "Patrol", Content: `# Tonight
+
+Check the **cemetery** and the [Bronze](https://example.com/bronze).
+[x] Stakes
+[ ] Holy water
+
+- Restfield
+- Shady Hill _if time_
+
+  1. North gate
+
+` + "```\ngrr\n  argh\n```" + `
+
+---
+
+> Into every generation
+> a slayer is born.`,
+			Tags:    []string{"sunnydale", "nights"},
+			Created: time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC),
+			Updated: time.Date(2020, 5, 2, 11, 30, 0, 0, time.UTC),
+		}}))
+	})
+
+	It("reads notes with unknown entities", func() {
+		items, err := importer.Read(strings.NewReader(enex), importer.FormatENEX, "text/xml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(items[1].Err).NotTo(HaveOccurred())
+		Expect(items[1].Note.Name).To(Equal("Broken"))
+	})
+
+	It("imports notes whose titles the local backend cannot keep in a file name", func() {
+		const titles = `<?xml version="1.0" encoding="UTF-8"?>
+<en-export>
+  <note><title>Slayer_handbook</title><content><![CDATA[<en-note><div>Chapter one.</div></en-note>]]></content></note>
+  <note><title>Sunnydale/Hellmouth</title><content><![CDATA[<en-note><div>Below the library.</div></en-note>]]></content></note>
+  <note><title>Watchers\Council</title><content><![CDATA[<en-note><div>In London.</div></en-note>]]></content></note>
+  <note><title>../Chapter..2</title><content><![CDATA[<en-note><div>Chapter two.</div></en-note>]]></content></note>
+</en-export>`
+
+		items, err := importer.Read(strings.NewReader(titles), importer.FormatENEX, importer.MediaXML)
+		Expect(err).NotTo(HaveOccurred())
+
+		notes := importLocally(items)
+		Expect(notes).To(ConsistOf(
+			HaveField("Name", "Slayer-handbook"),
+			HaveField("Name", "Sunnydale-Hellmouth"),
+			HaveField("Name", "Watchers-Council"),
+			HaveField("Name", ".-Chapter.2"),
+		))
+	})
+
+	It("rejects XML that is not an Evernote export", func() {
+		_, err := importer.Read(strings.NewReader(`<notes><note/></notes>`), "", importer.MediaXML)
+		Expect(err).To(MatchError(`invalid import: expected an en-export document, got "notes"`))
+	})
+})
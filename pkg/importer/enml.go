@@ -0,0 +1,337 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// node is an element of an ENML document, or a text when it has no name.
+type node struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*node
+}
+
+// enmlToMarkdown converts the content of an Evernote note, a restricted
+// XHTML, to Markdown. Attachments and encrypted text are left out.
+func enmlToMarkdown(content string) (string, error) {
+	root, err := parseENML(content)
+	if err != nil {
+		return "", err
+	}
+
+	r := &renderer{}
+	r.children(root)
+
+	return r.String(), nil
+}
+
+func parseENML(content string) (*node, error) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	root := &node{name: "en-export"}
+	stack := []*node{root}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid note content: %w", err)
+		}
+
+		parent := stack[len(stack)-1]
+		switch token := token.(type) {
+		case xml.StartElement:
+			child := &node{name: strings.ToLower(token.Name.Local), attrs: map[string]string{}}
+			for _, attr := range token.Attr {
+				child.attrs[strings.ToLower(attr.Name.Local)] = attr.Value
+			}
+			parent.children = append(parent.children, child)
+			stack = append(stack, child)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.children = append(parent.children, &node{text: string(token)})
+		}
+	}
+
+	return root, nil
+}
+
+// renderer writes Markdown, collapsing the white space of the text as
+// browsers do, except in preformatted blocks.
+type renderer struct {
+	out   strings.Builder
+	pre   bool
+	space bool
+}
+
+func (r *renderer) String() string {
+	lines := strings.Split(r.out.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+func (r *renderer) atLineStart() bool {
+	out := r.out.String()
+
+	return out == "" || strings.HasSuffix(out, "\n")
+}
+
+func (r *renderer) text(text string) {
+	if r.pre {
+		r.out.WriteString(text)
+		return
+	}
+
+	for _, c := range text {
+		if unicode.IsSpace(c) {
+			r.space = true
+			continue
+		}
+
+		r.flushSpace()
+		r.out.WriteRune(c)
+	}
+}
+
+// inline writes Markdown that is already rendered, keeping the white space
+// around it.
+func (r *renderer) inline(markdown string, before, after bool) {
+	if before {
+		r.space = true
+	}
+	if markdown != "" {
+		r.flushSpace()
+		r.out.WriteString(markdown)
+	}
+	if after {
+		r.space = true
+	}
+}
+
+func (r *renderer) flushSpace() {
+	if r.space && !r.atLineStart() {
+		r.out.WriteByte(' ')
+	}
+	r.space = false
+}
+
+func (r *renderer) line() {
+	r.space = false
+	if !r.atLineStart() {
+		r.out.WriteByte('\n')
+	}
+}
+
+func (r *renderer) block() {
+	r.line()
+	if r.out.Len() > 0 && !strings.HasSuffix(r.out.String(), "\n\n") {
+		r.out.WriteByte('\n')
+	}
+}
+
+func (r *renderer) children(n *node) {
+	for _, child := range n.children {
+		r.node(child)
+	}
+}
+
+// render renders the children of n on their own.
+func (r *renderer) render(n *node) string {
+	sub := &renderer{pre: r.pre}
+	sub.children(n)
+
+	return sub.String()
+}
+
+func (r *renderer) node(n *node) {
+	if n.name == "" {
+		r.text(n.text)
+		return
+	}
+
+	switch n.name {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		r.block()
+		r.out.WriteString(strings.Repeat("#", int(n.name[1]-'0')) + " " + strings.Join(strings.Fields(r.render(n)), " "))
+		r.block()
+	case "p":
+		r.block()
+		r.children(n)
+		r.block()
+	case "div", "en-note", "body":
+		r.line()
+		r.children(n)
+		r.line()
+	case "br":
+		r.space = false
+		r.out.WriteByte('\n')
+	case "hr":
+		r.block()
+		r.out.WriteString("---")
+		r.block()
+	case "b", "strong":
+		r.wrap(n, "**")
+	case "i", "em":
+		r.wrap(n, "_")
+	case "s", "strike", "del":
+		r.wrap(n, "~~")
+	case "code":
+		if r.pre {
+			r.children(n)
+		} else {
+			r.wrap(n, "`")
+		}
+	case "pre":
+		r.block()
+		sub := &renderer{pre: true}
+		sub.children(n)
+		r.out.WriteString("```\n" + strings.Trim(sub.out.String(), "\n") + "\n```")
+		r.block()
+	case "a":
+		text := r.render(n)
+		if href := n.attrs["href"]; href != "" && text != "" {
+			r.inline(fmt.Sprintf("[%s](%s)", text, href), false, false)
+		} else {
+			r.inline(text, false, false)
+		}
+	case "en-todo":
+		if n.attrs["checked"] == "true" {
+			r.inline("[x]", false, true)
+		} else {
+			r.inline("[ ]", false, true)
+		}
+	case "ul", "ol":
+		r.list(n)
+	case "blockquote":
+		r.block()
+		r.out.WriteString(prefixLines(r.render(n), "> ", ">"))
+		r.block()
+	case "table":
+		r.block()
+		r.table(n)
+		r.block()
+	case "en-media", "en-crypt", "img", "script", "style", "head", "title":
+	default:
+		r.children(n)
+	}
+}
+
+// wrap puts markers around the text of n, inside the white space around it.
+func (r *renderer) wrap(n *node, marker string) {
+	text := r.render(n)
+	if text == "" {
+		return
+	}
+
+	before, after := startsWithSpace(n), endsWithSpace(n)
+	r.inline(marker+text+marker, before, after)
+}
+
+func (r *renderer) list(n *node) {
+	r.block()
+
+	i := 0
+	for _, child := range n.children {
+		if child.name != "li" {
+			continue
+		}
+		i++
+
+		marker := "- "
+		if n.name == "ol" {
+			marker = fmt.Sprintf("%d. ", i)
+		}
+
+		indent := strings.Repeat(" ", len(marker))
+		r.line()
+		r.out.WriteString(marker + prefixLines(r.render(child), indent, "")[len(indent):])
+	}
+
+	r.block()
+}
+
+func (r *renderer) table(n *node) {
+	for _, row := range descendants(n, "tr") {
+		var cells []string
+		for _, cell := range row.children {
+			if cell.name == "td" || cell.name == "th" {
+				cells = append(cells, strings.Join(strings.Fields(r.render(cell)), " "))
+			}
+		}
+
+		r.line()
+		r.out.WriteString("| " + strings.Join(cells, " | ") + " |")
+	}
+}
+
+func descendants(n *node, name string) []*node {
+	var found []*node
+	for _, child := range n.children {
+		if child.name == name {
+			found = append(found, child)
+		} else {
+			found = append(found, descendants(child, name)...)
+		}
+	}
+
+	return found
+}
+
+func prefixLines(text string, prefix string, blank string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = blank
+		} else {
+			lines[i] = prefix + line
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func startsWithSpace(n *node) bool {
+	for n != nil {
+		if n.name == "" {
+			return strings.TrimLeftFunc(n.text, unicode.IsSpace) != n.text
+		}
+		if len(n.children) == 0 {
+			return false
+		}
+		n = n.children[0]
+	}
+
+	return false
+}
+
+func endsWithSpace(n *node) bool {
+	for n != nil {
+		if n.name == "" {
+			return strings.TrimRightFunc(n.text, unicode.IsSpace) != n.text
+		}
+		if len(n.children) == 0 {
+			return false
+		}
+		n = n.children[len(n.children)-1]
+	}
+
+	return false
+}
@@ -0,0 +1,115 @@
+package importer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/utils"
+)
+
+// The statuses of the notes of an import.
+const (
+	StatusCreated = "created"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+// Item is a note read from an import, with where it was read from. Items
+// that are not notes, like images in an archive, say why they are skipped,
+// and the ones that could not be read why they failed.
+type Item struct {
+	Source string
+	Note   models.Note
+	Skip   string
+	Err    error
+}
+
+// Import creates the notes of items for owner, one by one, and reports on
+// each of them. Notes with the name and content of one the owner already
+// has, or of an earlier item, are skipped as duplicates. Names are cleaned
+// of what the local backend cannot keep in a file name first. A dry run
+// reports what would be created without creating anything.
+func Import(ctx context.Context, db database.Database, owner string, items []Item, dryRun bool) ([]models.ImportResult, error) {
+	seen := map[string]string{}
+	err := db.EachNote(ctx, owner, func(note models.Note) error {
+		seen[fingerprint(note)] = "note " + note.Id
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]models.ImportResult, 0, len(items))
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		item.Note.Name = cleanName(item.Note.Name)
+		result := models.ImportResult{Source: item.Source, Name: item.Note.Name}
+		key := fingerprint(item.Note)
+
+		switch {
+		case item.Err != nil:
+			result.Status, result.Reason = StatusFailed, item.Err.Error()
+		case item.Skip != "":
+			result.Status, result.Reason = StatusSkipped, item.Skip
+		case !utils.IsSet(item.Note.Name):
+			result.Status, result.Reason = StatusFailed, "name must be set"
+		case utils.IsSet(item.Note.User.Username) && item.Note.User.Username != owner:
+			result.Status, result.Reason = StatusFailed, "user does not match the import"
+		case seen[key] != "":
+			result.Status, result.Reason = StatusSkipped, "duplicate of "+seen[key]
+		case dryRun:
+			result.Status = StatusCreated
+			seen[key] = item.Source
+		default:
+			note, err := create(ctx, db, owner, item.Note)
+			result.Id = note.Id
+			if err != nil {
+				result.Status, result.Reason = StatusFailed, err.Error()
+				break
+			}
+
+			result.Status = StatusCreated
+			seen[key] = "note " + note.Id
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// create stores the note for owner with a new id, keeping its archived
+// state, tags and times. Notes without times are stamped as created now.
+func create(ctx context.Context, db database.Database, owner string, note models.Note) (models.Note, error) {
+	note.Id, note.User = "", models.User{Username: owner}
+
+	created, _, err := db.PutNote(ctx, note)
+
+	return created, err
+}
+
+// cleanName replaces the underscores and slashes of name with dashes, and
+// runs of dots with one, since the local backend stores notes in files
+// named `<name>_<id>.txt`.
+func cleanName(name string) string {
+	name = strings.NewReplacer("_", "-", "/", "-", `\`, "-").Replace(name)
+	for strings.Contains(name, "..") {
+		name = strings.ReplaceAll(name, "..", ".")
+	}
+
+	return name
+}
+
+// fingerprint identifies notes by their name and content.
+func fingerprint(note models.Note) string {
+	sum := sha256.Sum256([]byte(note.Name + "\x00" + note.Content))
+
+	return hex.EncodeToString(sum[:])
+}
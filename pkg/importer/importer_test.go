@@ -0,0 +1,149 @@
+package importer_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/database/local"
+	"github.com/m-rcd/notes/pkg/importer"
+	"github.com/m-rcd/notes/pkg/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var ctx = context.Background()
+
+var _ = Describe("Import", func() {
+	var (
+		fakeDb  *databasefakes.FakeDatabase
+		created []models.Note
+	)
+
+	BeforeEach(func() {
+		fakeDb = new(databasefakes.FakeDatabase)
+		created = nil
+
+		fakeDb.EachNoteStub = func(_ context.Context, _ string, fn func(models.Note) error) error {
+			return fn(models.Note{Id: "1", Name: "Stakes", Content: "Pointy."})
+		}
+		fakeDb.PutNoteStub = func(_ context.Context, note models.Note) (models.Note, bool, error) {
+			created = append(created, note)
+			note.Id = "new"
+
+			return note, true, nil
+		}
+	})
+
+	items := []importer.Item{
+		{Source: "Stakes.md", Note: models.Note{Name: "Stakes", Content: "Pointy."}},
+		{Source: "Slayers.md", Note: models.Note{Name: "Slayers", Content: "One per generation."}},
+		{Source: "again/Slayers.md", Note: models.Note{Name: "Slayers", Content: "One per generation."}},
+		{Source: "photo.jpg", Skip: "not a Markdown or text file"},
+		{Source: "broken.md", Err: errors.New("front matter is not closed")},
+		{Source: "[5]", Note: models.Note{Content: "Nameless"}},
+		{Source: "[6]", Note: models.Note{Name: "Faith's", User: models.User{Username: "Faith"}}},
+	}
+
+	It("creates the new notes and reports on every item", func() {
+		results, err := importer.Import(ctx, fakeDb, "Buffy", items, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(Equal([]models.ImportResult{
+			{Source: "Stakes.md", Status: importer.StatusSkipped, Name: "Stakes", Reason: "duplicate of note 1"},
+			{Source: "Slayers.md", Status: importer.StatusCreated, Id: "new", Name: "Slayers"},
+			{Source: "again/Slayers.md", Status: importer.StatusSkipped, Name: "Slayers", Reason: "duplicate of note new"},
+			{Source: "photo.jpg", Status: importer.StatusSkipped, Reason: "not a Markdown or text file"},
+			{Source: "broken.md", Status: importer.StatusFailed, Reason: "front matter is not closed"},
+			{Source: "[5]", Status: importer.StatusFailed, Reason: "name must be set"},
+			{Source: "[6]", Status: importer.StatusFailed, Name: "Faith's", Reason: "user does not match the import"},
+		}))
+		Expect(created).To(Equal([]models.Note{{Name: "Slayers", Content: "One per generation.", User: models.User{Username: "Buffy"}}}))
+	})
+
+	It("only reports what would be created on dry runs", func() {
+		results, err := importer.Import(ctx, fakeDb, "Buffy", items[:3], true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[1]).To(Equal(models.ImportResult{Source: "Slayers.md", Status: importer.StatusCreated, Name: "Slayers"}))
+		Expect(results[2].Reason).To(Equal("duplicate of Slayers.md"))
+		Expect(fakeDb.PutNoteCallCount()).To(BeZero())
+	})
+
+	It("keeps the archived state, tags and times of the notes, but not their ids", func() {
+		note := models.Note{Id: "7", Name: "Old", Archived: true, Tags: []string{"sunnydale"},
+			Created: time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC), Updated: time.Date(2020, 5, 2, 11, 30, 0, 0, time.UTC)}
+
+		results, err := importer.Import(ctx, fakeDb, "Buffy", []importer.Item{{Source: "Old.md", Note: note}}, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(Equal([]models.ImportResult{{Source: "Old.md", Status: importer.StatusCreated, Id: "new", Name: "Old"}}))
+
+		note.Id, note.User = "", models.User{Username: "Buffy"}
+		Expect(created).To(Equal([]models.Note{note}))
+		Expect(fakeDb.SetArchivedCallCount()).To(BeZero())
+	})
+
+	It("reports notes that could not be created", func() {
+		fakeDb.PutNoteStub = nil
+		fakeDb.PutNoteReturns(models.Note{}, false, errors.New("name is too long"))
+
+		results, err := importer.Import(ctx, fakeDb, "Buffy", items[1:2], false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(Equal([]models.ImportResult{{Source: "Slayers.md", Status: importer.StatusFailed, Name: "Slayers", Reason: "name is too long"}}))
+	})
+
+	It("cleans the names of what the local backend cannot keep in a file name", func() {
+		results, err := importer.Import(ctx, fakeDb, "Buffy", []importer.Item{
+			{Source: "[0]", Note: models.Note{Name: "Slayer_handbook"}},
+			{Source: "[1]", Note: models.Note{Name: "Sunnydale/Hellmouth"}},
+			{Source: "[2]", Note: models.Note{Name: `Watchers\Council`}},
+			{Source: "[3]", Note: models.Note{Name: "../Chapter...2"}},
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		var names []string
+		for _, result := range results {
+			Expect(result.Status).To(Equal(importer.StatusCreated))
+			names = append(names, result.Name)
+		}
+		Expect(names).To(Equal([]string{"Slayer-handbook", "Sunnydale-Hellmouth", "Watchers-Council", ".-Chapter.2"}))
+		Expect(created[0].Name).To(Equal("Slayer-handbook"))
+	})
+
+	It("fails when the existing notes cannot be read", func() {
+		fakeDb.EachNoteStub = nil
+		fakeDb.EachNoteReturns(errors.New("disk on fire"))
+
+		_, err := importer.Import(ctx, fakeDb, "Buffy", items, false)
+		Expect(err).To(MatchError("disk on fire"))
+		Expect(fakeDb.PutNoteCallCount()).To(BeZero())
+	})
+})
+
+// importLocally imports items for Buffy into a local backend and lists the
+// notes it ends up with.
+func importLocally(items []importer.Item) []models.Note {
+	dir, err := ioutil.TempDir("", "importer_test")
+	Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	db := local.NewLocalFileSystem(dir)
+	Expect(db.Open()).To(Succeed())
+	defer db.Close()
+
+	results, err := importer.Import(ctx, db, "Buffy", items, false)
+	Expect(err).NotTo(HaveOccurred())
+	for _, result := range results {
+		Expect(result.Reason).To(BeEmpty())
+	}
+
+	var notes []models.Note
+	Expect(db.EachNote(ctx, "Buffy", func(note models.Note) error {
+		notes = append(notes, note)
+		return nil
+	})).To(Succeed())
+
+	return notes
+}
@@ -0,0 +1,115 @@
+package importer
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// maxTitle bounds the names made from the first line of untitled notes.
+const maxTitle = 50
+
+type keepNote struct {
+	Title       string `json:"title"`
+	TextContent string `json:"textContent"`
+	ListContent []struct {
+		Text      string `json:"text"`
+		IsChecked bool   `json:"isChecked"`
+	} `json:"listContent"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	IsArchived              bool  `json:"isArchived"`
+	IsTrashed               bool  `json:"isTrashed"`
+	CreatedTimestampUsec    int64 `json:"createdTimestampUsec"`
+	UserEditedTimestampUsec int64 `json:"userEditedTimestampUsec"`
+}
+
+// readKeepFile reads a note of a Google Takeout archive. Keep writes every
+// note as a JSON file, next to an HTML copy of it and its attachments, which
+// are ignored.
+func readKeepFile(name string, r io.Reader) (Item, bool) {
+	if strings.ToLower(path.Ext(name)) != ".json" {
+		return Item{}, false
+	}
+
+	item := Item{Source: name}
+
+	data, err := readText(r)
+	if err != nil {
+		item.Err = err
+		return item, true
+	}
+
+	var note keepNote
+	if err := json.Unmarshal(data, &note); err != nil {
+		item.Err = err
+		return item, true
+	}
+
+	if note.IsTrashed {
+		item.Skip = "in the trash"
+		return item, true
+	}
+
+	content := note.TextContent
+	if len(note.ListContent) > 0 {
+		lines := make([]string, 0, len(note.ListContent))
+		for _, entry := range note.ListContent {
+			box := "[ ]"
+			if entry.IsChecked {
+				box = "[x]"
+			}
+			lines = append(lines, "- "+box+" "+entry.Text)
+		}
+		content = strings.Join(lines, "\n")
+	}
+
+	var labels []string
+	for _, label := range note.Labels {
+		labels = append(labels, label.Name)
+	}
+
+	item.Note = models.Note{
+		Name:     keepName(note, content, name),
+		Content:  content,
+		Archived: note.IsArchived,
+		Tags:     labels,
+		Created:  fromUsec(note.CreatedTimestampUsec),
+		Updated:  fromUsec(note.UserEditedTimestampUsec),
+	}
+
+	return item, true
+}
+
+// keepName names a note after its title, or the first line of untitled ones.
+func keepName(note keepNote, content string, file string) string {
+	if title := strings.TrimSpace(note.Title); title != "" {
+		return title
+	}
+
+	line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(content), "\n", 2)[0])
+	line = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(line, "- "), "[ ]"), "[x]"))
+	if line == "" {
+		return strings.TrimSuffix(path.Base(file), path.Ext(file))
+	}
+
+	if utf8.RuneCountInString(line) > maxTitle {
+		line = strings.TrimSpace(string([]rune(line)[:maxTitle])) + "…"
+	}
+
+	return line
+}
+
+func fromUsec(usec int64) time.Time {
+	if usec == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, usec*int64(time.Microsecond)).UTC()
+}
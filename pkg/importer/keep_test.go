@@ -0,0 +1,106 @@
+package importer_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/importer"
+	"github.com/m-rcd/notes/pkg/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Keep", func() {
+	files := map[string]string{
+		"Takeout/Keep/Patrol.json": `{"title":"Patrol","textContent":"Check the cemetery.","labels":[{"name":"Sunnydale"}],
+			"isArchived":true,"isTrashed":false,"createdTimestampUsec":1588327200000000,"userEditedTimestampUsec":1588419000000000}`,
+		"Takeout/Keep/Patrol.html":     "<html></html>",
+		"Takeout/Keep/Shopping.json":   `{"title":"","textContent":"","listContent":[{"text":"Stakes","isChecked":true},{"text":"Holy water","isChecked":false}]}`,
+		"Takeout/Keep/Old.json":        `{"title":"Old","textContent":"Gone.","isTrashed":true}`,
+		"Takeout/Keep/Labels.json":     `{"labels":[{"name":"Sunnydale"}]}`,
+		"Takeout/Keep/Broken.json":     `{"title":`,
+		"Takeout/Keep/photo.png":       "\x89PNG",
+		"Takeout/archive_browser.html": "<html></html>",
+	}
+
+	It("reads the JSON notes of a Takeout archive", func() {
+		var buf bytes.Buffer
+		archive := zip.NewWriter(&buf)
+		for name, content := range files {
+			file, err := archive.Create(name)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = file.Write([]byte(content))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(archive.Close()).To(Succeed())
+
+		items, err := importer.Read(&buf, importer.FormatKeep, importer.MediaZip)
+		Expect(err).NotTo(HaveOccurred())
+
+		var read []importer.Item
+		for _, item := range items {
+			if item.Source == "Takeout/Keep/Broken.json" {
+				Expect(item.Err).To(MatchError("unexpected end of JSON input"))
+				continue
+			}
+
+			read = append(read, item)
+		}
+
+		Expect(read).To(ConsistOf(
+			importer.Item{Source: "Takeout/Keep/Patrol.json", Note: models.Note{
+				Name:     "Patrol",
+				Content:  "Check the cemetery.",
+				Archived: true,
+				Tags:     []string{"Sunnydale"},
+				Created:  time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC),
+				Updated:  time.Date(2020, 5, 2, 11, 30, 0, 0, time.UTC),
+			}},
+			importer.Item{Source: "Takeout/Keep/Shopping.json", Note: models.Note{Name: "Stakes", Content: "- [x] Stakes\n- [ ] Holy water"}},
+			importer.Item{Source: "Takeout/Keep/Old.json", Skip: "in the trash"},
+			importer.Item{Source: "Takeout/Keep/Labels.json", Note: models.Note{Name: "Labels", Tags: []string{"Sunnydale"}}},
+		))
+		Expect(items).To(HaveLen(5))
+	})
+
+	It("names untitled notes after their first line", func() {
+		var buf bytes.Buffer
+		archive := zip.NewWriter(&buf)
+		file, err := archive.Create("Keep/long.json")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.Write([]byte(`{"textContent":"` + strings.Repeat("a", 60) + `\nmore"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archive.Close()).To(Succeed())
+
+		items, err := importer.Read(&buf, importer.FormatKeep, importer.MediaZip)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(items[0].Note.Name).To(Equal(strings.Repeat("a", 50) + "…"))
+	})
+
+	It("imports notes whose titles the local backend cannot keep in a file name", func() {
+		var buf bytes.Buffer
+		archive := zip.NewWriter(&buf)
+		for i, title := range []string{"Slayer_handbook", "Sunnydale/Hellmouth", `Watchers\\Council`, "../Chapter..2"} {
+			file, err := archive.Create(fmt.Sprintf("Takeout/Keep/%d.json", i))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = file.Write([]byte(`{"title":"` + title + `","textContent":"Research."}`))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(archive.Close()).To(Succeed())
+
+		items, err := importer.Read(&buf, importer.FormatKeep, importer.MediaZip)
+		Expect(err).NotTo(HaveOccurred())
+
+		notes := importLocally(items)
+		Expect(notes).To(ConsistOf(
+			HaveField("Name", "Slayer-handbook"),
+			HaveField("Name", "Sunnydale-Hellmouth"),
+			HaveField("Name", "Watchers-Council"),
+			HaveField("Name", ".-Chapter.2"),
+		))
+	})
+})
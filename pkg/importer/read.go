@@ -0,0 +1,328 @@
+package importer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/m-rcd/notes/pkg/markdown"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/utils"
+)
+
+// The formats an import can be in.
+const (
+	// FormatMarkdown is an archive of Markdown and text files.
+	FormatMarkdown = "markdown"
+	// FormatJSON is a JSON array of notes.
+	FormatJSON = "json"
+	// FormatENEX is an Evernote export.
+	FormatENEX = "enex"
+	// FormatKeep is a Google Takeout archive of Keep notes.
+	FormatKeep = "keep"
+)
+
+// The media types an import can have.
+const (
+	MediaZip     = "application/zip"
+	MediaTar     = "application/x-tar"
+	MediaTarGzip = "application/gzip"
+	MediaJSON    = "application/json"
+	MediaXML     = "application/xml"
+)
+
+const (
+	// MaxSize bounds the size of an import as it is uploaded.
+	MaxSize = 32 << 20
+	// MaxItems bounds how many notes an import can have.
+	MaxItems = 10000
+	// MaxNoteSize bounds the size of each file of an archive, so that a
+	// small archive cannot expand into huge notes.
+	MaxNoteSize = 1 << 20
+)
+
+var (
+	// ErrUnsupported is returned for imports of other formats or media
+	// types.
+	ErrUnsupported = errors.New("unsupported import")
+	// ErrInvalid is returned for imports that cannot be read at all.
+	ErrInvalid = errors.New("invalid import")
+)
+
+// fileReader reads a file of an archive into a note, and returns false for
+// the files that are not meant to be notes.
+type fileReader func(name string, r io.Reader) (Item, bool)
+
+// Read parses an import in format, or the format usual for its media type
+// when format is empty:
+//   - markdown, a ZIP or tarball of Markdown and text files, named after
+//     their note unless their front matter says otherwise. The ones in an
+//     archived/ folder are archived notes.
+//   - json, a JSON array of notes.
+//   - enex, an XML export of Evernote notes.
+//   - keep, a ZIP or tarball of Google Keep notes from Google Takeout.
+func Read(r io.Reader, format string, mediaType string) ([]Item, error) {
+	if format == "" {
+		format = formatOf(mediaType)
+	}
+
+	var (
+		items []Item
+		err   error
+	)
+
+	switch format {
+	case FormatMarkdown:
+		items, err = readArchive(r, mediaType, format, readFile)
+	case FormatKeep:
+		items, err = readArchive(r, mediaType, format, readKeepFile)
+	case FormatJSON:
+		if mediaType != MediaJSON {
+			return nil, unsupported(mediaType, format, MediaJSON)
+		}
+		items, err = readJSON(r)
+	case FormatENEX:
+		if !isXML(mediaType) {
+			return nil, unsupported(mediaType, format, MediaXML)
+		}
+		items, err = readENEX(r)
+	default:
+		return nil, fmt.Errorf("%w format %q, expected %s, %s, %s or %s", ErrUnsupported, format, FormatMarkdown, FormatJSON, FormatENEX, FormatKeep)
+	}
+	if errors.Is(err, ErrUnsupported) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+
+	if len(items) > MaxItems {
+		return nil, fmt.Errorf("%w: an import can have at most %d notes", ErrInvalid, MaxItems)
+	}
+
+	return items, nil
+}
+
+// MediaTypeOf guesses the media type of an import from the name of its
+// file.
+func MediaTypeOf(name string) string {
+	name = strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return MediaZip
+	case strings.HasSuffix(name, ".tar"):
+		return MediaTar
+	case strings.HasSuffix(name, ".tgz"), strings.HasSuffix(name, ".tar.gz"):
+		return MediaTarGzip
+	case strings.HasSuffix(name, ".json"):
+		return MediaJSON
+	case strings.HasSuffix(name, ".enex"), strings.HasSuffix(name, ".xml"):
+		return MediaXML
+	}
+
+	return ""
+}
+
+func formatOf(mediaType string) string {
+	switch {
+	case mediaType == MediaJSON:
+		return FormatJSON
+	case isXML(mediaType):
+		return FormatENEX
+	}
+
+	return FormatMarkdown
+}
+
+func isXML(mediaType string) bool {
+	return mediaType == MediaXML || mediaType == "text/xml" || mediaType == "application/enex+xml"
+}
+
+func unsupported(mediaType string, format string, expected ...string) error {
+	list := expected[len(expected)-1]
+	if len(expected) > 1 {
+		list = strings.Join(expected[:len(expected)-1], ", ") + " or " + list
+	}
+
+	return fmt.Errorf("%w %q for the %s format, expected %s", ErrUnsupported, mediaType, format, list)
+}
+
+func readArchive(r io.Reader, mediaType string, format string, file fileReader) ([]Item, error) {
+	switch mediaType {
+	case MediaZip:
+		return readZip(r, file)
+	case MediaTar:
+		return readTar(r, file)
+	case MediaTarGzip, "application/x-gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return readTar(gz, file)
+	}
+
+	return nil, unsupported(mediaType, format, MediaZip, MediaTar, MediaTarGzip)
+}
+
+func readZip(r io.Reader, read fileReader) ([]Item, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	items := []Item{}
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || ignored(file.Name) {
+			continue
+		}
+
+		content, err := file.Open()
+		if err != nil {
+			items = append(items, Item{Source: file.Name, Err: err})
+			continue
+		}
+
+		item, ok := read(file.Name, content)
+		content.Close()
+		if ok {
+			items = append(items, item)
+		}
+
+		if len(items) > MaxItems {
+			break
+		}
+	}
+
+	return items, nil
+}
+
+func readTar(r io.Reader, read fileReader) ([]Item, error) {
+	archive := tar.NewReader(r)
+
+	items := []Item{}
+	for len(items) <= MaxItems {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg || ignored(header.Name) {
+			continue
+		}
+
+		if item, ok := read(header.Name, archive); ok {
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+func readJSON(r io.Reader) ([]Item, error) {
+	var notes []models.Note
+	if err := json.NewDecoder(r).Decode(&notes); err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(notes))
+	for i, note := range notes {
+		note.Id = ""
+		items = append(items, Item{Source: fmt.Sprintf("[%d]", i), Note: note})
+	}
+
+	return items, nil
+}
+
+func readFile(name string, r io.Reader) (Item, bool) {
+	item := Item{Source: name}
+
+	extension := path.Ext(name)
+	switch strings.ToLower(extension) {
+	case ".md", ".markdown", ".txt":
+	default:
+		item.Skip = "not a Markdown or text file"
+		return item, true
+	}
+
+	data, err := readText(r)
+	if err != nil {
+		item.Err = err
+		return item, true
+	}
+
+	front, content, found, err := markdown.Decode(data)
+	if err != nil {
+		item.Err = err
+		return item, true
+	}
+
+	item.Note = models.Note{Name: front.Name, Content: content, Archived: inArchived(name), Tags: front.Tags, Created: front.Created, Updated: front.Updated}
+	if found {
+		item.Note.Archived = front.Archived
+	}
+	if !utils.IsSet(item.Note.Name) {
+		item.Note.Name = strings.TrimSuffix(path.Base(name), extension)
+	}
+
+	return item, true
+}
+
+// readText reads a file of an archive, which has to be UTF-8 text of at
+// most MaxNoteSize bytes.
+func readText(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxNoteSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > MaxNoteSize {
+		return nil, fmt.Errorf("the file is larger than %d bytes", MaxNoteSize)
+	}
+
+	if !utf8.Valid(data) {
+		return nil, errors.New("the file is not UTF-8 text")
+	}
+
+	return data, nil
+}
+
+// ignored tells the files archivers leave behind, like macOS metadata, from
+// the notes.
+func ignored(name string) bool {
+	for _, part := range strings.Split(path.Clean(name), "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func inArchived(name string) bool {
+	for _, dir := range strings.Split(path.Dir(path.Clean(name)), "/") {
+		if dir == "archived" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,149 @@
+package importer_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/importer"
+	"github.com/m-rcd/notes/pkg/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Read", func() {
+	files := map[string]string{
+		"notes/Stakes.md":              "Pointy.",
+		"notes/archived/Hellmouth.txt": "Below the library.",
+		"notes/renamed.md":             "---\nname: Slayers\narchived: true\ntags: [calling]\ncreated: 2020-05-01T10:00:00Z\n---\nOne per generation.",
+		"notes/archived/Restored.md":   "---\nname: Restored\n---\nBack again.",
+		"notes/broken.md":              "---\nname: Broken\n",
+		"notes/photo.jpg":              "\xff\xd8",
+		"__MACOSX/notes/._Stakes.md":   "metadata",
+		"notes/.DS_Store":              "metadata",
+	}
+
+	expected := []importer.Item{
+		{Source: "notes/Stakes.md", Note: models.Note{Name: "Stakes", Content: "Pointy."}},
+		{Source: "notes/archived/Hellmouth.txt", Note: models.Note{Name: "Hellmouth", Content: "Below the library.", Archived: true}},
+		{Source: "notes/renamed.md", Note: models.Note{Name: "Slayers", Content: "One per generation.", Archived: true,
+			Tags: []string{"calling"}, Created: time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)}},
+		{Source: "notes/archived/Restored.md", Note: models.Note{Name: "Restored", Content: "Back again."}},
+		{Source: "notes/photo.jpg", Skip: "not a Markdown or text file"},
+	}
+
+	check := func(items []importer.Item) {
+		var read []importer.Item
+		for _, item := range items {
+			if item.Source == "notes/broken.md" {
+				Expect(item.Err).To(MatchError(`front matter is not closed by "---"`))
+				continue
+			}
+
+			read = append(read, item)
+		}
+
+		Expect(read).To(ConsistOf(expected))
+		Expect(items).To(HaveLen(len(expected) + 1))
+	}
+
+	It("reads the files of a ZIP", func() {
+		var buf bytes.Buffer
+		archive := zip.NewWriter(&buf)
+		for name, content := range files {
+			file, err := archive.Create(name)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = file.Write([]byte(content))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := archive.Create("notes/empty/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archive.Close()).To(Succeed())
+
+		items, err := importer.Read(&buf, "", importer.MediaZip)
+		Expect(err).NotTo(HaveOccurred())
+		check(items)
+	})
+
+	It("reads the files of a gzipped tarball", func() {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		archive := tar.NewWriter(gz)
+		for name, content := range files {
+			Expect(archive.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg})).To(Succeed())
+			_, err := archive.Write([]byte(content))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(archive.Close()).To(Succeed())
+		Expect(gz.Close()).To(Succeed())
+
+		items, err := importer.Read(&buf, "", importer.MediaTarGzip)
+		Expect(err).NotTo(HaveOccurred())
+		for i := range items {
+			items[i].Source = strings.TrimPrefix(items[i].Source, "./")
+		}
+		check(items)
+	})
+
+	It("fails the files that are too large or not text", func() {
+		var buf bytes.Buffer
+		archive := tar.NewWriter(&buf)
+		for name, content := range map[string]string{"big.md": strings.Repeat("a", importer.MaxNoteSize+1), "binary.txt": "\xff\xfe"} {
+			Expect(archive.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg})).To(Succeed())
+			_, err := archive.Write([]byte(content))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(archive.Close()).To(Succeed())
+
+		items, err := importer.Read(&buf, "", importer.MediaTar)
+		Expect(err).NotTo(HaveOccurred())
+		reasons := map[string]string{}
+		for _, item := range items {
+			reasons[item.Source] = item.Err.Error()
+		}
+		Expect(reasons).To(Equal(map[string]string{
+			"big.md":     "the file is larger than 1048576 bytes",
+			"binary.txt": "the file is not UTF-8 text",
+		}))
+	})
+
+	It("reads a JSON array of notes, without their ids", func() {
+		items, err := importer.Read(strings.NewReader(`[{"id":"7","name":"Stakes","content":"Pointy.","archived":true}]`), "", importer.MediaJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(items).To(Equal([]importer.Item{{Source: "[0]", Note: models.Note{Name: "Stakes", Content: "Pointy.", Archived: true}}}))
+	})
+
+	It("rejects imports it cannot read", func() {
+		_, err := importer.Read(strings.NewReader(`{}`), "", importer.MediaJSON)
+		Expect(err).To(MatchError(importer.ErrInvalid))
+
+		_, err = importer.Read(strings.NewReader(`not a zip`), "", importer.MediaZip)
+		Expect(err).To(MatchError(importer.ErrInvalid))
+
+		_, err = importer.Read(strings.NewReader(`<xml/>`), "", importer.MediaXML)
+		Expect(err).To(MatchError(importer.ErrInvalid))
+	})
+
+	It("rejects formats and media types it does not know", func() {
+		_, err := importer.Read(strings.NewReader(`a,b`), "", "text/csv")
+		Expect(err).To(MatchError(`unsupported import "text/csv" for the markdown format, expected application/zip, application/x-tar or application/gzip`))
+
+		_, err = importer.Read(strings.NewReader(`[]`), importer.FormatENEX, importer.MediaJSON)
+		Expect(err).To(MatchError(`unsupported import "application/json" for the enex format, expected application/xml`))
+
+		_, err = importer.Read(strings.NewReader(`[]`), "onenote", importer.MediaJSON)
+		Expect(err).To(MatchError(`unsupported import format "onenote", expected markdown, json, enex or keep`))
+		Expect(err).To(MatchError(importer.ErrUnsupported))
+	})
+
+	It("guesses media types from file names", func() {
+		Expect(importer.MediaTypeOf("notes.ZIP")).To(Equal(importer.MediaZip))
+		Expect(importer.MediaTypeOf("notes.tar.gz")).To(Equal(importer.MediaTarGzip))
+		Expect(importer.MediaTypeOf("Evernote.enex")).To(Equal(importer.MediaXML))
+		Expect(importer.MediaTypeOf("notes.csv")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,158 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	uuid "github.com/nu7hatch/gouuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/utils"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey struct{}
+
+type requestInfo struct {
+	entry *logrus.Entry
+	user  string
+}
+
+// Configure sets up the standard logger. The format is either `json` or
+// `logfmt`.
+func Configure(logger *logrus.Logger, format, level string) error {
+	switch format {
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case "logfmt":
+		logger.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logger.SetLevel(lvl)
+
+	return nil
+}
+
+// FromContext returns a logger carrying the request id of the request being
+// served, or the standard logger outside of a request.
+func FromContext(ctx context.Context) *logrus.Entry {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		return info.entry
+	}
+
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// SetUser records the user a request was made for, for when it is only known
+// once the request body has been read.
+func SetUser(ctx context.Context, username string) {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok && utils.IsSet(username) {
+		info.user = username
+	}
+}
+
+func Middleware(logger *logrus.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			requestID := r.Header.Get(RequestIDHeader)
+			if !utils.IsSet(requestID) {
+				requestID = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, requestID)
+
+			info := &requestInfo{entry: logger.WithField("request_id", requestID)}
+			if user, ok := auth.UserFromContext(r.Context()); ok {
+				info.user = user.Username
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), contextKey{}, info)))
+
+			info.entry.WithFields(logrus.Fields{
+				"method":     r.Method,
+				"route":      routeTemplate(r),
+				"path":       r.URL.Path,
+				"status":     recorder.status,
+				"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+				"bytes":      recorder.bytes,
+				"user":       info.user,
+			}).Info("request served")
+		})
+	}
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection, for handlers
+// that extend their write deadline.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Flush lets streamed responses through.
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets connections be upgraded to WebSockets.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	return hijacker.Hijack()
+}
+
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+
+	return template
+}
+
+func newRequestID() string {
+	id, _ := uuid.NewV4()
+
+	return id.String()
+}
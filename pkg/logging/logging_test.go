@@ -0,0 +1,107 @@
+package logging_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/logging"
+	"github.com/m-rcd/notes/pkg/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logging", func() {
+	var (
+		logger *logrus.Logger
+		hook   *test.Hook
+		router *mux.Router
+	)
+
+	BeforeEach(func() {
+		logger, hook = test.NewNullLogger()
+		router = mux.NewRouter()
+		router.Use(logging.Middleware(logger))
+		router.HandleFunc("/note/{id}", func(w http.ResponseWriter, r *http.Request) {
+			logging.FromContext(r.Context()).WithError(errors.New("Not updated")).Error("failed to update note")
+			logging.SetUser(r.Context(), "Buffy")
+			w.WriteHeader(http.StatusTeapot)
+			w.Write([]byte("short and stout"))
+		}).Methods("PATCH")
+	})
+
+	Context("Middleware", func() {
+		It("logs the request with a generated request id", func() {
+			r := httptest.NewRecorder()
+			req, err := http.NewRequest("PATCH", "http://localhost:10000/note/1", bytes.NewBufferString(`{}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			router.ServeHTTP(r, req)
+
+			requestID := r.Header().Get(logging.RequestIDHeader)
+			Expect(requestID).NotTo(BeEmpty())
+			Expect(hook.Entries).To(HaveLen(2))
+
+			storageError := hook.Entries[0]
+			Expect(storageError.Level).To(Equal(logrus.ErrorLevel))
+			Expect(storageError.Data).To(HaveKeyWithValue("request_id", requestID))
+
+			access := hook.LastEntry()
+			Expect(access.Message).To(Equal("request served"))
+			Expect(access.Data).To(HaveKeyWithValue("request_id", requestID))
+			Expect(access.Data).To(HaveKeyWithValue("method", "PATCH"))
+			Expect(access.Data).To(HaveKeyWithValue("route", "/note/{id}"))
+			Expect(access.Data).To(HaveKeyWithValue("path", "/note/1"))
+			Expect(access.Data).To(HaveKeyWithValue("status", http.StatusTeapot))
+			Expect(access.Data).To(HaveKeyWithValue("bytes", len("short and stout")))
+			Expect(access.Data).To(HaveKeyWithValue("user", "Buffy"))
+			Expect(access.Data).To(HaveKey("latency_ms"))
+		})
+
+		It("propagates the request id sent by the client", func() {
+			r := httptest.NewRecorder()
+			req, err := http.NewRequest("PATCH", "http://localhost:10000/note/1", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set(logging.RequestIDHeader, "abc-123")
+
+			router.ServeHTTP(r, req)
+
+			Expect(r.Header().Get(logging.RequestIDHeader)).To(Equal("abc-123"))
+			Expect(hook.LastEntry().Data).To(HaveKeyWithValue("request_id", "abc-123"))
+		})
+
+		It("records the authenticated user", func() {
+			router = mux.NewRouter()
+			router.Use(logging.Middleware(logger))
+			router.HandleFunc("/notes/active", func(w http.ResponseWriter, r *http.Request) {})
+
+			r := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "http://localhost:10000/notes/active", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req = req.WithContext(auth.WithUser(req.Context(), models.User{Username: "Willow"}))
+
+			router.ServeHTTP(r, req)
+
+			Expect(hook.LastEntry().Data).To(HaveKeyWithValue("user", "Willow"))
+			Expect(hook.LastEntry().Data).To(HaveKeyWithValue("status", http.StatusOK))
+		})
+	})
+
+	Context("Configure", func() {
+		It("rejects unknown formats", func() {
+			Expect(logging.Configure(logger, "xml", "info")).To(MatchError(`unknown log format "xml"`))
+		})
+
+		It("sets the level", func() {
+			Expect(logging.Configure(logger, "json", "debug")).To(Succeed())
+			Expect(logger.GetLevel()).To(Equal(logrus.DebugLevel))
+			Expect(logger.Formatter).To(BeAssignableToTypeOf(&logrus.JSONFormatter{}))
+		})
+	})
+})
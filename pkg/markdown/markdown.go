@@ -0,0 +1,77 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+const delimiter = "---\n"
+
+// FrontMatter is what a Markdown file says about its note besides the
+// content.
+type FrontMatter struct {
+	Id       string    `yaml:"id,omitempty"`
+	Name     string    `yaml:"name,omitempty"`
+	Archived bool      `yaml:"archived"`
+	Tags     []string  `yaml:"tags,omitempty"`
+	Created  time.Time `yaml:"created,omitempty"`
+	Updated  time.Time `yaml:"updated,omitempty"`
+}
+
+// Encode renders the note as Markdown, its content after a YAML front
+// matter.
+func Encode(note models.Note) ([]byte, error) {
+	front, err := yaml.Marshal(FrontMatter{
+		Id:       note.Id,
+		Name:     note.Name,
+		Archived: note.Archived,
+		Tags:     note.Tags,
+		Created:  note.Created,
+		Updated:  note.Updated,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(delimiter)
+	out.Write(front)
+	out.WriteString(delimiter)
+	out.WriteString(note.Content)
+
+	return out.Bytes(), nil
+}
+
+// Decode splits a Markdown file into its front matter and content. Files
+// without a front matter are all content, and found is false for them.
+func Decode(data []byte) (front FrontMatter, content string, found bool, err error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if !strings.HasPrefix(text, delimiter) {
+		return FrontMatter{}, string(data), false, nil
+	}
+
+	rest := text[len(delimiter):]
+	end := strings.Index(rest, "\n"+delimiter)
+	switch {
+	case strings.HasPrefix(rest, delimiter):
+		end, content = 0, rest[len(delimiter):]
+	case end >= 0:
+		content = rest[end+1+len(delimiter):]
+	case strings.HasSuffix(rest, "\n---"):
+		end = len(rest) - len("\n---")
+	default:
+		return FrontMatter{}, "", false, fmt.Errorf("front matter is not closed by %q", strings.TrimSpace(delimiter))
+	}
+
+	if err := yaml.Unmarshal([]byte(rest[:end]), &front); err != nil {
+		return FrontMatter{}, "", false, fmt.Errorf("invalid front matter: %w", err)
+	}
+
+	return front, content, true, nil
+}
@@ -0,0 +1,74 @@
+package markdown_test
+
+import (
+	"time"
+
+	"github.com/m-rcd/notes/pkg/markdown"
+	"github.com/m-rcd/notes/pkg/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Markdown", func() {
+	Context("Encode", func() {
+		It("puts the content after the front matter", func() {
+			note := models.Note{Id: "1", Name: "Vampires: a guide", Content: "# Stakes\n\nPointy.", Archived: true, User: models.User{Username: "Buffy"}}
+
+			out, err := markdown.Encode(note)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(out)).To(Equal("---\nid: \"1\"\nname: 'Vampires: a guide'\narchived: true\n---\n# Stakes\n\nPointy."))
+		})
+
+		It("puts the tags and times in the front matter", func() {
+			note := models.Note{Id: "1", Name: "Stakes", Content: "Pointy.", Tags: []string{"slaying", "wood"},
+				Created: time.Date(1997, 3, 10, 20, 0, 0, 0, time.UTC), Updated: time.Date(2003, 5, 20, 21, 30, 0, 0, time.UTC)}
+
+			out, err := markdown.Encode(note)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(out)).To(Equal("---\nid: \"1\"\nname: Stakes\narchived: false\ntags:\n- slaying\n- wood\ncreated: 1997-03-10T20:00:00Z\nupdated: 2003-05-20T21:30:00Z\n---\nPointy."))
+		})
+	})
+
+	Context("Decode", func() {
+		It("reads back what Encode wrote", func() {
+			created := time.Date(1997, 3, 10, 20, 0, 0, 123, time.UTC)
+			out, err := markdown.Encode(models.Note{Id: "1", Name: "Vampires: a guide", Content: "---\nPointy.\n", Archived: true, Tags: []string{"slaying"}, Created: created, Updated: created})
+			Expect(err).NotTo(HaveOccurred())
+
+			front, content, found, err := markdown.Decode(out)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(front).To(Equal(markdown.FrontMatter{Id: "1", Name: "Vampires: a guide", Archived: true, Tags: []string{"slaying"}, Created: created, Updated: created}))
+			Expect(content).To(Equal("---\nPointy.\n"))
+		})
+
+		It("reads files written on Windows and empty front matters", func() {
+			front, content, found, err := markdown.Decode([]byte("---\r\nname: Stakes\r\n---\r\nPointy."))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(front.Name).To(Equal("Stakes"))
+			Expect(content).To(Equal("Pointy."))
+
+			_, content, found, err = markdown.Decode([]byte("---\n---\nPointy."))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(content).To(Equal("Pointy."))
+		})
+
+		It("takes files without a front matter as all content", func() {
+			_, content, found, err := markdown.Decode([]byte("# Stakes\n---\nPointy."))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+			Expect(content).To(Equal("# Stakes\n---\nPointy."))
+		})
+
+		It("fails on front matters that are not closed or not YAML", func() {
+			_, _, _, err := markdown.Decode([]byte("---\nname: Stakes\nPointy."))
+			Expect(err).To(MatchError(`front matter is not closed by "---"`))
+
+			_, _, _, err = markdown.Decode([]byte("---\nname: [Stakes\n---\n"))
+			Expect(err).To(MatchError(HavePrefix("invalid front matter: ")))
+		})
+	})
+})
@@ -0,0 +1,277 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+type instrumentedDatabase struct {
+	db       database.Database
+	backend  string
+	duration *prometheus.HistogramVec
+}
+
+func (i *instrumentedDatabase) observe(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	i.duration.WithLabelValues(i.backend, operation, result).Observe(time.Since(start).Seconds())
+}
+
+func (i *instrumentedDatabase) Open() error {
+	start := time.Now()
+	err := i.db.Open()
+	i.observe("open", start, err)
+
+	return err
+}
+
+func (i *instrumentedDatabase) Close() error {
+	start := time.Now()
+	err := i.db.Close()
+	i.observe("close", start, err)
+
+	return err
+}
+
+func (i *instrumentedDatabase) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := i.db.Ping(ctx)
+	i.observe("ping", start, err)
+
+	return err
+}
+
+func (i *instrumentedDatabase) Create(ctx context.Context, body io.ReadCloser) (models.Note, error) {
+	start := time.Now()
+	note, err := i.db.Create(ctx, body)
+	i.observe("create", start, err)
+
+	return note, err
+}
+
+func (i *instrumentedDatabase) Patch(ctx context.Context, id string, username string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+	start := time.Now()
+	note, err := i.db.Patch(ctx, id, username, apply)
+	i.observe("patch", start, err)
+
+	return note, err
+}
+
+func (i *instrumentedDatabase) SetArchived(ctx context.Context, username string, ids []string, archived bool) ([]models.NoteResult, error) {
+	start := time.Now()
+	results, err := i.db.SetArchived(ctx, username, ids, archived)
+	i.observe("set_archived", start, err)
+
+	return results, err
+}
+
+func (i *instrumentedDatabase) Delete(ctx context.Context, id string, username string) error {
+	start := time.Now()
+	err := i.db.Delete(ctx, id, username)
+	i.observe("delete", start, err)
+
+	return err
+}
+
+func (i *instrumentedDatabase) Batch(ctx context.Context, username string, operations []database.Operation) ([]models.NoteResult, error) {
+	start := time.Now()
+	results, err := i.db.Batch(ctx, username, operations)
+	i.observe("batch", start, err)
+
+	return results, err
+}
+
+func (i *instrumentedDatabase) ListActiveNotes(ctx context.Context, username string) ([]models.Note, error) {
+	start := time.Now()
+	notes, err := i.db.ListActiveNotes(ctx, username)
+	i.observe("list_active_notes", start, err)
+
+	return notes, err
+}
+
+func (i *instrumentedDatabase) ListArchivedNotes(ctx context.Context, username string) ([]models.Note, error) {
+	start := time.Now()
+	notes, err := i.db.ListArchivedNotes(ctx, username)
+	i.observe("list_archived_notes", start, err)
+
+	return notes, err
+}
+
+func (i *instrumentedDatabase) EachNote(ctx context.Context, username string, fn func(models.Note) error) error {
+	start := time.Now()
+	err := i.db.EachNote(ctx, username, fn)
+	i.observe("each_note", start, err)
+
+	return err
+}
+
+func (i *instrumentedDatabase) CountNotes(ctx context.Context) (int, int, error) {
+	start := time.Now()
+	active, archived, err := i.db.CountNotes(ctx)
+	i.observe("count_notes", start, err)
+
+	return active, archived, err
+}
+
+func (i *instrumentedDatabase) ListUsers(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	users, err := i.db.ListUsers(ctx)
+	i.observe("list_users", start, err)
+
+	return users, err
+}
+
+func (i *instrumentedDatabase) PutNote(ctx context.Context, note models.Note) (models.Note, bool, error) {
+	start := time.Now()
+	stored, created, err := i.db.PutNote(ctx, note)
+	i.observe("put_note", start, err)
+
+	return stored, created, err
+}
+
+func (i *instrumentedDatabase) Snapshot(ctx context.Context, fn func(database.Snapshot) error) error {
+	start := time.Now()
+	err := i.db.Snapshot(ctx, fn)
+	i.observe("snapshot", start, err)
+
+	return err
+}
+
+func (i *instrumentedDatabase) ClaimIdempotencyKey(ctx context.Context, record database.IdempotencyRecord, now time.Time) (database.IdempotencyRecord, bool, error) {
+	start := time.Now()
+	existing, claimed, err := i.db.ClaimIdempotencyKey(ctx, record, now)
+	i.observe("claim_idempotency_key", start, err)
+
+	return existing, claimed, err
+}
+
+func (i *instrumentedDatabase) SaveIdempotencyKey(ctx context.Context, record database.IdempotencyRecord) error {
+	start := time.Now()
+	err := i.db.SaveIdempotencyKey(ctx, record)
+	i.observe("save_idempotency_key", start, err)
+
+	return err
+}
+
+func (i *instrumentedDatabase) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	start := time.Now()
+	err := i.db.ReleaseIdempotencyKey(ctx, key)
+	i.observe("release_idempotency_key", start, err)
+
+	return err
+}
+
+func (i *instrumentedDatabase) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	start := time.Now()
+	created, err := i.db.CreateWebhook(ctx, webhook)
+	i.observe("create_webhook", start, err)
+
+	return created, err
+}
+
+func (i *instrumentedDatabase) ListWebhooks(ctx context.Context, username string) ([]models.Webhook, error) {
+	start := time.Now()
+	webhooks, err := i.db.ListWebhooks(ctx, username)
+	i.observe("list_webhooks", start, err)
+
+	return webhooks, err
+}
+
+func (i *instrumentedDatabase) DeleteWebhook(ctx context.Context, id string, username string) error {
+	start := time.Now()
+	err := i.db.DeleteWebhook(ctx, id, username)
+	i.observe("delete_webhook", start, err)
+
+	return err
+}
+
+func (i *instrumentedDatabase) SaveDelivery(ctx context.Context, delivery models.Delivery) error {
+	start := time.Now()
+	err := i.db.SaveDelivery(ctx, delivery)
+	i.observe("save_delivery", start, err)
+
+	return err
+}
+
+func (i *instrumentedDatabase) ListDeliveries(ctx context.Context, webhookID string, username string) ([]models.Delivery, error) {
+	start := time.Now()
+	deliveries, err := i.db.ListDeliveries(ctx, webhookID, username)
+	i.observe("list_deliveries", start, err)
+
+	return deliveries, err
+}
+
+func (i *instrumentedDatabase) Changes(ctx context.Context, username string, since uint64) ([]models.Change, uint64, error) {
+	start := time.Now()
+	changes, latest, err := i.db.Changes(ctx, username, since)
+	i.observe("changes", start, err)
+
+	return changes, latest, err
+}
+
+func (i *instrumentedDatabase) ApplyChanges(ctx context.Context, username string, changes []models.Change) ([]models.ChangeResult, error) {
+	start := time.Now()
+	results, err := i.db.ApplyChanges(ctx, username, changes)
+	i.observe("apply_changes", start, err)
+
+	return results, err
+}
+
+func (i *instrumentedDatabase) DataKeys(ctx context.Context, username string) ([]database.DataKey, error) {
+	start := time.Now()
+	keys, err := i.db.DataKeys(ctx, username)
+	i.observe("data_keys", start, err)
+
+	return keys, err
+}
+
+func (i *instrumentedDatabase) SaveDataKey(ctx context.Context, key database.DataKey) error {
+	start := time.Now()
+	err := i.db.SaveDataKey(ctx, key)
+	i.observe("save_data_key", start, err)
+
+	return err
+}
+
+type noteCollector struct {
+	db    database.Database
+	notes *prometheus.Desc
+}
+
+func newNoteCollector(db database.Database, backend string) *noteCollector {
+	return &noteCollector{
+		db: db,
+		notes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "notes"),
+			"Number of notes stored, by state.",
+			[]string{"state"},
+			prometheus.Labels{"backend": backend},
+		),
+	}
+}
+
+func (c *noteCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.notes
+}
+
+func (c *noteCollector) Collect(ch chan<- prometheus.Metric) {
+	active, archived, err := c.db.CountNotes(context.Background())
+	if err != nil {
+		logrus.WithError(err).Error("failed to count notes")
+		ch <- prometheus.NewInvalidMetric(c.notes, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.notes, prometheus.GaugeValue, float64(active), "active")
+	ch <- prometheus.MustNewConstMetric(c.notes, prometheus.GaugeValue, float64(archived), "archived")
+}
@@ -0,0 +1,96 @@
+package metrics_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/metrics"
+	"github.com/m-rcd/notes/pkg/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var ctx = context.Background()
+
+var _ = Describe("Metrics", func() {
+	var (
+		m       *metrics.Metrics
+		fake_db *databasefakes.FakeDatabase
+	)
+
+	BeforeEach(func() {
+		m = metrics.New()
+		fake_db = new(databasefakes.FakeDatabase)
+	})
+
+	scrape := func() string {
+		r := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://localhost:10000/metrics", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		m.Handler().ServeHTTP(r, req)
+		Expect(r.Code).To(Equal(http.StatusOK))
+
+		return r.Body.String()
+	}
+
+	It("exposes Go runtime stats", func() {
+		Expect(scrape()).To(ContainSubstring("go_goroutines"))
+	})
+
+	Context("Middleware", func() {
+		It("counts requests by route and status", func() {
+			router := mux.NewRouter()
+			router.Use(m.Middleware)
+			router.HandleFunc("/note/{id}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			}).Methods("PATCH")
+
+			req, err := http.NewRequest("PATCH", "http://localhost:10000/note/1", nil)
+			Expect(err).NotTo(HaveOccurred())
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			out := scrape()
+			Expect(out).To(ContainSubstring(`notes_http_requests_total{method="PATCH",route="/note/{id}",status="404"} 1`))
+			Expect(out).To(ContainSubstring(`notes_http_request_duration_seconds_count{method="PATCH",route="/note/{id}",status="404"} 1`))
+		})
+	})
+
+	Context("Instrument", func() {
+		It("delegates to the database and records the latency of each operation", func() {
+			db := m.Instrument(fake_db, "local")
+			note := models.Note{Id: "1", Name: "Vampires", Content: "I SLAY", User: models.User{Username: "Buffy"}}
+			fake_db.CreateReturns(note, nil)
+			fake_db.DeleteReturns(errors.New("Not deleted"))
+
+			body := io.NopCloser(bytes.NewBufferString(`{}`))
+			created, err := db.Create(ctx, body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(Equal(note))
+			_, createBody := fake_db.CreateArgsForCall(0)
+			Expect(createBody).To(Equal(body))
+
+			Expect(db.Delete(ctx, "1", "Buffy")).To(MatchError("Not deleted"))
+
+			out := scrape()
+			Expect(out).To(ContainSubstring(`notes_storage_operation_duration_seconds_count{backend="local",operation="create",result="success"} 1`))
+			Expect(out).To(ContainSubstring(`notes_storage_operation_duration_seconds_count{backend="local",operation="delete",result="error"} 1`))
+		})
+
+		It("exports the number of notes by state", func() {
+			m.Instrument(fake_db, "sql")
+			fake_db.CountNotesReturns(3, 2, nil)
+
+			out := scrape()
+			Expect(out).To(ContainSubstring(`notes_notes{backend="sql",state="active"} 3`))
+			Expect(out).To(ContainSubstring(`notes_notes{backend="sql",state="archived"} 2`))
+		})
+	})
+})
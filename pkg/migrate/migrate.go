@@ -0,0 +1,147 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+// Counts is how many active and archived notes a user has in a backend.
+type Counts struct {
+	Active   int
+	Archived int
+}
+
+func (c *Counts) add(note models.Note) {
+	if note.Archived {
+		c.Archived++
+	} else {
+		c.Active++
+	}
+}
+
+// Result is how the notes of a user were migrated, and how many notes they
+// have in each backend once done.
+type Result struct {
+	User string
+	// Copied counts the notes written to the destination, Renumbered the
+	// ones of them that could not keep their id there.
+	Copied     int
+	Renumbered int
+	// Skipped counts the notes already in the destination, from an earlier
+	// run.
+	Skipped int
+	Errors  []string
+	Source  Counts
+	// Destination counts every note of the user in the destination, which
+	// can have notes of its own.
+	Destination Counts
+	// Missing counts the notes of the source that are not in the
+	// destination.
+	Missing int
+}
+
+// Verified tells whether every note of the source made it to the
+// destination.
+func (r Result) Verified() bool {
+	return r.Missing == 0 && len(r.Errors) == 0
+}
+
+// Migrate copies the notes of every user from one backend to another, one
+// note at a time, and calls done with the result of each user. Notes keep
+// their ids where the destination can store them, replacing the notes with
+// those ids, and notes the destination already has are skipped, so a
+// migration that stopped half way can be run again to finish it. The notes
+// of a user are written while they are read, so backends wrapped with
+// database.WithTimeouts bound reading each note rather than all of them.
+func Migrate(ctx context.Context, from database.Database, to database.Database, done func(Result)) error {
+	users, err := from.ListUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list the users: %w", err)
+	}
+
+	for _, user := range users {
+		result, err := migrateUser(ctx, from, to, user)
+		if err != nil {
+			return fmt.Errorf("failed to migrate the notes of %s: %w", user, err)
+		}
+
+		done(result)
+	}
+
+	return nil
+}
+
+type fingerprint [sha256.Size]byte
+
+func fingerprintOf(note models.Note) fingerprint {
+	return sha256.Sum256([]byte(fmt.Sprintf("%t\x00%s\x00%s", note.Archived, note.Name, note.Content)))
+}
+
+func migrateUser(ctx context.Context, from database.Database, to database.Database, user string) (Result, error) {
+	result := Result{User: user}
+
+	existing := map[string]fingerprint{}
+	unmatched := map[fingerprint]int{}
+	err := to.EachNote(ctx, user, func(note models.Note) error {
+		existing[note.Id] = fingerprintOf(note)
+		unmatched[fingerprintOf(note)]++
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	copied := map[fingerprint]int{}
+	err = from.EachNote(ctx, user, func(note models.Note) error {
+		result.Source.add(note)
+		key := fingerprintOf(note)
+		copied[key]++
+
+		if id, ok := existing[note.Id]; (ok && id == key) || (!ok && unmatched[key] > 0) {
+			unmatched[key]--
+			result.Skipped++
+			return nil
+		}
+
+		stored, _, err := to.PutNote(ctx, note)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+
+			result.Errors = append(result.Errors, fmt.Sprintf("note %s: %s", note.Id, err))
+			return nil
+		}
+
+		result.Copied++
+		if stored.Id != note.Id {
+			result.Renumbered++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	err = to.EachNote(ctx, user, func(note models.Note) error {
+		result.Destination.add(note)
+		copied[fingerprintOf(note)]--
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	for _, count := range copied {
+		if count > 0 {
+			result.Missing += count
+		}
+	}
+
+	return result, nil
+}
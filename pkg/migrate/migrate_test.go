@@ -0,0 +1,151 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/database/local"
+	"github.com/m-rcd/notes/pkg/migrate"
+	"github.com/m-rcd/notes/pkg/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migrate", func() {
+	var (
+		ctx      = context.Background()
+		from, to database.Database
+		dirs     []string
+	)
+
+	newBackend := func() database.Database {
+		dir, err := ioutil.TempDir("", "migrate_test")
+		Expect(err).NotTo(HaveOccurred())
+		dirs = append(dirs, dir)
+
+		db := local.NewLocalFileSystem(dir)
+		Expect(db.Open()).To(Succeed())
+
+		return db
+	}
+
+	put := func(db database.Database, note models.Note) models.Note {
+		stored, _, err := db.PutNote(ctx, note)
+		Expect(err).NotTo(HaveOccurred())
+
+		return stored
+	}
+
+	notesOf := func(db database.Database, user string) []models.Note {
+		var notes []models.Note
+		Expect(db.EachNote(ctx, user, func(note models.Note) error {
+			notes = append(notes, note)
+			return nil
+		})).To(Succeed())
+
+		return notes
+	}
+
+	run := func() []migrate.Result {
+		var results []migrate.Result
+		Expect(migrate.Migrate(ctx, from, to, func(result migrate.Result) {
+			results = append(results, result)
+		})).To(Succeed())
+
+		return results
+	}
+
+	BeforeEach(func() {
+		dirs = nil
+		from, to = newBackend(), newBackend()
+
+		put(from, models.Note{Id: "1", Name: "Stakes", Content: "Pointy.", User: models.User{Username: "Buffy"}})
+		put(from, models.Note{Id: "2", Name: "Hellmouth", Content: "Below the library.", Archived: true, User: models.User{Username: "Buffy"}})
+		put(from, models.Note{Id: "3", Name: "Books", Content: "Dusty.", User: models.User{Username: "Giles"}})
+	})
+
+	AfterEach(func() {
+		for _, dir := range dirs {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		}
+	})
+
+	It("copies every user's notes with their ids and verifies them", func() {
+		results := run()
+		Expect(results).To(Equal([]migrate.Result{
+			{User: "Buffy", Copied: 2, Source: migrate.Counts{Active: 1, Archived: 1}, Destination: migrate.Counts{Active: 1, Archived: 1}},
+			{User: "Giles", Copied: 1, Source: migrate.Counts{Active: 1}, Destination: migrate.Counts{Active: 1}},
+		}))
+		Expect(results[0].Verified()).To(BeTrue())
+
+		Expect(notesOf(to, "Buffy")).To(Equal(notesOf(from, "Buffy")))
+		Expect(notesOf(to, "Giles")).To(Equal(notesOf(from, "Giles")))
+	})
+
+	It("skips the notes an earlier run copied", func() {
+		put(to, models.Note{Id: "1", Name: "Stakes", Content: "Pointy.", User: models.User{Username: "Buffy"}})
+		put(to, models.Note{Id: "a7", Name: "Books", Content: "Dusty.", User: models.User{Username: "Giles"}})
+
+		results := run()
+		Expect(results[0].Copied).To(Equal(1))
+		Expect(results[0].Skipped).To(Equal(1))
+		Expect(results[1].Copied).To(BeZero())
+		Expect(results[1].Skipped).To(Equal(1))
+		Expect(results[1].Verified()).To(BeTrue())
+
+		results = run()
+		Expect(results[0].Copied).To(BeZero())
+		Expect(results[0].Skipped).To(Equal(2))
+	})
+
+	It("reports the notes it could not copy", func() {
+		fake := new(databasefakes.FakeDatabase)
+		fake.PutNoteReturns(models.Note{}, false, errors.New("content is too long"))
+		to = fake
+
+		var results []migrate.Result
+		Expect(migrate.Migrate(ctx, from, to, func(result migrate.Result) {
+			results = append(results, result)
+		})).To(Succeed())
+		Expect(results[0].Errors).To(Equal([]string{"note 1: content is too long", "note 2: content is too long"}))
+		Expect(results[0].Missing).To(Equal(2))
+		Expect(results[0].Verified()).To(BeFalse())
+	})
+
+	It("copies to a slow destination for longer than the storage timeout", func() {
+		for i := 0; i < 5; i++ {
+			put(from, models.Note{Id: fmt.Sprintf("slow-%d", i), Name: "Slayage", Content: "Slow.", User: models.User{Username: "Buffy"}})
+		}
+		from = database.WithTimeouts(from, database.Timeouts{Default: 50 * time.Millisecond})
+
+		dest := to
+		slow := new(databasefakes.FakeDatabase)
+		slow.EachNoteStub = dest.EachNote
+		slow.PutNoteStub = func(ctx context.Context, note models.Note) (models.Note, bool, error) {
+			time.Sleep(30 * time.Millisecond)
+			return dest.PutNote(ctx, note)
+		}
+		to = database.WithTimeouts(slow, database.Timeouts{Default: 50 * time.Millisecond})
+
+		results := run()
+		Expect(results[0].Copied).To(Equal(7))
+		Expect(results[0].Verified()).To(BeTrue())
+	})
+
+	It("stops when a backend fails", func() {
+		fake := new(databasefakes.FakeDatabase)
+		fake.ListUsersReturns([]string{"Buffy"}, nil)
+		fake.EachNoteReturns(errors.New("disk on fire"))
+		from = fake
+
+		err := migrate.Migrate(ctx, from, to, func(migrate.Result) {})
+		Expect(err).To(MatchError("failed to migrate the notes of Buffy: disk on fire"))
+	})
+})
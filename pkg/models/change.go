@@ -0,0 +1,19 @@
+package models
+
+// Change is one of a user's notes as of their change with Version, the
+// place of that change in the user's sequence. Deleted notes are tombstones
+// and have no note.
+type Change struct {
+	Id      string `json:"id"`
+	Version uint64 `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Note    *Note  `json:"note,omitempty"`
+}
+
+// ChangeResult reports on a change uploaded by a client: the note as it now
+// is, and whether that is because the change conflicted with a newer one.
+type ChangeResult struct {
+	Change
+	Conflict bool   `json:"conflict,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
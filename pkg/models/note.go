@@ -1,9 +1,16 @@
 package models
 
+import "time"
+
+// Note is a user's note. Created and Updated are set by the storage when the
+// note is written.
 type Note struct {
-	Id       string `json:"id"`
-	Name     string `json:"name"`
-	Content  string `json:"content"`
-	User     User   `json:"user"`
-	Archived bool   `json:"archived"`
+	Id       string    `json:"id"`
+	Name     string    `json:"name"`
+	Content  string    `json:"content"`
+	User     User      `json:"user"`
+	Archived bool      `json:"archived"`
+	Tags     []string  `json:"tags,omitempty"`
+	Created  time.Time `json:"created_at"`
+	Updated  time.Time `json:"updated_at"`
 }
@@ -0,0 +1,19 @@
+package models
+
+// NoteResult reports what happened to one note of a bulk operation: the note
+// as it now is, or why it could not be changed.
+type NoteResult struct {
+	Id    string `json:"id"`
+	Note  *Note  `json:"note,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ImportResult reports what became of one note of an import: where it was
+// read from, and whether it was created, skipped or failed, and why.
+type ImportResult struct {
+	Source string `json:"source"`
+	Status string `json:"status"`
+	Id     string `json:"id,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Webhook subscribes a URL to the events of a user's notes. The secret signs
+// the deliveries and is only shown when the webhook is created.
+type Webhook struct {
+	Id     string   `json:"id"`
+	User   User     `json:"user"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// Delivery is one attempt at sending an event to a webhook. Every attempt at
+// the same event has the same id. Dead deliveries were given up on, and keep
+// the payload that could not be delivered. Username is the owner of the
+// webhook, whose data key seals the payload when encryption is on.
+type Delivery struct {
+	Id         string    `json:"id"`
+	WebhookId  string    `json:"webhook_id"`
+	EventId    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Dead       bool      `json:"dead"`
+	Payload    string    `json:"payload,omitempty"`
+	Time       time.Time `json:"time"`
+	Username   string    `json:"-"`
+}
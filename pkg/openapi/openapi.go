@@ -0,0 +1,284 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gorilla/mux"
+
+	"github.com/m-rcd/notes/pkg/patch"
+)
+
+//go:embed openapi.json
+var document []byte
+
+func init() {
+	// Patch documents are JSON, but only application/json is decoded out of
+	// the box.
+	for _, contentType := range []string{patch.MergePatch, patch.JSONPatch} {
+		openapi3filter.RegisterBodyDecoder(contentType, openapi3filter.RegisteredBodyDecoder("application/json"))
+	}
+
+	// Archives and exports are checked as the binary strings they are
+	// described as.
+	for _, contentType := range []string{"application/zip", "application/x-tar", "application/gzip", "application/xml", "text/xml"} {
+		openapi3filter.RegisterBodyDecoder(contentType, openapi3filter.FileBodyDecoder)
+	}
+}
+
+// Handler serves the OpenAPI document describing the API.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	})
+}
+
+// Load parses and validates the OpenAPI document.
+func Load() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(document)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+
+	return doc, nil
+}
+
+// CheckRoutes walks router and fails unless every route and method it
+// serves is in the document, and every operation in the document is served.
+// Routes without methods are taken to serve GET.
+func CheckRoutes(router *mux.Router) error {
+	doc, err := Load()
+	if err != nil {
+		return err
+	}
+
+	served := map[string]bool{}
+	var problems []string
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+
+		for _, method := range methods {
+			served[method+" "+path] = true
+
+			item := doc.Paths.Find(path)
+			if item == nil || item.GetOperation(method) == nil {
+				problems = append(problems, fmt.Sprintf("%s %s is not documented", method, path))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for path, item := range doc.Paths {
+		for method := range item.Operations() {
+			if !served[method+" "+path] {
+				problems = append(problems, fmt.Sprintf("%s %s is documented but not served", method, path))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("the routes do not match the document: %s", strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+// Validator checks requests and responses against the OpenAPI document.
+type Validator struct {
+	router routers.Router
+}
+
+func NewValidator() (*Validator, error) {
+	doc, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Validator{router: router}, nil
+}
+
+// ValidateRequest checks that the request is described by the document. The
+// request body is left readable.
+func (v *Validator) ValidateRequest(r *http.Request) (*openapi3filter.RequestValidationInput, error) {
+	route, params, err := v.router.FindRoute(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", r.Method, r.URL.Path, err)
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: params,
+		Route:      route,
+		Options:    &openapi3filter.Options{IncludeResponseStatus: true},
+	}
+	err = openapi3filter.ValidateRequest(r.Context(), input)
+	if body != nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", r.Method, r.URL.Path, err)
+	}
+
+	return input, nil
+}
+
+// ValidateResponse checks that the response to a request that passed
+// ValidateRequest is described by the document.
+func (v *Validator) ValidateResponse(input *openapi3filter.RequestValidationInput, status int, header http.Header, body []byte) error {
+	err := openapi3filter.ValidateResponse(input.Request.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 status,
+		Header:                 header,
+		Body:                   ioutil.NopCloser(bytes.NewReader(body)),
+		Options:                input.Options,
+	})
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", input.Request.Method, input.Request.URL.Path, err)
+	}
+
+	return nil
+}
+
+// Middleware validates every request and response passing through it and
+// calls report for each one that does not match the document. Responses are
+// sent unchanged, so it can wrap a real server in tests.
+func (v *Validator) Middleware(report func(error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			input, err := v.ValidateRequest(r)
+			if err != nil {
+				report(err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			recorder := httptest.NewRecorder()
+			next.ServeHTTP(recorder, r)
+
+			if err := v.ValidateResponse(input, recorder.Code, recorder.Header(), recorder.Body.Bytes()); err != nil {
+				report(err)
+			}
+
+			for key, values := range recorder.Header() {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(recorder.Code)
+			w.Write(recorder.Body.Bytes())
+		})
+	}
+}
+
+// Transport wraps next so that a client fails any request or response that
+// does not match the document.
+func (v *Validator) Transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(r *http.Request) (*http.Response, error) {
+		input, err := v.ValidateRequest(r)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := next.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+
+		// Event streams do not end, so only their status and headers are
+		// checked.
+		if isStream(resp.Header) {
+			options := *input.Options
+			options.ExcludeResponseBody = true
+			input.Options = &options
+
+			if err := v.ValidateResponse(input, resp.StatusCode, resp.Header, nil); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+
+			return resp, nil
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		if err := v.ValidateResponse(input, resp.StatusCode, resp.Header, body); err != nil {
+			return nil, err
+		}
+
+		return resp, nil
+	})
+}
+
+func isStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+
+	return mediaType == "text/event-stream"
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
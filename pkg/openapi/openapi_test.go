@@ -0,0 +1,137 @@
+package openapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/handler"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/openapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Openapi", func() {
+	var (
+		validator *openapi.Validator
+		fake_db   *databasefakes.FakeDatabase
+		router    *mux.Router
+		problems  []error
+		err       error
+	)
+
+	BeforeEach(func() {
+		validator, err = openapi.NewValidator()
+		Expect(err).NotTo(HaveOccurred())
+
+		fake_db = new(databasefakes.FakeDatabase)
+		h := handler.New(fake_db)
+		problems = nil
+
+		router = mux.NewRouter()
+		router.HandleFunc("/note", h.CreateNewNote).Methods("POST")
+		router.HandleFunc("/note/{id}", h.DeleteNote).Methods("DELETE")
+		router.HandleFunc("/notes/active", h.ListActiveNotes).Methods("GET")
+	})
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Content-Type", "application/json")
+
+		r := httptest.NewRecorder()
+		validator.Middleware(func(err error) { problems = append(problems, err) })(router).ServeHTTP(r, req)
+
+		return r
+	}
+
+	It("serves a valid document", func() {
+		_, err := openapi.Load()
+		Expect(err).NotTo(HaveOccurred())
+
+		r := httptest.NewRecorder()
+		openapi.Handler().ServeHTTP(r, httptest.NewRequest("GET", "/openapi.json", nil))
+		Expect(r.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		var doc map[string]interface{}
+		Expect(json.Unmarshal(r.Body.Bytes(), &doc)).To(Succeed())
+		Expect(doc).To(HaveKeyWithValue("openapi", "3.0.3"))
+	})
+
+	Context("CheckRoutes", func() {
+		It("reports the routes and operations missing on either side", func() {
+			err := openapi.CheckRoutes(router)
+			Expect(err).To(MatchError(ContainSubstring("GET /readyz is documented but not served")))
+			Expect(err).NotTo(MatchError(ContainSubstring("POST /note is")))
+
+			router.HandleFunc("/notes/all", func(http.ResponseWriter, *http.Request) {}).Methods("GET")
+			router.HandleFunc("/note/{id}", func(http.ResponseWriter, *http.Request) {}).Methods("GET")
+			err = openapi.CheckRoutes(router)
+			Expect(err).To(MatchError(ContainSubstring("GET /notes/all is not documented")))
+			Expect(err).To(MatchError(ContainSubstring("GET /note/{id} is not documented")))
+		})
+	})
+
+	Context("Middleware", func() {
+		It("accepts requests and responses that match the document", func() {
+			fake_db.CreateReturns(models.Note{Id: "1", Name: "Vampires", Content: "I SLAY", User: models.User{Username: "Buffy"}}, nil)
+			r := serve("POST", "http://localhost:10000/note", `{"name":"Vampires","content":"I SLAY","user":{"username":"Buffy"}}`)
+			Expect(r.Code).To(Equal(http.StatusOK))
+
+			fake_db.ListActiveNotesReturns(nil, fmt.Errorf("no notes"))
+			serve("GET", "http://localhost:10000/notes/active", `{"username":"Buffy"}`)
+
+			Expect(problems).To(BeEmpty())
+			_, body := fake_db.CreateArgsForCall(0)
+			Expect(ioutil.ReadAll(body)).To(MatchJSON(`{"name":"Vampires","content":"I SLAY","user":{"username":"Buffy"}}`))
+		})
+
+		It("reports requests that do not match the document", func() {
+			serve("POST", "http://localhost:10000/note", `{"content":"I SLAY","user":{"username":"Buffy"}}`)
+			serve("DELETE", "http://localhost:10000/note/1", `{"username":1}`)
+			serve("GET", "http://localhost:10000/notes", ``)
+
+			Expect(problems).To(HaveLen(3))
+			Expect(problems[0]).To(MatchError(ContainSubstring(`property "name" is missing`)))
+			Expect(problems[1]).To(MatchError(ContainSubstring("request body has an error")))
+			Expect(problems[2]).To(MatchError(ContainSubstring("no matching operation was found")))
+		})
+
+		It("reports responses that do not match the document", func() {
+			router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"StatusCode":200}`))
+			}).Methods("GET")
+
+			r := serve("GET", "http://localhost:10000/healthz", ``)
+			Expect(r.Body.String()).To(Equal(`{"StatusCode":200}`))
+
+			Expect(problems).To(HaveLen(1))
+			Expect(problems[0]).To(MatchError(ContainSubstring(`property "status" is missing`)))
+		})
+	})
+
+	Context("Transport", func() {
+		It("fails requests whose response does not match the document", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`[]`))
+			}))
+			defer server.Close()
+
+			c := http.Client{Transport: validator.Transport(http.DefaultTransport)}
+			req, err := http.NewRequest("GET", server.URL+"/notes/archived", bytes.NewBufferString(`{"username":"Buffy"}`))
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
+
+			_, err = c.Do(req)
+			Expect(err).To(MatchError(ContainSubstring("response header Content-Type has unexpected value")))
+		})
+	})
+})
@@ -0,0 +1,173 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/utils"
+)
+
+const (
+	// MergePatch is the media type of an RFC 7396 JSON Merge Patch.
+	MergePatch = "application/merge-patch+json"
+	// JSONPatch is the media type of an RFC 6902 JSON Patch.
+	JSONPatch = "application/json-patch+json"
+)
+
+// ErrInvalid is returned for patches that cannot be applied, or that leave
+// the note invalid.
+var ErrInvalid = errors.New("invalid patch")
+
+// Func changes a note. Storage backends apply it to the stored note.
+type Func func(note models.Note) (models.Note, error)
+
+// Supports reports whether contentType is one of the patch media types.
+func Supports(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == MergePatch || mediaType == JSONPatch
+}
+
+// New parses a patch document of the given content type. Fields set to null
+// by a merge patch, or removed by a JSON patch, are cleared.
+func New(contentType string, body []byte) (Func, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, invalid(err.Error())
+	}
+
+	switch mediaType {
+	case MergePatch:
+		if !json.Valid(body) {
+			return nil, invalid("body is not valid JSON")
+		}
+
+		return document(func(doc []byte) ([]byte, error) {
+			return jsonpatch.MergePatch(doc, body)
+		}), nil
+	case JSONPatch:
+		operations, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return nil, invalid(err.Error())
+		}
+
+		return document(operations.Apply), nil
+	}
+
+	return nil, invalid(fmt.Sprintf("unsupported content type %q", mediaType))
+}
+
+// Fields parses a plain JSON note and changes the name and content it sets,
+// replaces the tags when it lists them, and archives or unarchives the note
+// when it sets archived.
+func Fields(body []byte) (Func, error) {
+	var fields struct {
+		Name     string    `json:"name"`
+		Content  string    `json:"content"`
+		Archived *bool     `json:"archived"`
+		Tags     *[]string `json:"tags"`
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, invalid(err.Error())
+	}
+
+	return checked(func(note models.Note) (models.Note, error) {
+		if utils.IsSet(fields.Name) {
+			note.Name = fields.Name
+		}
+
+		if utils.IsSet(fields.Content) {
+			note.Content = fields.Content
+		}
+
+		if fields.Archived != nil {
+			note.Archived = *fields.Archived
+		}
+
+		if fields.Tags != nil {
+			note.Tags = *fields.Tags
+		}
+
+		return note, nil
+	}), nil
+}
+
+// Replace swaps the stored note for note, keeping its id. Fields note leaves
+// out are cleared.
+func Replace(note models.Note) Func {
+	return checked(func(existing models.Note) (models.Note, error) {
+		note.Id = existing.Id
+		if !utils.IsSet(note.User.Username) {
+			note.User = existing.User
+		}
+
+		return note, nil
+	})
+}
+
+// Archived archives or unarchives the note, leaving the rest of it alone.
+func Archived(archived bool) Func {
+	return checked(func(note models.Note) (models.Note, error) {
+		note.Archived = archived
+		return note, nil
+	})
+}
+
+// document applies change to the JSON form of a note.
+func document(change func(doc []byte) ([]byte, error)) Func {
+	return checked(func(note models.Note) (models.Note, error) {
+		doc, err := json.Marshal(note)
+		if err != nil {
+			return models.Note{}, err
+		}
+
+		patched, err := change(doc)
+		if err != nil {
+			return models.Note{}, invalid(err.Error())
+		}
+
+		var result models.Note
+		if err := json.Unmarshal(patched, &result); err != nil {
+			return models.Note{}, invalid(err.Error())
+		}
+
+		return result, nil
+	})
+}
+
+// checked rejects changes that move the note to another id or user, or leave
+// it without a name.
+func checked(change Func) Func {
+	return func(note models.Note) (models.Note, error) {
+		result, err := change(note)
+		if err != nil {
+			return models.Note{}, err
+		}
+
+		if result.Id != note.Id {
+			return models.Note{}, invalid("id cannot be changed")
+		}
+
+		if result.User.Username != note.User.Username {
+			return models.Note{}, invalid("user cannot be changed")
+		}
+
+		if !utils.IsSet(result.Name) {
+			return models.Note{}, invalid("name must be set")
+		}
+
+		return result, nil
+	}
+}
+
+func invalid(message string) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, message)
+}
@@ -0,0 +1,122 @@
+package patch_test
+
+import (
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/patch"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Patch", func() {
+	var note models.Note
+
+	BeforeEach(func() {
+		note = models.Note{Id: "1", Name: "Vampires", Content: "I SLAY", Archived: true, User: models.User{Username: "Buffy"}}
+	})
+
+	apply := func(contentType, body string) (models.Note, error) {
+		change, err := patch.New(contentType, []byte(body))
+		Expect(err).NotTo(HaveOccurred())
+
+		return change(note)
+	}
+
+	It("recognises the patch media types", func() {
+		Expect(patch.Supports("application/merge-patch+json")).To(BeTrue())
+		Expect(patch.Supports("application/json-patch+json; charset=utf-8")).To(BeTrue())
+		Expect(patch.Supports("application/json")).To(BeFalse())
+		Expect(patch.Supports("")).To(BeFalse())
+	})
+
+	Context("JSON Merge Patch", func() {
+		It("clears fields set to null and keeps the ones left out", func() {
+			patched, err := apply(patch.MergePatch, `{"content":null,"archived":false}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patched).To(Equal(models.Note{Id: "1", Name: "Vampires", Content: "", Archived: false, User: models.User{Username: "Buffy"}}))
+		})
+
+		It("rejects documents that are not JSON", func() {
+			_, err := patch.New(patch.MergePatch, []byte(`{"content":`))
+			Expect(err).To(MatchError(patch.ErrInvalid))
+		})
+	})
+
+	Context("JSON Patch", func() {
+		It("applies the operations in order", func() {
+			patched, err := apply(patch.JSONPatch, `[
+				{"op":"test","path":"/archived","value":true},
+				{"op":"replace","path":"/archived","value":false},
+				{"op":"replace","path":"/name","value":"Slayers"},
+				{"op":"remove","path":"/content"}
+			]`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patched).To(Equal(models.Note{Id: "1", Name: "Slayers", Archived: false, User: models.User{Username: "Buffy"}}))
+		})
+
+		It("fails when a test operation does not hold", func() {
+			_, err := apply(patch.JSONPatch, `[{"op":"test","path":"/archived","value":false}]`)
+			Expect(err).To(MatchError(patch.ErrInvalid))
+		})
+
+		It("rejects documents that are not a list of operations", func() {
+			_, err := patch.New(patch.JSONPatch, []byte(`{"op":"remove"}`))
+			Expect(err).To(MatchError(patch.ErrInvalid))
+		})
+	})
+
+	Context("Fields", func() {
+		It("changes the fields that are set and keeps the others", func() {
+			change, err := patch.Fields([]byte(`{"content":"I STAKE","user":{"username":"Buffy"}}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			patched, err := change(note)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patched).To(Equal(models.Note{Id: "1", Name: "Vampires", Content: "I STAKE", Archived: true, User: models.User{Username: "Buffy"}}))
+
+			change, err = patch.Fields([]byte(`{"archived":false}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			patched, err = change(note)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patched.Archived).To(BeFalse())
+		})
+
+		It("rejects documents that are not JSON", func() {
+			_, err := patch.Fields([]byte(`{"content":`))
+			Expect(err).To(MatchError(patch.ErrInvalid))
+		})
+	})
+
+	Context("Replace", func() {
+		It("clears the fields the new note leaves out", func() {
+			patched, err := patch.Replace(models.Note{Name: "Slayers"})(note)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patched).To(Equal(models.Note{Id: "1", Name: "Slayers", User: models.User{Username: "Buffy"}}))
+		})
+	})
+
+	Context("Archived", func() {
+		It("only changes whether the note is archived", func() {
+			patched, err := patch.Archived(false)(note)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patched).To(Equal(models.Note{Id: "1", Name: "Vampires", Content: "I SLAY", User: models.User{Username: "Buffy"}}))
+		})
+	})
+
+	Context("when the patched note is invalid", func() {
+		It("rejects it", func() {
+			_, err := apply(patch.MergePatch, `{"name":null}`)
+			Expect(err).To(MatchError("invalid patch: name must be set"))
+
+			_, err = apply(patch.MergePatch, `{"id":"2"}`)
+			Expect(err).To(MatchError("invalid patch: id cannot be changed"))
+
+			_, err = apply(patch.JSONPatch, `[{"op":"replace","path":"/user/username","value":"Spike"}]`)
+			Expect(err).To(MatchError("invalid patch: user cannot be changed"))
+
+			_, err = patch.Replace(models.Note{Name: "Slayers", User: models.User{Username: "Spike"}})(note)
+			Expect(err).To(MatchError(patch.ErrInvalid))
+		})
+	})
+})
@@ -1,6 +1,15 @@
 package responses
 
-import "github.com/m-rcd/notes/pkg/models"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/patch"
+)
 
 type JsonNoteResponse struct {
 	Type       string        `json:"type"`
@@ -9,6 +18,63 @@ type JsonNoteResponse struct {
 	Message    string        `json:"message"`
 }
 
+// JsonResultsResponse reports the outcome of a bulk operation note by note.
+type JsonResultsResponse struct {
+	Type       string              `json:"type"`
+	StatusCode int                 `json:"status_code"`
+	Results    []models.NoteResult `json:"results"`
+	Message    string              `json:"message"`
+}
+
+// JsonWebhookResponse carries webhooks in the envelope of the note
+// responses.
+type JsonWebhookResponse struct {
+	Type       string           `json:"type"`
+	StatusCode int              `json:"status_code"`
+	Data       []models.Webhook `json:"data"`
+	Message    string           `json:"message"`
+}
+
+// JsonDeliveryResponse carries the delivery log of a webhook.
+type JsonDeliveryResponse struct {
+	Type       string            `json:"type"`
+	StatusCode int               `json:"status_code"`
+	Data       []models.Delivery `json:"data"`
+	Message    string            `json:"message"`
+}
+
+// JsonSyncResponse carries the changes to a user's notes since their last
+// sync, and the token to give to the next one.
+type JsonSyncResponse struct {
+	Type       string          `json:"type"`
+	StatusCode int             `json:"status_code"`
+	Changes    []models.Change `json:"changes"`
+	Token      string          `json:"token"`
+	Message    string          `json:"message"`
+}
+
+// JsonChangeResultsResponse reports on the changes uploaded by a client one
+// by one.
+type JsonChangeResultsResponse struct {
+	Type       string                `json:"type"`
+	StatusCode int                   `json:"status_code"`
+	Results    []models.ChangeResult `json:"results"`
+	Message    string                `json:"message"`
+}
+
+// JsonImportResponse reports on an import note by note, with how many notes
+// were created, skipped and failed.
+type JsonImportResponse struct {
+	Type       string                `json:"type"`
+	StatusCode int                   `json:"status_code"`
+	DryRun     bool                  `json:"dry_run"`
+	Created    int                   `json:"created"`
+	Skipped    int                   `json:"skipped"`
+	Failed     int                   `json:"failed"`
+	Results    []models.ImportResult `json:"results"`
+	Message    string                `json:"message"`
+}
+
 func Failure(message string) JsonNoteResponse {
 	return JsonNoteResponse{Type: "failed", StatusCode: 500, Data: []models.Note{}, Message: message}
 }
@@ -16,3 +82,141 @@ func Failure(message string) JsonNoteResponse {
 func Success(data []models.Note, message string) JsonNoteResponse {
 	return JsonNoteResponse{Type: "success", StatusCode: 200, Data: data, Message: message}
 }
+
+func Webhooks(data []models.Webhook, message string) JsonWebhookResponse {
+	return JsonWebhookResponse{Type: "success", StatusCode: 200, Data: data, Message: message}
+}
+
+func Deliveries(data []models.Delivery, message string) JsonDeliveryResponse {
+	return JsonDeliveryResponse{Type: "success", StatusCode: 200, Data: data, Message: message}
+}
+
+func BadRequest(message string) JsonNoteResponse {
+	response := Failure(message)
+	response.StatusCode = http.StatusBadRequest
+	return response
+}
+
+func Forbidden(message string) JsonNoteResponse {
+	response := Failure(message)
+	response.StatusCode = http.StatusForbidden
+	return response
+}
+
+func NotFound(message string) JsonNoteResponse {
+	response := Failure(message)
+	response.StatusCode = http.StatusNotFound
+	return response
+}
+
+func Conflict(message string) JsonNoteResponse {
+	response := Failure(message)
+	response.StatusCode = http.StatusConflict
+	return response
+}
+
+// Results builds the response for a bulk operation, which is partial, with
+// status 207, when some notes could not be changed.
+func Results(results []models.NoteResult, done string) JsonResultsResponse {
+	changed := 0
+	for _, result := range results {
+		if result.Error == "" {
+			changed++
+		}
+	}
+
+	response := JsonResultsResponse{Type: "success", StatusCode: http.StatusOK, Results: results, Message: fmt.Sprintf("%d of %d notes were %s", changed, len(results), done)}
+	if changed < len(results) {
+		response.Type = "partial"
+		response.StatusCode = http.StatusMultiStatus
+	}
+
+	return response
+}
+
+func Changes(changes []models.Change, token string) JsonSyncResponse {
+	return JsonSyncResponse{Type: "success", StatusCode: http.StatusOK, Changes: changes, Token: token, Message: "The changes were successfully listed"}
+}
+
+// ChangeResults builds the response for uploaded changes, which is partial,
+// with status 207, when some conflicted or failed.
+func ChangeResults(results []models.ChangeResult) JsonChangeResultsResponse {
+	applied := 0
+	for _, result := range results {
+		if !result.Conflict && result.Error == "" {
+			applied++
+		}
+	}
+
+	response := JsonChangeResultsResponse{Type: "success", StatusCode: http.StatusOK, Results: results, Message: fmt.Sprintf("%d of %d changes were applied", applied, len(results))}
+	if applied < len(results) {
+		response.Type = "partial"
+		response.StatusCode = http.StatusMultiStatus
+	}
+
+	return response
+}
+
+// Imported builds the response for an import, which is partial, with status
+// 207, when some notes failed. Skipped notes are not failures.
+func Imported(results []models.ImportResult, dryRun bool) JsonImportResponse {
+	response := JsonImportResponse{Type: "success", StatusCode: http.StatusOK, DryRun: dryRun, Results: results}
+	for _, result := range results {
+		switch result.Status {
+		case "created":
+			response.Created++
+		case "skipped":
+			response.Skipped++
+		default:
+			response.Failed++
+		}
+	}
+
+	verb := "were"
+	if dryRun {
+		verb = "would be"
+	}
+	response.Message = fmt.Sprintf("%d notes %s created, %d skipped and %d failed", response.Created, verb, response.Skipped, response.Failed)
+
+	if response.Failed > 0 {
+		response.Type = "partial"
+		response.StatusCode = http.StatusMultiStatus
+	}
+
+	return response
+}
+
+// Applied builds the response for a batch whose operations all succeeded.
+func Applied(results []models.NoteResult) JsonResultsResponse {
+	return JsonResultsResponse{Type: "success", StatusCode: http.StatusOK, Results: results, Message: fmt.Sprintf("The %d operations were successfully applied", len(results))}
+}
+
+// NotApplied builds the response for a batch that was undone because one of
+// its operations failed with err.
+func NotApplied(results []models.NoteResult, err error) JsonResultsResponse {
+	response := Error(err)
+
+	return JsonResultsResponse{Type: "failed", StatusCode: response.StatusCode, Results: results, Message: "no operation was applied: " + response.Message}
+}
+
+// Error builds the failure response for a storage error. Operations that ran
+// out of time are reported as timeouts rather than server errors, patches
+// that cannot be applied and requests the backend refuses as bad requests,
+// and missing notes and webhooks as not found.
+func Error(err error) JsonNoteResponse {
+	if errors.Is(err, patch.ErrInvalid) || errors.Is(err, database.ErrInvalid) {
+		return BadRequest(err.Error())
+	}
+
+	if errors.Is(err, database.ErrNoteNotFound) || errors.Is(err, database.ErrWebhookNotFound) {
+		return NotFound(err.Error())
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		response := Failure("the storage operation timed out")
+		response.StatusCode = http.StatusGatewayTimeout
+		return response
+	}
+
+	return Failure(err.Error())
+}
@@ -1,9 +1,14 @@
 package responses_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/m-rcd/notes/pkg/database"
 	"github.com/m-rcd/notes/pkg/models"
 	"github.com/m-rcd/notes/pkg/responses"
 )
@@ -29,4 +34,53 @@ var _ = Describe("Responses", func() {
 			Expect(responses.Failure(message)).To(Equal(expectedResponse))
 		})
 	})
+
+	Context("change results", func() {
+		It("is partial when a change conflicted or failed", func() {
+			applied := models.ChangeResult{Change: models.Change{Id: "1", Version: 3}}
+			Expect(responses.ChangeResults([]models.ChangeResult{applied}).StatusCode).To(Equal(200))
+
+			response := responses.ChangeResults([]models.ChangeResult{applied, {Change: models.Change{Id: "2", Version: 2}, Conflict: true}, {Error: "name must be set"}})
+			Expect(response.Type).To(Equal("partial"))
+			Expect(response.StatusCode).To(Equal(207))
+			Expect(response.Message).To(Equal("1 of 3 changes were applied"))
+		})
+	})
+
+	Context("imported", func() {
+		It("counts the notes and is partial when some failed", func() {
+			results := []models.ImportResult{{Status: "created"}, {Status: "skipped"}, {Status: "skipped"}}
+			response := responses.Imported(results, true)
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(response.Message).To(Equal("1 notes would be created, 2 skipped and 0 failed"))
+
+			response = responses.Imported(append(results, models.ImportResult{Status: "failed"}), false)
+			Expect(response.Type).To(Equal("partial"))
+			Expect(response.StatusCode).To(Equal(207))
+			Expect([]int{response.Created, response.Skipped, response.Failed}).To(Equal([]int{1, 2, 1}))
+			Expect(response.Message).To(Equal("1 notes were created, 2 skipped and 1 failed"))
+		})
+	})
+
+	Context("error", func() {
+		It("reports timeouts", func() {
+			response := responses.Error(fmt.Errorf("failed to list notes: %w", context.DeadlineExceeded))
+			Expect(response.StatusCode).To(Equal(504))
+			Expect(response.Message).To(Equal("the storage operation timed out"))
+		})
+
+		It("reports requests the backend refuses as bad requests", func() {
+			response := responses.Error(database.Invalid(errors.New("name must be set")))
+			Expect(response.StatusCode).To(Equal(400))
+			Expect(response.Message).To(Equal("name must be set"))
+		})
+
+		It("reports missing webhooks as not found", func() {
+			Expect(responses.Error(database.ErrWebhookNotFound).StatusCode).To(Equal(404))
+		})
+
+		It("reports other errors as server errors", func() {
+			Expect(responses.Error(errors.New("Not created"))).To(Equal(responses.Failure("Not created")))
+		})
+	})
 })
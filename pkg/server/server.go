@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/auth"
+	"github.com/m-rcd/notes/pkg/certs"
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/utils"
+)
+
+const certReloadInterval = 10 * time.Second
+
+type Options struct {
+	Address         string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	ShutdownDelay   time.Duration
+	TLS             TLSOptions
+}
+
+type TLSOptions struct {
+	CertFile        string
+	KeyFile         string
+	ClientCAFile    string
+	RedirectAddress string
+}
+
+// Worker is a background task that runs until its context is cancelled.
+type Worker func(ctx context.Context)
+
+type Server struct {
+	opts          Options
+	handler       http.Handler
+	db            database.Database
+	workers       []Worker
+	shutdownHooks []func()
+}
+
+func New(opts Options, handler http.Handler, db database.Database) *Server {
+	return &Server{
+		opts:    opts,
+		handler: handler,
+		db:      db,
+	}
+}
+
+func (s *Server) AddWorker(worker Worker) {
+	s.workers = append(s.workers, worker)
+}
+
+// OnShutdown registers a function called as soon as shutdown starts, before
+// the server stops accepting connections.
+func (s *Server) OnShutdown(hook func()) {
+	s.shutdownHooks = append(s.shutdownHooks, hook)
+}
+
+// Run serves requests until ctx is cancelled, then runs the shutdown hooks,
+// keeps serving for ShutdownDelay, stops accepting connections, waits up to
+// ShutdownTimeout for in-flight requests to finish, stops the background
+// workers and closes the database.
+func (s *Server) Run(ctx context.Context) error {
+	servers, err := s.buildServers()
+	if err != nil {
+		return s.closeDb(err)
+	}
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	for _, worker := range s.workers {
+		workers.Add(1)
+		go func(worker Worker) {
+			defer workers.Done()
+			worker(workerCtx)
+		}(worker)
+	}
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			errs <- listen(srv)
+		}(srv)
+	}
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-errs:
+	}
+
+	for _, hook := range s.shutdownHooks {
+		hook()
+	}
+
+	if serveErr == nil {
+		time.Sleep(s.opts.ShutdownDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.opts.ShutdownTimeout)
+	defer cancel()
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil && serveErr == nil {
+			serveErr = fmt.Errorf("failed to drain connections: %w", err)
+		}
+	}
+
+	stopWorkers()
+	workers.Wait()
+
+	return s.closeDb(serveErr)
+}
+
+func (s *Server) buildServers() ([]*http.Server, error) {
+	api := &http.Server{
+		Addr:         s.opts.Address,
+		Handler:      s.handler,
+		ReadTimeout:  s.opts.ReadTimeout,
+		WriteTimeout: s.opts.WriteTimeout,
+		IdleTimeout:  s.opts.IdleTimeout,
+	}
+
+	if !utils.IsSet(s.opts.TLS.CertFile) {
+		return []*http.Server{api}, nil
+	}
+
+	reloader, err := certs.NewReloader(s.opts.TLS.CertFile, s.opts.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	s.AddWorker(func(ctx context.Context) {
+		reloader.Watch(ctx, certReloadInterval)
+	})
+
+	tlsConfig, err := certs.NewServerConfig(reloader, s.opts.TLS.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	api.TLSConfig = tlsConfig
+	api.Handler = auth.ClientCertificate(s.handler)
+	servers := []*http.Server{api}
+
+	if utils.IsSet(s.opts.TLS.RedirectAddress) {
+		servers = append(servers, &http.Server{
+			Addr:         s.opts.TLS.RedirectAddress,
+			Handler:      certs.RedirectToHTTPS(s.opts.Address),
+			ReadTimeout:  s.opts.ReadTimeout,
+			WriteTimeout: s.opts.WriteTimeout,
+			IdleTimeout:  s.opts.IdleTimeout,
+		})
+	}
+
+	return servers, nil
+}
+
+func (s *Server) closeDb(err error) error {
+	if closeErr := s.db.Close(); closeErr != nil && err == nil {
+		return closeErr
+	}
+
+	return err
+}
+
+func listen(srv *http.Server) error {
+	var err error
+	if srv.TLSConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
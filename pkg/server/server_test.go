@@ -0,0 +1,135 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/server"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		fake_db *databasefakes.FakeDatabase
+		opts    server.Options
+	)
+
+	BeforeEach(func() {
+		fake_db = new(databasefakes.FakeDatabase)
+		opts = server.Options{
+			Address:         "127.0.0.1:10100",
+			ReadTimeout:     time.Second,
+			WriteTimeout:    time.Second,
+			IdleTimeout:     time.Second,
+			ShutdownTimeout: time.Second,
+		}
+	})
+
+	run := func(s *server.Server, ctx context.Context) chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Run(ctx)
+		}()
+
+		return done
+	}
+
+	It("drains in-flight requests, stops workers and closes the database on shutdown", func() {
+		started := make(chan struct{})
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte("done"))
+		})
+
+		workerStopped := make(chan struct{})
+		s := server.New(opts, handler, fake_db)
+		s.AddWorker(func(ctx context.Context) {
+			<-ctx.Done()
+			close(workerStopped)
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := run(s, ctx)
+
+		responses := make(chan string, 1)
+		go func() {
+			defer GinkgoRecover()
+			var resp *http.Response
+			Eventually(func() error {
+				var err error
+				resp, err = http.Get("http://127.0.0.1:10100/")
+				return err
+			}).Should(Succeed())
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			responses <- string(body)
+		}()
+
+		Eventually(started).Should(BeClosed())
+		cancel()
+
+		Eventually(responses).Should(Receive(Equal("done")))
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(workerStopped).To(BeClosed())
+		Expect(fake_db.CloseCallCount()).To(Equal(1))
+	})
+
+	It("runs the shutdown hooks and keeps serving for the shutdown delay", func() {
+		opts.ShutdownDelay = 300 * time.Millisecond
+		s := server.New(opts, http.NotFoundHandler(), fake_db)
+
+		shuttingDown := make(chan struct{})
+		s.OnShutdown(func() { close(shuttingDown) })
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := run(s, ctx)
+
+		Eventually(func() error {
+			resp, err := http.Get("http://127.0.0.1:10100/")
+			if err == nil {
+				resp.Body.Close()
+			}
+			return err
+		}).Should(Succeed())
+
+		cancel()
+		Eventually(shuttingDown).Should(BeClosed())
+
+		resp, err := http.Get("http://127.0.0.1:10100/")
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	Context("when the server cannot listen", func() {
+		It("closes the database and returns the error", func() {
+			opts.Address = "not an address"
+			s := server.New(opts, http.NotFoundHandler(), fake_db)
+
+			err := s.Run(context.Background())
+			Expect(err).To(HaveOccurred())
+			Expect(fake_db.CloseCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when closing the database fails", func() {
+		It("returns the error", func() {
+			fake_db.CloseReturns(errors.New("Not closed"))
+			s := server.New(opts, http.NotFoundHandler(), fake_db)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := run(s, ctx)
+			cancel()
+
+			Eventually(done).Should(Receive(MatchError("Not closed")))
+		})
+	})
+})
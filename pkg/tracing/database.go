@@ -0,0 +1,270 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/models"
+)
+
+type tracedDatabase struct {
+	db      database.Database
+	backend string
+	tracer  trace.Tracer
+}
+
+// Instrument wraps db so that every operation runs in its own span, which the
+// backend's filesystem or SQL spans are children of.
+func Instrument(db database.Database, backend string) database.Database {
+	return &tracedDatabase{
+		db:      db,
+		backend: backend,
+		tracer:  otel.Tracer("github.com/m-rcd/notes/pkg/database"),
+	}
+}
+
+func (t *tracedDatabase) start(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("notes.storage.backend", t.backend))
+
+	return t.tracer.Start(ctx, "storage."+operation, trace.WithAttributes(attrs...))
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *tracedDatabase) Open() error {
+	return t.db.Open()
+}
+
+func (t *tracedDatabase) Close() error {
+	return t.db.Close()
+}
+
+func (t *tracedDatabase) Ping(ctx context.Context) error {
+	ctx, span := t.start(ctx, "ping")
+	err := t.db.Ping(ctx)
+	end(span, err)
+
+	return err
+}
+
+func (t *tracedDatabase) Create(ctx context.Context, body io.ReadCloser) (models.Note, error) {
+	ctx, span := t.start(ctx, "create")
+	note, err := t.db.Create(ctx, body)
+	span.SetAttributes(attribute.String("notes.note.id", note.Id))
+	end(span, err)
+
+	return note, err
+}
+
+func (t *tracedDatabase) Patch(ctx context.Context, id string, username string, apply func(models.Note) (models.Note, error)) (models.Note, error) {
+	ctx, span := t.start(ctx, "patch", attribute.String("notes.note.id", id), attribute.String("notes.user", username))
+	note, err := t.db.Patch(ctx, id, username, apply)
+	end(span, err)
+
+	return note, err
+}
+
+func (t *tracedDatabase) SetArchived(ctx context.Context, username string, ids []string, archived bool) ([]models.NoteResult, error) {
+	ctx, span := t.start(ctx, "set_archived", attribute.String("notes.user", username), attribute.Int("notes.note.count", len(ids)), attribute.Bool("notes.note.archived", archived))
+	results, err := t.db.SetArchived(ctx, username, ids, archived)
+	end(span, err)
+
+	return results, err
+}
+
+func (t *tracedDatabase) Delete(ctx context.Context, id string, username string) error {
+	ctx, span := t.start(ctx, "delete", attribute.String("notes.note.id", id), attribute.String("notes.user", username))
+	err := t.db.Delete(ctx, id, username)
+	end(span, err)
+
+	return err
+}
+
+func (t *tracedDatabase) Batch(ctx context.Context, username string, operations []database.Operation) ([]models.NoteResult, error) {
+	ctx, span := t.start(ctx, "batch", attribute.String("notes.user", username), attribute.Int("notes.operation.count", len(operations)))
+	results, err := t.db.Batch(ctx, username, operations)
+	end(span, err)
+
+	return results, err
+}
+
+func (t *tracedDatabase) ListActiveNotes(ctx context.Context, username string) ([]models.Note, error) {
+	ctx, span := t.start(ctx, "list_active_notes", attribute.String("notes.user", username))
+	notes, err := t.db.ListActiveNotes(ctx, username)
+	span.SetAttributes(attribute.Int("notes.note.count", len(notes)))
+	end(span, err)
+
+	return notes, err
+}
+
+func (t *tracedDatabase) ListArchivedNotes(ctx context.Context, username string) ([]models.Note, error) {
+	ctx, span := t.start(ctx, "list_archived_notes", attribute.String("notes.user", username))
+	notes, err := t.db.ListArchivedNotes(ctx, username)
+	span.SetAttributes(attribute.Int("notes.note.count", len(notes)))
+	end(span, err)
+
+	return notes, err
+}
+
+func (t *tracedDatabase) EachNote(ctx context.Context, username string, fn func(models.Note) error) error {
+	ctx, span := t.start(ctx, "each_note", attribute.String("notes.user", username))
+
+	count := 0
+	err := t.db.EachNote(ctx, username, func(note models.Note) error {
+		count++
+		return fn(note)
+	})
+	span.SetAttributes(attribute.Int("notes.note.count", count))
+	end(span, err)
+
+	return err
+}
+
+func (t *tracedDatabase) CountNotes(ctx context.Context) (int, int, error) {
+	ctx, span := t.start(ctx, "count_notes")
+	active, archived, err := t.db.CountNotes(ctx)
+	end(span, err)
+
+	return active, archived, err
+}
+
+func (t *tracedDatabase) ListUsers(ctx context.Context) ([]string, error) {
+	ctx, span := t.start(ctx, "list_users")
+	users, err := t.db.ListUsers(ctx)
+	span.SetAttributes(attribute.Int("notes.user.count", len(users)))
+	end(span, err)
+
+	return users, err
+}
+
+func (t *tracedDatabase) PutNote(ctx context.Context, note models.Note) (models.Note, bool, error) {
+	ctx, span := t.start(ctx, "put_note", attribute.String("notes.user", note.User.Username))
+	stored, created, err := t.db.PutNote(ctx, note)
+	span.SetAttributes(attribute.String("notes.note.id", stored.Id), attribute.Bool("notes.note.created", created))
+	end(span, err)
+
+	return stored, created, err
+}
+
+func (t *tracedDatabase) Snapshot(ctx context.Context, fn func(database.Snapshot) error) error {
+	ctx, span := t.start(ctx, "snapshot")
+	err := t.db.Snapshot(ctx, fn)
+	end(span, err)
+
+	return err
+}
+
+func (t *tracedDatabase) ClaimIdempotencyKey(ctx context.Context, record database.IdempotencyRecord, now time.Time) (database.IdempotencyRecord, bool, error) {
+	ctx, span := t.start(ctx, "claim_idempotency_key")
+	existing, claimed, err := t.db.ClaimIdempotencyKey(ctx, record, now)
+	span.SetAttributes(attribute.Bool("notes.idempotency.claimed", claimed))
+	end(span, err)
+
+	return existing, claimed, err
+}
+
+func (t *tracedDatabase) SaveIdempotencyKey(ctx context.Context, record database.IdempotencyRecord) error {
+	ctx, span := t.start(ctx, "save_idempotency_key", attribute.Int("http.status_code", record.StatusCode))
+	err := t.db.SaveIdempotencyKey(ctx, record)
+	end(span, err)
+
+	return err
+}
+
+func (t *tracedDatabase) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	ctx, span := t.start(ctx, "release_idempotency_key")
+	err := t.db.ReleaseIdempotencyKey(ctx, key)
+	end(span, err)
+
+	return err
+}
+
+func (t *tracedDatabase) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	ctx, span := t.start(ctx, "create_webhook", attribute.String("notes.user", webhook.User.Username))
+	created, err := t.db.CreateWebhook(ctx, webhook)
+	span.SetAttributes(attribute.String("notes.webhook.id", created.Id))
+	end(span, err)
+
+	return created, err
+}
+
+func (t *tracedDatabase) ListWebhooks(ctx context.Context, username string) ([]models.Webhook, error) {
+	ctx, span := t.start(ctx, "list_webhooks", attribute.String("notes.user", username))
+	webhooks, err := t.db.ListWebhooks(ctx, username)
+	span.SetAttributes(attribute.Int("notes.webhook.count", len(webhooks)))
+	end(span, err)
+
+	return webhooks, err
+}
+
+func (t *tracedDatabase) DeleteWebhook(ctx context.Context, id string, username string) error {
+	ctx, span := t.start(ctx, "delete_webhook", attribute.String("notes.webhook.id", id), attribute.String("notes.user", username))
+	err := t.db.DeleteWebhook(ctx, id, username)
+	end(span, err)
+
+	return err
+}
+
+func (t *tracedDatabase) SaveDelivery(ctx context.Context, delivery models.Delivery) error {
+	ctx, span := t.start(ctx, "save_delivery", attribute.String("notes.webhook.id", delivery.WebhookId), attribute.Int("notes.delivery.attempt", delivery.Attempt))
+	err := t.db.SaveDelivery(ctx, delivery)
+	end(span, err)
+
+	return err
+}
+
+func (t *tracedDatabase) ListDeliveries(ctx context.Context, webhookID string, username string) ([]models.Delivery, error) {
+	ctx, span := t.start(ctx, "list_deliveries", attribute.String("notes.webhook.id", webhookID))
+	deliveries, err := t.db.ListDeliveries(ctx, webhookID, username)
+	span.SetAttributes(attribute.Int("notes.delivery.count", len(deliveries)))
+	end(span, err)
+
+	return deliveries, err
+}
+
+func (t *tracedDatabase) Changes(ctx context.Context, username string, since uint64) ([]models.Change, uint64, error) {
+	ctx, span := t.start(ctx, "changes", attribute.String("notes.user", username), attribute.Int64("notes.sync.since", int64(since)))
+	changes, latest, err := t.db.Changes(ctx, username, since)
+	span.SetAttributes(attribute.Int("notes.change.count", len(changes)))
+	end(span, err)
+
+	return changes, latest, err
+}
+
+func (t *tracedDatabase) ApplyChanges(ctx context.Context, username string, changes []models.Change) ([]models.ChangeResult, error) {
+	ctx, span := t.start(ctx, "apply_changes", attribute.String("notes.user", username), attribute.Int("notes.change.count", len(changes)))
+	results, err := t.db.ApplyChanges(ctx, username, changes)
+	end(span, err)
+
+	return results, err
+}
+
+func (t *tracedDatabase) DataKeys(ctx context.Context, username string) ([]database.DataKey, error) {
+	ctx, span := t.start(ctx, "data_keys", attribute.String("notes.user", username))
+	keys, err := t.db.DataKeys(ctx, username)
+	span.SetAttributes(attribute.Int("notes.data_key.count", len(keys)))
+	end(span, err)
+
+	return keys, err
+}
+
+func (t *tracedDatabase) SaveDataKey(ctx context.Context, key database.DataKey) error {
+	ctx, span := t.start(ctx, "save_data_key", attribute.String("notes.user", key.Username), attribute.String("notes.data_key.id", key.Id))
+	err := t.db.SaveDataKey(ctx, key)
+	end(span, err)
+
+	return err
+}
@@ -0,0 +1,127 @@
+package tracing_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/database/local"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/tracing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// The backends look their tracer up once, so a single provider is shared by
+// every test and each test only inspects the spans it produced.
+var recorder = tracetest.NewSpanRecorder()
+
+func init() {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+}
+
+var _ = Describe("Tracing", func() {
+	var (
+		db      database.Database
+		tempDir string
+		seen    int
+		err     error
+	)
+
+	BeforeEach(func() {
+		tempDir, err = ioutil.TempDir("", "tracing_test")
+		Expect(err).NotTo(HaveOccurred())
+
+		db = tracing.Instrument(local.NewLocalFileSystem(tempDir), "local")
+		Expect(db.Open()).To(Succeed())
+
+		seen = len(recorder.Ended())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	spans := func() map[string]sdktrace.ReadOnlySpan {
+		byName := map[string]sdktrace.ReadOnlySpan{}
+		for _, span := range recorder.Ended()[seen:] {
+			byName[span.Name()] = span
+		}
+
+		return byName
+	}
+
+	body := func(s string) io.ReadCloser {
+		return io.NopCloser(strings.NewReader(s))
+	}
+
+	Context("Instrument", func() {
+		It("creates a span per operation with the storage calls as children", func() {
+			_, err := db.Create(context.Background(), body(`{"name": "Daemons", "content": "Pan", "user": {"username": "Lyra"}}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			got := spans()
+			Expect(got).To(HaveKey("storage.create"))
+			Expect(got).To(HaveKey("decode body"))
+			Expect(got).To(HaveKey("fs.write"))
+
+			parent := got["storage.create"].SpanContext().SpanID()
+			Expect(got["decode body"].Parent().SpanID()).To(Equal(parent))
+			Expect(got["fs.write"].Parent().SpanID()).To(Equal(parent))
+		})
+
+		It("records errors on the span", func() {
+			_, err := db.Patch(context.Background(), "123", "Lyra", func(note models.Note) (models.Note, error) {
+				return note, nil
+			})
+			Expect(err).To(HaveOccurred())
+
+			span := spans()["storage.patch"]
+			Expect(span).NotTo(BeNil())
+			Expect(span.Status().Description).To(ContainSubstring("note does not exist"))
+			Expect(span.Events()).NotTo(BeEmpty())
+		})
+	})
+
+	Context("Middleware", func() {
+		It("continues the trace from the request headers into storage", func() {
+			_, err := tracing.Setup(context.Background(), "none", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			router := mux.NewRouter()
+			router.Use(tracing.Middleware())
+			router.HandleFunc("/notes/active", func(w http.ResponseWriter, r *http.Request) {
+				_, err := db.ListActiveNotes(r.Context(), "Lyra")
+				Expect(err).NotTo(HaveOccurred())
+			}).Methods("GET")
+
+			req, err := http.NewRequest("GET", "http://localhost:10000/notes/active", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			got := spans()
+			Expect(got).To(HaveKey("/notes/active"))
+			Expect(got).To(HaveKey("storage.list_active_notes"))
+			Expect(got["/notes/active"].SpanContext().TraceID().String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Expect(got["storage.list_active_notes"].Parent().SpanID()).To(Equal(got["/notes/active"].SpanContext().SpanID()))
+		})
+	})
+
+	Context("Setup", func() {
+		It("rejects unknown exporters", func() {
+			_, err := tracing.Setup(context.Background(), "jaeger", "")
+			Expect(err).To(MatchError(`unknown tracing exporter "jaeger"`))
+		})
+	})
+})
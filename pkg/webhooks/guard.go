@@ -0,0 +1,115 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrInternalAddress is returned for webhooks whose host is, or resolves to,
+// an address of the server's own network, which subscribers could otherwise
+// reach through the server.
+var ErrInternalAddress = errors.New("url must not point to a loopback, private, link-local or other internal address")
+
+// sharedAddressSpace is the carrier-grade NAT range, which is as internal as
+// the private ranges.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Resolver looks up the addresses of a host, like net.DefaultResolver.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Guard decides which addresses webhooks may be delivered to. Internal
+// addresses are refused when subscribing a webhook, after resolving its host,
+// and again when connecting to it, so that a host resolving differently by
+// then cannot get around the check.
+type Guard struct {
+	// AllowInternal lets webhooks reach internal addresses, for receivers
+	// running next to the server.
+	AllowInternal bool
+	// Resolver defaults to net.DefaultResolver.
+	Resolver Resolver
+}
+
+// CheckURL refuses a webhook URL whose host has an internal address.
+func (g Guard) CheckURL(ctx context.Context, rawURL string) error {
+	if g.AllowInternal {
+		return nil
+	}
+
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	host := target.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return checkIP(ip)
+	}
+
+	resolver := g.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %q: %w", host, err)
+	}
+
+	for _, addr := range addrs {
+		if err := checkIP(addr.IP); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// transport connects straight to the receivers, rather than through a proxy,
+// so that the addresses it checks are theirs.
+func (g Guard) transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   g.control,
+	}).DialContext
+
+	return transport
+}
+
+// control runs once the address to connect to is resolved.
+func (g Guard) control(network, address string, _ syscall.RawConn) error {
+	if g.AllowInternal {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("cannot parse address %q", address)
+	}
+
+	return checkIP(ip)
+}
+
+func checkIP(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip) {
+		return ErrInternalAddress
+	}
+
+	return nil
+}
@@ -0,0 +1,373 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/m-rcd/notes/pkg/database"
+	"github.com/m-rcd/notes/pkg/events"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/utils"
+)
+
+const (
+	// SignatureHeader holds `sha256=` and the hex HMAC-SHA256 of the
+	// timestamp, a dot and the body, keyed with the webhook's secret.
+	SignatureHeader = "X-Notes-Signature"
+	// TimestampHeader holds when the attempt was made, in Unix seconds.
+	TimestampHeader = "X-Notes-Timestamp"
+	EventHeader     = "X-Notes-Event"
+	// DeliveryHeader identifies the delivery, and is the same for every
+	// attempt at it.
+	DeliveryHeader = "X-Notes-Delivery"
+)
+
+// queueSize is how many events can wait for a worker before new ones are
+// dropped.
+const queueSize = 1000
+
+// Options tunes a Dispatcher. Zero values take the defaults.
+type Options struct {
+	Workers     int
+	MaxAttempts int
+	// Backoff is the delay before the second attempt, which doubles for
+	// every attempt after it up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds each attempt.
+	Timeout time.Duration
+	// Guard refuses deliveries to internal addresses.
+	Guard Guard
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = time.Minute
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+
+	return o
+}
+
+// Dispatcher delivers the events of a broker to the webhooks subscribed to
+// them. Failed attempts are retried with exponential backoff, and the last
+// one is kept as a dead delivery. Every attempt is logged in the database.
+type Dispatcher struct {
+	db      database.Database
+	logger  *logrus.Logger
+	client  *http.Client
+	options Options
+	queue   chan job
+	done    chan struct{}
+
+	mu      sync.Mutex
+	retries map[*time.Timer]job
+	pending sync.WaitGroup
+}
+
+type job struct {
+	event    events.Event
+	webhook  *models.Webhook
+	delivery string
+	attempt  int
+	payload  []byte
+}
+
+func NewDispatcher(db database.Database, logger *logrus.Logger, options Options) *Dispatcher {
+	options = options.withDefaults()
+
+	return &Dispatcher{
+		db:      db,
+		logger:  logger,
+		client:  &http.Client{Timeout: options.Timeout, Transport: options.Guard.transport()},
+		options: options,
+		queue:   make(chan job, queueSize),
+		done:    make(chan struct{}),
+		retries: map[*time.Timer]job{},
+	}
+}
+
+// Notify queues event for delivery. It never blocks, so it can listen to a
+// broker.
+func (d *Dispatcher) Notify(event events.Event) {
+	select {
+	case d.queue <- job{event: event}:
+	default:
+		d.logger.WithField("event", event.ID).Error("webhook queue is full, dropping event")
+	}
+}
+
+// Run delivers events until ctx is done, then waits for the attempts in
+// flight. Deliveries still waiting for a retry are kept as dead.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var workers sync.WaitGroup
+	for i := 0; i < d.options.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			d.work(ctx)
+		}()
+	}
+
+	<-ctx.Done()
+	close(d.done)
+	workers.Wait()
+
+	d.mu.Lock()
+	retries := d.retries
+	d.retries = map[*time.Timer]job{}
+	d.mu.Unlock()
+
+	for timer, j := range retries {
+		if timer.Stop() {
+			d.bury(j)
+			d.pending.Done()
+		}
+	}
+	d.pending.Wait()
+
+	for {
+		select {
+		case j := <-d.queue:
+			if j.webhook != nil {
+				d.bury(j)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-d.queue:
+			if j.webhook == nil {
+				d.fanOut(j.event)
+			} else {
+				d.attempt(j)
+			}
+		}
+	}
+}
+
+// fanOut makes the first attempt at delivering event to each webhook of the
+// note's user that subscribed to it.
+func (d *Dispatcher) fanOut(event events.Event) {
+	logger := d.logger.WithField("event", event.ID)
+
+	webhooks, err := d.db.ListWebhooks(context.Background(), event.Note.User.Username)
+	if err != nil {
+		logger.WithError(err).Error("failed to list webhooks, dropping event")
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.WithError(err).Error("failed to encode event, dropping it")
+		return
+	}
+
+	for i := range webhooks {
+		if !contains(webhooks[i].Events, event.Type) {
+			continue
+		}
+
+		delivery, _ := uuid.NewV4()
+		d.attempt(job{event: event, webhook: &webhooks[i], delivery: delivery.String(), attempt: 1, payload: payload})
+	}
+}
+
+func (d *Dispatcher) attempt(j job) {
+	delivery := d.delivery(j)
+
+	delivery.StatusCode, delivery.Error = d.send(j)
+	if delivery.Error == "" {
+		d.save(delivery)
+		return
+	}
+
+	if j.attempt >= d.options.MaxAttempts {
+		delivery.Dead = true
+		delivery.Payload = string(j.payload)
+		d.save(delivery)
+		return
+	}
+
+	d.save(delivery)
+	d.retry(j)
+}
+
+// send makes one attempt, and reports why it failed.
+func (d *Dispatcher) send(j job) (int, string) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest("POST", j.webhook.URL, bytes.NewReader(j.payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, j.event.Type)
+	req.Header.Set(DeliveryHeader, j.delivery)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(j.webhook.Secret, timestamp, j.payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Sprintf("receiver answered %s", resp.Status)
+	}
+
+	return resp.StatusCode, ""
+}
+
+// retry queues the next attempt once its backoff is over.
+func (d *Dispatcher) retry(j job) {
+	delay := d.options.Backoff << (j.attempt - 1)
+	if delay > d.options.MaxBackoff || delay <= 0 {
+		delay = d.options.MaxBackoff
+	}
+	j.attempt++
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pending.Add(1)
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		defer d.pending.Done()
+
+		d.mu.Lock()
+		delete(d.retries, timer)
+		d.mu.Unlock()
+
+		select {
+		case d.queue <- j:
+		case <-d.done:
+			d.bury(j)
+		}
+	})
+	d.retries[timer] = j
+}
+
+// bury keeps a delivery that will not be attempted again because the server
+// is shutting down.
+func (d *Dispatcher) bury(j job) {
+	delivery := d.delivery(j)
+	delivery.Error = "not attempted before the server shut down"
+	delivery.Dead = true
+	delivery.Payload = string(j.payload)
+
+	d.save(delivery)
+}
+
+func (d *Dispatcher) delivery(j job) models.Delivery {
+	return models.Delivery{
+		Id:        j.delivery,
+		WebhookId: j.webhook.Id,
+		EventId:   j.event.ID,
+		EventType: j.event.Type,
+		Attempt:   j.attempt,
+		Time:      time.Now().UTC(),
+		Username:  j.webhook.User.Username,
+	}
+}
+
+func (d *Dispatcher) save(delivery models.Delivery) {
+	logger := d.logger.WithFields(logrus.Fields{"webhook": delivery.WebhookId, "delivery": delivery.Id, "attempt": delivery.Attempt})
+	switch {
+	case delivery.Dead:
+		logger.WithField("error", delivery.Error).Warn("gave up delivering webhook")
+	case delivery.Error != "":
+		logger.WithField("error", delivery.Error).Info("failed to deliver webhook, will retry")
+	}
+
+	if err := d.db.SaveDelivery(context.Background(), delivery); err != nil {
+		logger.WithError(err).Error("failed to save webhook delivery")
+	}
+}
+
+// Sign computes the signature header of a delivery.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Validate checks the URL and events of a webhook someone subscribes.
+func Validate(webhook models.Webhook) error {
+	target, err := url.Parse(webhook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || !utils.IsSet(target.Host) {
+		return fmt.Errorf("url must be an http or https URL, got %q", webhook.URL)
+	}
+
+	if len(webhook.Events) == 0 {
+		return errors.New("events must list at least one event")
+	}
+
+	for _, event := range webhook.Events {
+		if !contains(events.Types, event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+
+	return nil
+}
+
+// NewSecret makes a secret for webhooks subscribed without one.
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
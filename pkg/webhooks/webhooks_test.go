@@ -0,0 +1,275 @@
+package webhooks_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/m-rcd/notes/pkg/database/databasefakes"
+	"github.com/m-rcd/notes/pkg/events"
+	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// hosts resolves names like a DNS server would.
+type hosts map[string][]string
+
+func (h hosts) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := h[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+
+	return addrs, nil
+}
+
+var _ = Describe("Webhooks", func() {
+	Context("Dispatcher", func() {
+		type request struct {
+			header http.Header
+			body   []byte
+		}
+
+		var (
+			db         *databasefakes.FakeDatabase
+			broker     *events.Broker
+			dispatcher *webhooks.Dispatcher
+			receiver   *httptest.Server
+			cancel     context.CancelFunc
+			stopped    chan struct{}
+
+			mu       sync.Mutex
+			requests []request
+			statuses []int
+		)
+
+		BeforeEach(func() {
+			requests = nil
+			statuses = nil
+			receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+
+				mu.Lock()
+				defer mu.Unlock()
+				requests = append(requests, request{header: r.Header, body: body})
+				status := http.StatusOK
+				if len(statuses) > 0 {
+					status, statuses = statuses[0], statuses[1:]
+				}
+				w.WriteHeader(status)
+			}))
+
+			db = new(databasefakes.FakeDatabase)
+			db.ListWebhooksReturns([]models.Webhook{
+				{Id: "1", User: models.User{Username: "Buffy"}, URL: receiver.URL, Events: []string{events.NoteCreated}, Secret: "stake"},
+				{Id: "2", User: models.User{Username: "Buffy"}, URL: receiver.URL, Events: []string{events.NoteDeleted}, Secret: "stake"},
+			}, nil)
+
+			logger := logrus.New()
+			logger.SetOutput(GinkgoWriter)
+			dispatcher = webhooks.NewDispatcher(db, logger, webhooks.Options{Workers: 2, MaxAttempts: 3, Backoff: 10 * time.Millisecond, Guard: webhooks.Guard{AllowInternal: true}})
+			broker = events.NewBroker(events.DefaultLogSize)
+			broker.Listen(dispatcher.Notify)
+		})
+
+		run := func() {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			stopped = make(chan struct{})
+			go func() {
+				defer close(stopped)
+				dispatcher.Run(ctx)
+			}()
+		}
+
+		stop := func() {
+			cancel()
+			Eventually(stopped).Should(BeClosed())
+		}
+
+		AfterEach(func() {
+			if cancel != nil {
+				stop()
+			}
+			receiver.Close()
+		})
+
+		received := func() []request {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]request(nil), requests...)
+		}
+
+		deliveries := func() []models.Delivery {
+			var saved []models.Delivery
+			for i := 0; i < db.SaveDeliveryCallCount(); i++ {
+				_, delivery := db.SaveDeliveryArgsForCall(i)
+				saved = append(saved, delivery)
+			}
+			return saved
+		}
+
+		It("sends signed events to the webhooks subscribed to them", func() {
+			run()
+			event := broker.Publish(events.NoteCreated, models.Note{Id: "7", Name: "Vampires", User: models.User{Username: "Buffy"}})
+
+			Eventually(received).Should(HaveLen(1))
+			first := received()[0]
+			Expect(first.header.Get(webhooks.EventHeader)).To(Equal(events.NoteCreated))
+			Expect(first.header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(first.body).To(ContainSubstring(`"id":"` + event.ID + `"`))
+
+			timestamp := first.header.Get(webhooks.TimestampHeader)
+			Expect(first.header.Get(webhooks.SignatureHeader)).To(Equal(webhooks.Sign("stake", timestamp, first.body)))
+
+			Eventually(deliveries).Should(HaveLen(1))
+			delivery := deliveries()[0]
+			Expect(delivery.Id).To(Equal(first.header.Get(webhooks.DeliveryHeader)))
+			Expect(delivery.WebhookId).To(Equal("1"))
+			Expect(delivery.EventId).To(Equal(event.ID))
+			Expect(delivery.Attempt).To(Equal(1))
+			Expect(delivery.StatusCode).To(Equal(http.StatusOK))
+			Expect(delivery.Dead).To(BeFalse())
+			Expect(delivery.Username).To(Equal("Buffy"))
+
+			_, username := db.ListWebhooksArgsForCall(0)
+			Expect(username).To(Equal("Buffy"))
+		})
+
+		It("retries failed deliveries with the same delivery id", func() {
+			statuses = []int{http.StatusInternalServerError, http.StatusBadGateway}
+			run()
+			broker.Publish(events.NoteCreated, models.Note{Id: "7", User: models.User{Username: "Buffy"}})
+
+			Eventually(deliveries).Should(HaveLen(3))
+			saved := deliveries()
+			Expect(saved[0].Error).To(Equal("receiver answered 500 Internal Server Error"))
+			Expect(saved[1].StatusCode).To(Equal(http.StatusBadGateway))
+			Expect(saved[2].Attempt).To(Equal(3))
+			Expect(saved[2].Error).To(BeEmpty())
+			Expect(saved[2].Id).To(Equal(saved[0].Id))
+
+			requests := received()
+			Expect(requests[2].header.Get(webhooks.DeliveryHeader)).To(Equal(requests[0].header.Get(webhooks.DeliveryHeader)))
+		})
+
+		It("gives up after the last attempt and keeps the payload", func() {
+			statuses = []int{500, 500, 500}
+			run()
+			broker.Publish(events.NoteCreated, models.Note{Id: "7", User: models.User{Username: "Buffy"}})
+
+			Eventually(deliveries).Should(HaveLen(3))
+			Consistently(deliveries, 50*time.Millisecond).Should(HaveLen(3))
+			dead := deliveries()[2]
+			Expect(dead.Dead).To(BeTrue())
+			Expect(dead.Payload).To(ContainSubstring(`"type":"note.created"`))
+			Expect(deliveries()[1].Dead).To(BeFalse())
+		})
+
+		It("keeps deliveries waiting for a retry as dead when stopped", func() {
+			dispatcher = webhooks.NewDispatcher(db, logrus.New(), webhooks.Options{MaxAttempts: 3, Backoff: time.Hour, Guard: webhooks.Guard{AllowInternal: true}})
+			broker.Listen(dispatcher.Notify)
+			statuses = []int{500}
+			run()
+			broker.Publish(events.NoteCreated, models.Note{Id: "7", User: models.User{Username: "Buffy"}})
+			Eventually(deliveries).Should(HaveLen(1))
+
+			stop()
+			cancel = nil
+			Expect(deliveries()).To(HaveLen(2))
+			dead := deliveries()[1]
+			Expect(dead.Dead).To(BeTrue())
+			Expect(dead.Attempt).To(Equal(2))
+			Expect(dead.Error).To(Equal("not attempted before the server shut down"))
+		})
+
+		It("does not connect to internal addresses unless allowed to", func() {
+			dispatcher = webhooks.NewDispatcher(db, logrus.New(), webhooks.Options{MaxAttempts: 1})
+			broker = events.NewBroker(events.DefaultLogSize)
+			broker.Listen(dispatcher.Notify)
+			run()
+			broker.Publish(events.NoteCreated, models.Note{Id: "7", User: models.User{Username: "Buffy"}})
+
+			Eventually(deliveries).Should(HaveLen(1))
+			Expect(deliveries()[0].Error).To(ContainSubstring(webhooks.ErrInternalAddress.Error()))
+			Expect(deliveries()[0].Dead).To(BeTrue())
+			Expect(received()).To(BeEmpty())
+		})
+	})
+
+	Context("Guard", func() {
+		resolver := hosts{"example.com": {"93.184.216.34"}, "sneaky.example": {"93.184.216.34", "10.1.2.3"}}
+		guard := webhooks.Guard{Resolver: resolver}
+
+		It("accepts public addresses", func() {
+			Expect(guard.CheckURL(context.Background(), "https://example.com/hooks")).To(Succeed())
+			Expect(guard.CheckURL(context.Background(), "http://93.184.216.34:8080/hooks")).To(Succeed())
+		})
+
+		It("rejects loopback, private, link-local and unspecified addresses", func() {
+			for _, url := range []string{
+				"http://127.0.0.1/hooks",
+				"http://[::1]:8080/hooks",
+				"http://169.254.169.254/latest/meta-data",
+				"http://10.0.0.7/hooks",
+				"http://192.168.1.1/hooks",
+				"http://[fd00::1]/hooks",
+				"http://100.64.0.1/hooks",
+				"http://0.0.0.0/hooks",
+			} {
+				Expect(guard.CheckURL(context.Background(), url)).To(MatchError(webhooks.ErrInternalAddress), url)
+			}
+		})
+
+		It("rejects hosts with any internal address", func() {
+			Expect(guard.CheckURL(context.Background(), "https://sneaky.example/hooks")).To(MatchError(webhooks.ErrInternalAddress))
+		})
+
+		It("rejects hosts that do not resolve", func() {
+			Expect(guard.CheckURL(context.Background(), "https://nowhere.example/hooks")).To(MatchError(ContainSubstring(`cannot resolve "nowhere.example"`)))
+		})
+
+		It("accepts anything when internal addresses are allowed", func() {
+			Expect(webhooks.Guard{AllowInternal: true, Resolver: resolver}.CheckURL(context.Background(), "http://127.0.0.1/hooks")).To(Succeed())
+		})
+	})
+
+	Context("Validate", func() {
+		webhook := models.Webhook{URL: "https://example.com/hooks", Events: []string{events.NoteCreated}}
+
+		It("accepts http and https URLs with known events", func() {
+			Expect(webhooks.Validate(webhook)).To(Succeed())
+		})
+
+		It("rejects other URLs", func() {
+			invalid := webhook
+			invalid.URL = "ftp://example.com"
+			Expect(webhooks.Validate(invalid)).To(MatchError(`url must be an http or https URL, got "ftp://example.com"`))
+
+			invalid.URL = "/hooks"
+			Expect(webhooks.Validate(invalid)).To(HaveOccurred())
+		})
+
+		It("rejects missing and unknown events", func() {
+			invalid := webhook
+			invalid.Events = nil
+			Expect(webhooks.Validate(invalid)).To(MatchError("events must list at least one event"))
+
+			invalid.Events = []string{"note.read"}
+			Expect(webhooks.Validate(invalid)).To(MatchError(`unknown event "note.read"`))
+		})
+	})
+})
@@ -1,21 +1,28 @@
 package integration_test
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
 
+	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
+	"github.com/m-rcd/notes/pkg/collab"
 	"github.com/m-rcd/notes/pkg/models"
+	"github.com/m-rcd/notes/pkg/openapi"
 	"github.com/m-rcd/notes/pkg/responses"
 	"github.com/m-rcd/notes/pkg/utils"
 
 	. "github.com/onsi/ginkgo"
 	"github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
 )
 
@@ -25,7 +32,9 @@ var _ = Describe("When running the note server", func() {
 	)
 
 	BeforeEach(func() {
-		Expect(godotenv.Load("./../../.env")).To(Succeed())
+		if err := godotenv.Load("./../../.env"); err != nil && !os.IsNotExist(err) {
+			Expect(err).NotTo(HaveOccurred())
+		}
 
 		var err error
 		tempDir, err = ioutil.TempDir("", "local_integration_test")
@@ -51,7 +60,7 @@ var _ = Describe("When running the note server", func() {
 			note1   models.Note
 			note2   models.Note
 
-			c    = http.Client{}
+			c    = validatingClient()
 			args = getArgs()
 		)
 
@@ -81,6 +90,8 @@ var _ = Describe("When running the note server", func() {
 			g.Expect(response.Type).To(Equal("success"))
 			g.Expect(response.StatusCode).To(Equal(200))
 			g.Expect(response.Message).To(Equal("The note was successfully created"))
+			g.Expect(resp.Header.Get("Deprecation")).To(Equal("true"))
+			g.Expect(resp.Header.Get("Sunset")).NotTo(BeEmpty())
 			note1 = response.Data[0]
 			g.Expect(note1.Name).To(Equal("note1"))
 
@@ -92,6 +103,7 @@ var _ = Describe("When running the note server", func() {
 			patchData := bytes.NewBuffer([]byte(`{"name":"note1","content":"I am updated!","user":{"username":"Pantalaimon"}}`))
 			req, err := http.NewRequest("PATCH", "http://localhost:10000/note/"+note1.Id, patchData)
 			g.Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
 			resp, err := c.Do(req)
 			g.Expect(err).NotTo(HaveOccurred())
 			body, err := ioutil.ReadAll(resp.Body)
@@ -131,9 +143,9 @@ var _ = Describe("When running the note server", func() {
 
 		By("listing active notes")
 		Eventually(func(g Gomega) error {
-			data := bytes.NewBuffer([]byte(`{"username":"Pantalaimon"}`))
-			req, err := http.NewRequest("GET", "http://localhost:10000/notes/active", data)
+			req, err := http.NewRequest("GET", "http://localhost:10000/notes/active?username=Pantalaimon", &bytes.Buffer{})
 			g.Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
 			resp, err := c.Do(req)
 			g.Expect(err).NotTo(HaveOccurred())
 			body, err := ioutil.ReadAll(resp.Body)
@@ -149,11 +161,27 @@ var _ = Describe("When running the note server", func() {
 
 		}, "20s").Should(Succeed())
 
+		By("scraping metrics")
+		Eventually(func(g Gomega) error {
+			resp, err := c.Get("http://localhost:10000/metrics")
+			g.Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			g.Expect(string(body)).To(ContainSubstring(`notes_http_requests_total{method="POST",route="/note",status="200"}`))
+			g.Expect(string(body)).To(MatchRegexp(`notes_notes{backend="(local|sql)",state="active"} \d+`))
+			g.Expect(string(body)).To(ContainSubstring(`operation="create",result="success"`))
+			return nil
+
+		}, "20s").Should(Succeed())
+
 		By("archiving a note")
 		Eventually(func(g Gomega) error {
 			patchData := bytes.NewBuffer([]byte(`{"archived":true,"user":{"username":"Pantalaimon"}}`))
 			req, err := http.NewRequest("PATCH", "http://localhost:10000/note/"+note1.Id, patchData)
 			g.Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
 			resp, err := c.Do(req)
 			g.Expect(err).NotTo(HaveOccurred())
 			body, err := ioutil.ReadAll(resp.Body)
@@ -175,6 +203,7 @@ var _ = Describe("When running the note server", func() {
 			data := bytes.NewBuffer([]byte(`{"username":"Pantalaimon"}`))
 			req, err := http.NewRequest("GET", "http://localhost:10000/notes/archived", data)
 			g.Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
 			resp, err := c.Do(req)
 			g.Expect(err).NotTo(HaveOccurred())
 			body, err := ioutil.ReadAll(resp.Body)
@@ -194,6 +223,7 @@ var _ = Describe("When running the note server", func() {
 			patchData := bytes.NewBuffer([]byte(`{"archived":false,"user":{"username":"Pantalaimon"}}`))
 			req, err := http.NewRequest("PATCH", "http://localhost:10000/note/"+note1.Id, patchData)
 			g.Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
 			resp, err := c.Do(req)
 			g.Expect(err).NotTo(HaveOccurred())
 			body, err := ioutil.ReadAll(resp.Body)
@@ -215,7 +245,9 @@ var _ = Describe("When running the note server", func() {
 			data := bytes.NewBuffer([]byte(`{"username":"Pantalaimon"}`))
 			req, err := http.NewRequest("DELETE", "http://localhost:10000/note/"+note1.Id, data)
 			g.Expect(err).NotTo(HaveOccurred())
-			resp, _ := c.Do(req)
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := c.Do(req)
+			g.Expect(err).NotTo(HaveOccurred())
 			body, err := ioutil.ReadAll(resp.Body)
 			g.Expect(err).NotTo(HaveOccurred())
 			defer req.Body.Close()
@@ -232,10 +264,11 @@ var _ = Describe("When running the note server", func() {
 
 		By("deleting the second note")
 		Eventually(func(g Gomega) error {
-			data := bytes.NewBuffer([]byte(`{"username":"Pantalaimon"}`))
-			req, err := http.NewRequest("DELETE", "http://localhost:10000/note/"+note2.Id, data)
+			req, err := http.NewRequest("DELETE", "http://localhost:10000/note/"+note2.Id+"?username=Pantalaimon", &bytes.Buffer{})
+			g.Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := c.Do(req)
 			g.Expect(err).NotTo(HaveOccurred())
-			resp, _ := c.Do(req)
 			body, err := ioutil.ReadAll(resp.Body)
 			g.Expect(err).NotTo(HaveOccurred())
 			defer req.Body.Close()
@@ -253,8 +286,364 @@ var _ = Describe("When running the note server", func() {
 		table.Entry("local", localArgsBuilder),
 		table.Entry("sql", sqlArgsBuilder),
 	)
+
+	table.DescribeTable("the user can manipulate notes through the v1 API", func(getArgs func() []string) {
+		var (
+			note models.Note
+
+			c    = validatingClient()
+			args = getArgs()
+		)
+
+		if databaseNotRunning(args[1]) {
+			Skip("skipped because SQL database not set and running")
+		}
+
+		session, err := gexec.Start(exec.Command(cliBin, args...), GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		defer func() {
+			session.Terminate().Wait()
+		}()
+
+		sendAs := func(g Gomega, contentType, method, path, body string) (int, responses.JsonNoteResponse) {
+			req, err := http.NewRequest(method, "http://localhost:10000/api/v1"+path, bytes.NewBufferString(body))
+			g.Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", contentType)
+			resp, err := c.Do(req)
+			g.Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			g.Expect(resp.Header.Get("Deprecation")).To(BeEmpty())
+
+			var response responses.JsonNoteResponse
+			g.Expect(json.NewDecoder(resp.Body).Decode(&response)).To(Succeed())
+			g.Expect(response.StatusCode).To(Equal(resp.StatusCode))
+
+			return resp.StatusCode, response
+		}
+
+		send := func(g Gomega, method, path, body string) (int, responses.JsonNoteResponse) {
+			return sendAs(g, "application/json", method, path, body)
+		}
+
+		sendBulk := func(path, body string) (int, responses.JsonResultsResponse) {
+			req, err := http.NewRequest("POST", "http://localhost:10000/api/v1"+path, bytes.NewBufferString(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := c.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			var response responses.JsonResultsResponse
+			Expect(json.NewDecoder(resp.Body).Decode(&response)).To(Succeed())
+			Expect(response.StatusCode).To(Equal(resp.StatusCode))
+
+			return resp.StatusCode, response
+		}
+
+		By("creating a note")
+		Eventually(func(g Gomega) {
+			status, response := send(g, "POST", "/notes", `{"name":"note1","content":"I am a v1 note!","user":{"username":"Kirjava"}}`)
+			g.Expect(status).To(Equal(http.StatusCreated))
+			note = response.Data[0]
+		}, "20s").Should(Succeed())
+
+		By("replaying a creation retried with the same idempotency key")
+		create := func(body string) (*http.Response, responses.JsonNoteResponse) {
+			req, err := http.NewRequest("POST", "http://localhost:10000/api/v1/notes", bytes.NewBufferString(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Idempotency-Key", "3f1d6a8e-retry")
+			resp, err := c.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			var response responses.JsonNoteResponse
+			Expect(json.NewDecoder(resp.Body).Decode(&response)).To(Succeed())
+
+			return resp, response
+		}
+
+		first, created := create(`{"name":"retried","user":{"username":"Kirjava"}}`)
+		Expect(first.StatusCode).To(Equal(http.StatusCreated))
+		retry, replayed := create(`{"name":"retried","user":{"username":"Kirjava"}}`)
+		Expect(retry.StatusCode).To(Equal(http.StatusCreated))
+		Expect(retry.Header.Get("Idempotent-Replayed")).To(Equal("true"))
+		Expect(replayed.Data).To(Equal(created.Data))
+		reused, _ := create(`{"name":"different","user":{"username":"Kirjava"}}`)
+		Expect(reused.StatusCode).To(Equal(http.StatusConflict))
+
+		status, _ := send(Default, "DELETE", "/users/Kirjava/notes/"+created.Data[0].Id, "")
+		Expect(status).To(Equal(http.StatusOK))
+
+		By("listing active notes")
+		status, response := send(Default, "GET", "/users/Kirjava/notes?state=active", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response.Data).To(ContainElement(note))
+
+		By("clearing the content with a merge patch")
+		status, response = sendAs(Default, "application/merge-patch+json", "PATCH", "/notes/"+note.Id+"?username=Kirjava", `{"content":null}`)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response.Data[0].Content).To(BeEmpty())
+		Expect(response.Data[0].Name).To(Equal("note1"))
+
+		By("not finding the note for another user")
+		status, _ = send(Default, "PATCH", "/notes/"+note.Id, `{"content":"I am Lyra's now","user":{"username":"Lyra"}}`)
+		Expect(status).To(Equal(http.StatusNotFound))
+
+		By("rejecting a JSON patch whose test fails")
+		status, _ = sendAs(Default, "application/json-patch+json", "PATCH", "/notes/"+note.Id+"?username=Kirjava", `[{"op":"test","path":"/content","value":"I am a v1 note!"},{"op":"remove","path":"/name"}]`)
+		Expect(status).To(Equal(http.StatusBadRequest))
+
+		By("following the changes to the notes")
+		events, err := c.Get("http://localhost:10000/api/v1/events?username=Kirjava")
+		Expect(err).NotTo(HaveOccurred())
+		defer events.Body.Close()
+		Expect(events.StatusCode).To(Equal(http.StatusOK))
+		Expect(events.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+		By("archiving the note")
+		status, response = send(Default, "POST", "/notes/"+note.Id+"/archive?username=Kirjava", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response.Data[0].Archived).To(BeTrue())
+		Expect(response.Data[0].Content).To(BeEmpty())
+
+		stream := bufio.NewReader(events.Body)
+		Eventually(func() string {
+			line, err := stream.ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			return line
+		}).Should(Equal("event: note.archived\n"))
+		data, err := stream.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(ContainSubstring(`"id":"` + note.Id + `"`))
+
+		By("listing archived notes")
+		status, response = send(Default, "GET", "/users/Kirjava/notes?state=archived", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response.Data).To(ContainElement(HaveField("Id", note.Id)))
+
+		By("unarchiving the note with a JSON patch")
+		status, response = sendAs(Default, "application/json-patch+json", "PATCH", "/notes/"+note.Id+"?username=Kirjava", `[{"op":"test","path":"/archived","value":true},{"op":"replace","path":"/archived","value":false}]`)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response.Data[0].Archived).To(BeFalse())
+
+		By("archiving notes in bulk with a filter")
+		status, results := sendBulk("/notes/archive", `{"username":"Kirjava","filter":{"name":"note1"}}`)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(results.Results).To(ContainElement(HaveField("Id", note.Id)))
+
+		By("unarchiving notes in bulk by id")
+		status, results = sendBulk("/notes/unarchive", `{"username":"Kirjava","ids":["`+note.Id+`","404"]}`)
+		Expect(status).To(Equal(http.StatusMultiStatus))
+		Expect(results.Type).To(Equal("partial"))
+		Expect(results.Results[0].Note.Archived).To(BeFalse())
+		Expect(results.Results[1]).To(Equal(models.NoteResult{Id: "404", Error: "note does not exist"}))
+
+		By("replacing the note")
+		status, response = send(Default, "PUT", "/notes/"+note.Id, `{"name":"note2","content":"I replaced it","tags":["daemons"],"user":{"username":"Kirjava"}}`)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response.Data).To(HaveLen(1))
+		Expect(response.Data[0].Updated).To(BeTemporally(">", note.Created))
+		Expect(response.Data).To(Equal([]models.Note{{Id: note.Id, Name: "note2", Content: "I replaced it", Tags: []string{"daemons"}, User: models.User{Username: "Kirjava"},
+			Created: note.Created, Updated: response.Data[0].Updated}}))
+
+		status, response = send(Default, "GET", "/users/Kirjava/notes?state=active", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response.Data).To(ContainElement(HaveField("Name", "note2")))
+
+		By("editing the note over a WebSocket")
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:10000/api/v1/notes/"+note.Id+"/collab?username=Kirjava", nil)
+		Expect(err).NotTo(HaveOccurred())
+		var snapshot collab.Message
+		Expect(conn.ReadJSON(&snapshot)).To(Succeed())
+		Expect(snapshot.Type).To(Equal(collab.MessageSnapshot))
+		last := snapshot.Elements[len(snapshot.Elements)-1].ID
+		op := collab.Op{Type: collab.OpInsert, ID: collab.ID{Clock: snapshot.Clock + 1, Site: snapshot.Site}, After: &last, Value: "!"}
+		Expect(conn.WriteJSON(collab.Message{Type: collab.MessageOps, Ops: []collab.Op{op}})).To(Succeed())
+		conn.Close()
+
+		Eventually(func() []models.Note {
+			_, response := send(Default, "GET", "/users/Kirjava/notes?state=active", "")
+			return response.Data
+		}).Should(ContainElement(HaveField("Content", "I replaced it!")))
+
+		By("leaving the notes alone when an operation of a batch fails")
+		status, results = sendBulk("/notes/batch?username=Kirjava", `{"operations":[{"op":"create","note":{"name":"batch1"}},{"op":"delete","id":"404"}]}`)
+		Expect(status).To(Equal(http.StatusNotFound))
+		Expect(results.Type).To(Equal("failed"))
+		Expect(results.Results[0]).To(Equal(models.NoteResult{Error: "not applied"}))
+		Expect(results.Results[1].Id).To(Equal("404"))
+		Expect(results.Results[1].Error).NotTo(Equal("not applied"))
+
+		status, response = send(Default, "GET", "/users/Kirjava/notes?state=active", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response.Data).NotTo(ContainElement(HaveField("Name", "batch1")))
+
+		By("applying a batch")
+		status, results = sendBulk("/notes/batch?username=Kirjava", `{"operations":[{"op":"create","note":{"name":"batch1"}},{"op":"update","id":"`+note.Id+`","note":{"content":"I was batched"}}]}`)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(results.Results[0].Note.Name).To(Equal("batch1"))
+		Expect(results.Results[1].Note.Content).To(Equal("I was batched"))
+
+		status, results = sendBulk("/notes/batch?username=Kirjava", `{"operations":[{"op":"delete","id":"`+results.Results[0].Id+`"}]}`)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(results.Message).To(Equal("The 1 operations were successfully applied"))
+
+		request := func(method, path, body string, response interface{}) int {
+			req, err := http.NewRequest(method, "http://localhost:10000/api/v1"+path, bytes.NewBufferString(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := c.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(json.NewDecoder(resp.Body).Decode(response)).To(Succeed())
+
+			return resp.StatusCode
+		}
+
+		By("refusing webhooks to callers without a client certificate")
+		var refused responses.JsonNoteResponse
+		Expect(request("POST", "/webhooks?username=Kirjava", `{"url":"https://example.com/hooks","events":["note.deleted"]}`, &refused)).To(Equal(http.StatusForbidden))
+		Expect(request("GET", "/webhooks?username=Kirjava", "", &refused)).To(Equal(http.StatusForbidden))
+
+		By("deleting the note")
+		status, response = send(Default, "DELETE", "/users/Kirjava/notes/"+note.Id, "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response.Message).To(Equal("The note was successfully deleted"))
+
+		By("syncing the notes from scratch")
+		var synced responses.JsonSyncResponse
+		Expect(request("GET", "/sync?username=Kirjava", "", &synced)).To(Equal(http.StatusOK))
+		Expect(synced.Changes).NotTo(ContainElement(HaveField("Id", note.Id)))
+		token := synced.Token
+
+		By("uploading a note made offline")
+		var uploaded responses.JsonChangeResultsResponse
+		Expect(request("POST", "/sync?username=Kirjava", `{"changes":[{"note":{"name":"offline","content":"made on a train"}}]}`, &uploaded)).To(Equal(http.StatusOK))
+		offline := uploaded.Results[0]
+		Expect(offline.Note.Content).To(Equal("made on a train"))
+
+		By("reporting changes made on an old version as conflicts")
+		stale := fmt.Sprintf(`{"changes":[{"id":"%s","version":%d,"note":{"name":"stale"}}]}`, offline.Id, offline.Version-1)
+		Expect(request("POST", "/sync?username=Kirjava", stale, &uploaded)).To(Equal(http.StatusMultiStatus))
+		Expect(uploaded.Results[0].Conflict).To(BeTrue())
+		Expect(uploaded.Results[0].Note.Name).To(Equal("offline"))
+
+		deletion := fmt.Sprintf(`{"changes":[{"id":"%s","version":%d,"deleted":true}]}`, offline.Id, offline.Version)
+		Expect(request("POST", "/sync?username=Kirjava", deletion, &uploaded)).To(Equal(http.StatusOK))
+
+		By("syncing the changes since the last sync")
+		Expect(request("GET", "/sync?username=Kirjava&since="+token, "", &synced)).To(Equal(http.StatusOK))
+		Expect(synced.Changes).To(Equal([]models.Change{{Id: offline.Id, Version: uploaded.Results[0].Version, Deleted: true}}))
+
+		Expect(request("GET", "/sync?username=Kirjava&since="+synced.Token, "", &synced)).To(Equal(http.StatusOK))
+		Expect(synced.Changes).To(BeEmpty())
+
+		By("exporting the notes as Markdown")
+		Expect(request("GET", "/sync?username=Kirjava", "", &synced)).To(Equal(http.StatusOK))
+
+		resp, err := c.Get("http://localhost:10000/api/v1/export?username=Kirjava&format=zip")
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		exported, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+
+		archive, err := zip.NewReader(bytes.NewReader(exported), int64(len(exported)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archive.File).To(HaveLen(len(synced.Changes)))
+		for _, file := range archive.File {
+			Expect(file.Name).To(MatchRegexp(`^(active|archived)/.+\.md$`))
+		}
+
+		By("importing the export again as duplicates")
+		req, err := http.NewRequest("POST", "http://localhost:10000/api/v1/import?username=Kirjava&dry_run=true", bytes.NewReader(exported))
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Content-Type", "application/zip")
+		resp, err = c.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+
+		var imported responses.JsonImportResponse
+		Expect(json.NewDecoder(resp.Body).Decode(&imported)).To(Succeed())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(imported.Skipped).To(Equal(len(synced.Changes)))
+		Expect(imported.Created).To(BeZero())
+
+		By("importing notes from JSON")
+		Expect(request("POST", "/import?username=Kirjava", `[{"name":"imported","content":"from elsewhere","archived":true}]`, &imported)).To(Equal(http.StatusOK))
+		Expect(imported.Results[0].Status).To(Equal("created"))
+
+		status, response = send(Default, "GET", "/users/Kirjava/notes?state=archived", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response.Data).To(ContainElement(HaveField("Id", imported.Results[0].Id)))
+
+		By("deleting an archived note")
+		status, _ = send(Default, "DELETE", "/users/Kirjava/notes/"+imported.Results[0].Id, "")
+		Expect(status).To(Equal(http.StatusOK))
+
+		status, response = send(Default, "GET", "/users/Kirjava/notes?state=archived", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response.Data).NotTo(ContainElement(HaveField("Id", imported.Results[0].Id)))
+	},
+		table.Entry("local", localArgsBuilder),
+		table.Entry("sql", sqlArgsBuilder),
+	)
+
+	It("prints the effective configuration without secrets", func() {
+		command := exec.Command(cliBin, "config", "print", "--db", "sql", "--db-username", "Pantalaimon")
+		command.Env = append(os.Environ(), "DB_PASSWORD=Kirjava")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(session, "20s").Should(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say("type: sql"))
+		Expect(session.Out).To(gbytes.Say("username: Pantalaimon"))
+		Expect(session.Out).To(gbytes.Say("password: <redacted>"))
+		Expect(string(session.Out.Contents())).NotTo(ContainSubstring("Kirjava"))
+	})
+
+	It("shuts down gracefully when terminated", func() {
+		command := exec.Command(cliBin, localArgsBuilder()...)
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		c := validatingClient()
+		Eventually(func(g Gomega) error {
+			resp, err := c.Get("http://localhost:10000/readyz")
+			g.Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			g.Expect(string(body)).To(MatchJSON(`{"status":"ok","components":{"database":{"status":"ok"},"server":{"status":"ok"}}}`))
+			return nil
+		}, "20s").Should(Succeed())
+
+		for _, path := range []string{"/", "/healthz", "/openapi.json"} {
+			resp, err := c.Get("http://localhost:10000" + path)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		session.Terminate()
+		Eventually(session, "20s").Should(gexec.Exit(0))
+	})
 })
 
+// validatingClient fails any request or response that does not match the
+// OpenAPI document served by the API.
+func validatingClient() http.Client {
+	validator, err := openapi.NewValidator()
+	Expect(err).NotTo(HaveOccurred())
+
+	return http.Client{Transport: validator.Transport(http.DefaultTransport)}
+}
+
 func databaseNotRunning(storage string) bool {
 	username := os.Getenv("DB_USERNAME")
 	password := os.Getenv("DB_PASSWORD")